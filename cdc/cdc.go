@@ -0,0 +1,289 @@
+// Package cdc delivers the writes of a database to external sinks in the order of the change log.
+// Every sink has an offset that is stored in the database after a batch has been delivered, so
+// the events are delivered at least once: after a failure or a restart the events after the
+// offset are delivered again and the consumers can skip the duplicates by their sequence.
+package cdc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nireo/dkv/db"
+)
+
+// Event is a change of a key. The sequence is the sequence of the change log entry, which is the
+// same for the changes that were written together. In json a key that is not valid UTF-8 is
+// encoded in base64 like the value and the event has "key_encoding":"base64".
+type Event struct {
+	Seq    uint64       `json:"seq"`
+	Time   time.Time    `json:"time"`
+	Type   db.EventType `json:"type"`
+	Bucket string       `json:"bucket,omitempty"`
+	Key    string       `json:"key"`
+	Value  []byte       `json:"value,omitempty"`
+}
+
+// EncodingBase64 is the key encoding of the events whose key is not valid UTF-8.
+const EncodingBase64 = "base64"
+
+// jsonEvent is the json form of an event without the methods of Event.
+type jsonEvent struct {
+	eventFields
+	KeyEncoding string `json:"key_encoding,omitempty"`
+}
+
+type eventFields Event
+
+// MarshalJSON encodes the event and encodes the key in base64 if it is not valid UTF-8.
+func (e Event) MarshalJSON() ([]byte, error) {
+	out := jsonEvent{eventFields: eventFields(e)}
+	if !utf8.ValidString(e.Key) {
+		out.Key = base64.StdEncoding.EncodeToString([]byte(e.Key))
+		out.KeyEncoding = EncodingBase64
+	}
+
+	return json.Marshal(&out)
+}
+
+// UnmarshalJSON decodes an event that was encoded with MarshalJSON.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var in jsonEvent
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	switch in.KeyEncoding {
+	case "":
+	case EncodingBase64:
+		key, err := base64.StdEncoding.DecodeString(in.Key)
+		if err != nil {
+			return fmt.Errorf("invalid key: %s", err)
+		}
+		in.Key = string(key)
+	default:
+		return fmt.Errorf("unknown key encoding %q", in.KeyEncoding)
+	}
+
+	*e = Event(in.eventFields)
+	return nil
+}
+
+// Sink receives the events in order. Write is called again with the same events if it returns an
+// error, so it must not return an error after it has made the events visible.
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// Options configures a Feed.
+type Options struct {
+	// BatchSize is the maximum amount of change log entries in a single write.
+	BatchSize int
+
+	// PollInterval is how often the change log is checked for new entries when the sink has
+	// received all of the events.
+	PollInterval time.Duration
+
+	// MinBackoff and MaxBackoff are the bounds of the exponential backoff after failures.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultOptions are the options that are used for the zero fields of Options.
+var DefaultOptions = Options{
+	BatchSize:    100,
+	PollInterval: 500 * time.Millisecond,
+	MinBackoff:   100 * time.Millisecond,
+	MaxBackoff:   time.Minute,
+}
+
+// Stats are the delivery statistics of a feed.
+type Stats struct {
+	Name string `json:"name"`
+
+	// Offset is the sequence of the last delivered entry and Sequence is the sequence of the
+	// latest entry in the change log, the difference is the lag of the sink.
+	Offset   uint64 `json:"offset"`
+	Sequence uint64 `json:"sequence"`
+
+	Delivered uint64 `json:"delivered"`
+	Failures  uint64 `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Lag returns the amount of change log entries that the sink hasn't received.
+func (st Stats) Lag() uint64 {
+	if st.Offset > st.Sequence {
+		return 0
+	}
+	return st.Sequence - st.Offset
+}
+
+// Feed delivers the changes of a database to a single sink.
+type Feed struct {
+	db   *db.DB
+	name string
+	sink Sink
+	opts Options
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New returns a feed that delivers the changes to the sink. The offset of the sink is stored under
+// the name, and a sink without an offset starts from the latest entry of the change log, such that
+// it only gets the changes after it was added. The change log needs to be enabled.
+func New(d *db.DB, name string, sink Sink, opts Options) (*Feed, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOptions.BatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultOptions.PollInterval
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultOptions.MinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+
+	offset, ok, err := d.SinkOffset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if offset, err = d.ChangeLogSequence(); err != nil {
+			return nil, err
+		}
+
+		if err := d.SetSinkOffset(name, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &Feed{db: d, name: name, sink: sink, opts: opts}
+	f.stats.Name = name
+	f.stats.Offset = offset
+
+	return f, nil
+}
+
+// Name returns the name of the feed.
+func (f *Feed) Name() string {
+	return f.name
+}
+
+// Stats returns the delivery statistics of the feed. The offset is read before the sequence, such
+// that the delivery can only move the sequence further ahead of it.
+func (f *Feed) Stats() (Stats, error) {
+	f.mu.Lock()
+	stats := f.stats
+	f.mu.Unlock()
+
+	seq, err := f.db.ChangeLogSequence()
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Sequence = seq
+	return stats, nil
+}
+
+// Run delivers the changes until stop is closed. The failed deliveries are retried with an
+// exponential backoff, and the sink is closed when Run returns.
+func (f *Feed) Run(stop <-chan struct{}) {
+	defer f.sink.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := f.opts.MinBackoff
+	for {
+		n, err := f.deliver(ctx)
+
+		wait := f.opts.PollInterval
+		if err != nil {
+			wait = backoff
+			if backoff *= 2; backoff > f.opts.MaxBackoff {
+				backoff = f.opts.MaxBackoff
+			}
+		} else {
+			backoff = f.opts.MinBackoff
+
+			// keep going while the sink is behind
+			if n > 0 {
+				wait = 0
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliver writes the next batch of events to the sink and stores the new offset. It returns the
+// amount of change log entries that were delivered.
+func (f *Feed) deliver(ctx context.Context) (int, error) {
+	f.mu.Lock()
+	offset := f.stats.Offset
+	f.mu.Unlock()
+
+	var events []Event
+	seq, err := f.db.ReadChanges(offset, f.opts.BatchSize, func(c *db.Change) error {
+		event := Event{
+			Seq:    c.Seq,
+			Time:   c.Time,
+			Type:   db.EventPut,
+			Bucket: c.Bucket,
+			Key:    string(c.Key),
+			Value:  c.Value,
+		}
+		if c.Delete {
+			event.Type = db.EventDelete
+		}
+
+		events = append(events, event)
+		return nil
+	})
+
+	if err == nil && len(events) > 0 {
+		err = f.sink.Write(ctx, events)
+	}
+
+	if err == nil && seq != offset {
+		err = f.db.SetSinkOffset(f.name, seq)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err != nil {
+		f.stats.Failures++
+		f.stats.LastError = err.Error()
+		log.Printf("could not deliver changes to sink %s: %s", f.name, err)
+		return 0, err
+	}
+
+	f.stats.Offset = seq
+	f.stats.Delivered += uint64(len(events))
+	f.stats.LastError = ""
+
+	return int(seq - offset), nil
+}
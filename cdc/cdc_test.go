@@ -0,0 +1,291 @@
+package cdc_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nireo/dkv/cdc"
+	"github.com/nireo/dkv/db"
+)
+
+var testOptions = cdc.Options{PollInterval: 5 * time.Millisecond, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+func openDB(t *testing.T) *db.DB {
+	t.Helper()
+
+	d, err := db.Open(db.EngineMemory, "", false)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	if err := d.EnableChangeLog(); err != nil {
+		t.Fatalf("could not enable change log: %s", err)
+	}
+
+	return d
+}
+
+// memorySink collects the events and fails the writes while failures is positive.
+type memorySink struct {
+	mu       sync.Mutex
+	events   []cdc.Event
+	failures int
+}
+
+func (s *memorySink) Write(ctx context.Context, events []cdc.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink is down")
+	}
+
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func (s *memorySink) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, len(s.events))
+	for i, event := range s.events {
+		keys[i] = string(event.Type) + " " + event.Key
+	}
+	return keys
+}
+
+// waitFor waits until the feed has delivered the change log.
+func waitFor(t *testing.T, f *cdc.Feed) {
+	t.Helper()
+
+	for i := 0; i < 500; i++ {
+		if stats, err := f.Stats(); err == nil && stats.Offset == stats.Sequence {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stats, err := f.Stats()
+	t.Fatalf("the feed did not catch up. stats=%+v, err=%v", stats, err)
+}
+
+func TestFeed(t *testing.T) {
+	d := openDB(t)
+	d.Set("before", []byte("0"))
+
+	sink := &memorySink{failures: 3}
+	f, err := cdc.New(d, "memory", sink, testOptions)
+	if err != nil {
+		t.Fatalf("could not create feed: %s", err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		f.Run(stop)
+		close(done)
+	}()
+
+	d.Set("a", []byte("1"))
+	d.Set("b", []byte("2"))
+	d.Delete("a")
+	waitFor(t, f)
+
+	// the changes before the sink was added are not delivered
+	want := []string{"put a", "put b", "delete a"}
+	if got := sink.keys(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("wrong events. got=%v want=%v", got, want)
+	}
+
+	stats, err := f.Stats()
+	if err != nil {
+		t.Fatalf("could not read stats: %s", err)
+	}
+	if stats.Failures != 3 || stats.Delivered != 3 || stats.LastError != "" {
+		t.Errorf("wrong stats. got=%+v", stats)
+	}
+
+	close(stop)
+	<-done
+
+	// a new feed continues from the stored offset
+	d.Set("c", []byte("3"))
+	resumed := &memorySink{}
+	f, err = cdc.New(d, "memory", resumed, testOptions)
+	if err != nil {
+		t.Fatalf("could not create feed: %s", err)
+	}
+
+	stop = make(chan struct{})
+	defer close(stop)
+	go f.Run(stop)
+	waitFor(t, f)
+
+	if got := resumed.keys(); len(got) != 1 || got[0] != "put c" {
+		t.Errorf("wrong events after resuming. got=%v", got)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cdc")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "changes.jsonl")
+	sink, err := cdc.NewFileSink(path, 100, 2)
+	if err != nil {
+		t.Fatalf("could not open file sink: %s", err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 10; i++ {
+		event := cdc.Event{Seq: uint64(i + 1), Type: db.EventPut, Key: "key", Value: []byte("value")}
+		if err := sink.Write(context.Background(), []cdc.Event{event}); err != nil {
+			t.Fatalf("could not write event: %s", err)
+		}
+	}
+
+	// the oldest files are removed
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("too many rotated files were kept. err=%v", err)
+	}
+
+	var last uint64
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("could not open %s: %s", name, err)
+		}
+
+		info, _ := file.Stat()
+		if info.Size() > 100 {
+			t.Errorf("the file %s is larger than the maximum size: %d", name, info.Size())
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var event cdc.Event
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatalf("invalid json line %q: %s", scanner.Text(), err)
+			}
+
+			if event.Seq != last+1 && last != 0 {
+				t.Errorf("the events are not in order. got=%d after %d", event.Seq, last)
+			}
+			last = event.Seq
+		}
+		file.Close()
+	}
+
+	if last != 10 {
+		t.Errorf("the latest event is missing. got=%d", last)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var received []cdc.Event
+	status := http.StatusInternalServerError
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("wrong content type: %q", r.Header.Get("Content-Type"))
+		}
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		dec := json.NewDecoder(r.Body)
+		for dec.More() {
+			var event cdc.Event
+			if err := dec.Decode(&event); err != nil {
+				t.Errorf("could not decode event: %s", err)
+				return
+			}
+			received = append(received, event)
+		}
+	}))
+	defer ts.Close()
+
+	sink := cdc.NewWebhookSink(ts.URL)
+	events := []cdc.Event{{Seq: 1, Type: db.EventPut, Key: "a"}, {Seq: 2, Type: db.EventDelete, Key: "a"}}
+
+	if err := sink.Write(context.Background(), events); err == nil {
+		t.Errorf("the failed response was not an error")
+	}
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+
+	if err := sink.Write(context.Background(), events); err != nil {
+		t.Fatalf("could not post events: %s", err)
+	}
+
+	if len(received) != 2 || received[1].Type != db.EventDelete {
+		t.Errorf("wrong events received. got=%+v", received)
+	}
+}
+
+func TestEventBinaryKey(t *testing.T) {
+	for _, key := range []string{"text", "\xff\x00binary"} {
+		event := cdc.Event{Seq: 1, Type: db.EventPut, Key: key, Value: []byte{0xff}}
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("could not encode event: %s", err)
+		}
+
+		if !utf8.Valid(data) {
+			t.Errorf("the event of %q is not valid UTF-8: %q", key, data)
+		}
+
+		var got cdc.Event
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("could not decode %s: %s", data, err)
+		}
+
+		if got.Key != key || got.Seq != 1 || got.Type != db.EventPut || string(got.Value) != "\xff" {
+			t.Errorf("wrong event after a round trip. got=%+v from %s", got, data)
+		}
+	}
+}
+
+func TestStatsLag(t *testing.T) {
+	for _, tc := range []struct {
+		offset, seq, lag uint64
+	}{
+		{0, 0, 0},
+		{3, 10, 7},
+		// a sequence behind the offset doesn't wrap around
+		{10, 3, 0},
+	} {
+		if lag := (cdc.Stats{Offset: tc.offset, Sequence: tc.seq}).Lag(); lag != tc.lag {
+			t.Errorf("offset %d and sequence %d: want lag %d, got %d", tc.offset, tc.seq, tc.lag, lag)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nireo/dkv/export"
+	"github.com/nireo/dkv/shards"
+)
+
+// ShardStatus is the status of a single shard in the cluster.
+type ShardStatus struct {
+	Index     int           `json:"index"`
+	Address   string        `json:"address"`
+	Reachable bool          `json:"reachable"`
+	Epoch     uint64        `json:"epoch"`
+	Latency   time.Duration `json:"latency"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// ReplicationStatus is the replication status of a single shard in the cluster.
+type ReplicationStatus struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+
+	// Backlog is the amount of changed values that the replicas haven't applied yet.
+	Backlog  int    `json:"backlog"`
+	ReadOnly bool   `json:"read_only"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Shards returns the current shard map of the client.
+func (c *Client) Shards() *shards.Shards {
+	return c.current()
+}
+
+// Status asks every shard for its shard map and returns whether the shards could be reached.
+func (c *Client) Status() []ShardStatus {
+	s := c.current()
+
+	statuses := make([]ShardStatus, s.Amount)
+	for i := 0; i < s.Amount; i++ {
+		status := ShardStatus{Index: i, Address: s.Addresses[i]}
+
+		start := time.Now()
+		data, err := c.send(s, i, http.MethodGet, "/v1/shards", nil, "")
+		status.Latency = time.Since(start)
+
+		var conf shards.Config
+		if err == nil {
+			err = json.Unmarshal(data, &conf)
+		}
+
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Reachable = true
+			status.Epoch = conf.Epoch
+		}
+
+		statuses[i] = status
+	}
+
+	return statuses
+}
+
+// Replication returns the replication status of every shard.
+func (c *Client) Replication() []ReplicationStatus {
+	s := c.current()
+
+	statuses := make([]ReplicationStatus, s.Amount)
+	for i := 0; i < s.Amount; i++ {
+		status := ReplicationStatus{Index: i, Address: s.Addresses[i]}
+
+		data, err := c.send(s, i, http.MethodGet, "/admin/replication", nil, "")
+		if err == nil {
+			err = json.Unmarshal(data, &status)
+		}
+
+		if err != nil {
+			status.Error = err.Error()
+		}
+
+		statuses[i] = status
+	}
+
+	return statuses
+}
+
+// Buckets returns the names of the buckets.
+func (c *Client) Buckets() ([]string, error) {
+	var names []string
+	err := c.retry(func(s *shards.Shards) error {
+		data, err := c.send(s, 0, http.MethodGet, "/v1/buckets", nil, "")
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(data, &names)
+	})
+
+	return names, err
+}
+
+// CreateBucket creates the bucket on every shard.
+func (c *Client) CreateBucket(name string) error {
+	s := c.current()
+	_, err := c.send(s, 0, http.MethodPut, "/v1/buckets/"+url.PathEscape(name), nil, "")
+	return err
+}
+
+// EnsureBucket creates the bucket on every shard of the client's shard map. The shards create it
+// locally without broadcasting it, such that the shards whose servers still use an other shard map
+// get the bucket too, and the shards that already have the bucket are left as they are.
+func (c *Client) EnsureBucket(name string) error {
+	s := c.current()
+	for i := 0; i < s.Amount; i++ {
+		_, err := c.send(s, i, http.MethodPut, "/v1/buckets/"+url.PathEscape(name)+"?local=1", nil, "")
+
+		var resErr *Error
+		if errors.As(err, &resErr) && resErr.Code == "bucket_exists" {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DropBucket drops the bucket on every shard.
+func (c *Client) DropBucket(name string) error {
+	s := c.current()
+	_, err := c.send(s, 0, http.MethodDelete, "/v1/buckets/"+url.PathEscape(name), nil, "")
+	return err
+}
+
+// Scan calls fn for every key-value pair of the default bucket, or the given bucket, whose key
+// starts with prefix. The shards are scanned one after another and the keys are sorted within a
+// shard.
+func (c *Client) Scan(bucket, prefix string, fn func(shard int, rec *export.Record) error) error {
+	s := c.current()
+	query := url.Values{"bucket": {bucket}, "prefix": {prefix}, "format": {export.FormatJSONL}}
+
+	for i := 0; i < s.Amount; i++ {
+		body, err := c.stream(s, i, http.MethodGet, "/export?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+
+		err = scanRecords(body, func(rec *export.Record) error {
+			return fn(i, rec)
+		})
+		body.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Import writes the records into the default bucket, or the given bucket. The records are grouped
+// by the shard map of the client and every shard writes its records without checking which shard
+// they belong to, such that the keys can be copied into a cluster whose nodes don't use the shard
+// map yet.
+func (c *Client) Import(bucket string, records []*export.Record) error {
+	byKey := make(map[string]*export.Record, len(records))
+	keys := make([]string, 0, len(records))
+	for _, rec := range records {
+		byKey[rec.Key] = rec
+		keys = append(keys, rec.Key)
+	}
+
+	query := url.Values{"bucket": {bucket}, "format": {export.FormatJSONL}, "local": {"1"}}
+	return c.batch(keys, func(s *shards.Shards, shard int, batch []string) error {
+		var body bytes.Buffer
+		enc, _ := export.NewWriter(&body, export.FormatJSONL)
+		for _, key := range batch {
+			if err := enc.Write(byKey[key]); err != nil {
+				return err
+			}
+		}
+		enc.Flush()
+
+		_, err := c.send(s, shard, http.MethodPost, "/import?"+query.Encode(), body.Bytes(),
+			export.ContentType(export.FormatJSONL))
+		return err
+	})
+}
+
+// Purge deletes the keys that don't belong to the shards from every shard, which should be done
+// after the shards have been changed and the keys have been copied to their new owners.
+func (c *Client) Purge() error {
+	s := c.current()
+	for i := 0; i < s.Amount; i++ {
+		if _, err := c.send(s, i, http.MethodGet, "/purge", nil, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Backup writes a full backup archive of the shard into w.
+func (c *Client) Backup(shard int, w io.Writer) error {
+	body, err := c.stream(c.current(), shard, http.MethodGet, "/admin/backup", nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// Restore applies the backup archive from r to the shard. A full backup can only be restored into
+// an empty shard.
+func (c *Client) Restore(shard int, r io.Reader) error {
+	body, err := c.stream(c.current(), shard, http.MethodPost, "/admin/restore", r)
+	if err != nil {
+		return err
+	}
+
+	return body.Close()
+}
+
+// stream sends the request to the shard and returns the body of a successful response.
+func (c *Client) stream(s *shards.Shards, shard int, method, path string, body io.Reader) (io.ReadCloser, error) {
+	req, err := http.NewRequest(method, c.scheme+"://"+s.Addresses[shard]+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.streams.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, responseError(resp.StatusCode, data)
+	}
+
+	return resp.Body, nil
+}
+
+func scanRecords(r io.Reader, fn func(rec *export.Record) error) error {
+	dec, err := export.NewReader(r, export.FormatJSONL)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rec, err := dec.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
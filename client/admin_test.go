@@ -0,0 +1,173 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/nireo/dkv/export"
+)
+
+func TestClientScan(t *testing.T) {
+	tc := startCluster(t, 2, 0)
+	c, err := New(tc.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := c.Set(fmt.Sprintf("scan%02d", i), []byte{byte(i)}); err != nil {
+			t.Fatalf("could not set: %s", err)
+		}
+	}
+	c.Set("other", []byte("x"))
+
+	found := make(map[string]int)
+	err = c.Scan("", "scan", func(shard int, rec *export.Record) error {
+		if c.Shards().GetShardIndex(rec.Key) != shard {
+			t.Errorf("%q was scanned from the wrong shard %d", rec.Key, shard)
+		}
+		found[rec.Key] = int(rec.Value[0])
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("could not scan: %s", err)
+	}
+
+	if len(found) != 20 || found["scan07"] != 7 {
+		t.Errorf("wrong scan results. got=%v", found)
+	}
+}
+
+func TestClientBuckets(t *testing.T) {
+	tc := startCluster(t, 2, 0)
+	c, err := New(tc.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	if err := c.CreateBucket("users"); err != nil {
+		t.Fatalf("could not create bucket: %s", err)
+	}
+
+	for i, d := range tc.dbs {
+		if err := d.Bucket("users").Set([]byte("k"), []byte("v")); err != nil {
+			t.Errorf("the bucket was not created on shard %d: %s", i, err)
+		}
+	}
+
+	names, err := c.Buckets()
+	if err != nil || len(names) != 1 || names[0] != "users" {
+		t.Errorf("wrong buckets. got=%v, err=%v", names, err)
+	}
+
+	if err := c.CreateBucket("users"); err == nil {
+		t.Errorf("creating an existing bucket didn't return an error")
+	}
+
+	if err := c.DropBucket("users"); err != nil {
+		t.Fatalf("could not drop bucket: %s", err)
+	}
+}
+
+func TestClientImport(t *testing.T) {
+	tc := startCluster(t, 2, 0)
+	c, err := New(tc.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	if err := c.CreateBucket("users"); err != nil {
+		t.Fatalf("could not create bucket: %s", err)
+	}
+
+	var records []*export.Record
+	for i := 0; i < 20; i++ {
+		records = append(records, &export.Record{Key: fmt.Sprintf("user%02d", i), Value: []byte{byte(i)}})
+	}
+
+	if err := c.Import("users", records); err != nil {
+		t.Fatalf("could not import: %s", err)
+	}
+
+	for _, rec := range records {
+		d := tc.dbs[c.Shards().GetShardIndex(rec.Key)]
+		if value, err := d.Bucket("users").Get([]byte(rec.Key)); err != nil || !bytes.Equal(value, rec.Value) {
+			t.Errorf("%q was not imported into its shard. got=%v, err=%v", rec.Key, value, err)
+		}
+	}
+
+	if _, err := tc.dbs[0].Get("user00"); err == nil {
+		t.Errorf("the records were imported into the default bucket")
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	tc := startCluster(t, 2, 4)
+	c, err := New(tc.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+	c.Set("key", []byte("value"))
+
+	tc.servers[1].Close()
+	statuses := c.Status()
+	if !statuses[0].Reachable || statuses[0].Epoch != 4 {
+		t.Errorf("wrong status for shard 0. got=%+v", statuses[0])
+	}
+
+	if statuses[1].Reachable || statuses[1].Error == "" {
+		t.Errorf("the closed shard was reachable. got=%+v", statuses[1])
+	}
+
+	replication := c.Replication()
+	owner := c.Shards().GetShardIndex("key")
+	if owner == 0 && replication[0].Backlog != 1 {
+		t.Errorf("wrong replication backlog. got=%+v", replication[0])
+	}
+
+	if replication[1].Error == "" {
+		t.Errorf("the closed shard had a replication status. got=%+v", replication[1])
+	}
+}
+
+func TestClientBackupRestore(t *testing.T) {
+	src := startCluster(t, 2, 0)
+	c, err := New(src.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	values := map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}
+	if err := c.SetMany(values); err != nil {
+		t.Fatalf("could not set: %s", err)
+	}
+
+	dst := startCluster(t, 2, 0)
+	restored, err := New(dst.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	for shard := 0; shard < 2; shard++ {
+		var buf bytes.Buffer
+		if err := c.Backup(shard, &buf); err != nil {
+			t.Fatalf("could not back up shard %d: %s", shard, err)
+		}
+
+		if err := restored.Restore(shard, bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("could not restore shard %d: %s", shard, err)
+		}
+
+		// the shard is not empty anymore
+		if err := restored.Restore(shard, bytes.NewReader(buf.Bytes())); err == nil {
+			t.Errorf("restoring into a shard with data didn't return an error")
+		}
+	}
+
+	got, err := restored.GetMany([]string{"a", "b", "c"})
+	if err != nil || len(got) != 3 {
+		t.Fatalf("the values were not restored. got=%v, err=%v", got, err)
+	}
+}
@@ -0,0 +1,462 @@
+// Package client is a Go client for dkv that routes every key directly to the shard that owns it,
+// such that the requests don't pay for an extra hop through another shard.
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nireo/dkv/shards"
+)
+
+// BatchSize is the largest amount of keys that is sent to a shard in a single batch request.
+const BatchSize = 500
+
+// ErrNotFound is returned when the key doesn't exist.
+var ErrNotFound = errors.New("the key was not found")
+
+// Error is an error response of the server.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("dkv: status %d: %s", e.StatusCode, e.Message)
+	}
+
+	return fmt.Sprintf("dkv: %s: %s", e.Code, e.Message)
+}
+
+// staleError is returned when a server responds with 421, which means that the shard map of the
+// client and the server have different epochs.
+type staleError struct {
+	addr  string
+	epoch uint64
+}
+
+func (e *staleError) Error() string {
+	return fmt.Sprintf("dkv: the shard at %s has epoch %d", e.addr, e.epoch)
+}
+
+// Client talks to the shards of a cluster. It is safe for concurrent use.
+type Client struct {
+	// Retries is the amount of times a request is retried after a transient error, which is a
+	// network error, 502 or 504. Backoff is the delay before the first retry and it doubles after
+	// every retry.
+	Retries int
+	Backoff time.Duration
+
+	http   *http.Client
+	scheme string // http, or https if UseTLS was called
+
+	// the archives and the exports can take longer than the timeout of the requests, so they are
+	// sent without a timeout.
+	streams *http.Client
+
+	mu     sync.RWMutex
+	shards *shards.Shards
+}
+
+// New returns a client for the cluster in the shards config.
+func New(conf *shards.Config) (*Client, error) {
+	s, err := conf.Cluster()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		Retries: 3,
+		Backoff: 50 * time.Millisecond,
+		scheme:  "http",
+		shards:  s,
+	}
+	c.setTransport(newTransport())
+
+	return c, nil
+}
+
+// UseTLS makes the client connect to the shards over https with the given config, which verifies
+// the servers with the system roots when it is nil. It must be called before the client is used.
+func (c *Client) UseTLS(config *tls.Config) {
+	transport := newTransport()
+	transport.TLSClientConfig = config
+
+	c.scheme = "https"
+	c.setTransport(transport)
+}
+
+// TLSConfig returns a config for UseTLS that verifies the servers with the certificates of the CA
+// file, or with the system roots if caFile is empty.
+func TLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates were found in %s", caFile)
+	}
+
+	return config, nil
+}
+
+func newTransport() *http.Transport {
+	return &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConns:        256,
+		MaxIdleConnsPerHost: 64,
+		IdleConnTimeout:     90 * time.Second,
+	}
+}
+
+func (c *Client) setTransport(transport *http.Transport) {
+	c.http = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	c.streams = &http.Client{Transport: transport}
+}
+
+// NewFromFile returns a client for the cluster in the shards file.
+func NewFromFile(path string) (*Client, error) {
+	conf, err := shards.ParseConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(conf)
+}
+
+// Epoch returns the epoch of the shard map of the client.
+func (c *Client) Epoch() uint64 {
+	return c.current().Epoch
+}
+
+// Refresh loads the shard map from the first shard that responds.
+func (c *Client) Refresh() error {
+	s := c.current()
+
+	var err error
+	for i := 0; i < s.Amount; i++ {
+		if err = c.refreshFrom(s.Addresses[i]); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// Get returns the value of the key.
+func (c *Client) Get(key string) ([]byte, error) {
+	var value []byte
+	err := c.retry(func(s *shards.Shards) error {
+		var err error
+		value, err = c.send(s, s.GetShardIndex(key), http.MethodGet, keyPath(key), nil, "")
+		return err
+	})
+
+	return value, err
+}
+
+// Set sets the value of the key.
+func (c *Client) Set(key string, value []byte) error {
+	return c.retry(func(s *shards.Shards) error {
+		_, err := c.send(s, s.GetShardIndex(key), http.MethodPut, keyPath(key), value, "")
+		return err
+	})
+}
+
+// Delete deletes the key.
+func (c *Client) Delete(key string) error {
+	return c.retry(func(s *shards.Shards) error {
+		_, err := c.send(s, s.GetShardIndex(key), http.MethodDelete, keyPath(key), nil, "")
+		return err
+	})
+}
+
+// GetMany returns the values of the keys. The keys are grouped per shard and the shards are
+// queried concurrently. The keys that are not found are left out of the result.
+func (c *Client) GetMany(keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	var mu sync.Mutex
+
+	err := c.batch(keys, func(s *shards.Shards, shard int, batch []string) error {
+		body, _ := json.Marshal(map[string][]string{"keys": batch})
+		data, err := c.send(s, shard, http.MethodPost, "/v1/batch/get", body, "application/json")
+		if err != nil {
+			return err
+		}
+
+		var res struct {
+			Values map[string][]byte `json:"values"`
+		}
+		if err := json.Unmarshal(data, &res); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		for key, value := range res.Values {
+			values[key] = value
+		}
+		mu.Unlock()
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// SetMany sets the values of the keys. The keys are grouped per shard and the shards are written
+// concurrently. The batches are not atomic, so some of the values can be written even if an
+// error is returned.
+func (c *Client) SetMany(values map[string][]byte) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	return c.batch(keys, func(s *shards.Shards, shard int, batch []string) error {
+		req := struct {
+			Values map[string][]byte `json:"values"`
+		}{Values: make(map[string][]byte, len(batch))}
+
+		for _, key := range batch {
+			req.Values[key] = values[key]
+		}
+
+		body, _ := json.Marshal(&req)
+		_, err := c.send(s, shard, http.MethodPost, "/v1/batch/set", body, "application/json")
+		return err
+	})
+}
+
+// batch groups the keys per shard and calls fn concurrently for every group. The groups that
+// succeed are not sent again when the others are retried.
+func (c *Client) batch(keys []string, fn func(s *shards.Shards, shard int, batch []string) error) error {
+	done := make(map[string]bool, len(keys))
+	var mu sync.Mutex
+
+	return c.retry(func(s *shards.Shards) error {
+		groups := make(map[int][]string)
+		for _, key := range keys {
+			if !done[key] {
+				shard := s.GetShardIndex(key)
+				groups[shard] = append(groups[shard], key)
+			}
+		}
+
+		var wg sync.WaitGroup
+		var first error
+		for shard, group := range groups {
+			for len(group) > 0 {
+				n := len(group)
+				if n > BatchSize {
+					n = BatchSize
+				}
+
+				wg.Add(1)
+				go func(shard int, batch []string) {
+					defer wg.Done()
+
+					err := fn(s, shard, batch)
+
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						if first == nil {
+							first = err
+						}
+						return
+					}
+
+					for _, key := range batch {
+						done[key] = true
+					}
+				}(shard, group[:n])
+
+				group = group[n:]
+			}
+		}
+
+		wg.Wait()
+		return first
+	})
+}
+
+// retry calls fn with the current shard map until it succeeds or the retries run out. A stale
+// shard map is refreshed from the server that reported it and the request is retried at once,
+// the transient errors are retried after the backoff.
+func (c *Client) retry(fn func(s *shards.Shards) error) error {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn(c.current())
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= c.Retries {
+			return err
+		}
+
+		var stale *staleError
+		if errors.As(err, &stale) {
+			if err := c.refreshFrom(stale.addr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !transient(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send sends the request to the shard with the epoch of the shard map and returns the body of a
+// successful response.
+func (c *Client) send(s *shards.Shards, shard int, method, path string, body []byte, contentType string) ([]byte, error) {
+	addr := s.Addresses[shard]
+	req, err := http.NewRequest(method, c.scheme+"://"+addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(shards.EpochHeader, strconv.FormatUint(s.Epoch, 10))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusMisdirectedRequest {
+		epoch, _ := strconv.ParseUint(resp.Header.Get(shards.EpochHeader), 10, 64)
+		return nil, &staleError{addr: addr, epoch: epoch}
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, responseError(resp.StatusCode, data)
+	}
+
+	return data, nil
+}
+
+// refreshFrom replaces the shard map with the shard map of the server at addr if it is newer.
+func (c *Client) refreshFrom(addr string) error {
+	resp, err := c.http.Get(c.scheme + "://" + addr + "/v1/shards")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp.StatusCode, data)
+	}
+
+	var conf shards.Config
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return err
+	}
+
+	s, err := conf.Cluster()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// another request could have already refreshed the shard map
+	if s.Epoch < c.shards.Epoch {
+		return fmt.Errorf("dkv: the shard at %s has an older epoch %d than the client %d", addr, s.Epoch, c.shards.Epoch)
+	}
+
+	if s.Epoch > c.shards.Epoch {
+		c.shards = s
+	}
+
+	return nil
+}
+
+func (c *Client) current() *shards.Shards {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.shards
+}
+
+// responseError returns the error of an error response, which is json for the /v1 routes.
+func responseError(status int, body []byte) error {
+	var res struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &res); err != nil || res.Error.Code == "" {
+		return &Error{StatusCode: status, Message: string(bytes.TrimSpace(body))}
+	}
+
+	if res.Error.Code == "not_found" {
+		return ErrNotFound
+	}
+
+	return &Error{StatusCode: status, Code: res.Error.Code, Message: res.Error.Message}
+}
+
+// transient reports whether the request can be retried.
+func transient(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+
+	var resErr *Error
+	if errors.As(err, &resErr) {
+		return resErr.StatusCode == http.StatusBadGateway || resErr.StatusCode == http.StatusGatewayTimeout
+	}
+
+	return false
+}
+
+func keyPath(key string) string {
+	return "/v1/kv/" + url.PathEscape(key)
+}
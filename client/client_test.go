@@ -0,0 +1,293 @@
+package client
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/handlers"
+	"github.com/nireo/dkv/shards"
+)
+
+type testCluster struct {
+	conf     *shards.Config
+	dbs      []*db.DB
+	servers  []*httptest.Server
+	requests []int64
+}
+
+// startCluster starts a server for every shard and returns the config of the cluster.
+func startCluster(t *testing.T, amount int, epoch uint64) *testCluster {
+	t.Helper()
+
+	tc := &testCluster{conf: &shards.Config{Epoch: epoch}, requests: make([]int64, amount)}
+	shardHandlers := make([]http.Handler, amount)
+	for i := 0; i < amount; i++ {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&tc.requests[i], 1)
+			shardHandlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(ts.Close)
+
+		tc.servers = append(tc.servers, ts)
+		tc.conf.Shards = append(tc.conf.Shards, shards.Shard{
+			Index:   i,
+			Name:    fmt.Sprintf("sh%d", i),
+			Address: strings.TrimPrefix(ts.URL, "http://"),
+		})
+	}
+
+	for i := 0; i < amount; i++ {
+		dir, err := ioutil.TempDir("", "dkv-client")
+		if err != nil {
+			t.Fatalf("could not create a temp directory: %s", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		d, err := db.NewDatabase(dir, false)
+		if err != nil {
+			t.Fatalf("could not create database: %s", err)
+		}
+		t.Cleanup(func() { d.Close() })
+
+		s, err := tc.conf.ParseConfigShards(fmt.Sprintf("sh%d", i))
+		if err != nil {
+			t.Fatalf("could not parse shards: %s", err)
+		}
+
+		tc.dbs = append(tc.dbs, d)
+		srv := handlers.NewServer(d, s)
+		mux := http.NewServeMux()
+		mux.Handle("/v1/", srv.V1())
+		mux.HandleFunc("/export", srv.Export)
+		mux.HandleFunc("/import", srv.Import)
+		mux.HandleFunc("/purge", srv.DeleteNotBelonging)
+		mux.HandleFunc("/admin/backup", srv.Backup)
+		mux.HandleFunc("/admin/restore", srv.Restore)
+		mux.HandleFunc("/admin/replication", srv.Replication)
+		shardHandlers[i] = mux
+	}
+
+	return tc
+}
+
+func (tc *testCluster) resetRequests() {
+	for i := range tc.requests {
+		atomic.StoreInt64(&tc.requests[i], 0)
+	}
+}
+
+func TestClientRouting(t *testing.T) {
+	tc := startCluster(t, 3, 1)
+	c, err := New(tc.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	cluster, _ := tc.conf.Cluster()
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key/%d", i)
+		tc.resetRequests()
+
+		if err := c.Set(key, []byte("value "+key)); err != nil {
+			t.Fatalf("could not set %q: %s", key, err)
+		}
+
+		value, err := c.Get(key)
+		if err != nil || string(value) != "value "+key {
+			t.Fatalf("wrong value for %q. got=%q, err=%v", key, value, err)
+		}
+
+		owner := cluster.GetShardIndex(key)
+		for shard := range tc.requests {
+			want := int64(0)
+			if shard == owner {
+				want = 2
+			}
+
+			if got := atomic.LoadInt64(&tc.requests[shard]); got != want {
+				t.Errorf("shard %d got %d requests for %q, want %d", shard, got, key, want)
+			}
+		}
+
+		if _, err := tc.dbs[owner].Get(key); err != nil {
+			t.Errorf("the key %q is not on its owner: %s", key, err)
+		}
+	}
+
+	if err := c.Delete("key/0"); err != nil {
+		t.Fatalf("could not delete: %s", err)
+	}
+
+	if _, err := c.Get("key/0"); err != ErrNotFound {
+		t.Errorf("wrong error for a deleted key. got=%v", err)
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	tc := startCluster(t, 3, 1)
+	c, err := New(tc.conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	values := make(map[string][]byte)
+	keys := []string{"missing"}
+	for i := 0; i < BatchSize+100; i++ {
+		key := fmt.Sprintf("batch%d", i)
+		values[key] = []byte{byte(i), 0, 0xff}
+		keys = append(keys, key)
+	}
+
+	tc.resetRequests()
+	if err := c.SetMany(values); err != nil {
+		t.Fatalf("could not set batch: %s", err)
+	}
+
+	// every shard gets its keys in at most two requests
+	for shard := range tc.requests {
+		if got := atomic.LoadInt64(&tc.requests[shard]); got == 0 || got > 2 {
+			t.Errorf("shard %d got %d batch requests", shard, got)
+		}
+	}
+
+	got, err := c.GetMany(keys)
+	if err != nil {
+		t.Fatalf("could not get batch: %s", err)
+	}
+
+	if len(got) != len(values) {
+		t.Fatalf("wrong amount of values. got=%d want=%d", len(got), len(values))
+	}
+
+	for key, value := range values {
+		if string(got[key]) != string(value) {
+			t.Errorf("wrong value for %q. got=%v", key, got[key])
+		}
+	}
+}
+
+func TestClientStaleEpoch(t *testing.T) {
+	tc := startCluster(t, 2, 2)
+
+	// the client has an old shard map where the shards are the other way around.
+	old := &shards.Config{Epoch: 1}
+	for _, shard := range tc.conf.Shards {
+		shard.Address = tc.conf.Shards[1-shard.Index].Address
+		old.Shards = append(old.Shards, shard)
+	}
+
+	c, err := New(old)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	if err := c.Set("key", []byte("value")); err != nil {
+		t.Fatalf("could not set with a stale shard map: %s", err)
+	}
+
+	if c.Epoch() != 2 {
+		t.Errorf("the shard map was not refreshed. epoch=%d", c.Epoch())
+	}
+
+	cluster, _ := tc.conf.Cluster()
+	if _, err := tc.dbs[cluster.GetShardIndex("key")].Get("key"); err != nil {
+		t.Errorf("the key is not on its owner: %s", err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	tc := startCluster(t, 1, 0)
+
+	// the first requests fail as if the shard was behind an unavailable proxy.
+	var failures int64 = 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&failures, -1) >= 0 {
+			http.Error(w, "unavailable", http.StatusBadGateway)
+			return
+		}
+
+		handlers.NewServer(tc.dbs[0], &shards.Shards{Amount: 1, Addresses: map[int]string{0: ""}}).V1().ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c, err := New(&shards.Config{Shards: []shards.Shard{{Address: strings.TrimPrefix(ts.URL, "http://")}}})
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+	c.Backoff = 0
+
+	if err := c.Set("key", []byte("value")); err != nil {
+		t.Fatalf("the request was not retried: %s", err)
+	}
+
+	atomic.StoreInt64(&failures, int64(c.Retries+1))
+	var resErr *Error
+	if _, err := c.Get("key"); !errors.As(err, &resErr) || resErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("wrong error after the retries ran out. got=%v", err)
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dkv-client")
+	if err != nil {
+		t.Fatalf("could not create a temp directory: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	d, err := db.NewDatabase(dir, false)
+	if err != nil {
+		t.Fatalf("could not create database: %s", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	mux := http.NewServeMux()
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	conf := &shards.Config{Shards: []shards.Shard{{Index: 0, Name: "sh0", Address: strings.TrimPrefix(ts.URL, "https://")}}}
+	s, err := conf.ParseConfigShards("sh0")
+	if err != nil {
+		t.Fatalf("could not parse shards: %s", err)
+	}
+	mux.Handle("/v1/", handlers.NewServer(d, s).V1())
+
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0644); err != nil {
+		t.Fatalf("could not write the CA: %s", err)
+	}
+
+	c, err := New(conf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	if err := c.Set("key", []byte("value")); err == nil {
+		t.Fatalf("the client reached an https server over http")
+	}
+
+	config, err := TLSConfig(ca)
+	if err != nil {
+		t.Fatalf("could not load the CA: %s", err)
+	}
+	c.UseTLS(config)
+
+	if err := c.Set("key", []byte("value")); err != nil {
+		t.Fatalf("could not set over https: %s", err)
+	}
+
+	if value, err := c.Get("key"); err != nil || string(value) != "value" {
+		t.Errorf("wrong value over https. got=%q, err=%v", value, err)
+	}
+}
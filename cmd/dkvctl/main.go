@@ -0,0 +1,441 @@
+// Command dkvctl manages a dkv cluster. It reads the shards of the cluster from the same shards
+// file as the servers and talks to the shards directly with the client package.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nireo/dkv/client"
+	"github.com/nireo/dkv/export"
+)
+
+const usage = `usage: dkvctl [flags] <command> [args]
+
+commands:
+  get <key>                     print the value of the key
+  set <key> [value]             set the value, which is read from stdin if it is not given
+  del <key>                     delete the key
+  scan [-bucket b] [-prefix p]  list the key-value pairs
+  buckets                       list the buckets
+  buckets create <name>         create a bucket on every shard
+  buckets drop <name>           drop a bucket from every shard
+  status                        show whether the shards are reachable
+  replication                   show the replication backlog of the shards
+  purge                         delete the keys that don't belong to the shards
+  backup <dir>                  write a full backup of every shard into the directory
+  restore <dir>                 restore the backups of a directory into empty shards
+  reshard <new shards file>     copy the keys into the cluster of the new shards file
+
+flags:
+`
+
+var (
+	configFile = flag.String("conf", "conf.json", "shards file of the cluster")
+	output     = flag.String("o", "table", "the output format: table or json")
+	useTLS     = flag.Bool("tls", false, "connect to the shards over https, verifying them with the system roots")
+	caFile     = flag.String("tls-ca", "", "the CA that is used to verify the shards, enables -tls")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("dkvctl: ")
+
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *output != "table" && *output != "json" {
+		log.Fatalf("unknown output format %q", *output)
+	}
+
+	c, err := newClient(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commands := map[string]func(*client.Client, []string) error{
+		"get":         get,
+		"set":         set,
+		"del":         del,
+		"scan":        scan,
+		"buckets":     buckets,
+		"status":      status,
+		"replication": replication,
+		"purge":       purge,
+		"backup":      backup,
+		"restore":     restore,
+		"reshard":     reshard,
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		log.Printf("unknown command %q", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(c, flag.Args()[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newClient returns a client for the cluster in the shards file, which connects over https if
+// -tls or -tls-ca is given.
+func newClient(path string) (*client.Client, error) {
+	c, err := client.NewFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if *useTLS || *caFile != "" {
+		config, err := client.TLSConfig(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.UseTLS(config)
+	}
+
+	return c, nil
+}
+
+func get(c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: get <key>")
+	}
+
+	value, err := c.Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		return printJSON(&export.Record{Key: args[0], Value: value})
+	}
+
+	_, err = os.Stdout.Write(value)
+	return err
+}
+
+func set(c *client.Client, args []string) error {
+	var value []byte
+	switch len(args) {
+	case 1:
+		var err error
+		if value, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	case 2:
+		value = []byte(args[1])
+	default:
+		return fmt.Errorf("usage: set <key> [value]")
+	}
+
+	return c.Set(args[0], value)
+}
+
+func del(c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: del <key>")
+	}
+
+	return c.Delete(args[0])
+}
+
+func scan(c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	bucket := fs.String("bucket", "", "the bucket that is scanned instead of the default bucket")
+	prefix := fs.String("prefix", "", "only list the keys that start with the prefix")
+	limit := fs.Int("limit", 0, "the largest amount of keys that is listed, 0 lists every key")
+	fs.Parse(args)
+
+	var records []*export.Record
+	errLimit := fmt.Errorf("limit reached")
+	err := c.Scan(*bucket, *prefix, func(shard int, rec *export.Record) error {
+		records = append(records, rec)
+		if *limit > 0 && len(records) >= *limit {
+			return errLimit
+		}
+		return nil
+	})
+
+	if err != nil && err != errLimit {
+		return err
+	}
+
+	if *output == "json" {
+		return printJSON(records)
+	}
+
+	rows := make([][]string, len(records))
+	for i, rec := range records {
+		rows[i] = []string{printable(rec.Key), printable(string(rec.Value))}
+	}
+
+	return printTable([]string{"KEY", "VALUE"}, rows)
+}
+
+func buckets(c *client.Client, args []string) error {
+	if len(args) == 0 {
+		names, err := c.Buckets()
+		if err != nil {
+			return err
+		}
+
+		if *output == "json" {
+			return printJSON(names)
+		}
+
+		rows := make([][]string, len(names))
+		for i, name := range names {
+			rows[i] = []string{name}
+		}
+
+		return printTable([]string{"BUCKET"}, rows)
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: buckets [create|drop <name>]")
+	}
+
+	switch args[0] {
+	case "create":
+		return c.CreateBucket(args[1])
+	case "drop":
+		return c.DropBucket(args[1])
+	}
+
+	return fmt.Errorf("unknown buckets command %q", args[0])
+}
+
+func status(c *client.Client, args []string) error {
+	statuses := c.Status()
+	if *output == "json" {
+		return printJSON(statuses)
+	}
+
+	rows := make([][]string, len(statuses))
+	for i, s := range statuses {
+		state := "up"
+		if !s.Reachable {
+			state = "down: " + s.Error
+		} else if s.Epoch != c.Epoch() {
+			state = fmt.Sprintf("epoch differs from the shards file (%d)", c.Epoch())
+		}
+
+		rows[i] = []string{strconv.Itoa(s.Index), s.Address, strconv.FormatUint(s.Epoch, 10),
+			s.Latency.Round(time.Microsecond).String(), state}
+	}
+
+	return printTable([]string{"SHARD", "ADDRESS", "EPOCH", "LATENCY", "STATUS"}, rows)
+}
+
+func replication(c *client.Client, args []string) error {
+	statuses := c.Replication()
+	if *output == "json" {
+		return printJSON(statuses)
+	}
+
+	rows := make([][]string, len(statuses))
+	for i, s := range statuses {
+		backlog := strconv.Itoa(s.Backlog)
+		if s.Error != "" {
+			backlog = "error: " + s.Error
+		}
+
+		rows[i] = []string{strconv.Itoa(s.Index), s.Address, strconv.FormatBool(s.ReadOnly), backlog}
+	}
+
+	return printTable([]string{"SHARD", "ADDRESS", "READ-ONLY", "BACKLOG"}, rows)
+}
+
+func purge(c *client.Client, args []string) error {
+	return c.Purge()
+}
+
+// backupPath returns the path of the backup of the shard in the directory.
+func backupPath(dir string, shard int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%d.dkvb", shard))
+}
+
+func backup(c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: backup <dir>")
+	}
+
+	if err := os.MkdirAll(args[0], 0755); err != nil {
+		return err
+	}
+
+	for shard := 0; shard < c.Shards().Amount; shard++ {
+		f, err := os.Create(backupPath(args[0], shard))
+		if err != nil {
+			return err
+		}
+
+		err = c.Backup(shard, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not back up shard %d: %s", shard, err)
+		}
+
+		log.Printf("backed up shard %d into %s", shard, f.Name())
+	}
+
+	return nil
+}
+
+func restore(c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: restore <dir>")
+	}
+
+	for shard := 0; shard < c.Shards().Amount; shard++ {
+		f, err := os.Open(backupPath(args[0], shard))
+		if err != nil {
+			return err
+		}
+
+		err = c.Restore(shard, f)
+		f.Close()
+
+		if err != nil {
+			return fmt.Errorf("could not restore shard %d: %s", shard, err)
+		}
+
+		log.Printf("restored shard %d from %s", shard, f.Name())
+	}
+
+	return nil
+}
+
+// reshard copies the keys of the default bucket and of the user created buckets from the current
+// cluster into the cluster of the new shards file. After the servers have been restarted with the
+// new shards file the keys that moved can be removed with purge.
+func reshard(c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: reshard <new shards file>")
+	}
+
+	to, err := newClient(args[0])
+	if err != nil {
+		return err
+	}
+
+	buckets, err := c.Buckets()
+	if err != nil {
+		return err
+	}
+
+	copied := 0
+	for _, bucket := range append([]string{""}, buckets...) {
+		// the new shards don't know about the buckets and the old servers would broadcast the
+		// bucket with their old shard map, so it is created on every shard separately.
+		if bucket != "" {
+			if err := to.EnsureBucket(bucket); err != nil {
+				return fmt.Errorf("could not create bucket %s: %s", bucket, err)
+			}
+		}
+
+		n, err := copyBucket(c, to, bucket)
+		copied += n
+		if err != nil {
+			return fmt.Errorf("resharding stopped after %d keys: %s", copied, err)
+		}
+	}
+
+	log.Printf("copied %d keys from %d buckets, run purge once the servers use the new shards file",
+		copied, len(buckets)+1)
+	return nil
+}
+
+// copyBucket copies the keys of the bucket from one cluster into the other in batches and returns
+// the amount of copied keys.
+func copyBucket(from, to *client.Client, bucket string) (int, error) {
+	copied := 0
+	var batch []*export.Record
+	flush := func() error {
+		if err := to.Import(bucket, batch); err != nil {
+			return err
+		}
+
+		copied += len(batch)
+		batch = nil
+		return nil
+	}
+
+	err := from.Scan(bucket, "", func(shard int, rec *export.Record) error {
+		batch = append(batch, rec)
+		if len(batch) >= client.BatchSize {
+			return flush()
+		}
+		return nil
+	})
+
+	if err == nil {
+		err = flush()
+	}
+
+	return copied, err
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printTable(headers []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	writeRow(w, headers)
+	for _, row := range rows {
+		writeRow(w, row)
+	}
+
+	return w.Flush()
+}
+
+func writeRow(w *tabwriter.Writer, cols []string) {
+	for i, col := range cols {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, col)
+	}
+	fmt.Fprintln(w)
+}
+
+// printable quotes the strings that are not valid UTF-8 or contain control characters, such that
+// binary values don't break the table.
+func printable(s string) string {
+	if !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+
+	for _, r := range s {
+		if r < ' ' || r == 0x7f {
+			return strconv.Quote(s)
+		}
+	}
+
+	return s
+}
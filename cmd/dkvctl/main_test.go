@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/nireo/dkv/client"
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/handlers"
+	"github.com/nireo/dkv/shards"
+)
+
+type testNode struct {
+	addr string
+	db   *db.DB
+
+	// ts is started once the shard map of the node is known.
+	ts *httptest.Server
+}
+
+func newNode(t *testing.T) *testNode {
+	t.Helper()
+
+	d, err := db.NewDatabase(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("could not create database: %s", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	ts := httptest.NewUnstartedServer(nil)
+	t.Cleanup(ts.Close)
+
+	return &testNode{addr: ts.Listener.Addr().String(), db: d, ts: ts}
+}
+
+// start starts the node as the shard name of the shard map.
+func (n *testNode) start(t *testing.T, conf *shards.Config, name string) {
+	t.Helper()
+
+	s, err := conf.ParseConfigShards(name)
+	if err != nil {
+		t.Fatalf("could not parse shards: %s", err)
+	}
+
+	srv := handlers.NewServer(n.db, s)
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", srv.V1())
+	mux.HandleFunc("/export", srv.Export)
+	mux.HandleFunc("/import", srv.Import)
+	n.ts.Config.Handler = mux
+	n.ts.Start()
+}
+
+func shardConfig(epoch uint64, nodes ...*testNode) *shards.Config {
+	conf := &shards.Config{Epoch: epoch}
+	for i, n := range nodes {
+		conf.Shards = append(conf.Shards, shards.Shard{Index: i, Name: fmt.Sprintf("sh%d", i), Address: n.addr})
+	}
+
+	return conf
+}
+
+func writeShards(t *testing.T, conf *shards.Config) string {
+	t.Helper()
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatalf("could not encode shards: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "conf.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("could not write shards: %s", err)
+	}
+
+	return path
+}
+
+func TestReshardUserBuckets(t *testing.T) {
+	old, added := newNode(t), newNode(t)
+
+	// the old node keeps its old shard map like it would until it is restarted, and the added
+	// node already uses the new one.
+	oldConf := shardConfig(1, old)
+	newConf := shardConfig(2, old, added)
+	old.start(t, oldConf, "sh0")
+	added.start(t, newConf, "sh1")
+
+	c, err := client.New(oldConf)
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+
+	if err := c.CreateBucket("users"); err != nil {
+		t.Fatalf("could not create bucket: %s", err)
+	}
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("user%02d", i)
+		keys = append(keys, key)
+		if err := old.db.Bucket("users").Set([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("could not put %s: %s", key, err)
+		}
+	}
+
+	if err := reshard(c, []string{writeShards(t, newConf)}); err != nil {
+		t.Fatalf("could not reshard: %s", err)
+	}
+
+	s, err := newConf.Cluster()
+	if err != nil {
+		t.Fatalf("could not parse shards: %s", err)
+	}
+
+	nodes := []*testNode{old, added}
+	moved := 0
+	for _, key := range keys {
+		shard := s.GetShardIndex(key)
+		if shard == 1 {
+			moved++
+		}
+
+		value, err := nodes[shard].db.Bucket("users").Get([]byte(key))
+		if err != nil || string(value) != key {
+			t.Errorf("%s was not copied into shard %d. got=%q, err=%v", key, shard, value, err)
+		}
+	}
+
+	if moved == 0 {
+		t.Fatalf("none of the keys moved into the added shard")
+	}
+
+	// the bucket already exists on every shard, so resharding again succeeds.
+	if err := reshard(c, []string{writeShards(t, newConf)}); err != nil {
+		t.Fatalf("could not reshard again: %s", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nireo/dkv/config"
+)
+
+// loadConfig parses the flags of the node from args and returns the config of the node. The config
+// file is given with -config or DKV_CONFIG, after which the environment variables and the flags
+// override it.
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Config, error) {
+	path := fs.String("config", os.Getenv("DKV_CONFIG"), "the yaml or json config file of the node")
+	config.Flags(fs)
+	fs.Parse(args)
+
+	conf := config.Default()
+	if *path != "" {
+		var err error
+		if conf, err = config.Load(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := conf.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := conf.ApplyFlags(fs); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// configCommand runs the config subcommands. validate checks the config of a node, including the
+// overrides of the environment and the flags, and exits with 1 if it is invalid.
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "validate" {
+		log.Fatal("usage: dkv config validate [-config file] [flags]")
+	}
+
+	conf, err := loadConfig(flag.NewFlagSet("config validate", flag.ExitOnError), args[1:])
+	if err == nil {
+		err = conf.Validate()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("config is valid: shard %s as %s at %s\n", conf.Shard, conf.Role, conf.Listen.HTTP)
+}
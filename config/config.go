@@ -0,0 +1,405 @@
+// Package config loads the configuration of a dkv node. The config is read from a yaml or json
+// file and every option can be overridden with an environment variable and a command line flag,
+// in that order of precedence.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/handlers"
+	"github.com/nireo/dkv/server"
+	"github.com/nireo/dkv/shards"
+	"gopkg.in/yaml.v2"
+)
+
+// The roles of a node.
+const (
+	RoleMaster  = "master"
+	RoleReplica = "replica"
+)
+
+// Config is the configuration of a node. The shard map is either given inline in Cluster or read
+// from ShardsFile.
+type Config struct {
+	// Shard is the name of the shard of this node in the shard map.
+	Shard    string `yaml:"shard" json:"shard"`
+	Role     string `yaml:"role" json:"role"`
+	ReadOnly bool   `yaml:"read_only" json:"read_only"`
+	Redirect bool   `yaml:"redirect" json:"redirect"`
+
+	Listen   Listen   `yaml:"listen" json:"listen"`
+	Storage  Storage  `yaml:"storage" json:"storage"`
+	Timeouts Timeouts `yaml:"timeouts" json:"timeouts"`
+	TLS      TLS      `yaml:"tls" json:"tls"`
+	Limits   Limits   `yaml:"limits" json:"limits"`
+	CDC      CDC      `yaml:"cdc" json:"cdc"`
+
+	ShardsFile string         `yaml:"shards_file" json:"shards_file"`
+	Cluster    *shards.Config `yaml:"cluster" json:"cluster"`
+}
+
+// Listen contains the addresses of the apis. The listeners other than http are disabled when
+// their address is empty.
+type Listen struct {
+	HTTP     string `yaml:"http" json:"http"`
+	GRPC     string `yaml:"grpc" json:"grpc"`
+	Redis    string `yaml:"redis" json:"redis"`
+	Memcache string `yaml:"memcache" json:"memcache"`
+}
+
+// Storage contains the settings of the database.
+type Storage struct {
+	Path      string `yaml:"path" json:"path"`
+	Engine    string `yaml:"engine" json:"engine"`
+	ChangeLog bool   `yaml:"changelog" json:"changelog"`
+}
+
+// Timeouts contains the timeouts of the node, which are written like 30s or 1m30s.
+type Timeouts struct {
+	Shutdown      Duration `yaml:"shutdown" json:"shutdown"`
+	MaxReplicaLag Duration `yaml:"max_replica_lag" json:"max_replica_lag"`
+	ReadHeader    Duration `yaml:"read_header" json:"read_header"`
+	Idle          Duration `yaml:"idle" json:"idle"`
+}
+
+// TLS contains the certificate of the node and the CA that is used to verify the other nodes.
+type TLS struct {
+	CertFile string `yaml:"cert_file" json:"cert_file"`
+	KeyFile  string `yaml:"key_file" json:"key_file"`
+	CAFile   string `yaml:"ca_file" json:"ca_file"`
+}
+
+// Limits contains the size limits of the requests in bytes.
+type Limits struct {
+	MaxValueSize   int64 `yaml:"max_value_size" json:"max_value_size"`
+	MaxHeaderBytes int   `yaml:"max_header_bytes" json:"max_header_bytes"`
+}
+
+// CDC contains the change data capture sinks, which are disabled when File or Webhook is empty.
+type CDC struct {
+	File      string `yaml:"file" json:"file"`
+	FileSize  int64  `yaml:"file_size" json:"file_size"`
+	FileCount int    `yaml:"file_count" json:"file_count"`
+	Webhook   string `yaml:"webhook" json:"webhook"`
+}
+
+// Duration is a time.Duration that is written as a string like 30s in the config file.
+type Duration time.Duration
+
+func parseDuration(s string) (Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use a value like 30s or 1m30s", s)
+	}
+
+	return Duration(d), nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// UnmarshalYAML parses the duration from a yaml string.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	var err error
+	*d, err = parseDuration(s)
+	return err
+}
+
+// UnmarshalJSON parses the duration from a json string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s, use a string like \"30s\"", data)
+	}
+
+	var err error
+	*d, err = parseDuration(s)
+	return err
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Default returns the config with the default values of every option.
+func Default() *Config {
+	return &Config{
+		Role:       RoleMaster,
+		Listen:     Listen{HTTP: "localhost:8080"},
+		Storage:    Storage{Engine: db.EngineLevelDB},
+		ShardsFile: "conf.json",
+		Timeouts: Timeouts{
+			Shutdown:      Duration(server.DefaultShutdownTimeout),
+			MaxReplicaLag: Duration(handlers.DefaultMaxReplicaLag),
+		},
+		Limits: Limits{MaxValueSize: handlers.MaxValueSize},
+		CDC:    CDC{FileSize: 64 << 20, FileCount: 5},
+	}
+}
+
+// Load reads the config file at path on top of the defaults. The format is chosen by the
+// extension of the file, which is .yaml, .yml or .json, and unknown fields are errors.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %s", err)
+	}
+
+	c := Default()
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		return nil, fmt.Errorf("unknown config format %q, the file must end with .yaml, .yml or .json", ext)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// Errors contains every problem that was found in a config.
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// errorList returns errs as an error, or nil if there are no errors.
+func errorList(errs Errors) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// Validate checks the whole config, including the shard map and the TLS files, and returns
+// Errors with every problem that was found.
+func (c *Config) Validate() error {
+	_, err := c.Node()
+	return err
+}
+
+// Node validates the config and returns the config of the node that it describes. The shard map
+// and the TLS files are read here.
+func (c *Config) Node() (server.Config, error) {
+	var errs Errors
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, describe(field)+": "+fmt.Sprintf(format, args...))
+	}
+
+	node := server.Config{
+		DBPath:            c.Storage.Path,
+		Engine:            c.Storage.Engine,
+		ReadOnly:          c.ReadOnly,
+		Replica:           c.Role == RoleReplica,
+		ChangeLog:         c.Storage.ChangeLog,
+		Redirect:          c.Redirect,
+		Addr:              c.Listen.HTTP,
+		GRPCAddr:          c.Listen.GRPC,
+		RedisAddr:         c.Listen.Redis,
+		MemcacheAddr:      c.Listen.Memcache,
+		CDCFile:           c.CDC.File,
+		CDCFileSize:       c.CDC.FileSize,
+		CDCFileCount:      c.CDC.FileCount,
+		CDCWebhook:        c.CDC.Webhook,
+		MaxReplicaLag:     time.Duration(c.Timeouts.MaxReplicaLag),
+		ShutdownTimeout:   time.Duration(c.Timeouts.Shutdown),
+		ReadHeaderTimeout: time.Duration(c.Timeouts.ReadHeader),
+		IdleTimeout:       time.Duration(c.Timeouts.Idle),
+		MaxHeaderBytes:    c.Limits.MaxHeaderBytes,
+		MaxValueSize:      c.Limits.MaxValueSize,
+	}
+
+	if c.Role != RoleMaster && c.Role != RoleReplica {
+		fail("role", "unknown role %q, it must be %s or %s", c.Role, RoleMaster, RoleReplica)
+	}
+
+	if c.Storage.Path == "" {
+		fail("storage.path", "the database path is required")
+	} else if err := db.CheckPath(c.Storage.Path); err != nil {
+		fail("storage.path", "%s", err)
+	}
+
+	switch c.Storage.Engine {
+	case db.EngineLevelDB, db.EngineMemory, db.EngineBolt:
+	default:
+		fail("storage.engine", "unknown engine %q, it must be %s, %s or %s", c.Storage.Engine,
+			db.EngineLevelDB, db.EngineMemory, db.EngineBolt)
+	}
+
+	// the addresses are checked for typos and for listeners that would collide
+	if c.Listen.HTTP == "" {
+		fail("listen.http", "the http address is required")
+	}
+	used := make(map[string]string)
+	for _, l := range []struct{ field, addr string }{
+		{"listen.http", c.Listen.HTTP},
+		{"listen.grpc", c.Listen.GRPC},
+		{"listen.redis", c.Listen.Redis},
+		{"listen.memcache", c.Listen.Memcache},
+	} {
+		if l.addr == "" {
+			continue
+		}
+
+		_, port, err := net.SplitHostPort(l.addr)
+		if err != nil {
+			fail(l.field, "invalid address %q, use host:port", l.addr)
+			continue
+		}
+
+		if other, ok := used[l.addr]; ok && port != "0" {
+			fail(l.field, "the address %s is already used by %s", l.addr, other)
+		}
+		used[l.addr] = l.field
+	}
+
+	for _, d := range []struct {
+		field string
+		value Duration
+	}{
+		{"timeouts.shutdown", c.Timeouts.Shutdown},
+		{"timeouts.max_replica_lag", c.Timeouts.MaxReplicaLag},
+		{"timeouts.read_header", c.Timeouts.ReadHeader},
+		{"timeouts.idle", c.Timeouts.Idle},
+	} {
+		if d.value < 0 {
+			fail(d.field, "the timeout cannot be negative")
+		}
+	}
+
+	if c.Limits.MaxValueSize < 0 {
+		fail("limits.max_value_size", "the limit cannot be negative")
+	}
+	if c.Limits.MaxHeaderBytes < 0 {
+		fail("limits.max_header_bytes", "the limit cannot be negative")
+	}
+
+	if c.CDC.File != "" {
+		if c.CDC.FileSize <= 0 {
+			fail("cdc.file_size", "the size must be positive")
+		}
+		if c.CDC.FileCount <= 0 {
+			fail("cdc.file_count", "at least one file must be kept")
+		}
+	}
+
+	if c.CDC.Webhook != "" {
+		if u, err := url.Parse(c.CDC.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("cdc.webhook", "invalid url %q, use an http or https url", c.CDC.Webhook)
+		}
+	}
+
+	var err error
+	node.TLS, node.ClientTLS, err = c.TLS.load()
+	if err != nil {
+		fail("tls", "%s", err)
+	}
+
+	node.Shards, err = c.shards()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	return node, errorList(errs)
+}
+
+// load returns the server and the client configs of the certificates, which are nil if TLS is
+// disabled.
+func (t *TLS) load() (*tls.Config, *tls.Config, error) {
+	if t.CertFile == "" && t.KeyFile == "" {
+		if t.CAFile != "" {
+			return nil, nil, fmt.Errorf("ca_file requires cert_file and key_file")
+		}
+		return nil, nil, nil
+	}
+
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, nil, fmt.Errorf("cert_file and key_file must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load the key pair: %s", err)
+	}
+
+	client := &tls.Config{}
+	if t.CAFile != "" {
+		data, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read the CA: %s", err)
+		}
+
+		client.RootCAs = x509.NewCertPool()
+		if !client.RootCAs.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("no certificates were found in %s", t.CAFile)
+		}
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, client, nil
+}
+
+// shards returns the shards of the node from the inline shard map or from the shards file.
+func (c *Config) shards() (*shards.Shards, error) {
+	conf, source := c.Cluster, "cluster"
+	if conf == nil {
+		if c.ShardsFile == "" {
+			return nil, fmt.Errorf("%s: the shard map is missing, set cluster or shards_file", describe("cluster"))
+		}
+
+		var err error
+		if conf, err = shards.ParseConfigFile(c.ShardsFile); err != nil {
+			return nil, fmt.Errorf("%s: %s", describe("shards_file"), err)
+		}
+		source = "shards_file"
+	}
+
+	if _, err := conf.Cluster(); err != nil {
+		return nil, fmt.Errorf("%s: %s", describe(source), err)
+	}
+
+	if c.Shard == "" {
+		return nil, fmt.Errorf("%s: the name of the shard of this node is required", describe("shard"))
+	}
+
+	s, err := conf.ParseConfigShards(c.Shard)
+	if err != nil {
+		names := make([]string, len(conf.Shards))
+		for i, shard := range conf.Shards {
+			names[i] = shard.Name
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("%s: the shard %q is not in the shard map, the shards are %s",
+			describe("shard"), c.Shard, strings.Join(names, ", "))
+	}
+
+	return s, nil
+}
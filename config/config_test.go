@@ -0,0 +1,219 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/config"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "dkvconfig")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("could not write file: %s", err)
+	}
+
+	return path
+}
+
+const yamlConfig = `
+shard: sh2
+role: replica
+listen:
+  http: localhost:8081
+  redis: localhost:6380
+storage:
+  path: sh2.db
+  engine: bolt
+timeouts:
+  shutdown: 5s
+limits:
+  max_value_size: 1024
+cluster:
+  epoch: 3
+  shards:
+    - name: sh1
+      index: 0
+      address: localhost:8080
+    - name: sh2
+      index: 1
+      address: localhost:8081
+      redis_address: localhost:6380
+`
+
+const jsonConfig = `{
+	"shard": "sh2",
+	"role": "replica",
+	"listen": {"http": "localhost:8081", "redis": "localhost:6380"},
+	"storage": {"path": "sh2.db", "engine": "bolt"},
+	"timeouts": {"shutdown": "5s"},
+	"limits": {"max_value_size": 1024},
+	"cluster": {"epoch": 3, "shards": [
+		{"name": "sh1", "index": 0, "address": "localhost:8080"},
+		{"name": "sh2", "index": 1, "address": "localhost:8081", "redis_address": "localhost:6380"}
+	]}
+}`
+
+func TestLoad(t *testing.T) {
+	for _, file := range []struct{ name, data string }{
+		{"node.yaml", yamlConfig},
+		{"node.json", jsonConfig},
+	} {
+		conf, err := config.Load(writeFile(t, file.name, file.data))
+		if err != nil {
+			t.Fatalf("could not load %s: %s", file.name, err)
+		}
+
+		node, err := conf.Node()
+		if err != nil {
+			t.Fatalf("%s is not valid: %s", file.name, err)
+		}
+
+		if !node.Replica || node.DBPath != "sh2.db" || node.Engine != "bolt" || node.Addr != "localhost:8081" ||
+			node.RedisAddr != "localhost:6380" || node.ShutdownTimeout != 5*time.Second || node.MaxValueSize != 1024 {
+			t.Errorf("%s: wrong node config: %+v", file.name, node)
+		}
+
+		if node.Shards.Index != 1 || node.Shards.Amount != 2 || node.Shards.Epoch != 3 || node.Shards.RedisAddresses[1] != "localhost:6380" {
+			t.Errorf("%s: wrong shards: %+v", file.name, node.Shards)
+		}
+
+		// the options that are not in the file have their defaults
+		if node.CDCFileCount != 5 || node.MaxReplicaLag != 10*time.Second {
+			t.Errorf("%s: the defaults were not kept: %+v", file.name, node)
+		}
+	}
+}
+
+func TestLoadStrict(t *testing.T) {
+	for _, file := range []struct{ name, data, want string }{
+		{"node.yaml", "listen:\n  htp: localhost:8080\n", "htp"},
+		{"node.json", `{"storage": {"pth": "x"}}`, "pth"},
+		{"node.yaml", "timeouts:\n  shutdown: 5\n", "invalid duration"},
+		{"node.toml", "shard = 'sh1'", "unknown config format"},
+	} {
+		_, err := config.Load(writeFile(t, file.name, file.data))
+		if err == nil || !strings.Contains(err.Error(), file.want) {
+			t.Errorf("%s %q: got error %v, want it to mention %q", file.name, file.data, err, file.want)
+		}
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	conf, err := config.Load(writeFile(t, "node.yaml", yamlConfig))
+	if err != nil {
+		t.Fatalf("could not load config: %s", err)
+	}
+
+	env := map[string]string{"DKV_DB": "env.db", "DKV_ADDR": "localhost:9000", "DKV_REPLICA": "false"}
+	err = conf.ApplyEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatalf("could not apply env: %s", err)
+	}
+
+	// the flags override the environment
+	fs := flag.NewFlagSet("dkv", flag.ContinueOnError)
+	config.Flags(fs)
+	if err := fs.Parse([]string{"-db", "flag.db", "-shutdown-timeout", "1m", "-ronly"}); err != nil {
+		t.Fatalf("could not parse flags: %s", err)
+	}
+	if err := conf.ApplyFlags(fs); err != nil {
+		t.Fatalf("could not apply flags: %s", err)
+	}
+
+	node, err := conf.Node()
+	if err != nil {
+		t.Fatalf("config is not valid: %s", err)
+	}
+
+	if node.DBPath != "flag.db" || node.Addr != "localhost:9000" || node.Replica || !node.ReadOnly ||
+		node.ShutdownTimeout != time.Minute || node.Engine != "bolt" {
+		t.Errorf("wrong node config: %+v", node)
+	}
+
+	err = conf.ApplyEnv(func(key string) (string, bool) {
+		if key == "DKV_CDC_FILE_COUNT" {
+			return "many", true
+		}
+		return "", false
+	})
+	if err == nil || !strings.Contains(err.Error(), "DKV_CDC_FILE_COUNT") {
+		t.Errorf("invalid env value was not reported: %v", err)
+	}
+
+	if err := fs.Parse([]string{"-max-replica-lag", "soon"}); err == nil {
+		t.Errorf("invalid flag value was accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	conf := config.Default()
+	conf.Role = "leader"
+	conf.Storage.Engine = "rocks"
+	conf.Listen.GRPC = conf.Listen.HTTP
+	conf.Listen.Redis = "6379"
+	conf.Timeouts.Idle = config.Duration(-time.Second)
+	conf.TLS.KeyFile = "key.pem"
+	conf.CDC.Webhook = "localhost/changes"
+	conf.ShardsFile = writeFile(t, "conf.json", `{"shards": [{"name": "sh1", "index": 0, "address": "localhost:8080"}]}`)
+	conf.Shard = "sh2"
+
+	err := conf.Validate()
+	errs, ok := err.(config.Errors)
+	if !ok {
+		t.Fatalf("want config.Errors, got %v", err)
+	}
+
+	want := []string{
+		"role (-replica, DKV_REPLICA)",
+		"storage.path (-db, DKV_DB): the database path is required",
+		"storage.engine (-engine, DKV_ENGINE)",
+		"listen.grpc (-grpc-addr, DKV_GRPC_ADDR): the address localhost:8080 is already used by listen.http",
+		"listen.redis (-redis-addr, DKV_REDIS_ADDR): invalid address",
+		"timeouts.idle (-idle-timeout, DKV_IDLE_TIMEOUT)",
+		"cdc.webhook (-cdc-webhook, DKV_CDC_WEBHOOK)",
+		"tls: cert_file and key_file must be set together",
+		`shard (-shards, DKV_SHARDS): the shard "sh2" is not in the shard map, the shards are sh1`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("want %d errors, got %d:\n%s", len(want), len(errs), err)
+	}
+
+	for i := range want {
+		if !strings.HasPrefix(errs[i], want[i]) {
+			t.Errorf("error %d: want prefix %q, got %q", i, want[i], errs[i])
+		}
+	}
+
+	conf = config.Default()
+	conf.Storage.Path = "mem:///var/lib/dkv/cache.snap?interval=0s"
+	conf.ShardsFile = writeFile(t, "conf.json", `{"shards": [{"name": "sh1", "index": 0, "address": "localhost:8080"}]}`)
+	conf.Shard = "sh1"
+	if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "storage.path (-db, DKV_DB): invalid snapshot interval") {
+		t.Errorf("invalid snapshot interval was not reported: %v", err)
+	}
+
+	conf = config.Default()
+	conf.Storage.Path = "sh1.db"
+	conf.ShardsFile = filepath.Join(os.TempDir(), "dkv-missing-conf.json")
+	conf.Shard = "sh1"
+	if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "shards_file (-conf, DKV_CONF)") {
+		t.Errorf("missing shards file was not reported: %v", err)
+	}
+}
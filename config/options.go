@@ -0,0 +1,253 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// option is a setting that can be overridden with a flag and an environment variable. The name of
+// the environment variable is the flag name in upper case with the dashes replaced by underscores
+// and the DKV_ prefix, e.g. -grpc-addr is DKV_GRPC_ADDR.
+type option struct {
+	flag  string
+	field string // the path of the field in the config file
+	usage string
+	value func(c *Config) interface{}
+}
+
+// replicaFlag sets the role with a boolean flag.
+type replicaFlag struct{ role *string }
+
+var options = []option{
+	{"db", "storage.path", "path to the database, mem:// or mem:///path/to/snapshot?interval=1m uses the memory engine",
+		func(c *Config) interface{} { return &c.Storage.Path }},
+	{"engine", "storage.engine", "the storage engine: leveldb, memory or bolt",
+		func(c *Config) interface{} { return &c.Storage.Engine }},
+	{"changelog", "storage.changelog", "record every write into the change log for incremental backups",
+		func(c *Config) interface{} { return &c.Storage.ChangeLog }},
+	{"addr", "listen.http", "address where the server will be hosted",
+		func(c *Config) interface{} { return &c.Listen.HTTP }},
+	{"grpc-addr", "listen.grpc", "address of the gRPC api, the gRPC api is disabled by default",
+		func(c *Config) interface{} { return &c.Listen.GRPC }},
+	{"redis-addr", "listen.redis", "address of the redis protocol listener, disabled by default",
+		func(c *Config) interface{} { return &c.Listen.Redis }},
+	{"memcache-addr", "listen.memcache", "address of the memcached protocol listener, disabled by default",
+		func(c *Config) interface{} { return &c.Listen.Memcache }},
+	{"conf", "shards_file", "shards file for shards, used when the config has no cluster",
+		func(c *Config) interface{} { return &c.ShardsFile }},
+	{"shards", "shard", "the shard of this node in the shard map",
+		func(c *Config) interface{} { return &c.Shard }},
+	{"ronly", "read_only", "set the database into read-only mode",
+		func(c *Config) interface{} { return &c.ReadOnly }},
+	{"replica", "role", "run as read-only replica server",
+		func(c *Config) interface{} { return replicaFlag{&c.Role} }},
+	{"redirect", "redirect", "redirect the requests for keys on other shards with 307 instead of proxying them",
+		func(c *Config) interface{} { return &c.Redirect }},
+	{"cdc-file", "cdc.file", "write the changes as json lines into the file, enables the change log",
+		func(c *Config) interface{} { return &c.CDC.File }},
+	{"cdc-file-size", "cdc.file_size", "rotate the cdc file when it would grow over the size in bytes",
+		func(c *Config) interface{} { return &c.CDC.FileSize }},
+	{"cdc-file-count", "cdc.file_count", "the amount of rotated cdc files to keep",
+		func(c *Config) interface{} { return &c.CDC.FileCount }},
+	{"cdc-webhook", "cdc.webhook", "post the changes as json lines to the url, enables the change log",
+		func(c *Config) interface{} { return &c.CDC.Webhook }},
+	{"max-replica-lag", "timeouts.max_replica_lag", "the replication lag after which a replica is not ready",
+		func(c *Config) interface{} { return &c.Timeouts.MaxReplicaLag }},
+	{"shutdown-timeout", "timeouts.shutdown", "how long the requests in flight are waited for when shutting down",
+		func(c *Config) interface{} { return &c.Timeouts.Shutdown }},
+	{"read-header-timeout", "timeouts.read_header", "how long the http server waits for the request headers, unlimited by default",
+		func(c *Config) interface{} { return &c.Timeouts.ReadHeader }},
+	{"idle-timeout", "timeouts.idle", "how long idle http connections are kept open, unlimited by default",
+		func(c *Config) interface{} { return &c.Timeouts.Idle }},
+	{"tls-cert", "tls.cert_file", "the certificate of the node, enables TLS for the http and gRPC apis",
+		func(c *Config) interface{} { return &c.TLS.CertFile }},
+	{"tls-key", "tls.key_file", "the private key of the certificate",
+		func(c *Config) interface{} { return &c.TLS.KeyFile }},
+	{"tls-ca", "tls.ca_file", "the CA that is used to verify the other nodes, the system roots by default",
+		func(c *Config) interface{} { return &c.TLS.CAFile }},
+	{"max-value-size", "limits.max_value_size", "the largest value in bytes that can be written",
+		func(c *Config) interface{} { return &c.Limits.MaxValueSize }},
+	{"max-header-bytes", "limits.max_header_bytes", "the largest request headers in bytes, 1MB by default",
+		func(c *Config) interface{} { return &c.Limits.MaxHeaderBytes }},
+}
+
+// lookup returns the option of the flag.
+func lookup(name string) (*option, bool) {
+	for i := range options {
+		if options[i].flag == name {
+			return &options[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// envName returns the environment variable of the flag.
+func envName(flag string) string {
+	return "DKV_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// describe returns the field with the flag and the environment variable that set it, such that
+// the errors tell every way of fixing the field.
+func describe(field string) string {
+	for _, o := range options {
+		if o.field == field {
+			return fmt.Sprintf("%s (-%s, %s)", field, o.flag, envName(o.flag))
+		}
+	}
+
+	return field
+}
+
+// Set sets the option of the flag from its string value.
+func (c *Config) Set(name, value string) error {
+	o, ok := lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown option %s", name)
+	}
+
+	switch p := o.value(c).(type) {
+	case *string:
+		*p = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*p = b
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*p = n
+	case *int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*p = n
+	case *Duration:
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = d
+	case replicaFlag:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+
+		*p.role = RoleMaster
+		if b {
+			*p.role = RoleReplica
+		}
+	}
+
+	return nil
+}
+
+// get returns the option of the flag as a string.
+func (c *Config) get(o *option) string {
+	switch p := o.value(c).(type) {
+	case *string:
+		return *p
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *int:
+		return strconv.Itoa(*p)
+	case *int64:
+		return strconv.FormatInt(*p, 10)
+	case *Duration:
+		return p.String()
+	case replicaFlag:
+		return strconv.FormatBool(*p.role == RoleReplica)
+	}
+
+	return ""
+}
+
+// ApplyEnv overrides the config with the environment variables that are set.
+func (c *Config) ApplyEnv(lookupEnv func(key string) (string, bool)) error {
+	var errs Errors
+	for _, o := range options {
+		value, ok := lookupEnv(envName(o.flag))
+		if !ok {
+			continue
+		}
+
+		if err := c.Set(o.flag, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", envName(o.flag), err))
+		}
+	}
+
+	return errorList(errs)
+}
+
+// flagValue is the flag of an option. It only checks the value, which is applied to the config
+// by ApplyFlags.
+type flagValue struct {
+	o     *option
+	value string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	if err := Default().Set(v.o.flag, value); err != nil {
+		return err
+	}
+
+	v.value = value
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	switch v.o.value(Default()).(type) {
+	case *bool, replicaFlag:
+		return true
+	}
+
+	return false
+}
+
+// Flags defines the flags of the options in fs with the defaults of the config.
+func Flags(fs *flag.FlagSet) {
+	def := Default()
+	for i := range options {
+		o := &options[i]
+		value := def.get(o)
+		if value == "false" || value == "0" || value == "0s" {
+			// the zero defaults are not shown in the usage
+			value = ""
+		}
+
+		fs.Var(&flagValue{o: o, value: value}, o.flag, o.usage)
+	}
+}
+
+// ApplyFlags overrides the config with the flags of fs that were set on the command line. The
+// flags that are not options are ignored.
+func (c *Config) ApplyFlags(fs *flag.FlagSet) error {
+	var errs Errors
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := lookup(f.Name); !ok {
+			return
+		}
+
+		if err := c.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Sprintf("-%s: %s", f.Name, err))
+		}
+	})
+
+	return errorList(errs)
+}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotEmpty happens when restoring a full backup into a database that already has data.
+	ErrNotEmpty = errors.New("a full backup can only be restored into an empty database")
+
+	// ErrBackupSequence happens when an incremental backup doesn't continue from the change log
+	// sequence of the database.
+	ErrBackupSequence = errors.New("the backup doesn't continue from the sequence of the database")
+
+	// backupInfoKey is the key of the first record of a backup archive, which holds the BackupInfo.
+	backupInfoKey = []byte("info")
+)
+
+// BackupInfo describes the contents of a backup archive. A full backup contains all of the data up
+// to the change log sequence To, while an incremental backup contains the change log entries after
+// the sequence From up to and including To.
+type BackupInfo struct {
+	Full    bool      `json:"full"`
+	From    uint64    `json:"from"`
+	To      uint64    `json:"to"`
+	Format  byte      `json:"format"`
+	Created time.Time `json:"created"`
+}
+
+// Backup writes a full backup of a consistent snapshot of the database into w. The archive is a
+// record file that ends with a checksum, so a truncated or corrupted archive is refused when it's
+// restored. The change log itself is not included in the backup.
+func (d *DB) Backup(w io.Writer) error {
+	snap, err := d.db.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	seq, err := readSequence(snap)
+	if err != nil {
+		return err
+	}
+
+	rw, err := writeBackupHeader(w, &BackupInfo{Full: true, To: seq})
+	if err != nil {
+		return err
+	}
+
+	logPrefix := encodeBucketID(changeLogBucketID)
+	iter := snap.NewIterator(nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if bytes.HasPrefix(iter.Key(), logPrefix) {
+			continue
+		}
+
+		if err := rw.write(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	return rw.close()
+}
+
+// BackupSince writes an incremental backup of the change log entries after the sequence since
+// into w. The sequence is usually the To sequence of the previous backup. The change log needs
+// to be enabled and the entries after since must not have been trimmed.
+func (d *DB) BackupSince(w io.Writer, since uint64) error {
+	if _, ok := d.db.(*changeLogEngine); !ok {
+		return ErrChangeLogDisabled
+	}
+
+	snap, err := d.db.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	seq, err := readSequence(snap)
+	if err != nil {
+		return err
+	}
+
+	if since > seq {
+		return ErrBackupSequence
+	}
+
+	// check that the log hasn't been trimmed before anything is written, such that the caller
+	// can still report the error
+	if since < seq {
+		if _, err := snap.Get(changeLogKey(since + 1)); err == ErrNotFound {
+			return ErrChangeLogTrimmed
+		} else if err != nil {
+			return err
+		}
+	}
+
+	rw, err := writeBackupHeader(w, &BackupInfo{From: since, To: seq})
+	if err != nil {
+		return err
+	}
+
+	err = readChangeLog(snap, since, func(entry *ChangeLogEntry) error {
+		return rw.write(changeLogKey(entry.Seq), encodeChangeLogEntry(entry.Time, entry.batch))
+	})
+	if err != nil {
+		return err
+	}
+
+	return rw.close()
+}
+
+// ReadBackupInfo reads the information from the start of a backup archive.
+func ReadBackupInfo(r io.Reader) (*BackupInfo, error) {
+	_, info, err := readBackupHeader(r)
+	return info, err
+}
+
+// Restore applies the backup archive from r to the database. A full backup can only be restored
+// into an empty database and an incremental backup must continue from the change log sequence of
+// the database. The writes are applied in batches, so if an error is returned the database can
+// contain a part of the backup and it should be thrown away.
+func (d *DB) Restore(r io.Reader) (*BackupInfo, error) {
+	res, err := d.RestoreUntil(r, RecoveryTarget{})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Info, nil
+}
+
+func writeBackupHeader(w io.Writer, info *BackupInfo) (*recordWriter, error) {
+	info.Format = formatVersion
+	info.Created = time.Now().UTC()
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	rw := newRecordWriter(w, backupMagic)
+	if err := rw.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	return rw, rw.write(backupInfoKey, data)
+}
+
+func readBackupHeader(r io.Reader) (*recordReader, *BackupInfo, error) {
+	rr := newRecordReader(r, backupMagic)
+	if err := rr.readHeader(); err != nil {
+		return nil, nil, err
+	}
+
+	key, data, err := rr.next()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(key, backupInfoKey) {
+		return nil, nil, ErrCorruptFile
+	}
+
+	var info BackupInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, nil, ErrCorruptFile
+	}
+
+	if info.Format != formatVersion {
+		return nil, nil, ErrFormatVersion
+	}
+
+	return rr, &info, nil
+}
+
+// checkEmpty makes sure that the engine only contains the keys that are written when a new
+// database is opened.
+func checkEmpty(engine StorageEngine) error {
+	iter := engine.NewIterator(nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()
+		if !bytes.Equal(key, metaKey(formatKey)) && !bytes.Equal(key, metaKey(sequenceKey)) {
+			return ErrNotEmpty
+		}
+	}
+
+	return iter.Error()
+}
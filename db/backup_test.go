@@ -0,0 +1,145 @@
+package db_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src := createTestDatabase(t, false)
+	setKey(t, src, "key1", "value1")
+	if err := src.CreateNamespace("tenant", db.Quota{MaxKeys: 10}); err != nil {
+		t.Fatalf("could not create namespace, err: %s", err)
+	}
+	if err := src.Bucket("tenant").Set([]byte("key2"), []byte("value2")); err != nil {
+		t.Fatalf("could not write into namespace, err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.Backup(&buf); err != nil {
+		t.Fatalf("could not backup database, err: %s", err)
+	}
+
+	dst := createTestDatabase(t, false)
+	info, err := dst.Restore(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("could not restore backup, err: %s", err)
+	}
+
+	if !info.Full {
+		t.Fatalf("the backup should be a full backup")
+	}
+
+	if value, err := dst.Get("key1"); err != nil || string(value) != "value1" {
+		t.Fatalf("wrong value after restore. got=%q err=%v", value, err)
+	}
+
+	if value, err := dst.Bucket("tenant").Get([]byte("key2")); err != nil || string(value) != "value2" {
+		t.Fatalf("wrong bucket value after restore. got=%q err=%v", value, err)
+	}
+
+	if ns := dst.Namespaces(); len(ns) != 1 || ns[0].Usage.Keys != 1 {
+		t.Fatalf("the namespace wasn't restored. got=%+v", ns)
+	}
+
+	// a full backup cannot be restored on top of existing data
+	if _, err := dst.Restore(bytes.NewReader(buf.Bytes())); err != db.ErrNotEmpty {
+		t.Fatalf("expected ErrNotEmpty, got %v", err)
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	src := createTestDatabase(t, false)
+	if err := src.EnableChangeLog(); err != nil {
+		t.Fatalf("could not enable change log, err: %s", err)
+	}
+
+	setKey(t, src, "key1", "value1")
+	setKey(t, src, "key2", "value2")
+
+	var full bytes.Buffer
+	if err := src.Backup(&full); err != nil {
+		t.Fatalf("could not backup database, err: %s", err)
+	}
+	seq, _ := src.ChangeLogSequence()
+
+	setKey(t, src, "key1", "changed")
+	if err := src.Delete("key2"); err != nil {
+		t.Fatalf("could not delete key, err: %s", err)
+	}
+
+	var incr bytes.Buffer
+	if err := src.BackupSince(&incr, seq); err != nil {
+		t.Fatalf("could not create incremental backup, err: %s", err)
+	}
+
+	dst := createTestDatabase(t, false)
+
+	// the incremental backup needs the full backup to be restored first
+	if _, err := dst.Restore(bytes.NewReader(incr.Bytes())); err != db.ErrBackupSequence {
+		t.Fatalf("expected ErrBackupSequence, got %v", err)
+	}
+
+	for _, archive := range []*bytes.Buffer{&full, &incr} {
+		if _, err := dst.Restore(archive); err != nil {
+			t.Fatalf("could not restore backup, err: %s", err)
+		}
+	}
+
+	if value, err := dst.Get("key1"); err != nil || string(value) != "changed" {
+		t.Fatalf("wrong value after restore. got=%q err=%v", value, err)
+	}
+
+	if _, err := dst.Get("key2"); err != db.ErrNotFound {
+		t.Fatalf("deleted key was restored, err: %v", err)
+	}
+
+	// the entries after seq are gone once the log is trimmed
+	latest, _ := src.ChangeLogSequence()
+	if _, err := src.TrimChangeLog(latest); err != nil {
+		t.Fatalf("could not trim change log, err: %s", err)
+	}
+
+	if err := src.BackupSince(&bytes.Buffer{}, seq); err != db.ErrChangeLogTrimmed {
+		t.Fatalf("expected ErrChangeLogTrimmed, got %v", err)
+	}
+}
+
+func TestRestoreCorruptedBackup(t *testing.T) {
+	src := createTestDatabase(t, false)
+	setKey(t, src, "key1", "value1")
+
+	var buf bytes.Buffer
+	if err := src.Backup(&buf); err != nil {
+		t.Fatalf("could not backup database, err: %s", err)
+	}
+
+	data := buf.Bytes()
+	data[len(data)-8] ^= 0xff
+
+	dst := createTestDatabase(t, false)
+	if _, err := dst.Restore(bytes.NewReader(data)); err != db.ErrCorruptFile {
+		t.Fatalf("expected ErrCorruptFile, got %v", err)
+	}
+}
+
+func TestRestoreCorruptedLength(t *testing.T) {
+	src := createTestDatabase(t, false)
+	setKey(t, src, "key1", "value1")
+
+	var buf bytes.Buffer
+	if err := src.Backup(&buf); err != nil {
+		t.Fatalf("could not backup database, err: %s", err)
+	}
+
+	// the length of the backup info after the magic header claims to be huge
+	data := buf.Bytes()[:5]
+	data = append(data, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f)
+
+	dst := createTestDatabase(t, false)
+	if _, err := dst.Restore(bytes.NewReader(data)); err != db.ErrCorruptFile {
+		t.Fatalf("expected ErrCorruptFile, got %v", err)
+	}
+}
@@ -1,17 +1,24 @@
 package db
 
 import (
-	"github.com/syndtr/goleveldb/leveldb"
+	"bytes"
+	"encoding/binary"
 )
 
-// Bucket is a collection of records in the database
+// Bucket is a collection of records in the database. The keys of the bucket are prefixed
+// with the fixed-width id of the bucket.
 type Bucket struct {
-	id []byte
-	db *DB
+	id   []byte
+	name string
+	db   *DB
 }
 
 // Set places a key into the bucket
 func (b *Bucket) Set(key []byte, data []byte) error {
+	if b.id == nil {
+		return ErrBucketNotFound
+	}
+
 	if b.db.ronly {
 		return ErrReadOnly
 	}
@@ -20,31 +27,34 @@ func (b *Bucket) Set(key []byte, data []byte) error {
 		return ErrKeyLength
 	}
 
+	if ns := b.db.namespace(b.name); ns != nil {
+		return ns.set(b, key, data)
+	}
+
 	prefixedKey := b.bucketPrefix(key)
-	return b.db.db.Put(prefixedKey, data, nil)
+	return b.db.db.Put(prefixedKey, data)
 }
 
 // Get gets a key from the bucket
 func (b *Bucket) Get(key []byte) ([]byte, error) {
+	if b.id == nil {
+		return nil, ErrBucketNotFound
+	}
+
 	if len(key) == 0 {
 		return nil, ErrKeyLength
 	}
 
 	prefixedKey := b.bucketPrefix(key)
-	val, err := b.db.db.Get(prefixedKey, nil)
-	if err == leveldb.ErrNotFound {
-		return nil, ErrNotFound
-	}
-
-	if err != nil {
-		return nil, err
-	}
-
-	return val, nil
+	return b.db.db.Get(prefixedKey)
 }
 
 // Delete delets a key from the bucket
 func (b *Bucket) Delete(key []byte) error {
+	if b.id == nil {
+		return ErrBucketNotFound
+	}
+
 	if b.db.ronly {
 		return ErrReadOnly
 	}
@@ -53,16 +63,59 @@ func (b *Bucket) Delete(key []byte) error {
 		return ErrKeyLength
 	}
 
+	if ns := b.db.namespace(b.name); ns != nil {
+		return ns.delete(b, key)
+	}
+
 	prefixedKey := b.bucketPrefix(key)
-	return b.db.db.Delete(prefixedKey, nil)
+	return b.db.db.Delete(prefixedKey)
+}
+
+// Scan calls fn for every key-value pair of the bucket whose key starts with prefix in sorted
+// order. If after is not empty the scan starts from the first key after it, which can be used to
+// continue an interrupted scan. The scan reads a consistent snapshot of the bucket and the slices
+// given to fn are only valid until fn returns.
+func (b *Bucket) Scan(prefix, after []byte, fn func(key, value []byte) error) error {
+	if b.id == nil {
+		return ErrBucketNotFound
+	}
+
+	snap, err := b.db.db.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	iter := snap.NewIterator(b.bucketPrefix(prefix))
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()[len(b.id):]
+		if len(after) > 0 && bytes.Compare(key, after) <= 0 {
+			continue
+		}
+
+		if err := fn(key, iter.Value()); err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
 }
 
 // bucketPrefix adds the bucket's prefix to the beginning of the key.
 func (b *Bucket) bucketPrefix(key []byte) []byte {
-	// 2 is the bucket id size
 	buf := make([]byte, len(key)+len(b.id))
 	copy(buf, b.id[:])
 	copy(buf[len(b.id):], key)
 
 	return buf
 }
+
+// encodeBucketID encodes the id into the fixed-width prefix used in the keys.
+func encodeBucketID(id uint16) []byte {
+	buf := make([]byte, bucketIDSize)
+	binary.BigEndian.PutUint16(buf, id)
+
+	return buf
+}
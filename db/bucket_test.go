@@ -3,6 +3,7 @@ package db
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -15,7 +16,7 @@ func createTempDb(t *testing.T, readOnly bool) *DB {
 	}
 	defer os.Remove(dir)
 
-	db, err := NewDatabase(dir, readOnly)
+	db, err := Open(testEngine, dir, readOnly)
 	if err != nil {
 		t.Fatalf("could not create db instance, err: %s", err)
 	}
@@ -37,8 +38,7 @@ func TestBucketGetSet(t *testing.T) {
 		t.Fatalf("error placing key into the bucket, err: %s", err)
 	}
 
-	ldb := db.GetLevelDB()
-	if _, err := ldb.Get([]byte("testkey"), nil); err == nil {
+	if _, err := db.Engine().Get([]byte("testkey")); err == nil {
 		t.Fatalf("could find key from database without bucket")
 	}
 
@@ -71,3 +71,114 @@ func TestBucketDelete(t *testing.T) {
 		t.Fatalf("was able to find key-value after deletion")
 	}
 }
+
+func TestBucketPrefixIsolation(t *testing.T) {
+	db := createTempDb(t, false)
+
+	names := []string{"dex", "a", "ab", "abc"}
+	for _, name := range names {
+		if err := db.CreateBucket(name); err != nil {
+			t.Fatalf("could not create bucket %q, err: %s", name, err)
+		}
+	}
+
+	// each pair would map into the same leveldb key if the bucket names were used as prefixes
+	testCases := []struct {
+		bucket string
+		key    string
+	}{
+		{defaultBucket, "xabc"},
+		{"dex", "abc"},
+		{"a", "bc"},
+		{"ab", "c"},
+		{"abc", ""},
+		{replicaBucket, "x"},
+	}
+
+	for _, tc := range testCases {
+		if tc.key == "" {
+			continue
+		}
+
+		value := []byte(tc.bucket + "/" + tc.key)
+		if err := db.Bucket(tc.bucket).Set([]byte(tc.key), value); err != nil {
+			t.Fatalf("error setting key %q in bucket %q, err: %s", tc.key, tc.bucket, err)
+		}
+	}
+
+	for _, tc := range testCases {
+		if tc.key == "" {
+			continue
+		}
+
+		val, err := db.Bucket(tc.bucket).Get([]byte(tc.key))
+		if err != nil {
+			t.Fatalf("error getting key %q from bucket %q, err: %s", tc.key, tc.bucket, err)
+		}
+
+		if want := tc.bucket + "/" + tc.key; string(val) != want {
+			t.Errorf("buckets share keys. got=%q want=%q", val, want)
+		}
+	}
+
+	if _, err := db.Bucket("abc").Get([]byte("x")); err != ErrNotFound {
+		t.Errorf("found a key from an empty bucket, err: %v", err)
+	}
+
+	// dropping a bucket shouldn't touch the keys of the other buckets
+	if err := db.DropBucket("a"); err != nil {
+		t.Fatalf("could not drop bucket, err: %s", err)
+	}
+
+	if _, err := db.Bucket("ab").Get([]byte("c")); err != nil {
+		t.Fatalf("dropping a bucket removed keys from another bucket, err: %s", err)
+	}
+}
+
+func TestDeleteNotBelongingIDs(t *testing.T) {
+	db := createTempDb(t, false)
+
+	if err := db.Set("key", []byte("value")); err != nil {
+		t.Fatalf("error setting key, err: %s", err)
+	}
+
+	var keys []string
+	err := db.DeleteNotBelonging(func(key string) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if err != nil {
+		t.Fatalf("error purging keys, err: %s", err)
+	}
+
+	if len(keys) != 1 || keys[0] != "key" {
+		t.Fatalf("the bucket id was not stripped correctly. got=%q", keys)
+	}
+}
+
+func TestBucketScan(t *testing.T) {
+	db := createTempDb(t, false)
+	if err := db.CreateBucket("scan"); err != nil {
+		t.Fatalf("could not create bucket, err: %s", err)
+	}
+
+	b := db.Bucket("scan")
+	for _, key := range []string{"a1", "b1", "b2", "b3", "c1"} {
+		if err := b.Set([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatalf("error setting key, err: %s", err)
+		}
+	}
+
+	var keys []string
+	err := b.Scan([]byte("b"), []byte("b1"), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed, err: %s", err)
+	}
+
+	if strings.Join(keys, ",") != "b2,b3" {
+		t.Fatalf("wrong keys from scan. got=%q", keys)
+	}
+}
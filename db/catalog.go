@@ -0,0 +1,241 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+)
+
+var (
+	// ErrBucketNotFound happens when doing operations on a bucket that has not been created.
+	ErrBucketNotFound = errors.New("the bucket was not found")
+
+	// ErrBucketExists happens when creating a bucket with a name that is already taken.
+	ErrBucketExists = errors.New("the bucket already exists")
+
+	// ErrBucketReserved happens when trying to drop one of the internal buckets.
+	ErrBucketReserved = errors.New("the bucket is reserved for internal use")
+
+	// ErrInvalidBucketName happens when the bucket name contains characters that are not allowed.
+	ErrInvalidBucketName = errors.New("the bucket name can only contain letters, numbers, '_', '-' and '.' and be at most 64 characters")
+
+	// ErrTooManyBuckets happens when creating a bucket would exceed MaxBuckets.
+	ErrTooManyBuckets = errors.New("the maximum amount of buckets has been reached")
+
+	// ErrNeedsMigration happens when opening a database that was created with the old key encoding
+	// where the bucket names were used as the key prefixes. See Migrate.
+	ErrNeedsMigration = errors.New("the database uses an old key encoding and needs to be migrated")
+
+	// ErrFormatVersion happens when the database was created by a newer version of dkv.
+	ErrFormatVersion = errors.New("unsupported database format version")
+
+	// the keys of the meta bucket. The catalogue maps the bucket names into their ids and the
+	// format key stores the version of the key encoding.
+	catalogPrefix = "c"
+	formatKey     = "v"
+
+	bucketNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+)
+
+// deleteBatchSize is the amount of keys deleted in a single batch when dropping a bucket.
+const deleteBatchSize = 1000
+
+// formatVersion is the version of the key encoding. Version 1 uses fixed-width bucket ids.
+const formatVersion byte = 1
+
+// CreateBucket creates a new bucket and stores it in the bucket catalogue.
+func (d *DB) CreateBucket(name string) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	if len(name) == 0 {
+		return ErrBucketName
+	}
+
+	if !bucketNameRegexp.MatchString(name) {
+		return ErrInvalidBucketName
+	}
+
+	d.bmutex.Lock()
+	defer d.bmutex.Unlock()
+
+	if _, ok := d.buckets[name]; ok {
+		return ErrBucketExists
+	}
+
+	if len(d.buckets)-len(d.system) >= MaxBuckets {
+		return ErrTooManyBuckets
+	}
+
+	id, err := d.allocateBucketID()
+	if err != nil {
+		return err
+	}
+
+	// remove any data left behind by a dropped bucket that had the same id
+	if err := d.deletePrefix(id); err != nil {
+		return err
+	}
+
+	if err := d.db.Put(catalogKey(name), id); err != nil {
+		return err
+	}
+	d.buckets[name] = id
+
+	return nil
+}
+
+// DropBucket removes the bucket from the catalogue and deletes all of its data.
+func (d *DB) DropBucket(name string) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	d.bmutex.Lock()
+	id, ok := d.buckets[name]
+	if !ok {
+		d.bmutex.Unlock()
+		return ErrBucketNotFound
+	}
+
+	if d.system[name] {
+		d.bmutex.Unlock()
+		return ErrBucketReserved
+	}
+	delete(d.buckets, name)
+	d.bmutex.Unlock()
+
+	if err := d.db.Delete(catalogKey(name)); err != nil {
+		return err
+	}
+
+	if err := d.removeNamespace(name); err != nil {
+		return err
+	}
+
+	return d.deletePrefix(id)
+}
+
+// ListBuckets returns the names of the user created buckets in sorted order.
+func (d *DB) ListBuckets() []string {
+	d.bmutex.RLock()
+	defer d.bmutex.RUnlock()
+
+	names := make([]string, 0, len(d.buckets))
+	for name := range d.buckets {
+		if !d.system[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// loadCatalog reads the user created buckets from the catalogue.
+func (d *DB) loadCatalog() error {
+	prefix := metaKey(catalogPrefix)
+	iter := d.db.NewIterator(prefix)
+	defer iter.Release()
+
+	d.bmutex.Lock()
+	defer d.bmutex.Unlock()
+
+	for iter.Next() {
+		name := string(removeBucketPrefix(prefix, iter.Key()))
+		if len(iter.Value()) != bucketIDSize {
+			return fmt.Errorf("invalid id for bucket %q in the catalogue", name)
+		}
+		d.buckets[name] = copyBytes(iter.Value())
+	}
+
+	return iter.Error()
+}
+
+// allocateBucketID returns the smallest id that is not used by any bucket. The caller must hold
+// the bucket lock.
+func (d *DB) allocateBucketID() ([]byte, error) {
+	used := make(map[uint16]bool, len(d.buckets))
+	for _, id := range d.buckets {
+		used[binary.BigEndian.Uint16(id)] = true
+	}
+
+	for id := firstUserBucketID; id <= math.MaxUint16; id++ {
+		if !used[uint16(id)] {
+			return encodeBucketID(uint16(id)), nil
+		}
+	}
+
+	return nil, ErrTooManyBuckets
+}
+
+// checkFormat makes sure that the database uses the current key encoding. A new database gets the
+// format version written into it, while a database with data but without a version is from
+// before the fixed-width bucket ids were introduced.
+func (d *DB) checkFormat() error {
+	version, err := d.db.Get(metaKey(formatKey))
+	if err == nil {
+		if len(version) != 1 || version[0] != formatVersion {
+			return ErrFormatVersion
+		}
+		return nil
+	}
+
+	if err != ErrNotFound {
+		return err
+	}
+
+	iter := d.db.NewIterator(nil)
+	empty := !iter.Next()
+	iter.Release()
+	if !empty {
+		return ErrNeedsMigration
+	}
+
+	return d.db.Put(metaKey(formatKey), []byte{formatVersion})
+}
+
+// deletePrefix deletes all of the keys with the given prefix in batches and compacts the
+// range afterwards if the engine supports it, such that the disk space is freed. The iterator is
+// released before each batch is written, since some engines don't allow writes while reading.
+func (d *DB) deletePrefix(prefix []byte) error {
+	for {
+		batch := new(Batch)
+		iter := d.db.NewIterator(prefix)
+		for batch.Len() < deleteBatchSize && iter.Next() {
+			batch.Delete(copyBytes(iter.Key()))
+		}
+		iter.Release()
+
+		if err := iter.Error(); err != nil {
+			return err
+		}
+
+		if batch.Len() == 0 {
+			break
+		}
+
+		if err := d.db.Write(batch); err != nil {
+			return err
+		}
+	}
+
+	if c, ok := d.db.(compacter); ok {
+		return c.CompactPrefix(prefix)
+	}
+
+	return nil
+}
+
+// metaKey returns the key prefixed with the meta bucket id.
+func metaKey(key string) []byte {
+	return append(encodeBucketID(metaBucketID), key...)
+}
+
+func catalogKey(name string) []byte {
+	return metaKey(catalogPrefix + name)
+}
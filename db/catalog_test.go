@@ -0,0 +1,125 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestBucketCatalogPersists(t *testing.T) {
+	if !db.PersistentTestEngine() {
+		t.Skip("the storage engine doesn't persist data")
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "dkvdb")
+	if err != nil {
+		t.Fatalf("error creating temp file, err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := db.OpenTest(dir, false)
+	if err != nil {
+		t.Fatalf("could not create new database, err: %s", err)
+	}
+
+	for _, name := range []string{"users", "sessions"} {
+		if err := d.CreateBucket(name); err != nil {
+			t.Fatalf("could not create bucket %q: %s", name, err)
+		}
+	}
+
+	if err := d.Bucket("users").Set([]byte("alice"), []byte("admin")); err != nil {
+		t.Fatalf("could not set key in bucket: %s", err)
+	}
+	d.Close()
+
+	d, err = db.OpenTest(dir, false)
+	if err != nil {
+		t.Fatalf("could not reopen database, err: %s", err)
+	}
+	defer d.Close()
+
+	want := []string{"sessions", "users"}
+	if got := d.ListBuckets(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong buckets after reopening. got=%v want=%v", got, want)
+	}
+
+	value, err := d.Bucket("users").Get([]byte("alice"))
+	if err != nil || string(value) != "admin" {
+		t.Fatalf("could not find key after reopening. got=%q err=%v", value, err)
+	}
+}
+
+func TestDropBucket(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.CreateBucket("tmp"); err != nil {
+		t.Fatalf("could not create bucket: %s", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.Bucket("tmp").Set([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("could not set key: %s", err)
+		}
+	}
+	setKey(t, d, "a", "default")
+
+	if err := d.DropBucket("tmp"); err != nil {
+		t.Fatalf("could not drop bucket: %s", err)
+	}
+
+	if _, err := d.Bucket("tmp").Get([]byte("a")); err != db.ErrBucketNotFound {
+		t.Fatalf("wrong error after dropping bucket. got=%v want=%v", err, db.ErrBucketNotFound)
+	}
+
+	// recreating the bucket should not bring back the old data
+	if err := d.CreateBucket("tmp"); err != nil {
+		t.Fatalf("could not recreate bucket: %s", err)
+	}
+
+	if _, err := d.Bucket("tmp").Get([]byte("a")); err != db.ErrNotFound {
+		t.Fatalf("found data from dropped bucket. err=%v", err)
+	}
+
+	if _, err := d.Get("a"); err != nil {
+		t.Fatalf("dropping bucket removed data from default bucket: %s", err)
+	}
+}
+
+func TestBucketErrors(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.CreateBucket("logs"); err != nil {
+		t.Fatalf("could not create bucket: %s", err)
+	}
+
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{"", db.ErrBucketName},
+		{"has space", db.ErrInvalidBucketName},
+		{"logs", db.ErrBucketExists},
+	}
+
+	for _, tc := range testCases {
+		if err := d.CreateBucket(tc.name); err != tc.err {
+			t.Errorf("wrong error creating bucket %q. got=%v want=%v", tc.name, err, tc.err)
+		}
+	}
+
+	if err := d.DropBucket("missing"); err != db.ErrBucketNotFound {
+		t.Errorf("wrong error dropping missing bucket. got=%v want=%v", err, db.ErrBucketNotFound)
+	}
+
+	if err := d.DropBucket("de"); err != db.ErrBucketReserved {
+		t.Errorf("wrong error dropping the default bucket. got=%v want=%v", err, db.ErrBucketReserved)
+	}
+
+	if err := d.Bucket("missing").Set([]byte("key"), []byte("value")); err != db.ErrBucketNotFound {
+		t.Errorf("was able to write into a bucket that doesn't exist. err=%v", err)
+	}
+}
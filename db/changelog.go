@@ -0,0 +1,339 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrChangeLogDisabled happens when reading the change log of a database that doesn't keep one.
+	ErrChangeLogDisabled = errors.New("the change log is not enabled")
+
+	// ErrChangeLogTrimmed happens when the wanted change log entries have already been trimmed.
+	ErrChangeLogTrimmed = errors.New("the change log has been trimmed past the wanted sequence")
+
+	// ErrCorruptEntry happens when a change log entry cannot be decoded.
+	ErrCorruptEntry = errors.New("the change log entry is corrupted")
+
+	changeLogBucket = "cl"
+
+	// sequenceKey is the key of the meta bucket that stores the sequence of the latest change log
+	// entry. It is written in the same batch as the entry.
+	sequenceKey = "s"
+)
+
+// ChangeLogEntry is a single atomic write into the storage engine.
+type ChangeLogEntry struct {
+	Seq   uint64
+	Time  time.Time
+	batch *Batch
+}
+
+// Len returns the amount of key writes and deletions in the entry.
+func (e *ChangeLogEntry) Len() int {
+	return e.batch.Len()
+}
+
+// changeLogEngine wraps a storage engine and records every write into the change log bucket with
+// an increasing sequence number. The writes are serialized, such that the order of the sequence
+// numbers is the same as the order in which the writes were applied.
+type changeLogEngine struct {
+	StorageEngine
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+// EnableChangeLog starts recording all of the writes into the change log, which is used for the
+// incremental backups. It should be called right after opening the database, before any writes.
+func (d *DB) EnableChangeLog() error {
+	if _, ok := d.db.(*changeLogEngine); ok {
+		return nil
+	}
+
+	seq, err := d.ChangeLogSequence()
+	if err != nil {
+		return err
+	}
+
+	d.db = &changeLogEngine{StorageEngine: d.db, seq: seq}
+
+	return nil
+}
+
+// ChangeLogSequence returns the sequence of the latest write that has been recorded into the
+// change log. It is 0 if nothing has been recorded.
+func (d *DB) ChangeLogSequence() (uint64, error) {
+	return readSequence(d.db)
+}
+
+// ChangeLogTrim is the result of TrimChangeLog.
+type ChangeLogTrim struct {
+	// Requested is the sequence that was asked to be trimmed and Seq is the sequence up to which
+	// the entries were actually trimmed. Seq is lower if Sink hasn't received the entries yet.
+	Requested uint64 `json:"requested"`
+	Seq       uint64 `json:"seq"`
+	Sink      string `json:"sink,omitempty"`
+}
+
+// TrimChangeLog deletes all of the change log entries up to and including seq. The entries should
+// be trimmed once they have been archived with an incremental backup. The entries that have not
+// been delivered to every change data capture sink are kept, and the result tells which sink held
+// the trim back. Sinks that are no longer used can be removed with DeleteSinkOffset.
+func (d *DB) TrimChangeLog(seq uint64) (ChangeLogTrim, error) {
+	trim := ChangeLogTrim{Requested: seq, Seq: seq}
+	if d.ronly {
+		return trim, ErrReadOnly
+	}
+
+	offsets, err := d.SinkOffsets()
+	if err != nil {
+		return trim, err
+	}
+
+	for name, offset := range offsets {
+		if offset < trim.Seq || (offset == trim.Seq && trim.Sink != "" && name < trim.Sink) {
+			trim.Seq = offset
+			trim.Sink = name
+		}
+	}
+
+	engine := d.rawEngine()
+	for {
+		batch := new(Batch)
+		iter := engine.NewIterator(encodeBucketID(changeLogBucketID))
+		for batch.Len() < deleteBatchSize && iter.Next() {
+			if decodeChangeLogSeq(iter.Key()) > trim.Seq {
+				break
+			}
+			batch.Delete(copyBytes(iter.Key()))
+		}
+		iter.Release()
+
+		if err := iter.Error(); err != nil {
+			return trim, err
+		}
+
+		if batch.Len() == 0 {
+			return trim, nil
+		}
+
+		if err := engine.Write(batch); err != nil {
+			return trim, err
+		}
+	}
+}
+
+// rawEngine returns the storage engine without the change log, such that the writes are not
+// recorded.
+func (d *DB) rawEngine() StorageEngine {
+	if e, ok := d.db.(*changeLogEngine); ok {
+		return e.StorageEngine
+	}
+
+	return d.db
+}
+
+func (e *changeLogEngine) Put(key, value []byte) error {
+	batch := new(Batch)
+	batch.Put(key, value)
+
+	return e.Write(batch)
+}
+
+func (e *changeLogEngine) Delete(key []byte) error {
+	batch := new(Batch)
+	batch.Delete(key)
+
+	return e.Write(batch)
+}
+
+// Write applies the batch together with its change log entry and the new sequence.
+func (e *changeLogEngine) Write(batch *Batch) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seq := e.seq + 1
+	logged := &Batch{ops: make([]batchOp, len(batch.ops), len(batch.ops)+2)}
+	copy(logged.ops, batch.ops)
+	logged.Put(changeLogKey(seq), encodeChangeLogEntry(time.Now(), batch))
+	logged.Put(metaKey(sequenceKey), encodeSequence(seq))
+
+	if err := e.StorageEngine.Write(logged); err != nil {
+		return err
+	}
+	e.seq = seq
+
+	return nil
+}
+
+// CompactPrefix compacts the wrapped engine if it supports compactions.
+func (e *changeLogEngine) CompactPrefix(prefix []byte) error {
+	if c, ok := e.StorageEngine.(compacter); ok {
+		return c.CompactPrefix(prefix)
+	}
+
+	return nil
+}
+
+// readChangeLog calls fn for every change log entry in the snapshot after the sequence since. It
+// returns ErrChangeLogTrimmed if the entry right after since doesn't exist anymore.
+func readChangeLog(snap Snapshot, since uint64, fn func(entry *ChangeLogEntry) error) error {
+	latest, err := readSequence(snap)
+	if err != nil {
+		return err
+	}
+
+	iter := snap.NewIterator(encodeBucketID(changeLogBucketID))
+	defer iter.Release()
+
+	next := since + 1
+	for iter.Next() {
+		seq := decodeChangeLogSeq(iter.Key())
+		if seq < next {
+			continue
+		}
+
+		if seq != next {
+			return ErrChangeLogTrimmed
+		}
+
+		entry, err := decodeChangeLogEntry(seq, iter.Value())
+		if err != nil {
+			return err
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+		next++
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	if next <= latest {
+		return ErrChangeLogTrimmed
+	}
+
+	return nil
+}
+
+type getter interface {
+	Get(key []byte) ([]byte, error)
+}
+
+func readSequence(g getter) (uint64, error) {
+	data, err := g.Get(metaKey(sequenceKey))
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data) != 8 {
+		return 0, ErrCorruptEntry
+	}
+
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func encodeSequence(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+
+	return buf
+}
+
+// changeLogKey returns the key of the entry. The sequence is big-endian, such that the entries
+// are iterated in order.
+func changeLogKey(seq uint64) []byte {
+	return append(encodeBucketID(changeLogBucketID), encodeSequence(seq)...)
+}
+
+func decodeChangeLogSeq(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[bucketIDSize:])
+}
+
+// encodeChangeLogEntry encodes the time and the operations of the batch. Every operation is
+// encoded as a type byte followed by the length prefixed key and, for writes, the value.
+func encodeChangeLogEntry(t time.Time, batch *Batch) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+
+	binary.BigEndian.PutUint64(tmp[:8], uint64(t.UnixNano()))
+	buf.Write(tmp[:8])
+
+	writeBytes := func(b []byte) {
+		n := binary.PutUvarint(tmp[:], uint64(len(b)))
+		buf.Write(tmp[:n])
+		buf.Write(b)
+	}
+
+	for _, op := range batch.ops {
+		if op.delete {
+			buf.WriteByte(1)
+			writeBytes(op.key)
+		} else {
+			buf.WriteByte(0)
+			writeBytes(op.key)
+			writeBytes(op.value)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func decodeChangeLogEntry(seq uint64, data []byte) (*ChangeLogEntry, error) {
+	if len(data) < 8 {
+		return nil, ErrCorruptEntry
+	}
+
+	entry := &ChangeLogEntry{
+		Seq:   seq,
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(data))),
+		batch: new(Batch),
+	}
+	data = data[8:]
+
+	readBytes := func() ([]byte, bool) {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			return nil, false
+		}
+
+		b := copyBytes(data[n : n+int(length)])
+		data = data[n+int(length):]
+		return b, true
+	}
+
+	for len(data) > 0 {
+		op := data[0]
+		data = data[1:]
+
+		key, ok := readBytes()
+		if !ok {
+			return nil, ErrCorruptEntry
+		}
+
+		switch op {
+		case 0:
+			value, ok := readBytes()
+			if !ok {
+				return nil, ErrCorruptEntry
+			}
+			entry.batch.Put(key, value)
+		case 1:
+			entry.batch.Delete(key)
+		default:
+			return nil, ErrCorruptEntry
+		}
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// cdcBucket stores the sequences of the change log entries that have been delivered to the change
+// data capture sinks, keyed by the names of the sinks.
+const cdcBucket = "cd"
+
+// Change is a write into the default bucket or a user created bucket that has been recorded into
+// the change log.
+type Change struct {
+	Seq  uint64
+	Time time.Time
+
+	// Bucket is the name of a user created bucket, or empty for the default bucket.
+	Bucket string
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// ReadChanges calls fn for the changes in at most limit change log entries after the sequence
+// since. It returns the sequence of the last entry that was read, which is since if there are no
+// new entries. The entries that only change the internal buckets move the sequence without
+// calling fn.
+func (d *DB) ReadChanges(since uint64, limit int, fn func(c *Change) error) (uint64, error) {
+	if _, ok := d.db.(*changeLogEngine); !ok {
+		return since, ErrChangeLogDisabled
+	}
+
+	snap, err := d.db.Snapshot()
+	if err != nil {
+		return since, err
+	}
+	defer snap.Release()
+
+	latest, err := readSequence(snap)
+	if err != nil {
+		return since, err
+	}
+
+	names := d.bucketNames()
+	seq := since
+	for ; seq < latest && seq-since < uint64(limit); seq++ {
+		data, err := snap.Get(changeLogKey(seq + 1))
+		if err == ErrNotFound {
+			return seq, ErrChangeLogTrimmed
+		}
+
+		if err != nil {
+			return seq, err
+		}
+
+		entry, err := decodeChangeLogEntry(seq+1, data)
+		if err != nil {
+			return seq, err
+		}
+
+		for _, op := range entry.batch.ops {
+			if len(op.key) < bucketIDSize {
+				continue
+			}
+
+			name, ok := names[binary.BigEndian.Uint16(op.key)]
+			if !ok {
+				continue
+			}
+
+			err := fn(&Change{
+				Seq:    entry.Seq,
+				Time:   entry.Time,
+				Bucket: name,
+				Key:    op.key[bucketIDSize:],
+				Value:  op.value,
+				Delete: op.delete,
+			})
+			if err != nil {
+				return seq, err
+			}
+		}
+	}
+
+	return seq, nil
+}
+
+// bucketNames maps the ids of the default bucket and the user created buckets into the names of
+// the changes.
+func (d *DB) bucketNames() map[uint16]string {
+	d.bmutex.RLock()
+	defer d.bmutex.RUnlock()
+
+	names := map[uint16]string{defaultBucketID: ""}
+	for name, id := range d.buckets {
+		if !d.system[name] {
+			names[binary.BigEndian.Uint16(id)] = name
+		}
+	}
+
+	return names
+}
+
+// SinkOffset returns the sequence of the last change log entry that has been delivered to the
+// sink. The second return value is false if the sink doesn't have an offset yet.
+func (d *DB) SinkOffset(name string) (uint64, bool, error) {
+	data, err := d.Bucket(cdcBucket).Get([]byte(name))
+	if err == ErrNotFound {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(data) != 8 {
+		return 0, false, ErrCorruptEntry
+	}
+
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+// SetSinkOffset stores the offset of the sink. The offsets are not recorded into the change log,
+// such that storing them doesn't create new entries for the sinks.
+func (d *DB) SetSinkOffset(name string, seq uint64) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	return d.rawEngine().Put(d.Bucket(cdcBucket).bucketPrefix([]byte(name)), encodeSequence(seq))
+}
+
+// DeleteSinkOffset removes the offset of a sink that is no longer used, such that the change log
+// can be trimmed past it. It returns ErrNotFound if the sink doesn't have an offset.
+func (d *DB) DeleteSinkOffset(name string) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	if _, ok, err := d.SinkOffset(name); err != nil || !ok {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return err
+	}
+
+	return d.rawEngine().Delete(d.Bucket(cdcBucket).bucketPrefix([]byte(name)))
+}
+
+// SinkOffsets returns the offsets of all of the sinks.
+func (d *DB) SinkOffsets() (map[string]uint64, error) {
+	offsets := make(map[string]uint64)
+	err := d.Bucket(cdcBucket).Scan(nil, nil, func(key, value []byte) error {
+		if len(value) != 8 {
+			return ErrCorruptEntry
+		}
+
+		offsets[string(key)] = binary.BigEndian.Uint64(value)
+		return nil
+	})
+
+	return offsets, err
+}
@@ -0,0 +1,100 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestReadChanges(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if _, err := d.ReadChanges(0, 10, nil); err != db.ErrChangeLogDisabled {
+		t.Fatalf("wrong error without the change log. got=%v", err)
+	}
+
+	if err := d.EnableChangeLog(); err != nil {
+		t.Fatalf("could not enable change log: %s", err)
+	}
+
+	d.Set("a", []byte("1"))
+	d.CreateBucket("users")
+	d.Bucket("users").Set([]byte("alice"), []byte("admin"))
+	d.Delete("a")
+
+	var changes []*db.Change
+	seq, err := d.ReadChanges(0, 100, func(c *db.Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not read changes: %s", err)
+	}
+
+	latest, _ := d.ChangeLogSequence()
+	if seq != latest {
+		t.Errorf("wrong sequence. got=%d want=%d", seq, latest)
+	}
+
+	if len(changes) != 3 || string(changes[0].Key) != "a" || string(changes[0].Value) != "1" ||
+		changes[1].Bucket != "users" || string(changes[1].Key) != "alice" || !changes[2].Delete {
+		t.Errorf("wrong changes. got=%+v", changes)
+	}
+
+	// the limit is in entries
+	seq, err = d.ReadChanges(0, 1, func(c *db.Change) error { return nil })
+	if err != nil || seq != 1 {
+		t.Errorf("wrong sequence with a limit. got=%d, err=%v", seq, err)
+	}
+}
+
+func TestSinkOffsetsKeepChangeLog(t *testing.T) {
+	d := createTestDatabase(t, false)
+	if err := d.EnableChangeLog(); err != nil {
+		t.Fatalf("could not enable change log: %s", err)
+	}
+
+	d.Set("a", []byte("1"))
+	if err := d.SetSinkOffset("sink", 1); err != nil {
+		t.Fatalf("could not set offset: %s", err)
+	}
+	d.Set("b", []byte("2"))
+
+	// storing the offset doesn't add an entry into the change log
+	latest, _ := d.ChangeLogSequence()
+	if latest != 2 {
+		t.Fatalf("wrong sequence. got=%d", latest)
+	}
+
+	trim, err := d.TrimChangeLog(latest)
+	if err != nil {
+		t.Fatalf("could not trim change log: %s", err)
+	}
+
+	if trim.Requested != latest || trim.Seq != 1 || trim.Sink != "sink" {
+		t.Errorf("the clamped trim was not reported. got=%+v", trim)
+	}
+
+	var keys []string
+	if _, err := d.ReadChanges(1, 10, func(c *db.Change) error {
+		keys = append(keys, string(c.Key))
+		return nil
+	}); err != nil || len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("the undelivered entry was trimmed. got=%v, err=%v", keys, err)
+	}
+
+	if err := d.DeleteSinkOffset("sink"); err != nil {
+		t.Fatalf("could not delete offset: %s", err)
+	}
+
+	if err := d.DeleteSinkOffset("sink"); err != db.ErrNotFound {
+		t.Errorf("deleting a missing offset: want ErrNotFound, got %v", err)
+	}
+
+	if trim, err := d.TrimChangeLog(latest); err != nil || trim.Seq != latest || trim.Sink != "" {
+		t.Errorf("wrong trim without sinks. got=%+v, err=%v", trim, err)
+	}
+	if _, err := d.ReadChanges(1, 10, func(c *db.Change) error { return nil }); err != db.ErrChangeLogTrimmed {
+		t.Errorf("wrong error after trimming. got=%v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// the CRDT values are stored in their own typed buckets, such that a counter and a set with
+	// the same name don't override each other.
+	counterBucket  = "cn"
+	setBucket      = "st"
+	registerBucket = "rg"
+
+	// crdtReplicaBucket is the replication queue for CRDT values. The keys are prefixed with the
+	// typed bucket name, so that the replica knows which merge function to use.
+	crdtReplicaBucket = "rc"
+
+	// tagCounter makes sure that OR-set tags created in the same nanosecond are still unique.
+	tagCounter uint64
+)
+
+// PNCounter is a counter that supports both increments and decrements. Each node only modifies
+// its own entries, and merging takes the maximum of each entry, which makes the counter converge.
+type PNCounter struct {
+	P map[string]int64 `json:"p"`
+	N map[string]int64 `json:"n"`
+}
+
+// NewPNCounter returns an empty counter.
+func NewPNCounter() *PNCounter {
+	return &PNCounter{
+		P: make(map[string]int64),
+		N: make(map[string]int64),
+	}
+}
+
+// Add adds delta to the counter on behalf of the given node. Negative deltas decrement the counter.
+func (c *PNCounter) Add(node string, delta int64) {
+	if delta >= 0 {
+		c.P[node] += delta
+	} else {
+		c.N[node] -= delta
+	}
+}
+
+// Value returns the current value of the counter.
+func (c *PNCounter) Value() int64 {
+	var res int64
+	for _, v := range c.P {
+		res += v
+	}
+	for _, v := range c.N {
+		res -= v
+	}
+	return res
+}
+
+// Merge merges the other counter into c.
+func (c *PNCounter) Merge(o *PNCounter) {
+	mergeMax(c.P, o.P)
+	mergeMax(c.N, o.N)
+}
+
+func mergeMax(dst, src map[string]int64) {
+	for node, v := range src {
+		if v > dst[node] {
+			dst[node] = v
+		}
+	}
+}
+
+// ORSet is an observed-remove set. Every add creates a unique tag and a remove only removes the
+// tags that it has observed, such that concurrent adds win over removes.
+type ORSet struct {
+	Adds    map[string]map[string]bool `json:"adds"`
+	Removes map[string]map[string]bool `json:"removes"`
+}
+
+// NewORSet returns an empty set.
+func NewORSet() *ORSet {
+	return &ORSet{
+		Adds:    make(map[string]map[string]bool),
+		Removes: make(map[string]map[string]bool),
+	}
+}
+
+// Add adds a member into the set with a new tag created by the given node.
+func (s *ORSet) Add(node, member string) {
+	tag := fmt.Sprintf("%s-%d-%d", node, time.Now().UnixNano(), atomic.AddUint64(&tagCounter, 1))
+	addTags(s.Adds, member, map[string]bool{tag: true})
+}
+
+// Remove removes a member from the set by marking all of the observed tags as removed.
+func (s *ORSet) Remove(member string) {
+	addTags(s.Removes, member, s.Adds[member])
+}
+
+// Contains reports whether the member is in the set.
+func (s *ORSet) Contains(member string) bool {
+	for tag := range s.Adds[member] {
+		if !s.Removes[member][tag] {
+			return true
+		}
+	}
+	return false
+}
+
+// Members returns the members of the set in sorted order.
+func (s *ORSet) Members() []string {
+	members := make([]string, 0, len(s.Adds))
+	for member := range s.Adds {
+		if s.Contains(member) {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+
+	return members
+}
+
+// Merge merges the other set into s.
+func (s *ORSet) Merge(o *ORSet) {
+	for member, tags := range o.Adds {
+		addTags(s.Adds, member, tags)
+	}
+	for member, tags := range o.Removes {
+		addTags(s.Removes, member, tags)
+	}
+}
+
+func addTags(dst map[string]map[string]bool, member string, tags map[string]bool) {
+	if len(tags) == 0 {
+		return
+	}
+
+	if _, ok := dst[member]; !ok {
+		dst[member] = make(map[string]bool, len(tags))
+	}
+	for tag := range tags {
+		dst[member][tag] = true
+	}
+}
+
+// LWWRegister is a last-writer-wins register. Ties between writes with the same timestamp are
+// broken using the node name, such that every node picks the same winner.
+type LWWRegister struct {
+	Value     []byte `json:"value"`
+	Timestamp int64  `json:"ts"`
+	Node      string `json:"node"`
+}
+
+// Set sets the value of the register if the write is newer than the current value.
+func (r *LWWRegister) Set(node string, value []byte, ts int64) {
+	r.Merge(&LWWRegister{Value: value, Timestamp: ts, Node: node})
+}
+
+// Merge merges the other register into r.
+func (r *LWWRegister) Merge(o *LWWRegister) {
+	if o.Timestamp > r.Timestamp || (o.Timestamp == r.Timestamp && o.Node > r.Node) {
+		*r = *o
+	}
+}
+
+// SetNodeID sets the node identifier which is used to separate the CRDT updates of different
+// nodes. It should be unique in the cluster.
+func (d *DB) SetNodeID(id string) {
+	d.node = id
+}
+
+// CounterIncr adds delta to the counter stored in key and returns the new value.
+func (d *DB) CounterIncr(key string, delta int64) (int64, error) {
+	if d.ronly {
+		return 0, ErrReadOnly
+	}
+
+	d.crdtMutex.Lock()
+	defer d.crdtMutex.Unlock()
+
+	c := NewPNCounter()
+	if err := d.loadCRDT(counterBucket, key, c); err != nil {
+		return 0, err
+	}
+	c.Add(d.node, delta)
+
+	if err := d.storeCRDT(counterBucket, key, c, true); err != nil {
+		return 0, err
+	}
+
+	return c.Value(), nil
+}
+
+// CounterValue returns the value of the counter stored in key. A counter that doesn't exist
+// has the value 0.
+func (d *DB) CounterValue(key string) (int64, error) {
+	c := NewPNCounter()
+	if err := d.loadCRDT(counterBucket, key, c); err != nil {
+		return 0, err
+	}
+
+	return c.Value(), nil
+}
+
+// SetAdd adds the member into the set stored in key.
+func (d *DB) SetAdd(key, member string) error {
+	return d.updateSet(key, func(s *ORSet) { s.Add(d.node, member) })
+}
+
+// SetRemove removes the member from the set stored in key.
+func (d *DB) SetRemove(key, member string) error {
+	return d.updateSet(key, func(s *ORSet) { s.Remove(member) })
+}
+
+// SetMembers returns the members of the set stored in key.
+func (d *DB) SetMembers(key string) ([]string, error) {
+	s := NewORSet()
+	if err := d.loadCRDT(setBucket, key, s); err != nil {
+		return nil, err
+	}
+
+	return s.Members(), nil
+}
+
+func (d *DB) updateSet(key string, fn func(s *ORSet)) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	d.crdtMutex.Lock()
+	defer d.crdtMutex.Unlock()
+
+	s := NewORSet()
+	if err := d.loadCRDT(setBucket, key, s); err != nil {
+		return err
+	}
+	fn(s)
+
+	return d.storeCRDT(setBucket, key, s, true)
+}
+
+// RegisterSet sets the value of the register stored in key.
+func (d *DB) RegisterSet(key string, value []byte) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	d.crdtMutex.Lock()
+	defer d.crdtMutex.Unlock()
+
+	r := &LWWRegister{}
+	if err := d.loadCRDT(registerBucket, key, r); err != nil {
+		return err
+	}
+	r.Set(d.node, value, time.Now().UnixNano())
+
+	return d.storeCRDT(registerBucket, key, r, true)
+}
+
+// RegisterGet returns the value of the register stored in key.
+func (d *DB) RegisterGet(key string) ([]byte, error) {
+	data, err := d.Bucket(registerBucket).Get([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	var r LWWRegister
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+
+	return r.Value, nil
+}
+
+// MergeOnReplica merges a CRDT value received from the master into the local value. The bucket
+// decides which merge function is used.
+func (d *DB) MergeOnReplica(bucket, key string, data []byte) error {
+	d.crdtMutex.Lock()
+	defer d.crdtMutex.Unlock()
+
+	switch bucket {
+	case counterBucket:
+		local, remote := NewPNCounter(), NewPNCounter()
+		if err := d.decodeMerge(bucket, key, data, local, remote); err != nil {
+			return err
+		}
+		local.Merge(remote)
+		return d.storeCRDT(bucket, key, local, false)
+	case setBucket:
+		local, remote := NewORSet(), NewORSet()
+		if err := d.decodeMerge(bucket, key, data, local, remote); err != nil {
+			return err
+		}
+		local.Merge(remote)
+		return d.storeCRDT(bucket, key, local, false)
+	case registerBucket:
+		local, remote := &LWWRegister{}, &LWWRegister{}
+		if err := d.decodeMerge(bucket, key, data, local, remote); err != nil {
+			return err
+		}
+		local.Merge(remote)
+		return d.storeCRDT(bucket, key, local, false)
+	}
+
+	return fmt.Errorf("unknown crdt bucket: %q", bucket)
+}
+
+// GetNextCRDTReplica returns the next CRDT value that has not yet been applied to replicas.
+func (d *DB) GetNextCRDTReplica() (bucket string, key, value []byte, err error) {
+	queue := d.Bucket(crdtReplicaBucket)
+	iter := d.db.NewIterator(queue.id)
+	defer iter.Release()
+	if ok := iter.Next(); !ok {
+		return "", nil, nil, iter.Error()
+	}
+
+	// the key contains both the replication bucket prefix and the typed bucket name
+	key = removeBucketPrefix(queue.id, iter.Key())
+	bucket = string(key[:len(counterBucket)])
+	key = key[len(counterBucket):]
+
+	return bucket, key, copyBytes(iter.Value()), nil
+}
+
+// DeleteCRDTReplicationKey deletes the CRDT value from the replication queue.
+func (d *DB) DeleteCRDTReplicationKey(bucket string, key, val []byte) error {
+	return d.deleteFromQueue(crdtReplicaBucket, append([]byte(bucket), key...), val)
+}
+
+func (d *DB) decodeMerge(bucket, key string, data []byte, local, remote interface{}) error {
+	if err := d.loadCRDT(bucket, key, local); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, remote)
+}
+
+// loadCRDT decodes the value stored in key into v. If the key doesn't exist v is left untouched.
+func (d *DB) loadCRDT(bucket, key string, v interface{}) error {
+	data, err := d.Bucket(bucket).Get([]byte(key))
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// storeCRDT encodes and stores v into the typed bucket and adds it into the replication queue
+// if replicate is set.
+func (d *DB) storeCRDT(bucket, key string, v interface{}, replicate bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Bucket(bucket).Set([]byte(key), data); err != nil {
+		return err
+	}
+
+	if !replicate {
+		return nil
+	}
+
+	return d.Bucket(crdtReplicaBucket).Set(append([]byte(bucket), key...), data)
+}
@@ -0,0 +1,156 @@
+package db_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+)
+
+// replicateCRDTs moves all of the CRDT values in the replication queue of src into dst.
+func replicateCRDTs(t *testing.T, src, dst *db.DB) {
+	t.Helper()
+
+	for {
+		bucket, key, value, err := src.GetNextCRDTReplica()
+		if err != nil {
+			t.Fatalf("could not get next crdt replica: %s", err)
+		}
+
+		if key == nil {
+			return
+		}
+
+		if err := dst.MergeOnReplica(bucket, string(key), value); err != nil {
+			t.Fatalf("could not merge value on replica: %s", err)
+		}
+
+		if err := src.DeleteCRDTReplicationKey(bucket, key, value); err != nil {
+			t.Fatalf("could not delete crdt replication key: %s", err)
+		}
+	}
+}
+
+func createNodes(t *testing.T) (*db.DB, *db.DB) {
+	t.Helper()
+
+	n1 := createTestDatabase(t, false)
+	n1.SetNodeID("n1")
+	n2 := createTestDatabase(t, false)
+	n2.SetNodeID("n2")
+
+	return n1, n2
+}
+
+func TestCounterConverges(t *testing.T) {
+	n1, n2 := createNodes(t)
+
+	if _, err := n1.CounterIncr("visits", 5); err != nil {
+		t.Fatalf("error incrementing counter: %s", err)
+	}
+
+	if _, err := n2.CounterIncr("visits", 3); err != nil {
+		t.Fatalf("error incrementing counter: %s", err)
+	}
+
+	value, err := n2.CounterIncr("visits", -1)
+	if err != nil {
+		t.Fatalf("error decrementing counter: %s", err)
+	}
+
+	if value != 2 {
+		t.Fatalf("wrong counter value. got=%d want=%d", value, 2)
+	}
+
+	replicateCRDTs(t, n1, n2)
+	replicateCRDTs(t, n2, n1)
+
+	for _, n := range []*db.DB{n1, n2} {
+		value, err := n.CounterValue("visits")
+		if err != nil {
+			t.Fatalf("error reading counter: %s", err)
+		}
+
+		if value != 7 {
+			t.Errorf("counters didn't converge. got=%d want=%d", value, 7)
+		}
+	}
+}
+
+func TestSetConverges(t *testing.T) {
+	n1, n2 := createNodes(t)
+
+	for _, member := range []string{"a", "b"} {
+		if err := n1.SetAdd("tags", member); err != nil {
+			t.Fatalf("error adding member: %s", err)
+		}
+	}
+	replicateCRDTs(t, n1, n2)
+
+	// n2 removes a member which n1 concurrently adds again, the add should win.
+	if err := n2.SetRemove("tags", "a"); err != nil {
+		t.Fatalf("error removing member: %s", err)
+	}
+	if err := n2.SetRemove("tags", "b"); err != nil {
+		t.Fatalf("error removing member: %s", err)
+	}
+	if err := n1.SetAdd("tags", "a"); err != nil {
+		t.Fatalf("error adding member: %s", err)
+	}
+
+	replicateCRDTs(t, n1, n2)
+	replicateCRDTs(t, n2, n1)
+
+	for _, n := range []*db.DB{n1, n2} {
+		members, err := n.SetMembers("tags")
+		if err != nil {
+			t.Fatalf("error reading members: %s", err)
+		}
+
+		if !reflect.DeepEqual(members, []string{"a"}) {
+			t.Errorf("sets didn't converge. got=%v want=%v", members, []string{"a"})
+		}
+	}
+}
+
+func TestRegisterConverges(t *testing.T) {
+	n1, n2 := createNodes(t)
+
+	if err := n1.RegisterSet("config", []byte("old")); err != nil {
+		t.Fatalf("error setting register: %s", err)
+	}
+
+	if err := n2.RegisterSet("config", []byte("new")); err != nil {
+		t.Fatalf("error setting register: %s", err)
+	}
+
+	replicateCRDTs(t, n1, n2)
+	replicateCRDTs(t, n2, n1)
+
+	for _, n := range []*db.DB{n1, n2} {
+		value, err := n.RegisterGet("config")
+		if err != nil {
+			t.Fatalf("error reading register: %s", err)
+		}
+
+		if string(value) != "new" {
+			t.Errorf("registers didn't converge. got=%q want=%q", value, "new")
+		}
+	}
+}
+
+func TestCRDTReadOnly(t *testing.T) {
+	db := createTestDatabase(t, true)
+
+	if _, err := db.CounterIncr("key", 1); err == nil {
+		t.Errorf("was able to increment counter in read-only mode")
+	}
+
+	if err := db.SetAdd("key", "member"); err == nil {
+		t.Errorf("was able to add set member in read-only mode")
+	}
+
+	if err := db.RegisterSet("key", []byte("value")); err == nil {
+		t.Errorf("was able to set register in read-only mode")
+	}
+}
@@ -8,9 +8,7 @@ import (
 	"log"
 	"math"
 	"sync"
-
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync/atomic"
 )
 
 var (
@@ -32,8 +30,40 @@ var (
 	// be deleted.
 	ErrValDontMatch = errors.New("values don't match")
 
+	// ErrClosed happens when pinging a database that has been closed.
+	ErrClosed = errors.New("the database is closed")
+
 	replicaBucket = "re"
 	defaultBucket = "de"
+
+	// contentTypeBucket stores the content types of the values in the default bucket.
+	contentTypeBucket = "ct"
+)
+
+// the ids of the internal buckets. The ids below firstUserBucketID are reserved for internal use
+// and the user created buckets get ids allocated from the bucket catalogue.
+const (
+	metaBucketID uint16 = iota
+	defaultBucketID
+	replicaBucketID
+	counterBucketID
+	setBucketID
+	registerBucketID
+	crdtReplicaBucketID
+	changeLogBucketID
+	contentTypeBucketID
+	expiryBucketID
+	versionBucketID
+	flagsBucketID
+	cdcBucketID
+)
+
+const (
+	// bucketIDSize is the size of the bucket id prefix in bytes.
+	bucketIDSize = 2
+
+	// firstUserBucketID is the first id that can be allocated for user created buckets.
+	firstUserBucketID = 8 * 256
 )
 
 // MaxBuckets is the maximum amount of buckets
@@ -41,60 +71,172 @@ const MaxBuckets = math.MaxUint16 - (8 * 256)
 
 // DB represents the database
 type DB struct {
-	db    *leveldb.DB
+	// lastVersion is the latest version given to a value. It is accessed atomically, so it is the
+	// first field to keep it 64-bit aligned.
+	lastVersion uint64
+
+	db    StorageEngine
 	ronly bool // indicator if the database is in the read-only mode
 
 	// buckets map maps to the identifiers, such that we can easily create a new bucket instance
 	// it is currently a map since maybe in the future I will implement a better indexing solution
 	buckets map[string][]byte
+	system  map[string]bool // the internal buckets which cannot be dropped
 	bmutex  sync.RWMutex
+
+	// node identifies this database in the CRDT values and crdtMutex serializes the
+	// read-modify-write cycles of CRDT updates.
+	node      string
+	crdtMutex sync.Mutex
+
+	// keyLocks serialize the writes to the same key, such that read-modify-write operations
+	// like Incr don't lose updates. The keys are hashed into a fixed set of locks.
+	keyLocks [keyLockCount]sync.Mutex
+
+	// namespaces maps the bucket names into their quotas and usage.
+	namespaces map[string]*namespace
+	nsMutex    sync.RWMutex
+
+	// notifier publishes the changes of the default bucket to the watchers.
+	notifier notifier
+
+	closed int32 // set atomically when the database is closed
 }
 
 // Close closes the database connection
 func (d *DB) Close() error {
+	atomic.StoreInt32(&d.closed, 1)
 	return d.db.Close()
 }
 
-// GetLevelDB returns a pointer to the underlying levelDB database
+// Ping checks that the database is open and that the storage engine can be read.
+func (d *DB) Ping() error {
+	if atomic.LoadInt32(&d.closed) == 1 {
+		return ErrClosed
+	}
+
+	_, err := d.db.Get(metaKey(formatKey))
+	if err == ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// Engine returns the underlying storage engine
 // this is mostly used for testing if buckets really insert into buckets
-func (d *DB) GetLevelDB() *leveldb.DB {
+func (d *DB) Engine() StorageEngine {
 	return d.db
 }
 
-// NewDatabase returns a new instance of a database
+// NewDatabase returns a new instance of a database that uses the leveldb storage engine
 func NewDatabase(path string, ronly bool) (*DB, error) {
-	ldb, err := leveldb.OpenFile(path, nil)
+	return Open(EngineLevelDB, path, ronly)
+}
+
+// Open opens the storage engine of the given kind at path and returns a new instance of a
+// database on top of it.
+func Open(engine, path string, ronly bool) (*DB, error) {
+	e, err := OpenEngine(engine, path)
 	if err != nil {
 		return nil, err
 	}
 
-	d := &DB{db: ldb, ronly: ronly}
+	d, err := NewDatabaseWithEngine(e, ronly)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	d.node = path
+
+	return d, nil
+}
+
+// NewDatabaseWithEngine returns a new instance of a database on top of the given storage engine
+func NewDatabaseWithEngine(engine StorageEngine, ronly bool) (*DB, error) {
+	d := &DB{db: engine, ronly: ronly}
+
+	// the events before opening are not known, so the watches can't be resumed from them
+	if err := d.loadRevision(); err != nil {
+		return nil, err
+	}
 
 	d.buckets = make(map[string][]byte)
+	d.system = make(map[string]bool)
+
+	// create the internal buckets
+	internal := []struct {
+		name string
+		id   uint16
+	}{
+		{defaultBucket, defaultBucketID},
+		{replicaBucket, replicaBucketID},
+		{counterBucket, counterBucketID},
+		{setBucket, setBucketID},
+		{registerBucket, registerBucketID},
+		{crdtReplicaBucket, crdtReplicaBucketID},
+		{changeLogBucket, changeLogBucketID},
+		{contentTypeBucket, contentTypeBucketID},
+		{expiryBucket, expiryBucketID},
+		{versionBucket, versionBucketID},
+		{flagsBucket, flagsBucketID},
+		{cdcBucket, cdcBucketID},
+	}
+	for _, b := range internal {
+		if _, err := d.newBucket(b.name, b.id); err != nil {
+			return nil, err
+		}
+	}
 
-	// create the default bucket
-	if _, err := d.newBucket(defaultBucket); err != nil {
+	// make sure that the database uses the current key encoding
+	if err := d.checkFormat(); err != nil {
 		return nil, err
 	}
 
-	// create the replication bucket
-	if _, err := d.newBucket("re"); err != nil {
+	// load the user created buckets from the catalogue
+	if err := d.loadCatalog(); err != nil {
+		return nil, err
+	}
+
+	// load the namespace quotas and their usage
+	if err := d.loadNamespaces(); err != nil {
 		return nil, err
 	}
 
 	return d, nil
 }
 
-// Get finds a key-value pair from the database
+// Get finds a key-value pair from the database. The keys whose time to live has passed are not
+// found even if they haven't been deleted yet.
 func (d *DB) Get(key string) ([]byte, error) {
 	data, err := d.Bucket(defaultBucket).Get([]byte(key))
 	if err != nil {
 		return nil, err
 	}
 
+	if expired, err := d.expired(key); err != nil || expired {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+
 	return data, nil
 }
 
+// Scan calls fn for the key-value pairs of the default bucket that haven't expired, see
+// Bucket.Scan.
+func (d *DB) Scan(prefix, after []byte, fn func(key, value []byte) error) error {
+	return d.Bucket(defaultBucket).Scan(prefix, after, func(key, value []byte) error {
+		expired, err := d.expired(string(key))
+		if err != nil || expired {
+			return err
+		}
+
+		return fn(key, value)
+	})
+}
+
 // DeleteNotBelonging deletes all the key-value pairs in which the key matches the
 // doesntBelong function.
 func (d *DB) DeleteNotBelonging(doesntBelong func(string) bool) error {
@@ -102,11 +244,12 @@ func (d *DB) DeleteNotBelonging(doesntBelong func(string) bool) error {
 		return ErrReadOnly
 	}
 
-	iter := d.db.NewIterator(util.BytesPrefix([]byte(defaultBucket)), nil)
+	bucket := d.Bucket(defaultBucket)
+	iter := d.db.NewIterator(bucket.id)
 	var keys []string
 	for iter.Next() {
 		// remove the bucket id from the key name
-		key := string(iter.Key())[2:]
+		key := string(removeBucketPrefix(bucket.id, iter.Key()))
 		if doesntBelong(key) {
 			keys = append(keys, key)
 		}
@@ -121,7 +264,7 @@ func (d *DB) DeleteNotBelonging(doesntBelong func(string) bool) error {
 		// is used for all the operations, meaning that the bucket prefix would just
 		// be appended to the start two times.
 		log.Println("deleting", key)
-		if err := d.Bucket(defaultBucket).Delete([]byte(key)); err != nil {
+		if err := d.delete(key); err != nil {
 			return err
 		}
 	}
@@ -130,12 +273,12 @@ func (d *DB) DeleteNotBelonging(doesntBelong func(string) bool) error {
 }
 
 // Bucket is the common method for doing operations on a bucket for example:
-// d.Bucket(defaultBucket).Get(key)
+// d.Bucket(defaultBucket).Get(key). If the bucket doesn't exist all of the operations
+// on the returned bucket return ErrBucketNotFound.
 func (d *DB) Bucket(name string) *Bucket {
 	bucket, ok := d.bucket(name)
 	if !ok {
-		bucket, _ := d.newBucket(name)
-		return bucket
+		return &Bucket{db: d}
 	}
 	return bucket
 }
@@ -151,31 +294,27 @@ func (d *DB) bucket(name string) (*Bucket, bool) {
 	}
 
 	bucket := &Bucket{
-		db: d,
-		id: bucketID,
+		db:   d,
+		id:   bucketID,
+		name: name,
 	}
 	return bucket, true
 }
 
-// newBucket creates a new bucket and returns an error if any
-func (d *DB) newBucket(name string) (*Bucket, error) {
+// newBucket registers one of the internal buckets. The internal buckets are not stored in the
+// bucket catalogue, since they are created every time the database is opened.
+func (d *DB) newBucket(name string, id uint16) (*Bucket, error) {
 	if len(name) == 0 {
 		return nil, ErrBucketName
 	}
 
-	// check if a bucket already exists
-	d.bmutex.RLock()
-	if _, ok := d.buckets[name]; ok {
-		return &Bucket{db: d, id: []byte(name)}, nil
-	}
-	d.bmutex.RUnlock()
-
-	// write the bucket to the list of buckets
 	d.bmutex.Lock()
-	d.buckets[name] = []byte(name)
-	d.bmutex.Unlock()
+	defer d.bmutex.Unlock()
 
-	return &Bucket{db: d, id: []byte(name)}, nil
+	d.buckets[name] = encodeBucketID(id)
+	d.system[name] = true
+
+	return &Bucket{db: d, id: d.buckets[name], name: name}, nil
 }
 
 // Set creates a key-value entry in the database
@@ -184,11 +323,88 @@ func (d *DB) Set(key string, value []byte) error {
 		return ErrReadOnly
 	}
 
-	if err := d.Bucket(defaultBucket).Set([]byte(key), value); err != nil {
-		return err
+	mu := d.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	return d.set(key, value, "")
+}
+
+// SetWithType creates a key-value entry in the database and stores the content type of the value
+// next to it. An empty content type removes the stored one.
+func (d *DB) SetWithType(key string, value []byte, contentType string) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	mu := d.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	return d.set(key, value, contentType)
+}
+
+// GetWithType returns the value of the key with its content type. The content type is empty if
+// the value was written without one.
+func (d *DB) GetWithType(key string) ([]byte, string, error) {
+	value, err := d.Get(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType, err := d.Bucket(contentTypeBucket).Get([]byte(key))
+	if err != nil && err != ErrNotFound {
+		return nil, "", err
+	}
+
+	return value, string(contentType), nil
+}
+
+// set writes the key-value pair with its content type and adds it into the replication queue in a
+// single batch. The time to live and the flags of the key are removed. The caller must hold the
+// key lock.
+func (d *DB) set(key string, value []byte, contentType string) error {
+	return d.setItem(key, value, itemMeta{contentType: contentType})
+}
+
+// setItem writes the key-value pair like set with the metadata and gives the value a new version.
+func (d *DB) setItem(key string, value []byte, meta itemMeta) error {
+	return d.writeItem(key, value, meta, true)
+}
+
+// writeItem writes the key-value pair with its metadata, gives the value a new version and adds
+// it into the replication queue if queue is set. The caller must hold the key lock.
+func (d *DB) writeItem(key string, value []byte, meta itemMeta, queue bool) error {
+	if len(key) == 0 {
+		return ErrKeyLength
+	}
+
+	k := []byte(key)
+	batch := new(Batch)
+	batch.Put(d.Bucket(defaultBucket).bucketPrefix(k), value)
+	if queue {
+		batch.Put(d.Bucket(replicaBucket).bucketPrefix(k), value)
+	}
+
+	if meta.contentType != "" {
+		batch.Put(d.Bucket(contentTypeBucket).bucketPrefix(k), []byte(meta.contentType))
+	} else {
+		batch.Delete(d.Bucket(contentTypeBucket).bucketPrefix(k))
+	}
+
+	if !meta.deadline.IsZero() {
+		batch.Put(d.Bucket(expiryBucket).bucketPrefix(k), encodeDeadline(meta.deadline))
+	} else {
+		batch.Delete(d.Bucket(expiryBucket).bucketPrefix(k))
 	}
 
-	return d.Bucket(replicaBucket).Set([]byte(key), value)
+	if meta.flags != 0 {
+		batch.Put(d.Bucket(flagsBucket).bucketPrefix(k), encodeFlags(meta.flags))
+	} else {
+		batch.Delete(d.Bucket(flagsBucket).bucketPrefix(k))
+	}
+
+	return d.commit(batch, Event{Type: EventPut, Key: key, Value: value})
 }
 
 // Delete removes an entry from the database
@@ -197,14 +413,45 @@ func (d *DB) Delete(key string) error {
 		return ErrReadOnly
 	}
 
-	return d.Bucket(defaultBucket).Delete([]byte(key))
+	mu := d.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	return d.delete(key)
+}
+
+// delete removes the key with its metadata.
+func (d *DB) delete(key string) error {
+	return d.deleteItem(key, false)
+}
+
+// deleteItem deletes the key with its metadata and removes it from the replication queue if
+// dequeue is set. The caller must hold the key lock.
+func (d *DB) deleteItem(key string, dequeue bool) error {
+	if len(key) == 0 {
+		return ErrKeyLength
+	}
+
+	batch := new(Batch)
+	if dequeue {
+		batch.Delete(d.Bucket(replicaBucket).bucketPrefix([]byte(key)))
+	}
+	batch.Delete(d.Bucket(defaultBucket).bucketPrefix([]byte(key)))
+	batch.Delete(d.Bucket(contentTypeBucket).bucketPrefix([]byte(key)))
+	batch.Delete(d.Bucket(expiryBucket).bucketPrefix([]byte(key)))
+	batch.Delete(d.Bucket(versionBucket).bucketPrefix([]byte(key)))
+	batch.Delete(d.Bucket(flagsBucket).bucketPrefix([]byte(key)))
+
+	return d.commit(batch, Event{Type: EventDelete, Key: key})
 }
 
 // GetNextReplica returns the key-value pair that has changed and has not yet applied to replicas.
 func (d *DB) GetNextReplica() (key, value []byte, err error) {
-	iter := d.db.NewIterator(util.BytesPrefix([]byte(replicaBucket)), nil)
-	if ok := iter.First(); !ok {
-		return nil, nil, nil
+	bucket := d.Bucket(replicaBucket)
+	iter := d.db.NewIterator(bucket.id)
+	if ok := iter.Next(); !ok {
+		iter.Release()
+		return nil, nil, iter.Error()
 	}
 
 	k := iter.Key()
@@ -212,7 +459,7 @@ func (d *DB) GetNextReplica() (key, value []byte, err error) {
 
 	key = copyBytes(k)
 	// remove the replication bucket prefix from the key
-	key = removeBucketPrefix([]byte(replicaBucket), key)
+	key = removeBucketPrefix(bucket.id, key)
 
 	value = copyBytes(v)
 
@@ -223,7 +470,36 @@ func (d *DB) GetNextReplica() (key, value []byte, err error) {
 
 // DeleteReplicationKey deletes the key from the replication queue.
 func (d *DB) DeleteReplicationKey(key, val []byte) error {
-	value, err := d.Bucket(replicaBucket).Get(key)
+	return d.deleteFromQueue(replicaBucket, key, val)
+}
+
+// ReplicationBacklog returns the amount of changed values that have not yet been applied to
+// the replicas, including the CRDT values.
+func (d *DB) ReplicationBacklog() (int, error) {
+	count := 0
+	inc := func(key, value []byte) error {
+		count++
+		return nil
+	}
+
+	for _, queue := range []string{replicaBucket, crdtReplicaBucket} {
+		if err := d.Bucket(queue).Scan(nil, nil, inc); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// ReadOnly reports whether the database is in the read-only mode.
+func (d *DB) ReadOnly() bool {
+	return d.ronly
+}
+
+// deleteFromQueue deletes the key from the given replication queue if the value is still
+// the same as the one that was replicated.
+func (d *DB) deleteFromQueue(queue string, key, val []byte) error {
+	value, err := d.Bucket(queue).Get(key)
 	if err != nil {
 		return err
 	}
@@ -232,12 +508,34 @@ func (d *DB) DeleteReplicationKey(key, val []byte) error {
 		return ErrValDontMatch
 	}
 
-	return d.Bucket(replicaBucket).Delete(key)
+	return d.Bucket(queue).Delete(key)
 }
 
 // SetOnReplica sets the key to the requested value into the default database
 func (d *DB) SetOnReplica(key string, val []byte) error {
-	return d.Bucket(defaultBucket).Set([]byte(key), val)
+	return d.SetOnReplicaWithType(key, val, "")
+}
+
+// SetOnReplicaWithType sets the key to the requested value and content type into the default
+// database without adding it into the replication queue.
+func (d *DB) SetOnReplicaWithType(key string, val []byte, contentType string) error {
+	return d.SetOnReplicaItem(key, &Item{Value: val, ContentType: contentType})
+}
+
+// SetOnReplicaItem sets the key to the value of the item with its content type, flags and deadline
+// without adding it into the replication queue. The value is written like on the master, so it
+// gets a new version and the watchers of the replica are notified.
+func (d *DB) SetOnReplicaItem(key string, item *Item) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	mu := d.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	meta := itemMeta{contentType: item.ContentType, deadline: item.Deadline, flags: item.Flags}
+	return d.writeItem(key, item.Value, meta, false)
 }
 
 func copyBytes(b []byte) []byte {
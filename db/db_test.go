@@ -17,7 +17,7 @@ func createTestDatabase(t *testing.T, ronly bool) *db.DB {
 	}
 	t.Cleanup(func() { os.Remove(dir) })
 
-	db, err := db.NewDatabase(dir, ronly)
+	db, err := db.OpenTest(dir, ronly)
 	if err != nil {
 		t.Fatalf("could not create new database, err: %s", err)
 	}
@@ -141,3 +141,60 @@ func TestDeleteReplicationKey(t *testing.T) {
 		t.Fatalf("next replication values are not nil")
 	}
 }
+
+func TestReplicationBacklog(t *testing.T) {
+	db := createTestDatabase(t, false)
+	setKey(t, db, "a", "1")
+	setKey(t, db, "b", "2")
+	if _, err := db.CounterIncr("c", 1); err != nil {
+		t.Fatalf("could not increment counter: %s", err)
+	}
+
+	n, err := db.ReplicationBacklog()
+	if err != nil || n != 3 {
+		t.Fatalf("wrong replication backlog. got=%d, err=%v", n, err)
+	}
+
+	if err := db.DeleteReplicationKey([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("could not delete replication key: %s", err)
+	}
+
+	if n, _ := db.ReplicationBacklog(); n != 2 {
+		t.Errorf("wrong replication backlog after delete. got=%d", n)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.SetWithType("image", []byte{0xff, 0xd8}, "image/jpeg"); err != nil {
+		t.Fatalf("could not write key: %v", err)
+	}
+
+	value, contentType, err := d.GetWithType("image")
+	if err != nil || contentType != "image/jpeg" || !bytes.Equal(value, []byte{0xff, 0xd8}) {
+		t.Fatalf("wrong value. got=%v type=%q err=%v", value, contentType, err)
+	}
+
+	// writing the key without a content type removes the old one
+	setKey(t, d, "image", "text")
+	if _, contentType, _ := d.GetWithType("image"); contentType != "" {
+		t.Fatalf("the content type was not removed. got=%q", contentType)
+	}
+}
+
+func TestPing(t *testing.T) {
+	d, err := db.Open(db.EngineMemory, "", false)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+
+	if err := d.Ping(); err != nil {
+		t.Fatalf("could not ping open database: %s", err)
+	}
+
+	d.Close()
+	if err := d.Ping(); err != db.ErrClosed {
+		t.Fatalf("wrong error when pinging closed database. got=%v want=%v", err, db.ErrClosed)
+	}
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"fmt"
+)
+
+// the names of the storage engines that can be given to OpenEngine.
+const (
+	EngineLevelDB = "leveldb"
+	EngineMemory  = "memory"
+	EngineBolt    = "bolt"
+)
+
+// Engines contains the names of all of the storage engines.
+var Engines = []string{EngineLevelDB, EngineMemory, EngineBolt}
+
+// StorageEngine is the ordered key-value store that the database is built on. All of the
+// buckets, catalogues and queues of the database are stored as prefixed keys in the engine.
+type StorageEngine interface {
+	// Get returns the value of the key or ErrNotFound. The returned slice is owned by the caller.
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+
+	// Write applies all of the operations in the batch atomically.
+	Write(batch *Batch) error
+
+	// NewIterator returns an iterator over the keys that start with prefix in sorted order. A nil
+	// prefix iterates over all of the keys.
+	NewIterator(prefix []byte) Iterator
+
+	// Snapshot returns a consistent read-only view of the engine.
+	Snapshot() (Snapshot, error)
+
+	Close() error
+}
+
+// Snapshot is a consistent read-only view of a storage engine. It must be released after use.
+type Snapshot interface {
+	Get(key []byte) ([]byte, error)
+	NewIterator(prefix []byte) Iterator
+	Release()
+}
+
+// Iterator iterates over key-value pairs. The first call to Next moves the iterator to the first
+// pair. The slices returned by Key and Value are only valid until the next call to Next, so they
+// need to be copied if they are kept around. The iterator must be released after use.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Release()
+	Error() error
+}
+
+// compacter is implemented by the engines that can reclaim the space of deleted keys.
+type compacter interface {
+	CompactPrefix(prefix []byte) error
+}
+
+// Batch collects writes that are applied atomically with StorageEngine.Write. The batch keeps
+// references to the given slices, so they must not be modified before the batch is written.
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// Put adds a write of the key-value pair into the batch.
+func (b *Batch) Put(key, value []byte) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+// Delete adds a deletion of the key into the batch.
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+// Len returns the amount of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset removes all of the operations from the batch.
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// CheckPath checks the options of a database path without opening it. Only the paths that start
+// with MemoryScheme have options, such as the snapshot interval.
+func CheckPath(path string) error {
+	if !isMemoryPath(path) {
+		return nil
+	}
+
+	_, _, err := parseMemoryPath(path)
+	return err
+}
+
+// OpenEngine opens the storage engine of the given kind at path. Paths that start with
+// MemoryScheme always open the memory engine, which otherwise ignores the path.
+func OpenEngine(kind, path string) (StorageEngine, error) {
+	if isMemoryPath(path) {
+		file, interval, err := parseMemoryPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return OpenMemory(file, interval)
+	}
+
+	switch kind {
+	case EngineLevelDB, "":
+		return OpenLevelDB(path)
+	case EngineMemory:
+		return NewMemoryEngine(), nil
+	case EngineBolt:
+		return OpenBolt(path)
+	}
+
+	return nil, fmt.Errorf("unknown storage engine: %q", kind)
+}
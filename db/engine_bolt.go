@@ -0,0 +1,193 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bolt bucket that contains all of the keys. The dkv buckets are implemented
+// with key prefixes like in the other engines.
+var boltBucket = []byte("dkv")
+
+// boltMmapSize is the initial size of the memory map. Bolt cannot grow the memory map while
+// there are open read transactions, so writes would block behind long-lived snapshots and
+// iterators until the data file outgrows this size. It only reserves address space.
+const boltMmapSize = 1 << 30
+
+// boltEngine stores the data in a B+tree using bolt. Reads are cheap and don't block writes,
+// which makes it a good fit for read-heavy shards.
+type boltEngine struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens a bolt storage engine in the directory at path.
+func OpenBolt(path string) (StorageEngine, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	bdb, err := bolt.Open(filepath.Join(path, "data.bolt"), 0644, &bolt.Options{
+		Timeout:         time.Second,
+		InitialMmapSize: boltMmapSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+
+	return &boltEngine{db: bdb}, nil
+}
+
+func (e *boltEngine) Get(key []byte) (value []byte, err error) {
+	err = e.db.View(func(tx *bolt.Tx) error {
+		value, err = boltGet(tx, key)
+		return err
+	})
+
+	return value, err
+}
+
+func (e *boltEngine) Put(key, value []byte) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+}
+
+func (e *boltEngine) Delete(key []byte) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+func (e *boltEngine) Write(batch *Batch) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		for _, op := range batch.ops {
+			var err error
+			if op.delete {
+				err = b.Delete(op.key)
+			} else {
+				err = b.Put(op.key, op.value)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// NewIterator returns an iterator which holds a read transaction until it is released.
+func (e *boltEngine) NewIterator(prefix []byte) Iterator {
+	tx, err := e.db.Begin(false)
+	if err != nil {
+		return &boltIterator{err: err}
+	}
+
+	return &boltIterator{tx: tx, ownsTx: true, cursor: tx.Bucket(boltBucket).Cursor(), prefix: prefix}
+}
+
+func (e *boltEngine) Snapshot() (Snapshot, error) {
+	tx, err := e.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltSnapshot{tx: tx}, nil
+}
+
+func (e *boltEngine) Close() error {
+	return e.db.Close()
+}
+
+// boltSnapshot is a read transaction that is kept open until the snapshot is released.
+type boltSnapshot struct {
+	tx *bolt.Tx
+}
+
+func (s *boltSnapshot) Get(key []byte) ([]byte, error) {
+	return boltGet(s.tx, key)
+}
+
+func (s *boltSnapshot) NewIterator(prefix []byte) Iterator {
+	return &boltIterator{tx: s.tx, cursor: s.tx.Bucket(boltBucket).Cursor(), prefix: prefix}
+}
+
+func (s *boltSnapshot) Release() {
+	s.tx.Rollback()
+}
+
+type boltIterator struct {
+	tx     *bolt.Tx
+	ownsTx bool // the iterators of a snapshot share the transaction of the snapshot
+	cursor *bolt.Cursor
+	prefix []byte
+
+	started    bool
+	key, value []byte
+	err        error
+}
+
+func (i *boltIterator) Next() bool {
+	if i.cursor == nil {
+		return false
+	}
+
+	if !i.started {
+		i.started = true
+		i.key, i.value = i.cursor.Seek(i.prefix)
+	} else {
+		i.key, i.value = i.cursor.Next()
+	}
+
+	if i.key == nil || !bytes.HasPrefix(i.key, i.prefix) {
+		i.key, i.value = nil, nil
+		i.cursor = nil
+		return false
+	}
+
+	return true
+}
+
+func (i *boltIterator) Key() []byte {
+	return i.key
+}
+
+func (i *boltIterator) Value() []byte {
+	return i.value
+}
+
+func (i *boltIterator) Release() {
+	if i.ownsTx && i.tx != nil {
+		i.tx.Rollback()
+	}
+	i.tx, i.cursor = nil, nil
+}
+
+func (i *boltIterator) Error() error {
+	return i.err
+}
+
+// boltGet returns a copy of the value, since bolt values are only valid inside the transaction.
+func boltGet(tx *bolt.Tx, key []byte) ([]byte, error) {
+	value := tx.Bucket(boltBucket).Get(key)
+	if value == nil {
+		return nil, ErrNotFound
+	}
+
+	return copyBytes(value), nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// levelDBEngine is the default storage engine which stores the data in a leveldb database.
+type levelDBEngine struct {
+	db *leveldb.DB
+}
+
+// OpenLevelDB opens a leveldb storage engine in the directory at path.
+func OpenLevelDB(path string) (StorageEngine, error) {
+	ldb, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &levelDBEngine{db: ldb}, nil
+}
+
+func (e *levelDBEngine) Get(key []byte) ([]byte, error) {
+	return levelDBGet(e.db.Get(key, nil))
+}
+
+func (e *levelDBEngine) Put(key, value []byte) error {
+	return e.db.Put(key, value, nil)
+}
+
+func (e *levelDBEngine) Delete(key []byte) error {
+	return e.db.Delete(key, nil)
+}
+
+func (e *levelDBEngine) Write(batch *Batch) error {
+	b := new(leveldb.Batch)
+	for _, op := range batch.ops {
+		if op.delete {
+			b.Delete(op.key)
+		} else {
+			b.Put(op.key, op.value)
+		}
+	}
+
+	return e.db.Write(b, nil)
+}
+
+func (e *levelDBEngine) NewIterator(prefix []byte) Iterator {
+	return e.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+func (e *levelDBEngine) Snapshot() (Snapshot, error) {
+	snap, err := e.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &levelDBSnapshot{snap: snap}, nil
+}
+
+func (e *levelDBEngine) CompactPrefix(prefix []byte) error {
+	return e.db.CompactRange(*util.BytesPrefix(prefix))
+}
+
+func (e *levelDBEngine) Close() error {
+	return e.db.Close()
+}
+
+type levelDBSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+func (s *levelDBSnapshot) Get(key []byte) ([]byte, error) {
+	return levelDBGet(s.snap.Get(key, nil))
+}
+
+func (s *levelDBSnapshot) NewIterator(prefix []byte) Iterator {
+	return s.snap.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+func (s *levelDBSnapshot) Release() {
+	s.snap.Release()
+}
+
+// levelDBGet converts the leveldb not found error into ErrNotFound.
+func levelDBGet(value []byte, err error) ([]byte, error) {
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+
+	return value, err
+}
@@ -0,0 +1,410 @@
+package db
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// MemoryScheme is the prefix of database paths that use the in-memory engine, for example
+// mem:// or mem:///var/lib/dkv/cache.snap?interval=30s. If a file path is given the data is
+// restored from the snapshot file on startup and written into it periodically and on close.
+const MemoryScheme = "mem://"
+
+const (
+	// memoryShards is the amount of skiplists the keys are hashed into, such that writes into
+	// different keys don't all contend on the same lock.
+	memoryShards = 16
+
+	// defaultSnapshotInterval is how often the snapshot file is written if the path doesn't
+	// specify an interval.
+	defaultSnapshotInterval = time.Minute
+
+	// the skiplists never free the space of overwritten and deleted values, so a shard is rebuilt
+	// once the garbage grows larger than the live data and minRebuildSize.
+	minRebuildSize = 1 << 20
+)
+
+// MemoryEngine keeps all of the data in sharded in-memory skiplists. The keys are hashed into
+// the shards and iterators merge the shards back into sorted order.
+type MemoryEngine struct {
+	shards [memoryShards]memoryShard
+
+	// snapshot persistence, the path is empty if the data is not persisted.
+	path     string
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type memoryShard struct {
+	mu sync.RWMutex
+	db *memdb.DB
+
+	// snapshots is the amount of snapshots that share db. The next write copies the skiplist
+	// before modifying it, such that the snapshots keep seeing the old data.
+	snapshots int
+}
+
+// memorySnapshot shares the skiplists of the engine at the time of the snapshot.
+type memorySnapshot struct {
+	e    *MemoryEngine
+	dbs  [memoryShards]*memdb.DB
+	once sync.Once
+}
+
+// NewMemoryEngine returns an empty in-memory storage engine that doesn't persist its data.
+func NewMemoryEngine() *MemoryEngine {
+	e := &MemoryEngine{}
+	for i := range e.shards {
+		e.shards[i].db = memdb.New(comparer.DefaultComparer, 0)
+	}
+
+	return e
+}
+
+// OpenMemory returns an in-memory storage engine that is restored from the snapshot file at
+// path if it exists and writes the snapshot into it every interval. An empty path disables
+// the persistence.
+func OpenMemory(path string, interval time.Duration) (*MemoryEngine, error) {
+	e := NewMemoryEngine()
+	if path == "" {
+		return e, nil
+	}
+
+	if err := e.LoadSnapshot(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	e.path = path
+	e.interval = interval
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.snapshotLoop()
+
+	return e, nil
+}
+
+// parseMemoryPath parses the snapshot file path and interval from a mem:// path. The interval
+// must be positive, since it drives the ticker of the snapshot loop.
+func parseMemoryPath(path string) (string, time.Duration, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	interval := defaultSnapshotInterval
+	if v := u.Query().Get("interval"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			return "", 0, fmt.Errorf("invalid snapshot interval %q, use a value like 30s", v)
+		}
+		if interval <= 0 {
+			return "", 0, fmt.Errorf("invalid snapshot interval %q, it must be positive", v)
+		}
+	}
+
+	return u.Path, interval, nil
+}
+
+func isMemoryPath(path string) bool {
+	return strings.HasPrefix(path, MemoryScheme)
+}
+
+func (e *MemoryEngine) shard(key []byte) *memoryShard {
+	h := fnv.New32a()
+	h.Write(key)
+
+	return &e.shards[h.Sum32()%memoryShards]
+}
+
+func (e *MemoryEngine) Get(key []byte) ([]byte, error) {
+	s := e.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, err := s.db.Get(key)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+
+	// the value points into the memory of the skiplist, so it needs to be copied before the
+	// caller is allowed to modify it.
+	return copyBytes(value), err
+}
+
+func (e *MemoryEngine) Put(key, value []byte) error {
+	batch := new(Batch)
+	batch.Put(key, value)
+
+	return e.Write(batch)
+}
+
+func (e *MemoryEngine) Delete(key []byte) error {
+	batch := new(Batch)
+	batch.Delete(key)
+
+	return e.Write(batch)
+}
+
+// Write locks all of the shards that the batch touches in order and applies the batch, such that
+// readers never see a partially applied batch.
+func (e *MemoryEngine) Write(batch *Batch) error {
+	var touched [memoryShards]bool
+	for _, op := range batch.ops {
+		touched[e.shardIndex(op.key)] = true
+	}
+
+	for i := range e.shards {
+		if touched[i] {
+			e.shards[i].mu.Lock()
+			defer e.shards[i].mu.Unlock()
+		}
+	}
+
+	for i := range e.shards {
+		if touched[i] {
+			e.shards[i].unshare()
+		}
+	}
+
+	for _, op := range batch.ops {
+		s := e.shard(op.key)
+
+		var err error
+		if op.delete {
+			err = s.db.Delete(op.key)
+		} else {
+			err = s.db.Put(op.key, op.value)
+		}
+
+		if err != nil && err != leveldb.ErrNotFound {
+			return err
+		}
+	}
+
+	for i := range e.shards {
+		if touched[i] {
+			e.shards[i].rebuild()
+		}
+	}
+
+	return nil
+}
+
+func (e *MemoryEngine) shardIndex(key []byte) int {
+	return memoryShardIndex(key)
+}
+
+func memoryShardIndex(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+
+	return int(h.Sum32() % memoryShards)
+}
+
+// NewIterator merges the iterators of the shards. The iterator is not a consistent snapshot, but
+// it is safe to write into the engine while iterating.
+func (e *MemoryEngine) NewIterator(prefix []byte) Iterator {
+	rng := util.BytesPrefix(prefix)
+	iters := make([]iterator.Iterator, memoryShards)
+	for i := range e.shards {
+		e.shards[i].mu.RLock()
+		iters[i] = e.shards[i].db.NewIterator(rng)
+		e.shards[i].mu.RUnlock()
+	}
+
+	return iterator.NewMergedIterator(iters, comparer.DefaultComparer, true)
+}
+
+// Snapshot shares the skiplists of all of the shards while holding all of the shard locks, so it
+// doesn't copy anything. A shard is copied only when it is written before the snapshot is
+// released.
+func (e *MemoryEngine) Snapshot() (Snapshot, error) {
+	for i := range e.shards {
+		e.shards[i].mu.Lock()
+		defer e.shards[i].mu.Unlock()
+	}
+
+	snap := &memorySnapshot{e: e}
+	for i := range e.shards {
+		snap.dbs[i] = e.shards[i].db
+		e.shards[i].snapshots++
+	}
+
+	return snap, nil
+}
+
+func (s *memorySnapshot) Get(key []byte) ([]byte, error) {
+	value, err := s.dbs[memoryShardIndex(key)].Get(key)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+
+	return copyBytes(value), err
+}
+
+func (s *memorySnapshot) NewIterator(prefix []byte) Iterator {
+	rng := util.BytesPrefix(prefix)
+	iters := make([]iterator.Iterator, memoryShards)
+	for i, db := range s.dbs {
+		iters[i] = db.NewIterator(rng)
+	}
+
+	return iterator.NewMergedIterator(iters, comparer.DefaultComparer, true)
+}
+
+// Release stops sharing the skiplists that have not been copied since the snapshot.
+func (s *memorySnapshot) Release() {
+	s.once.Do(func() {
+		for i := range s.e.shards {
+			shard := &s.e.shards[i]
+			shard.mu.Lock()
+			if shard.db == s.dbs[i] {
+				shard.snapshots--
+			}
+			shard.mu.Unlock()
+		}
+	})
+}
+
+// SaveSnapshot writes all of the data into a snapshot file at path. The file is written next to
+// the old one and renamed over it, such that a crash never leaves a partial snapshot behind.
+func (e *MemoryEngine) SaveSnapshot(path string) error {
+	snap, err := e.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	iter := snap.NewIterator(nil)
+	err = writeRecordFile(f, iter)
+	iter.Release()
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot adds all of the key-value pairs from the snapshot file at path into the engine.
+func (e *MemoryEngine) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	batch := new(Batch)
+	err = readRecordFile(f, func(key, value []byte) error {
+		batch.Put(key, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.Write(batch)
+}
+
+// snapshotLoop writes the snapshot file every interval until the engine is closed.
+func (e *MemoryEngine) snapshotLoop() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.SaveSnapshot(e.path); err != nil {
+				log.Printf("could not save memory snapshot: %s", err)
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Close writes the final snapshot if the data is persisted and frees the data. The later calls
+// return the error of the first one.
+func (e *MemoryEngine) Close() error {
+	e.closeOnce.Do(func() {
+		if e.path != "" {
+			close(e.stop)
+			<-e.done
+			e.closeErr = e.SaveSnapshot(e.path)
+		}
+
+		// the skiplists of the snapshots are left for the garbage collector
+		for i := range e.shards {
+			e.shards[i].mu.Lock()
+			e.shards[i].db = memdb.New(comparer.DefaultComparer, 0)
+			e.shards[i].snapshots = 0
+			e.shards[i].mu.Unlock()
+		}
+	})
+
+	return e.closeErr
+}
+
+// unshare copies the skiplist of the shard if a snapshot shares it. The caller must hold the
+// shard lock.
+func (s *memoryShard) unshare() {
+	if s.snapshots > 0 {
+		s.db = copyMemDB(s.db)
+		s.snapshots = 0
+	}
+}
+
+// rebuild copies the live data of the shard into a new skiplist once the space used by
+// overwritten and deleted values grows too large. Open iterators keep using the old skiplist.
+// The caller must hold the shard lock.
+func (s *memoryShard) rebuild() {
+	size := s.db.Size()
+	if s.db.Capacity()-size < minRebuildSize || s.db.Capacity() < 2*size {
+		return
+	}
+
+	s.db = copyMemDB(s.db)
+}
+
+func copyMemDB(src *memdb.DB) *memdb.DB {
+	dst := memdb.New(comparer.DefaultComparer, src.Size())
+	iter := src.NewIterator(nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		dst.Put(iter.Key(), iter.Value())
+	}
+
+	return dst
+}
@@ -0,0 +1,341 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testEngine is the storage engine that the tests are currently run against.
+var testEngine string
+
+// TestMain runs all of the tests once for every storage engine.
+func TestMain(m *testing.M) {
+	for _, engine := range Engines {
+		testEngine = engine
+		log.Printf("running tests with the %s engine", engine)
+
+		if code := m.Run(); code != 0 {
+			os.Exit(code)
+		}
+	}
+
+	os.Exit(0)
+}
+
+// OpenTest opens a database at path using the engine that the tests are currently run against.
+// It is exported for the tests in the db_test package.
+func OpenTest(path string, ronly bool) (*DB, error) {
+	return Open(testEngine, path, ronly)
+}
+
+// StoredRevision returns the revision that the database continues from after a restart. It is
+// exported for the tests in the db_test package.
+func StoredRevision(d *DB) uint64 {
+	data, err := d.db.Get(metaKey(revisionKey))
+	if err != nil || len(data) != 8 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(data)
+}
+
+// PersistentTestEngine reports if the current test engine keeps its data after it is closed.
+func PersistentTestEngine() bool {
+	return testEngine != EngineMemory
+}
+
+func TestEngineIterator(t *testing.T) {
+	db := createTempDb(t, false)
+	engine := db.Engine()
+
+	for _, key := range []string{"a1", "b1", "b2", "b3", "c1"} {
+		if err := engine.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatalf("error writing key, err: %s", err)
+		}
+	}
+
+	iter := engine.NewIterator([]byte("b"))
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+		if !bytes.Equal(iter.Value(), []byte("v"+string(iter.Key()))) {
+			t.Errorf("wrong value for key %q: %q", iter.Key(), iter.Value())
+		}
+	}
+	iter.Release()
+
+	if err := iter.Error(); err != nil {
+		t.Fatalf("iterator error: %s", err)
+	}
+
+	if len(keys) != 3 || keys[0] != "b1" || keys[2] != "b3" {
+		t.Fatalf("wrong keys from prefix iterator. got=%q", keys)
+	}
+}
+
+func TestEngineBatchAndSnapshot(t *testing.T) {
+	db := createTempDb(t, false)
+	engine := db.Engine()
+
+	if err := engine.Put([]byte("k1"), []byte("old")); err != nil {
+		t.Fatalf("error writing key, err: %s", err)
+	}
+
+	snap, err := engine.Snapshot()
+	if err != nil {
+		t.Fatalf("could not take snapshot, err: %s", err)
+	}
+	defer snap.Release()
+
+	batch := new(Batch)
+	batch.Put([]byte("k1"), []byte("new"))
+	batch.Put([]byte("k2"), []byte("value"))
+	batch.Delete([]byte("k3"))
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("could not write batch, err: %s", err)
+	}
+
+	if val, err := engine.Get([]byte("k1")); err != nil || string(val) != "new" {
+		t.Fatalf("batch was not applied. got=%q err=%v", val, err)
+	}
+
+	if val, err := snap.Get([]byte("k1")); err != nil || string(val) != "old" {
+		t.Fatalf("snapshot saw a later write. got=%q err=%v", val, err)
+	}
+
+	if _, err := snap.Get([]byte("k2")); err != ErrNotFound {
+		t.Fatalf("snapshot saw a later key, err: %v", err)
+	}
+
+	iter := snap.NewIterator([]byte("k"))
+	count := 0
+	for iter.Next() {
+		count++
+	}
+	iter.Release()
+
+	if count != 1 {
+		t.Fatalf("wrong amount of keys in snapshot. got=%d want=%d", count, 1)
+	}
+}
+
+func TestEngineGetCopies(t *testing.T) {
+	db := createTempDb(t, false)
+	engine := db.Engine()
+
+	engine.Put([]byte("a"), []byte("ab"))
+	engine.Put([]byte("b"), []byte("cd"))
+
+	val, err := engine.Get([]byte("a"))
+	if err != nil {
+		t.Fatalf("error getting key, err: %s", err)
+	}
+
+	// appending to the returned value should never change the stored data
+	_ = append(val, 'x', 'y', 'z')
+
+	if val, _ := engine.Get([]byte("b")); string(val) != "cd" {
+		t.Fatalf("modifying a returned value changed the engine data. got=%q", val)
+	}
+}
+
+func TestMemorySnapshotRestore(t *testing.T) {
+	if testEngine != EngineMemory {
+		t.Skip("the snapshots are only used by the memory engine")
+	}
+
+	path := MemoryScheme + t.TempDir() + "/cache.snap?interval=1h"
+	db, err := Open(EngineLevelDB, path, false)
+	if err != nil {
+		t.Fatalf("could not open memory database, err: %s", err)
+	}
+
+	if _, ok := db.Engine().(*MemoryEngine); !ok {
+		t.Fatalf("mem:// path should open the memory engine, got %T", db.Engine())
+	}
+
+	if err := db.CreateBucket("cache"); err != nil {
+		t.Fatalf("could not create bucket, err: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := []byte("key" + strconv.Itoa(i))
+		if err := db.Bucket("cache").Set(key, []byte("value"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("error setting key, err: %s", err)
+		}
+	}
+	db.Set("default", []byte("value"))
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("could not close database, err: %s", err)
+	}
+
+	db, err = Open(EngineMemory, path, false)
+	if err != nil {
+		t.Fatalf("could not reopen memory database, err: %s", err)
+	}
+	defer db.Close()
+
+	value, err := db.Bucket("cache").Get([]byte("key42"))
+	if err != nil || string(value) != "value42" {
+		t.Fatalf("bucket data wasn't restored. got=%q err=%v", value, err)
+	}
+
+	if value, err := db.Get("default"); err != nil || string(value) != "value" {
+		t.Fatalf("default bucket data wasn't restored. got=%q err=%v", value, err)
+	}
+}
+
+func TestMemorySnapshotSharing(t *testing.T) {
+	e := NewMemoryEngine()
+	for i := 0; i < 100; i++ {
+		e.Put([]byte("key"+strconv.Itoa(i)), []byte("old"))
+	}
+
+	s, err := e.Snapshot()
+	if err != nil {
+		t.Fatalf("could not take snapshot, err: %s", err)
+	}
+	snap := s.(*memorySnapshot)
+
+	for i := range e.shards {
+		if snap.dbs[i] != e.shards[i].db {
+			t.Fatalf("the snapshot copied shard %d", i)
+		}
+	}
+
+	key := []byte("key42")
+	if err := e.Put(key, []byte("new")); err != nil {
+		t.Fatalf("error writing key, err: %s", err)
+	}
+
+	// only the written shard is copied
+	written := memoryShardIndex(key)
+	for i := range e.shards {
+		if shared := snap.dbs[i] == e.shards[i].db; shared == (i == written) {
+			t.Errorf("wrong sharing of shard %d after a write. shared=%v", i, shared)
+		}
+	}
+
+	if val, err := snap.Get(key); err != nil || string(val) != "old" {
+		t.Fatalf("snapshot saw a later write. got=%q err=%v", val, err)
+	}
+
+	snap.Release()
+	snap.Release()
+	for i := range e.shards {
+		if e.shards[i].snapshots != 0 {
+			t.Errorf("shard %d is still shared by %d snapshots", i, e.shards[i].snapshots)
+		}
+	}
+}
+
+func TestMemoryCloseTwice(t *testing.T) {
+	e, err := OpenMemory(t.TempDir()+"/cache.snap", time.Hour)
+	if err != nil {
+		t.Fatalf("could not open memory engine, err: %s", err)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("could not close, err: %s", err)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("the second close failed, err: %s", err)
+	}
+}
+
+func TestParseMemoryPath(t *testing.T) {
+	file, interval, err := parseMemoryPath("mem:///var/lib/dkv/cache.snap?interval=30s")
+	if err != nil {
+		t.Fatalf("could not parse path, err: %s", err)
+	}
+	if file != "/var/lib/dkv/cache.snap" || interval != 30*time.Second {
+		t.Errorf("got file %q and interval %s", file, interval)
+	}
+
+	if _, interval, err = parseMemoryPath("mem://"); err != nil || interval != defaultSnapshotInterval {
+		t.Errorf("want the default interval, got %s, err: %v", interval, err)
+	}
+
+	for _, path := range []string{
+		"mem:///x.snap?interval=0s",
+		"mem:///x.snap?interval=-1m",
+		"mem:///x.snap?interval=soon",
+	} {
+		if _, _, err := parseMemoryPath(path); err == nil {
+			t.Errorf("%s: the invalid interval was accepted", path)
+		}
+	}
+}
+
+func TestMemorySnapshotCorrupted(t *testing.T) {
+	if testEngine != EngineMemory {
+		t.Skip("the snapshots are only used by the memory engine")
+	}
+
+	path := t.TempDir() + "/cache.snap"
+	e := NewMemoryEngine()
+	e.Put([]byte("key"), []byte("value"))
+	if err := e.SaveSnapshot(path); err != nil {
+		t.Fatalf("could not save snapshot, err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read snapshot, err: %s", err)
+	}
+
+	data[len(data)-6] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("could not write snapshot, err: %s", err)
+	}
+
+	if _, err := OpenMemory(path, time.Hour); err != ErrCorruptFile {
+		t.Fatalf("expected ErrCorruptFile, got %v", err)
+	}
+}
+
+func TestRecordFileCorruptedLength(t *testing.T) {
+	var buf [binary.MaxVarintLen64]byte
+	for _, length := range []uint64{1 << 62, maxRecordSize, 100 << 20} {
+		data := append([]byte{}, recordFileMagic...)
+		data = append(data, buf[:binary.PutUvarint(buf[:], length)]...)
+		data = append(data, "short"...)
+
+		err := readRecordFile(bytes.NewReader(data), func(key, value []byte) error { return nil })
+		if err != ErrCorruptFile {
+			t.Errorf("length %d: expected ErrCorruptFile, got %v", length, err)
+		}
+	}
+}
+
+func TestParseLevelStats(t *testing.T) {
+	table := "Compactions\n" +
+		" Level |   Tables   |    Size(MB)   |    Time(sec)  |    Read(MB)   |   Write(MB)\n" +
+		"-------+------------+---------------+---------------+---------------+---------------\n" +
+		"   0   |          2 |       1.00000 |       0.50000 |       0.00000 |       1.00000\n" +
+		"   1   |          4 |       2.50000 |       1.25000 |       3.00000 |       2.50000\n"
+
+	levels := parseLevelStats(table)
+	want := []LevelStats{
+		{Level: 0, Tables: 2, Size: 1 << 20, CompactionTime: 500 * time.Millisecond, CompactionWrite: 1 << 20},
+		{Level: 1, Tables: 4, Size: 5 << 19, CompactionTime: 1250 * time.Millisecond, CompactionRead: 3 << 20, CompactionWrite: 5 << 19},
+	}
+
+	if len(levels) != len(want) {
+		t.Fatalf("wrong amount of levels. got=%d want=%d", len(levels), len(want))
+	}
+
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("wrong stats for level %d. got=%+v want=%+v", i, levels[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+)
+
+// expiryBucket stores the deadlines of the keys in the default bucket that have a time to live as
+// 8-byte big-endian unix nanoseconds.
+const expiryBucket = "ex"
+
+// SetOptions are the options of SetWithOptions.
+type SetOptions struct {
+	// TTL is the time to live of the key, the key doesn't expire if it is zero.
+	TTL time.Duration
+
+	// KeepTTL keeps the time to live of an existing key when TTL is zero.
+	KeepTTL bool
+
+	// IfNotExists only sets the value if the key doesn't exist and IfExists only if it exists.
+	IfNotExists bool
+	IfExists    bool
+
+	// CheckVersion only sets the value if the version of the key is Version. The error is
+	// ErrNotFound if the key doesn't exist and ErrVersionMismatch if the version is different.
+	CheckVersion bool
+	Version      uint64
+
+	// ContentType is stored next to the value like in SetWithType.
+	ContentType string
+
+	// Flags are stored next to the value, see Item.
+	Flags uint32
+}
+
+// SetWithOptions sets the value of the key with the options. It returns false if the value was not
+// set because of IfNotExists or IfExists.
+func (d *DB) SetWithOptions(key string, value []byte, opts SetOptions) (bool, error) {
+	if d.ronly {
+		return false, ErrReadOnly
+	}
+
+	mu := d.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var current *Item
+	if opts.IfNotExists || opts.IfExists || opts.CheckVersion || opts.KeepTTL {
+		var err error
+		current, err = d.GetItem(key)
+		if err != nil && err != ErrNotFound {
+			return false, err
+		}
+
+		exists := err == nil
+		if (opts.IfNotExists && exists) || (opts.IfExists && !exists) {
+			return false, nil
+		}
+
+		if opts.CheckVersion && !exists {
+			return false, ErrNotFound
+		}
+
+		if opts.CheckVersion && current.Version != opts.Version {
+			return false, ErrVersionMismatch
+		}
+	}
+
+	meta := itemMeta{contentType: opts.ContentType, flags: opts.Flags}
+	if opts.TTL > 0 {
+		meta.deadline = time.Now().Add(opts.TTL)
+	} else if opts.KeepTTL && current != nil {
+		meta.deadline = current.Deadline
+	}
+
+	return true, d.setItem(key, value, meta)
+}
+
+// TTL returns the remaining time to live of the key. It is zero if the key doesn't expire.
+func (d *DB) TTL(key string) (time.Duration, error) {
+	if _, err := d.Get(key); err != nil {
+		return 0, err
+	}
+
+	deadline, err := d.deadline(key)
+	if err != nil || deadline.IsZero() {
+		return 0, err
+	}
+
+	return time.Until(deadline), nil
+}
+
+// DeleteExpired deletes the keys whose time to live has passed and returns the amount of deleted
+// keys. The expired keys are not found even before they are deleted, so this only frees space.
+func (d *DB) DeleteExpired() (int, error) {
+	if d.ronly {
+		return 0, ErrReadOnly
+	}
+
+	now := time.Now()
+	var keys []string
+	err := d.Bucket(expiryBucket).Scan(nil, nil, func(key, value []byte) error {
+		if !decodeDeadline(value).After(now) {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		mu := d.keyLock(key)
+		mu.Lock()
+
+		// the key could have been set again after the scan. The expired value is not sent to the
+		// replicas anymore, since they expire the key on their own.
+		expired, err := d.expired(key)
+		if err == nil && expired {
+			err = d.deleteItem(key, true)
+			deleted++
+		}
+		mu.Unlock()
+
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// ExpireLoop deletes the expired keys every interval until stop is closed.
+func (d *DB) ExpireLoop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := d.DeleteExpired(); err != nil {
+				log.Printf("could not delete expired keys: %s", err)
+			}
+		}
+	}
+}
+
+// deadline returns the time when the key expires, which is zero if the key doesn't expire.
+func (d *DB) deadline(key string) (time.Time, error) {
+	data, err := d.Bucket(expiryBucket).Get([]byte(key))
+	if err == ErrNotFound {
+		return time.Time{}, nil
+	}
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return decodeDeadline(data), nil
+}
+
+func (d *DB) expired(key string) (bool, error) {
+	deadline, err := d.deadline(key)
+	if err != nil || deadline.IsZero() {
+		return false, err
+	}
+
+	return !deadline.After(time.Now()), nil
+}
+
+func encodeDeadline(t time.Time) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(t.UnixNano()))
+
+	return buf
+}
+
+func decodeDeadline(data []byte) time.Time {
+	if len(data) != 8 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+}
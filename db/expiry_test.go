@@ -0,0 +1,125 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestSetOptions(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	ok, err := d.SetWithOptions("k", []byte("1"), db.SetOptions{IfExists: true})
+	if err != nil || ok {
+		t.Fatalf("XX set a missing key. ok=%v, err=%v", ok, err)
+	}
+
+	ok, err = d.SetWithOptions("k", []byte("1"), db.SetOptions{IfNotExists: true})
+	if err != nil || !ok {
+		t.Fatalf("NX didn't set a missing key. ok=%v, err=%v", ok, err)
+	}
+
+	ok, err = d.SetWithOptions("k", []byte("2"), db.SetOptions{IfNotExists: true})
+	if err != nil || ok {
+		t.Fatalf("NX set an existing key. ok=%v, err=%v", ok, err)
+	}
+
+	ok, err = d.SetWithOptions("k", []byte("3"), db.SetOptions{IfExists: true})
+	if err != nil || !ok {
+		t.Fatalf("XX didn't set an existing key. ok=%v, err=%v", ok, err)
+	}
+
+	if value, _ := d.Get("k"); string(value) != "3" {
+		t.Errorf("wrong value. got=%q", value)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	d := createTestDatabase(t, false)
+	ttl := 50 * time.Millisecond
+
+	for _, key := range []string{"expires", "counter", "persisted"} {
+		if _, err := d.SetWithOptions(key, []byte("1"), db.SetOptions{TTL: ttl}); err != nil {
+			t.Fatalf("could not set with ttl: %s", err)
+		}
+	}
+
+	if left, err := d.TTL("expires"); err != nil || left <= 0 || left > ttl {
+		t.Errorf("wrong ttl. got=%s, err=%v", left, err)
+	}
+
+	// incr keeps the ttl and a plain set removes it
+	if _, err := d.Incr("counter", 1); err != nil {
+		t.Fatalf("could not increment: %s", err)
+	}
+
+	if err := d.Set("persisted", []byte("2")); err != nil {
+		t.Fatalf("could not set: %s", err)
+	}
+
+	time.Sleep(2 * ttl)
+
+	for _, key := range []string{"expires", "counter"} {
+		if _, err := d.Get(key); err != db.ErrNotFound {
+			t.Errorf("the key %q didn't expire. err=%v", key, err)
+		}
+	}
+
+	if value, err := d.Get("persisted"); err != nil || string(value) != "2" {
+		t.Errorf("the key without ttl expired. got=%q, err=%v", value, err)
+	}
+
+	scanned := 0
+	d.Scan(nil, nil, func(key, value []byte) error {
+		scanned++
+		return nil
+	})
+
+	if scanned != 1 {
+		t.Errorf("the expired keys were scanned. got=%d", scanned)
+	}
+
+	// an expired counter starts from zero without the old ttl
+	if n, err := d.Incr("counter", 5); err != nil || n != 5 {
+		t.Errorf("wrong value for an expired counter. got=%d, err=%v", n, err)
+	}
+
+	deleted, err := d.DeleteExpired()
+	if err != nil || deleted != 1 {
+		t.Errorf("wrong amount of deleted keys. got=%d, err=%v", deleted, err)
+	}
+
+	if value, err := d.Get("counter"); err != nil || string(value) != "5" {
+		t.Errorf("the counter was deleted. got=%q, err=%v", value, err)
+	}
+}
+
+func TestExpiryReplicationQueue(t *testing.T) {
+	d := createTestDatabase(t, false)
+	ttl := 20 * time.Millisecond
+
+	if _, err := d.SetWithOptions("expires", []byte("1"), db.SetOptions{TTL: ttl}); err != nil {
+		t.Fatalf("could not set with ttl: %s", err)
+	}
+
+	item, err := d.ReplicationItem("expires")
+	if err != nil || item.Deadline.IsZero() {
+		t.Fatalf("the deadline is not replicated. got=%+v, err=%v", item, err)
+	}
+
+	time.Sleep(2 * ttl)
+
+	// the metadata is still sent for a key that has expired in the queue
+	if item, err := d.ReplicationItem("expires"); err != nil || item.Deadline.IsZero() {
+		t.Errorf("the deadline of an expired key is missing. got=%+v, err=%v", item, err)
+	}
+
+	if _, err := d.DeleteExpired(); err != nil {
+		t.Fatalf("could not delete expired keys: %s", err)
+	}
+
+	if key, _, err := d.GetNextReplica(); err != nil || key != nil {
+		t.Errorf("the expired key is still in the replication queue. got=%q, err=%v", key, err)
+	}
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// the internal buckets that store the versions and the flags of the values in the default bucket.
+const (
+	versionBucket = "vr"
+	flagsBucket   = "fl"
+)
+
+var (
+	// ErrVersionMismatch happens when a value is set with SetOptions.CheckVersion and the key has
+	// a different version.
+	ErrVersionMismatch = errors.New("the version of the key doesn't match")
+
+	errCorruptMeta = errors.New("the metadata of the key is corrupted")
+)
+
+// Item is a value in the default bucket with its metadata.
+type Item struct {
+	Value       []byte
+	ContentType string
+
+	// Flags are opaque to the database and they are stored for the memcached protocol.
+	Flags uint32
+
+	// Version changes whenever the value is written. The values that were written before the
+	// versions existed have the version 0 until they are written again.
+	Version uint64
+
+	// Deadline is the time when the key expires, which is zero if the key doesn't expire.
+	Deadline time.Time
+}
+
+// itemMeta is the metadata that is written next to a value.
+type itemMeta struct {
+	contentType string
+	deadline    time.Time
+	flags       uint32
+}
+
+// GetItem returns the value of the key with its metadata.
+func (d *DB) GetItem(key string) (*Item, error) {
+	value, contentType, err := d.GetWithType(key)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := d.meta(key)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := d.getUint(versionBucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Item{
+		Value:       value,
+		ContentType: contentType,
+		Flags:       meta.flags,
+		Version:     version,
+		Deadline:    meta.deadline,
+	}, nil
+}
+
+// ReplicationItem returns the content type, the flags and the deadline of the key, which are sent
+// to the replicas with the value from the replication queue. Unlike GetItem it doesn't hide
+// expired keys and the value and the version are not included.
+func (d *DB) ReplicationItem(key string) (*Item, error) {
+	meta, err := d.meta(key)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, err := d.Bucket(contentTypeBucket).Get([]byte(key))
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	return &Item{ContentType: string(contentType), Flags: meta.flags, Deadline: meta.deadline}, nil
+}
+
+// meta returns the time to live and the flags of the key, such that they can be kept when the
+// value is modified. The content type is not included.
+func (d *DB) meta(key string) (itemMeta, error) {
+	deadline, err := d.deadline(key)
+	if err != nil {
+		return itemMeta{}, err
+	}
+
+	flags, err := d.getUint(flagsBucket, key)
+	if err != nil {
+		return itemMeta{}, err
+	}
+
+	return itemMeta{deadline: deadline, flags: uint32(flags)}, nil
+}
+
+// getUint returns the big-endian integer of the key in the internal bucket, which is 0 if the key
+// doesn't exist.
+func (d *DB) getUint(bucket, key string) (uint64, error) {
+	data, err := d.Bucket(bucket).Get([]byte(key))
+	if err == ErrNotFound {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(data) {
+	case 4:
+		return uint64(binary.BigEndian.Uint32(data)), nil
+	case 8:
+		return binary.BigEndian.Uint64(data), nil
+	}
+
+	return 0, errCorruptMeta
+}
+
+// nextVersion returns a new version that is larger than the previous versions. The versions are
+// based on the clock, such that they keep increasing after a restart.
+func (d *DB) nextVersion() uint64 {
+	for {
+		last := atomic.LoadUint64(&d.lastVersion)
+		next := uint64(time.Now().UnixNano())
+		if next <= last {
+			next = last + 1
+		}
+
+		if atomic.CompareAndSwapUint64(&d.lastVersion, last, next) {
+			return next
+		}
+	}
+}
+
+func encodeVersion(version uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, version)
+
+	return buf
+}
+
+func encodeFlags(flags uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, flags)
+
+	return buf
+}
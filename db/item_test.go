@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestItemVersions(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if _, err := d.GetItem("k"); err != db.ErrNotFound {
+		t.Fatalf("missing key was found. err=%v", err)
+	}
+
+	ok, err := d.SetWithOptions("k", []byte("1"), db.SetOptions{Flags: 42, TTL: time.Minute})
+	if err != nil || !ok {
+		t.Fatalf("could not set key. ok=%v, err=%v", ok, err)
+	}
+
+	item, err := d.GetItem("k")
+	if err != nil {
+		t.Fatalf("could not get item: %s", err)
+	}
+
+	if string(item.Value) != "1" || item.Flags != 42 || item.Version == 0 || item.Deadline.IsZero() {
+		t.Fatalf("wrong item. got=%+v", item)
+	}
+
+	_, err = d.SetWithOptions("k", []byte("2"), db.SetOptions{CheckVersion: true, Version: item.Version + 1})
+	if err != db.ErrVersionMismatch {
+		t.Errorf("wrong error for a different version. got=%v", err)
+	}
+
+	_, err = d.SetWithOptions("missing", []byte("2"), db.SetOptions{CheckVersion: true, Version: item.Version})
+	if err != db.ErrNotFound {
+		t.Errorf("wrong error for a missing key. got=%v", err)
+	}
+
+	_, err = d.SetWithOptions("k", []byte("2"), db.SetOptions{CheckVersion: true, Version: item.Version, KeepTTL: true})
+	if err != nil {
+		t.Fatalf("could not set key with its version: %s", err)
+	}
+
+	updated, err := d.GetItem("k")
+	if err != nil || updated.Version <= item.Version || updated.Flags != 0 || !updated.Deadline.Equal(item.Deadline) {
+		t.Errorf("wrong item after update. got=%+v, err=%v", updated, err)
+	}
+
+	// the increments keep the flags and the time to live
+	d.SetWithOptions("n", []byte("1"), db.SetOptions{Flags: 7, TTL: time.Minute})
+	if _, err := d.Incr("n", 1); err != nil {
+		t.Fatalf("could not increment: %s", err)
+	}
+
+	if item, err := d.GetItem("n"); err != nil || item.Flags != 7 || item.Deadline.IsZero() {
+		t.Errorf("the increment lost the metadata. got=%+v, err=%v", item, err)
+	}
+
+	// a plain set clears the flags
+	d.Set("n", []byte("1"))
+	if item, err := d.GetItem("n"); err != nil || item.Flags != 0 {
+		t.Errorf("the flags were kept. got=%+v, err=%v", item, err)
+	}
+}
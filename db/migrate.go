@@ -0,0 +1,124 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// ErrAlreadyMigrated happens when migrating a database that already uses the current key encoding.
+var ErrAlreadyMigrated = errors.New("the database already uses the current key encoding")
+
+// oldCatalogPrefix is the prefix of the bucket catalogue in the old key encoding.
+const oldCatalogPrefix = "\x00c"
+
+// Migrate copies the database at src, which uses the old key encoding where the bucket names were
+// used as key prefixes, into a new database at dst that uses fixed-width bucket ids. The internal
+// buckets get their reserved ids and the user created buckets get new ids from the catalogue.
+// It returns the amount of keys that were copied. The src database is not modified.
+func Migrate(src, dst string) (int, error) {
+	old, err := leveldb.OpenFile(src, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return 0, err
+	}
+	defer old.Close()
+
+	if _, err := old.Get(metaKey(formatKey), nil); err == nil {
+		return 0, ErrAlreadyMigrated
+	}
+
+	prefixes := map[string][]byte{
+		defaultBucket:     encodeBucketID(defaultBucketID),
+		replicaBucket:     encodeBucketID(replicaBucketID),
+		counterBucket:     encodeBucketID(counterBucketID),
+		setBucket:         encodeBucketID(setBucketID),
+		registerBucket:    encodeBucketID(registerBucketID),
+		crdtReplicaBucket: encodeBucketID(crdtReplicaBucketID),
+	}
+
+	// give the user created buckets new ids in the order of their names
+	var names []string
+	iter := old.NewIterator(nil, nil)
+	for iter.Next() {
+		if bytes.HasPrefix(iter.Key(), []byte(oldCatalogPrefix)) {
+			names = append(names, string(iter.Key()[len(oldCatalogPrefix):]))
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	sort.Strings(names)
+
+	if len(names) > MaxBuckets {
+		return 0, ErrTooManyBuckets
+	}
+
+	catalog := make(map[string][]byte, len(names))
+	for i, name := range names {
+		id := encodeBucketID(uint16(firstUserBucketID + i))
+		prefixes[name] = id
+		catalog[name] = id
+	}
+
+	ndb, err := leveldb.OpenFile(dst, &opt.Options{ErrorIfExist: true})
+	if err != nil {
+		return 0, err
+	}
+	defer ndb.Close()
+
+	count := 0
+	batch := new(leveldb.Batch)
+	iter = old.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if bytes.HasPrefix(key, []byte(oldCatalogPrefix)) {
+			continue
+		}
+
+		oldPrefix, id := matchOldPrefix(prefixes, key)
+		if id == nil {
+			return count, fmt.Errorf("key %q doesn't belong to any known bucket", key)
+		}
+
+		newKey := append(copyBytes(id), key[len(oldPrefix):]...)
+		batch.Put(newKey, copyBytes(iter.Value()))
+		count++
+
+		if batch.Len() >= deleteBatchSize {
+			if err := ndb.Write(batch, nil); err != nil {
+				return count, err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return count, err
+	}
+
+	for name, id := range catalog {
+		batch.Put(catalogKey(name), id)
+	}
+	batch.Put(metaKey(formatKey), []byte{formatVersion})
+
+	return count, ndb.Write(batch, nil)
+}
+
+// matchOldPrefix finds the longest bucket name that is a prefix of the key.
+func matchOldPrefix(prefixes map[string][]byte, key []byte) (string, []byte) {
+	var best string
+	var id []byte
+	for name, bucketID := range prefixes {
+		if len(name) > len(best) && bytes.HasPrefix(key, []byte(name)) {
+			best, id = name, bucketID
+		}
+	}
+
+	return best, id
+}
@@ -0,0 +1,78 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "dkvmigrate")
+	if err != nil {
+		t.Fatalf("error creating temp file, err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "old")
+	dst := filepath.Join(dir, "new")
+
+	// write a database using the old encoding where the bucket names are the key prefixes
+	old, err := leveldb.OpenFile(src, nil)
+	if err != nil {
+		t.Fatalf("could not create old database, err: %s", err)
+	}
+
+	oldKeys := map[string]string{
+		"deuser":        "alice",
+		"repending":     "value",
+		"\x00cphotos":   "photos",
+		"photoscat.jpg": "meow",
+	}
+	for k, v := range oldKeys {
+		if err := old.Put([]byte(k), []byte(v), nil); err != nil {
+			t.Fatalf("could not write old key, err: %s", err)
+		}
+	}
+	old.Close()
+
+	if _, err := db.NewDatabase(src, false); err != db.ErrNeedsMigration {
+		t.Fatalf("opening old database didn't require migration, err: %v", err)
+	}
+
+	count, err := db.Migrate(src, dst)
+	if err != nil {
+		t.Fatalf("could not migrate database, err: %s", err)
+	}
+
+	if count != 3 {
+		t.Errorf("wrong amount of migrated keys. got=%d want=%d", count, 3)
+	}
+
+	d, err := db.NewDatabase(dst, false)
+	if err != nil {
+		t.Fatalf("could not open migrated database, err: %s", err)
+	}
+
+	if value, err := d.Get("user"); err != nil || string(value) != "alice" {
+		t.Errorf("default bucket was not migrated. got=%q err=%v", value, err)
+	}
+
+	if value, err := d.Bucket("photos").Get([]byte("cat.jpg")); err != nil || string(value) != "meow" {
+		t.Errorf("user bucket was not migrated. got=%q err=%v", value, err)
+	}
+
+	key, value, err := d.GetNextReplica()
+	if err != nil || string(key) != "pending" || string(value) != "value" {
+		t.Errorf("replication queue was not migrated. got=%q-%q err=%v", key, value, err)
+	}
+
+	d.Close()
+
+	if _, err := db.Migrate(dst, filepath.Join(dir, "again")); err != db.ErrAlreadyMigrated {
+		t.Errorf("migrating twice didn't fail, err: %v", err)
+	}
+}
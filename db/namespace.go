@@ -0,0 +1,332 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQuotaExceeded happens when a write into a namespace would exceed one of its limits.
+	ErrQuotaExceeded = errors.New("the namespace quota has been exceeded")
+
+	// ErrInvalidQuota happens when a limit of the quota is negative.
+	ErrInvalidQuota = errors.New("the quota limits cannot be negative")
+
+	// namespacePrefix is the prefix of the namespace entries in the meta bucket.
+	namespacePrefix = "n"
+)
+
+// Quota contains the limits of a namespace. A zero value means that there is no limit.
+type Quota struct {
+	MaxKeys   int64   `json:"max_keys"`
+	MaxBytes  int64   `json:"max_bytes"`
+	WriteRate float64 `json:"write_rate"` // writes per second
+}
+
+// Usage contains the amount of keys and bytes stored in a namespace. The bytes include both the
+// keys and the values.
+type Usage struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// NamespaceInfo describes the quota and the current usage of a namespace.
+type NamespaceInfo struct {
+	Name  string `json:"name"`
+	Quota Quota  `json:"quota"`
+	Usage Usage  `json:"usage"`
+}
+
+func (q Quota) valid() bool {
+	return q.MaxKeys >= 0 && q.MaxBytes >= 0 && q.WriteRate >= 0
+}
+
+// namespace is a bucket that has a quota. The usage is tracked incrementally on every write and
+// it is stored in the meta bucket in the same batch as the write.
+type namespace struct {
+	mu    sync.Mutex
+	name  string
+	quota Quota
+	usage Usage
+
+	// the write rate is limited with a token bucket which holds at most one second of writes.
+	tokens float64
+	last   time.Time
+
+	db *DB
+}
+
+// nsState is the persisted form of a namespace.
+type nsState struct {
+	Quota Quota `json:"quota"`
+	Usage Usage `json:"usage"`
+}
+
+// CreateNamespace creates a new bucket with the given quota.
+func (d *DB) CreateNamespace(name string, q Quota) error {
+	if !q.valid() {
+		return ErrInvalidQuota
+	}
+
+	if err := d.CreateBucket(name); err != nil {
+		return err
+	}
+
+	return d.SetQuota(name, q)
+}
+
+// SetQuota sets the quota of an existing bucket, which makes it a namespace. The usage of the
+// bucket is calculated when it becomes a namespace and after that it is tracked incrementally.
+func (d *DB) SetQuota(name string, q Quota) error {
+	if d.ronly {
+		return ErrReadOnly
+	}
+
+	if !q.valid() {
+		return ErrInvalidQuota
+	}
+
+	b, ok := d.bucket(name)
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	d.bmutex.RLock()
+	reserved := d.system[name]
+	d.bmutex.RUnlock()
+
+	if reserved {
+		return ErrBucketReserved
+	}
+
+	d.nsMutex.Lock()
+	defer d.nsMutex.Unlock()
+
+	ns, ok := d.namespaces[name]
+	if !ok {
+		usage, err := d.bucketUsage(b)
+		if err != nil {
+			return err
+		}
+		ns = &namespace{name: name, usage: usage, db: d}
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.quota = q
+	ns.tokens = ns.burst()
+	ns.last = time.Now()
+
+	batch := new(Batch)
+	if err := ns.writeState(batch, ns.usage); err != nil {
+		return err
+	}
+
+	if err := d.db.Write(batch); err != nil {
+		return err
+	}
+	d.namespaces[name] = ns
+
+	return nil
+}
+
+// Namespaces returns the quotas and the usage of all of the namespaces sorted by name.
+func (d *DB) Namespaces() []NamespaceInfo {
+	d.nsMutex.RLock()
+	defer d.nsMutex.RUnlock()
+
+	infos := make([]NamespaceInfo, 0, len(d.namespaces))
+	for _, ns := range d.namespaces {
+		ns.mu.Lock()
+		infos = append(infos, NamespaceInfo{Name: ns.name, Quota: ns.quota, Usage: ns.usage})
+		ns.mu.Unlock()
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos
+}
+
+// namespace returns the namespace of the bucket or nil if the bucket doesn't have a quota.
+func (d *DB) namespace(name string) *namespace {
+	d.nsMutex.RLock()
+	defer d.nsMutex.RUnlock()
+
+	return d.namespaces[name]
+}
+
+// removeNamespace removes the quota and usage of a dropped bucket.
+func (d *DB) removeNamespace(name string) error {
+	d.nsMutex.Lock()
+	defer d.nsMutex.Unlock()
+
+	if _, ok := d.namespaces[name]; !ok {
+		return nil
+	}
+	delete(d.namespaces, name)
+
+	return d.db.Delete(metaKey(namespacePrefix + name))
+}
+
+// loadNamespaces reads the namespaces from the meta bucket.
+func (d *DB) loadNamespaces() error {
+	d.namespaces = make(map[string]*namespace)
+
+	prefix := metaKey(namespacePrefix)
+	iter := d.db.NewIterator(prefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		var state nsState
+		if err := json.Unmarshal(iter.Value(), &state); err != nil {
+			return err
+		}
+
+		name := string(removeBucketPrefix(prefix, iter.Key()))
+		ns := &namespace{
+			name:  name,
+			quota: state.Quota,
+			usage: state.Usage,
+			last:  time.Now(),
+			db:    d,
+		}
+		ns.tokens = ns.burst()
+		d.namespaces[name] = ns
+	}
+
+	return iter.Error()
+}
+
+// bucketUsage calculates the usage of the bucket by going through all of its keys.
+func (d *DB) bucketUsage(b *Bucket) (Usage, error) {
+	var usage Usage
+
+	iter := d.db.NewIterator(b.id)
+	defer iter.Release()
+
+	for iter.Next() {
+		usage.Keys++
+		usage.Bytes += int64(len(iter.Key()) - bucketIDSize + len(iter.Value()))
+	}
+
+	return usage, iter.Error()
+}
+
+// set writes the key-value pair if it fits into the quota and updates the usage.
+func (ns *namespace) set(b *Bucket, key, data []byte) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if !ns.allowWrite() {
+		return ErrQuotaExceeded
+	}
+
+	prefixedKey := b.bucketPrefix(key)
+	usage := ns.usage
+
+	old, err := ns.db.db.Get(prefixedKey)
+	switch err {
+	case nil:
+		usage.Bytes += int64(len(data) - len(old))
+	case ErrNotFound:
+		usage.Keys++
+		usage.Bytes += int64(len(key) + len(data))
+	default:
+		return err
+	}
+
+	if ns.quota.MaxKeys > 0 && usage.Keys > ns.quota.MaxKeys {
+		return ErrQuotaExceeded
+	}
+
+	if ns.quota.MaxBytes > 0 && usage.Bytes > ns.quota.MaxBytes {
+		return ErrQuotaExceeded
+	}
+
+	batch := new(Batch)
+	batch.Put(prefixedKey, data)
+	return ns.commit(batch, usage)
+}
+
+// delete deletes the key and updates the usage.
+func (ns *namespace) delete(b *Bucket, key []byte) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	prefixedKey := b.bucketPrefix(key)
+	old, err := ns.db.db.Get(prefixedKey)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	usage := ns.usage
+	usage.Keys--
+	usage.Bytes -= int64(len(key) + len(old))
+
+	batch := new(Batch)
+	batch.Delete(prefixedKey)
+	return ns.commit(batch, usage)
+}
+
+// commit writes the batch together with the new usage. The caller must hold the namespace lock.
+func (ns *namespace) commit(batch *Batch, usage Usage) error {
+	if err := ns.writeState(batch, usage); err != nil {
+		return err
+	}
+
+	if err := ns.db.db.Write(batch); err != nil {
+		return err
+	}
+	ns.usage = usage
+
+	return nil
+}
+
+func (ns *namespace) writeState(batch *Batch, usage Usage) error {
+	data, err := json.Marshal(&nsState{Quota: ns.quota, Usage: usage})
+	if err != nil {
+		return err
+	}
+	batch.Put(metaKey(namespacePrefix+ns.name), data)
+
+	return nil
+}
+
+// allowWrite takes a token from the token bucket. The caller must hold the namespace lock.
+func (ns *namespace) allowWrite() bool {
+	if ns.quota.WriteRate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	ns.tokens += now.Sub(ns.last).Seconds() * ns.quota.WriteRate
+	ns.last = now
+
+	if burst := ns.burst(); ns.tokens > burst {
+		ns.tokens = burst
+	}
+
+	if ns.tokens < 1 {
+		return false
+	}
+	ns.tokens--
+
+	return true
+}
+
+// burst returns the size of the token bucket. It allows bursts of one second worth of writes,
+// but always at least a single write.
+func (ns *namespace) burst() float64 {
+	if ns.quota.WriteRate < 1 {
+		return 1
+	}
+
+	return ns.quota.WriteRate
+}
@@ -0,0 +1,153 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestNamespaceKeyQuota(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.CreateNamespace("team", db.Quota{MaxKeys: 2}); err != nil {
+		t.Fatalf("could not create namespace: %s", err)
+	}
+
+	b := d.Bucket("team")
+	for _, key := range []string{"a", "b"} {
+		if err := b.Set([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("could not set key: %s", err)
+		}
+	}
+
+	if err := b.Set([]byte("c"), []byte("value")); err != db.ErrQuotaExceeded {
+		t.Fatalf("wrong error when exceeding key quota. got=%v want=%v", err, db.ErrQuotaExceeded)
+	}
+
+	// overwriting an existing key doesn't add a new key
+	if err := b.Set([]byte("a"), []byte("new")); err != nil {
+		t.Fatalf("could not overwrite key: %s", err)
+	}
+
+	if err := b.Delete([]byte("b")); err != nil {
+		t.Fatalf("could not delete key: %s", err)
+	}
+
+	if err := b.Set([]byte("c"), []byte("value")); err != nil {
+		t.Fatalf("could not set key after freeing quota: %s", err)
+	}
+}
+
+func TestNamespaceByteQuota(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.CreateNamespace("team", db.Quota{MaxBytes: 10}); err != nil {
+		t.Fatalf("could not create namespace: %s", err)
+	}
+
+	b := d.Bucket("team")
+	if err := b.Set([]byte("key"), []byte("1234")); err != nil {
+		t.Fatalf("could not set key: %s", err)
+	}
+
+	if err := b.Set([]byte("key"), []byte("12345678")); err != db.ErrQuotaExceeded {
+		t.Fatalf("wrong error when exceeding byte quota. got=%v want=%v", err, db.ErrQuotaExceeded)
+	}
+
+	infos := d.Namespaces()
+	if len(infos) != 1 || infos[0].Usage != (db.Usage{Keys: 1, Bytes: 7}) {
+		t.Fatalf("wrong namespace usage. got=%+v", infos)
+	}
+}
+
+func TestNamespaceWriteRate(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.CreateNamespace("team", db.Quota{WriteRate: 2}); err != nil {
+		t.Fatalf("could not create namespace: %s", err)
+	}
+
+	b := d.Bucket("team")
+	var limited bool
+	for i := 0; i < 10; i++ {
+		if err := b.Set([]byte("key"), []byte("value")); err == db.ErrQuotaExceeded {
+			limited = true
+			break
+		}
+	}
+
+	if !limited {
+		t.Fatalf("writes were not rate limited")
+	}
+}
+
+func TestNamespaceUsagePersists(t *testing.T) {
+	if !db.PersistentTestEngine() {
+		t.Skip("the storage engine doesn't persist data")
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "dkvdb")
+	if err != nil {
+		t.Fatalf("error creating temp file, err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := db.OpenTest(dir, false)
+	if err != nil {
+		t.Fatalf("could not create new database, err: %s", err)
+	}
+
+	if err := d.CreateBucket("team"); err != nil {
+		t.Fatalf("could not create bucket: %s", err)
+	}
+
+	// the usage of existing data is counted when the quota is set
+	if err := d.Bucket("team").Set([]byte("a"), []byte("bc")); err != nil {
+		t.Fatalf("could not set key: %s", err)
+	}
+
+	if err := d.SetQuota("team", db.Quota{MaxKeys: 5}); err != nil {
+		t.Fatalf("could not set quota: %s", err)
+	}
+
+	if err := d.Bucket("team").Set([]byte("d"), []byte("ef")); err != nil {
+		t.Fatalf("could not set key: %s", err)
+	}
+	d.Close()
+
+	d, err = db.OpenTest(dir, false)
+	if err != nil {
+		t.Fatalf("could not reopen database, err: %s", err)
+	}
+	defer d.Close()
+
+	want := db.NamespaceInfo{Name: "team", Quota: db.Quota{MaxKeys: 5}, Usage: db.Usage{Keys: 2, Bytes: 6}}
+	if infos := d.Namespaces(); len(infos) != 1 || infos[0] != want {
+		t.Fatalf("wrong namespaces after reopening. got=%+v want=%+v", infos, want)
+	}
+}
+
+func TestNamespaceInvalidQuota(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.CreateNamespace("team", db.Quota{MaxKeys: -1}); err != db.ErrInvalidQuota {
+		t.Fatalf("wrong error for a negative quota. got=%v want=%v", err, db.ErrInvalidQuota)
+	}
+
+	// the bucket is not created for an invalid quota
+	if err := d.CreateNamespace("team", db.Quota{MaxKeys: 1}); err != nil {
+		t.Fatalf("could not create namespace: %s", err)
+	}
+
+	for _, q := range []db.Quota{{MaxBytes: -1}, {WriteRate: -0.5}} {
+		if err := d.SetQuota("team", q); err != db.ErrInvalidQuota {
+			t.Errorf("wrong error for quota %+v. got=%v want=%v", q, err, db.ErrInvalidQuota)
+		}
+	}
+
+	if err := d.SetQuota("de", db.Quota{MaxKeys: 1}); err != db.ErrBucketReserved {
+		t.Errorf("wrong error for the default bucket. got=%v want=%v", err, db.ErrBucketReserved)
+	}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrNotInteger happens when incrementing a key whose value is not a base 10 integer.
+	ErrNotInteger = errors.New("the value is not an integer")
+
+	// ErrOverflow happens when an increment would overflow a 64-bit integer.
+	ErrOverflow = errors.New("increment would overflow")
+)
+
+// keyLockCount is the number of locks that the keys are hashed into.
+const keyLockCount = 256
+
+// keyLock returns the lock that guards the writes of the given key.
+func (d *DB) keyLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return &d.keyLocks[h.Sum32()%keyLockCount]
+}
+
+// Incr atomically adds delta to the integer stored in key and returns the new value. A key
+// that doesn't exist is treated as 0. The new value is replicated like any other write.
+func (d *DB) Incr(key string, delta int64) (int64, error) {
+	if d.ronly {
+		return 0, ErrReadOnly
+	}
+
+	mu := d.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var current int64
+	data, err := d.Get(key)
+	if err != nil && err != ErrNotFound {
+		return 0, err
+	}
+
+	found := err == nil
+	if found {
+		current, err = strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+
+	// an existing key keeps its time to live like in redis and its flags like in memcached
+	var meta itemMeta
+	if found {
+		if meta, err = d.meta(key); err != nil {
+			return 0, err
+		}
+	}
+
+	current += delta
+	if err := d.setItem(key, []byte(strconv.FormatInt(current, 10)), meta); err != nil {
+		return 0, err
+	}
+
+	return current, nil
+}
+
+// Decr atomically subtracts delta from the integer stored in key and returns the new value.
+func (d *DB) Decr(key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, ErrOverflow
+	}
+
+	return d.Incr(key, -delta)
+}
+
+// Append atomically appends the value to the end of the value stored in key and returns the
+// new value. A key that doesn't exist is treated as an empty value.
+func (d *DB) Append(key string, value []byte) ([]byte, error) {
+	if d.ronly {
+		return nil, ErrReadOnly
+	}
+
+	mu := d.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the appended value keeps its content type
+	data, contentType, err := d.GetWithType(key)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	var meta itemMeta
+	if err == nil {
+		if meta, err = d.meta(key); err != nil {
+			return nil, err
+		}
+	}
+	meta.contentType = contentType
+
+	data = append(data, value...)
+	if err := d.setItem(key, data, meta); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,82 @@
+package db_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestIncrConcurrent(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := d.Incr("counter", 2); err != nil {
+					t.Errorf("error incrementing key: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, err := d.Decr("counter", 1)
+	if err != nil {
+		t.Fatalf("error decrementing key: %s", err)
+	}
+
+	if value != 999 {
+		t.Fatalf("lost updates while incrementing. got=%d want=%d", value, 999)
+	}
+
+	data, err := d.Get("counter")
+	if err != nil {
+		t.Fatalf("error getting key: %s", err)
+	}
+
+	if string(data) != "999" {
+		t.Fatalf("wrong stored value. got=%q want=%q", data, "999")
+	}
+}
+
+func TestIncrNotInteger(t *testing.T) {
+	d := createTestDatabase(t, false)
+	setKey(t, d, "key", "value")
+
+	if _, err := d.Incr("key", 1); err != db.ErrNotInteger {
+		t.Fatalf("wrong error. got=%v want=%v", err, db.ErrNotInteger)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	for _, part := range []string{"hello", " ", "world"} {
+		if _, err := d.Append("key", []byte(part)); err != nil {
+			t.Fatalf("error appending to key: %s", err)
+		}
+	}
+
+	value, err := d.Get("key")
+	if err != nil {
+		t.Fatalf("error getting key: %s", err)
+	}
+
+	if string(value) != "hello world" {
+		t.Fatalf("wrong value. got=%q want=%q", value, "hello world")
+	}
+
+	// the appended value should be replicated
+	k, v, err := d.GetNextReplica()
+	if err != nil {
+		t.Fatalf("cannot get next key from replication: %s", err)
+	}
+
+	if string(k) != "key" || string(v) != "hello world" {
+		t.Fatalf("wrong replication entry. got=%q-%q", k, v)
+	}
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// ErrCorruptFile happens when a record file is truncated or its checksum doesn't match.
+var ErrCorruptFile = errors.New("the file is corrupted")
+
+const (
+	// maxRecordSize is the largest key or value in a record file. The lengths are only verified by
+	// the checksum at the end of the file, so a larger length means that the file is corrupted.
+	maxRecordSize = 1 << 30
+
+	// recordChunkSize is the amount of bytes that is allocated at once when a record is read,
+	// such that a corrupted length can't allocate more memory than there is data in the file.
+	recordChunkSize = 1 << 20
+)
+
+// the magic headers of the record files. The snapshots of the memory engine and the backup
+// archives use the same record format, but different headers.
+var (
+	recordFileMagic = []byte("DKVR\x01")
+	backupMagic     = []byte("DKVB\x01")
+)
+
+// writeRecordFile writes the key-value pairs of the iterator into w. The file starts with a magic
+// header followed by the length prefixed keys and values. The end of the records is marked with
+// an empty key, since keys can never be empty, and the file ends with a CRC-32 checksum of
+// everything before it.
+func writeRecordFile(w io.Writer, iter Iterator) error {
+	rw := newRecordWriter(w, recordFileMagic)
+	if err := rw.writeHeader(); err != nil {
+		return err
+	}
+
+	for iter.Next() {
+		if err := rw.write(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	return rw.close()
+}
+
+// readRecordFile reads a file written by writeRecordFile and calls fn for every key-value pair.
+// The checksum is only verified at the end, so the caller must be ready to throw away the pairs
+// if an error is returned.
+func readRecordFile(r io.Reader, fn func(key, value []byte) error) error {
+	rr := newRecordReader(r, recordFileMagic)
+	if err := rr.readHeader(); err != nil {
+		return err
+	}
+
+	for {
+		key, value, err := rr.next()
+		if err != nil {
+			return err
+		}
+
+		if key == nil {
+			return rr.verify()
+		}
+
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+type recordWriter struct {
+	w     *bufio.Writer
+	crc   hash.Hash32
+	magic []byte
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func newRecordWriter(w io.Writer, magic []byte) *recordWriter {
+	crc := crc32.NewIEEE()
+	return &recordWriter{w: bufio.NewWriter(io.MultiWriter(w, crc)), crc: crc, magic: magic}
+}
+
+func (rw *recordWriter) writeHeader() error {
+	_, err := rw.w.Write(rw.magic)
+	return err
+}
+
+func (rw *recordWriter) writeBytes(b []byte) error {
+	n := binary.PutUvarint(rw.buf[:], uint64(len(b)))
+	if _, err := rw.w.Write(rw.buf[:n]); err != nil {
+		return err
+	}
+
+	_, err := rw.w.Write(b)
+	return err
+}
+
+func (rw *recordWriter) write(key, value []byte) error {
+	if err := rw.writeBytes(key); err != nil {
+		return err
+	}
+
+	return rw.writeBytes(value)
+}
+
+// close writes the end marker and the checksum.
+func (rw *recordWriter) close() error {
+	if err := rw.writeBytes(nil); err != nil {
+		return err
+	}
+
+	// flush everything so that the checksum covers all of the written bytes
+	if err := rw.w.Flush(); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], rw.crc.Sum32())
+	if _, err := rw.w.Write(sum[:]); err != nil {
+		return err
+	}
+
+	return rw.w.Flush()
+}
+
+type recordReader struct {
+	r     *bufio.Reader
+	crc   hash.Hash32
+	magic []byte
+}
+
+func newRecordReader(r io.Reader, magic []byte) *recordReader {
+	return &recordReader{r: bufio.NewReader(r), crc: crc32.NewIEEE(), magic: magic}
+}
+
+func (rr *recordReader) read(b []byte) error {
+	if _, err := io.ReadFull(rr.r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorruptFile
+		}
+		return err
+	}
+	rr.crc.Write(b)
+
+	return nil
+}
+
+func (rr *recordReader) readHeader() error {
+	magic := make([]byte, len(rr.magic))
+	if err := rr.read(magic); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(magic, rr.magic) {
+		return ErrCorruptFile
+	}
+
+	return nil
+}
+
+func (rr *recordReader) readBytes() ([]byte, error) {
+	var length uint64
+	var shift uint
+	for i := 0; ; i++ {
+		var b [1]byte
+		if err := rr.read(b[:]); err != nil {
+			return nil, err
+		}
+
+		if i == binary.MaxVarintLen64 {
+			return nil, ErrCorruptFile
+		}
+
+		length |= uint64(b[0]&0x7f) << shift
+		if b[0] < 0x80 {
+			break
+		}
+		shift += 7
+	}
+
+	if length > maxRecordSize {
+		return nil, ErrCorruptFile
+	}
+
+	buf := make([]byte, 0, minUint64(length, recordChunkSize))
+	for remaining := length; remaining > 0; {
+		n := minUint64(remaining, recordChunkSize)
+		start := len(buf)
+		buf = append(buf, make([]byte, n)...)
+		if err := rr.read(buf[start:]); err != nil {
+			return nil, err
+		}
+		remaining -= n
+	}
+
+	return buf, nil
+}
+
+// next returns the next key-value pair or a nil key at the end of the records.
+func (rr *recordReader) next() (key, value []byte, err error) {
+	if key, err = rr.readBytes(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(key) == 0 {
+		return nil, nil, nil
+	}
+
+	if value, err = rr.readBytes(); err != nil {
+		return nil, nil, err
+	}
+
+	return key, value, nil
+}
+
+// verify reads the checksum at the end of the file and compares it to the read bytes.
+func (rr *recordReader) verify() error {
+	want := rr.crc.Sum32()
+
+	var sum [4]byte
+	if _, err := io.ReadFull(rr.r, sum[:]); err != nil {
+		return ErrCorruptFile
+	}
+
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return ErrCorruptFile
+	}
+
+	return nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,270 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrBrokenChain happens when the change log entries of the backups are not contiguous.
+	ErrBrokenChain = errors.New("the change log chain of the backups is not contiguous")
+
+	// ErrRecoveryPoint happens when there is no full backup that was taken before the recovery point.
+	ErrRecoveryPoint = errors.New("there is no full backup from before the recovery point")
+)
+
+// RecoveryTarget is the point up to which the change log entries are replayed. The entries with a
+// sequence larger than Seq or a time after Time are not applied. Zero values don't limit the replay.
+type RecoveryTarget struct {
+	Seq  uint64
+	Time time.Time
+}
+
+// RecoveryResult describes what was restored from a backup archive.
+type RecoveryResult struct {
+	Info *BackupInfo
+
+	// Entries is the amount of change log entries and Writes the amount of key writes and
+	// deletions that were applied.
+	Entries int
+	Writes  int
+
+	// Seq and Time are the sequence and the time of the last applied change. For a full backup
+	// they are the sequence and the time of the snapshot.
+	Seq  uint64
+	Time time.Time
+
+	// Reached is set when the target was reached and the later backups are not needed.
+	Reached bool
+}
+
+// after reports if the change log entry is after the recovery target.
+func (t RecoveryTarget) after(seq uint64, tm time.Time) bool {
+	if t.Seq != 0 && seq > t.Seq {
+		return true
+	}
+
+	return !t.Time.IsZero() && tm.After(t.Time)
+}
+
+// RestoreUntil is like Restore, but it stops replaying the change log entries of incremental
+// backups at the target. The entries after the target are still read, such that the checksum of
+// the archive is verified. The change log sequence of the database is set to the last applied
+// entry, so later backups can only be restored if the target was not reached.
+func (d *DB) RestoreUntil(r io.Reader, target RecoveryTarget) (*RecoveryResult, error) {
+	if d.ronly {
+		return nil, ErrReadOnly
+	}
+
+	engine := d.rawEngine()
+	check := func(info *BackupInfo) error {
+		if info.Full {
+			return checkEmpty(engine)
+		}
+
+		seq, err := readSequence(engine)
+		if err != nil {
+			return err
+		}
+
+		if seq != info.From {
+			return ErrBackupSequence
+		}
+
+		return nil
+	}
+
+	res, err := replayBackup(r, target, check, engine.Write)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := engine.Put(metaKey(sequenceKey), encodeSequence(res.Seq)); err != nil {
+		return nil, err
+	}
+
+	if e, ok := d.db.(*changeLogEngine); ok {
+		e.mu.Lock()
+		e.seq = res.Seq
+		e.mu.Unlock()
+	}
+
+	// the restored data can contain new buckets and namespaces
+	if err := d.loadCatalog(); err != nil {
+		return nil, err
+	}
+
+	if err := d.loadNamespaces(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ScanBackup reads the whole backup archive without restoring it and reports what would be
+// restored up to the target. It verifies the checksum and the contiguity of the change log entries.
+func ScanBackup(r io.Reader, target RecoveryTarget) (*RecoveryResult, error) {
+	return replayBackup(r, target, nil, nil)
+}
+
+// RecoveryChain chooses the backups that are needed to recover to the target. It picks the latest
+// full backup that was taken before the target, followed by the incremental backups that continue
+// from it until the target is reached. It returns the indices of the chosen backups in the order
+// they need to be restored.
+func RecoveryChain(infos []*BackupInfo, target RecoveryTarget) ([]int, error) {
+	full := -1
+	for i, info := range infos {
+		if !info.Full || target.after(info.To, info.Created) {
+			continue
+		}
+
+		if full == -1 || info.To > infos[full].To {
+			full = i
+		}
+	}
+
+	if full == -1 {
+		return nil, ErrRecoveryPoint
+	}
+
+	incremental := make([]int, 0, len(infos))
+	for i, info := range infos {
+		if !info.Full {
+			incremental = append(incremental, i)
+		}
+	}
+
+	// when there are overlapping segments, prefer the ones that reach further
+	sort.Slice(incremental, func(i, j int) bool {
+		a, b := infos[incremental[i]], infos[incremental[j]]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To > b.To
+	})
+
+	chain := []int{full}
+	seq := infos[full].To
+	for _, i := range incremental {
+		info := infos[i]
+		if info.From < seq || info.To == info.From {
+			continue
+		}
+
+		if target.Seq != 0 && seq >= target.Seq {
+			break
+		}
+
+		// a later segment exists, so the entries between them are missing from the backups
+		if info.From > seq {
+			return chain, fmt.Errorf("%w: no backup continues from sequence %d", ErrBrokenChain, seq)
+		}
+
+		chain = append(chain, i)
+		seq = info.To
+
+		// the segment contains the entries up to the target time, so the later ones are not needed
+		if !target.Time.IsZero() && info.Created.After(target.Time) {
+			return chain, nil
+		}
+	}
+
+	if target.Seq != 0 && seq < target.Seq {
+		return chain, fmt.Errorf("%w: no backup continues from sequence %d", ErrBrokenChain, seq)
+	}
+
+	return chain, nil
+}
+
+// replayBackup reads the backup archive and calls apply with batches of the writes that are before
+// the target. The check function is called with the archive information before anything is applied.
+func replayBackup(r io.Reader, target RecoveryTarget, check func(info *BackupInfo) error, apply func(batch *Batch) error) (*RecoveryResult, error) {
+	rr, info, err := readBackupHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Full && target.after(info.To, info.Created) {
+		return nil, ErrRecoveryPoint
+	}
+
+	if check != nil {
+		if err := check(info); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &RecoveryResult{Info: info, Seq: info.From}
+	if info.Full {
+		res.Seq, res.Time = info.To, info.Created
+	}
+
+	flush := func(batch *Batch) error {
+		if apply == nil || batch.Len() == 0 {
+			return nil
+		}
+		return apply(batch)
+	}
+
+	batch := new(Batch)
+	next := info.From + 1
+	for {
+		key, value, err := rr.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if key == nil {
+			break
+		}
+
+		if info.Full {
+			batch.Put(key, value)
+			res.Writes++
+		} else {
+			entry, err := decodeChangeLogEntry(decodeChangeLogSeq(key), value)
+			if err != nil {
+				return nil, err
+			}
+
+			if entry.Seq != next {
+				return nil, ErrBrokenChain
+			}
+			next++
+
+			if res.Reached || target.after(entry.Seq, entry.Time) {
+				res.Reached = true
+				continue
+			}
+
+			batch.ops = append(batch.ops, entry.batch.ops...)
+			res.Entries++
+			res.Writes += entry.Len()
+			res.Seq, res.Time = entry.Seq, entry.Time
+		}
+
+		if batch.Len() >= deleteBatchSize {
+			if err := flush(batch); err != nil {
+				return nil, err
+			}
+			batch = new(Batch)
+		}
+	}
+
+	if err := rr.verify(); err != nil {
+		return nil, err
+	}
+
+	if !info.Full && next != info.To+1 {
+		return nil, ErrBrokenChain
+	}
+
+	if target.Seq != 0 && res.Seq >= target.Seq {
+		res.Reached = true
+	}
+
+	return res, flush(batch)
+}
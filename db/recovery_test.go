@@ -0,0 +1,155 @@
+package db_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+// createBackups writes key1..key6 into a database with the change log enabled and returns a full
+// backup taken after key2 and incremental backups after key4 and key6.
+func createBackups(t *testing.T) ([]*bytes.Buffer, time.Time) {
+	t.Helper()
+
+	src := createTestDatabase(t, false)
+	if err := src.EnableChangeLog(); err != nil {
+		t.Fatalf("could not enable change log, err: %s", err)
+	}
+
+	var backups []*bytes.Buffer
+	var since uint64
+	var mid time.Time
+	for i, key := range []string{"key1", "key2", "key3", "key4", "key5", "key6"} {
+		setKey(t, src, key, "value")
+
+		if i == 2 {
+			time.Sleep(time.Millisecond)
+			mid = time.Now()
+			time.Sleep(time.Millisecond)
+		}
+
+		if i%2 == 0 {
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		var err error
+		if len(backups) == 0 {
+			err = src.Backup(buf)
+		} else {
+			err = src.BackupSince(buf, since)
+		}
+		if err != nil {
+			t.Fatalf("could not backup database, err: %s", err)
+		}
+
+		backups = append(backups, buf)
+		since, _ = src.ChangeLogSequence()
+	}
+
+	return backups, mid
+}
+
+func backupInfos(t *testing.T, backups []*bytes.Buffer) []*db.BackupInfo {
+	t.Helper()
+
+	infos := make([]*db.BackupInfo, len(backups))
+	for i, b := range backups {
+		info, err := db.ReadBackupInfo(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatalf("could not read backup info, err: %s", err)
+		}
+		infos[i] = info
+	}
+
+	return infos
+}
+
+func recoverTo(t *testing.T, backups []*bytes.Buffer, target db.RecoveryTarget) *db.DB {
+	t.Helper()
+
+	chain, err := db.RecoveryChain(backupInfos(t, backups), target)
+	if err != nil {
+		t.Fatalf("could not find recovery chain, err: %s", err)
+	}
+
+	d := createTestDatabase(t, false)
+	for _, i := range chain {
+		if _, err := d.RestoreUntil(bytes.NewReader(backups[i].Bytes()), target); err != nil {
+			t.Fatalf("could not restore backup, err: %s", err)
+		}
+	}
+
+	return d
+}
+
+func checkKeys(t *testing.T, d *db.DB, present int) {
+	t.Helper()
+
+	for i, key := range []string{"key1", "key2", "key3", "key4", "key5", "key6"} {
+		_, err := d.Get(key)
+		if i < present && err != nil {
+			t.Errorf("%s should have been recovered, err: %s", key, err)
+		}
+		if i >= present && err != db.ErrNotFound {
+			t.Errorf("%s should not have been recovered, err: %v", key, err)
+		}
+	}
+}
+
+func TestRecoverUntilSequence(t *testing.T) {
+	backups, _ := createBackups(t)
+	infos := backupInfos(t, backups)
+
+	// the last backup contains the writes of key5 and key6, recover up to the first half of it
+	target := infos[2].From + (infos[2].To-infos[2].From)/2
+	d := recoverTo(t, backups, db.RecoveryTarget{Seq: target})
+	checkKeys(t, d, 5)
+}
+
+func TestRecoverUntilTime(t *testing.T) {
+	backups, mid := createBackups(t)
+
+	// the backups are given out of order
+	backups[0], backups[2] = backups[2], backups[0]
+
+	d := recoverTo(t, backups, db.RecoveryTarget{Time: mid})
+	checkKeys(t, d, 3)
+}
+
+func TestRecoveryChainGap(t *testing.T) {
+	backups, mid := createBackups(t)
+	infos := backupInfos(t, backups)
+
+	_, err := db.RecoveryChain([]*db.BackupInfo{infos[0], infos[2]}, db.RecoveryTarget{Seq: infos[2].To})
+	if !errors.Is(err, db.ErrBrokenChain) {
+		t.Fatalf("expected ErrBrokenChain, got %v", err)
+	}
+
+	_, err = db.RecoveryChain([]*db.BackupInfo{infos[0], infos[2]}, db.RecoveryTarget{Time: mid})
+	if !errors.Is(err, db.ErrBrokenChain) {
+		t.Fatalf("expected ErrBrokenChain for a time target, got %v", err)
+	}
+
+	_, err = db.RecoveryChain(infos[1:], db.RecoveryTarget{Seq: infos[2].To})
+	if err != db.ErrRecoveryPoint {
+		t.Fatalf("expected ErrRecoveryPoint, got %v", err)
+	}
+}
+
+func TestScanBackup(t *testing.T) {
+	backups, _ := createBackups(t)
+	infos := backupInfos(t, backups)
+
+	res, err := db.ScanBackup(bytes.NewReader(backups[2].Bytes()), db.RecoveryTarget{Seq: infos[2].From + 1})
+	if err != nil {
+		t.Fatalf("could not scan backup, err: %s", err)
+	}
+
+	if res.Entries != 1 || !res.Reached || res.Seq != infos[2].From+1 {
+		t.Fatalf("wrong scan result. got=%+v", res)
+	}
+}
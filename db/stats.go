@@ -0,0 +1,169 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LevelStats are the statistics of a single level of the leveldb engine. The compaction fields
+// are the totals of the compactions into the level since the database was opened.
+type LevelStats struct {
+	Level  int
+	Tables int
+	Size   int64
+
+	CompactionTime  time.Duration
+	CompactionRead  int64
+	CompactionWrite int64
+}
+
+// EngineStats are the internal statistics of the storage engine.
+type EngineStats struct {
+	Levels []LevelStats
+
+	// the bytes read and written by the engine since it was opened.
+	IORead  int64
+	IOWrite int64
+
+	// WriteDelays is the amount of writes that were delayed because the compactions were behind.
+	WriteDelays int64
+	WriteDelay  time.Duration
+}
+
+// statser is implemented by the engines that have internal statistics.
+type statser interface {
+	Stats() (*EngineStats, error)
+}
+
+// EngineStats returns the statistics of the storage engine, or nil if the engine doesn't have
+// any. Only the leveldb engine has statistics.
+func (d *DB) EngineStats() (*EngineStats, error) {
+	if s, ok := d.db.(statser); ok {
+		return s.Stats()
+	}
+
+	return nil, nil
+}
+
+// Usage returns the amount of keys and bytes in the default bucket, which has an empty name, and
+// in the user created buckets. The usage of the namespaces is tracked on every write, but the
+// other buckets are counted by going through their keys.
+func (d *DB) Usage() (map[string]Usage, error) {
+	usage := make(map[string]Usage)
+	for _, ns := range d.Namespaces() {
+		usage[ns.Name] = ns.Usage
+	}
+
+	names := append([]string{""}, d.ListBuckets()...)
+	for _, name := range names {
+		if _, ok := usage[name]; ok {
+			continue
+		}
+
+		bucket := defaultBucket
+		if name != "" {
+			bucket = name
+		}
+
+		u, err := d.bucketUsage(d.Bucket(bucket))
+		if err != nil {
+			return nil, err
+		}
+		usage[name] = u
+	}
+
+	return usage, nil
+}
+
+// Stats parses the statistics from the leveldb properties.
+func (e *levelDBEngine) Stats() (*EngineStats, error) {
+	stats := new(EngineStats)
+
+	compactions, err := e.db.GetProperty("leveldb.stats")
+	if err != nil {
+		return nil, err
+	}
+	stats.Levels = parseLevelStats(compactions)
+
+	io, err := e.db.GetProperty("leveldb.iostats")
+	if err != nil {
+		return nil, err
+	}
+
+	var read, write float64
+	if _, err := fmt.Sscanf(io, "Read(MB):%f Write(MB):%f", &read, &write); err != nil {
+		return nil, fmt.Errorf("could not parse leveldb io stats %q: %s", io, err)
+	}
+	stats.IORead, stats.IOWrite = megabytes(read), megabytes(write)
+
+	delay, err := e.db.GetProperty("leveldb.writedelay")
+	if err != nil {
+		return nil, err
+	}
+
+	var duration string
+	var paused bool
+	if _, err := fmt.Sscanf(delay, "DelayN:%d Delay:%s Paused:%t", &stats.WriteDelays, &duration, &paused); err != nil {
+		return nil, fmt.Errorf("could not parse leveldb write delay %q: %s", delay, err)
+	}
+	if stats.WriteDelay, err = time.ParseDuration(duration); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// parseLevelStats parses the compaction table of the leveldb.stats property, which has the
+// columns level, tables, size, time, read and write with the sizes in megabytes.
+func parseLevelStats(table string) []LevelStats {
+	var levels []LevelStats
+
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 6 {
+			continue
+		}
+
+		var values [6]float64
+		numeric := true
+		for i, field := range fields {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				numeric = false
+				break
+			}
+			values[i] = v
+		}
+
+		// skip the header
+		if !numeric {
+			continue
+		}
+
+		levels = append(levels, LevelStats{
+			Level:           int(values[0]),
+			Tables:          int(values[1]),
+			Size:            megabytes(values[2]),
+			CompactionTime:  time.Duration(values[3] * float64(time.Second)),
+			CompactionRead:  megabytes(values[4]),
+			CompactionWrite: megabytes(values[5]),
+		})
+	}
+
+	return levels
+}
+
+func megabytes(mb float64) int64 {
+	return int64(mb * 1048576)
+}
+
+// Stats returns the statistics of the wrapped engine if it has them.
+func (e *changeLogEngine) Stats() (*EngineStats, error) {
+	if s, ok := e.StorageEngine.(statser); ok {
+		return s.Stats()
+	}
+
+	return nil, nil
+}
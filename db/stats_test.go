@@ -0,0 +1,71 @@
+package db_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+)
+
+func TestEngineStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dkvstats")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := db.Open(db.EngineLevelDB, dir, false)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+	defer d.Close()
+
+	if stats, err := d.EngineStats(); err != nil || stats == nil {
+		t.Fatalf("leveldb doesn't have stats. stats=%v err=%v", stats, err)
+	}
+
+	memory, err := db.Open(db.EngineMemory, "", false)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+	defer memory.Close()
+
+	if stats, err := memory.EngineStats(); err != nil || stats != nil {
+		t.Fatalf("the memory engine has stats. stats=%v err=%v", stats, err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if err := d.CreateBucket("users"); err != nil {
+		t.Fatalf("could not create bucket: %s", err)
+	}
+	if err := d.CreateNamespace("team", db.Quota{MaxKeys: 10}); err != nil {
+		t.Fatalf("could not create namespace: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		setKey(t, d, fmt.Sprintf("key%d", i), "value")
+	}
+	d.Bucket("users").Set([]byte("alice"), []byte("1"))
+	d.Bucket("team").Set([]byte("bob"), []byte("22"))
+
+	usage, err := d.Usage()
+	if err != nil {
+		t.Fatalf("could not count usage: %s", err)
+	}
+
+	want := map[string]db.Usage{
+		"":      {Keys: 3, Bytes: 3 * 9},
+		"users": {Keys: 1, Bytes: 6},
+		"team":  {Keys: 1, Bytes: 5},
+	}
+	for name, u := range want {
+		if usage[name] != u {
+			t.Errorf("wrong usage for bucket %q. got=%+v want=%+v", name, usage[name], u)
+		}
+	}
+}
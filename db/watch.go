@@ -0,0 +1,253 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrCompacted happens when a watch is resumed from a revision whose events are no longer in
+	// the history, such that some events would be lost.
+	ErrCompacted = errors.New("the revision has been compacted")
+
+	// ErrWatchOverflow happens when a watcher doesn't receive its events fast enough. The watch
+	// can be resumed from the revision of the last received event.
+	ErrWatchOverflow = errors.New("the watcher fell behind")
+)
+
+// WatchHistory is the amount of the latest events that are kept in memory for resuming watches.
+var WatchHistory = 4096
+
+// watchBuffer is the amount of events that a watcher can have waiting before it is closed.
+const watchBuffer = 256
+
+// EventType is the type of a change to a key.
+type EventType string
+
+// the types of the events.
+const (
+	EventPut    EventType = "put"
+	EventDelete EventType = "delete"
+)
+
+// Event is a change to a key in the default bucket. The revision of a put is the version of the
+// value, so the revisions of the events increase in the order of the writes.
+type Event struct {
+	Type     EventType
+	Key      string
+	Value    []byte
+	Revision uint64
+}
+
+// notifier publishes the changes of the default bucket to the watchers and keeps the latest
+// events for resuming watches.
+type notifier struct {
+	mu       sync.Mutex
+	watchers map[*Watcher]struct{}
+
+	// history is a ring buffer of the latest events, which starts at head.
+	history []Event
+	head    int
+
+	// compacted is the latest revision that is no longer in the history and last is the revision
+	// of the latest event.
+	compacted uint64
+	last      uint64
+
+	// pending are the commits whose revisions have been allocated, in the order of the revisions.
+	// Their events are published once the commits before them have been written.
+	pending []*pendingCommit
+}
+
+// pendingCommit is a commit whose batch is being written.
+type pendingCommit struct {
+	event  Event
+	done   bool
+	failed bool
+}
+
+// revisionKey is the key of the meta bucket that stores the latest revision. It is written in
+// the batches of the commits, such that the revisions keep increasing after a restart even if the
+// clock has moved backwards.
+const revisionKey = "r"
+
+// Watcher receives the events of the keys with a prefix.
+type Watcher struct {
+	events chan Event
+	prefix string
+	n      *notifier
+	err    error
+}
+
+// commit writes the batch of a change and publishes the event. The revision of the event is
+// allocated under the notifier lock, but the batch is written without it, such that the writes of
+// different keys don't wait for each other. The events are published in the order of their
+// revisions once the writes before them have finished. The revision of a put is stored as the
+// version of the value.
+func (d *DB) commit(batch *Batch, event Event) error {
+	n := &d.notifier
+	n.mu.Lock()
+	event.Revision = d.nextVersion()
+	p := &pendingCommit{event: event}
+	n.pending = append(n.pending, p)
+	n.mu.Unlock()
+
+	if event.Type == EventPut {
+		batch.Put(d.Bucket(versionBucket).bucketPrefix([]byte(event.Key)), encodeVersion(event.Revision))
+		p.event.Value = copyBytes(event.Value)
+	}
+	batch.Put(metaKey(revisionKey), encodeVersion(event.Revision))
+
+	err := d.db.Write(batch)
+
+	n.mu.Lock()
+	// a commit with a later revision can have been written before this one, and this batch then
+	// stored a lower revision over it. The latest revision is stored again under the lock, such
+	// that the restarts continue after every revision that has been given out.
+	if last := atomic.LoadUint64(&d.lastVersion); err == nil && event.Revision < last {
+		if err := d.db.Put(metaKey(revisionKey), encodeVersion(last)); err != nil {
+			log.Printf("could not store revision %d: %s", last, err)
+		}
+	}
+	p.done, p.failed = true, err != nil
+	for len(n.pending) > 0 && n.pending[0].done {
+		if !n.pending[0].failed {
+			n.publish(n.pending[0].event)
+		}
+		n.pending[0] = nil
+		n.pending = n.pending[1:]
+	}
+	n.mu.Unlock()
+
+	return err
+}
+
+// loadRevision continues the revisions after the latest stored one. The history of the events
+// is not stored, so the watches can only be resumed from the revisions after opening.
+func (d *DB) loadRevision() error {
+	data, err := d.db.Get(metaKey(revisionKey))
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if len(data) == 8 {
+		d.lastVersion = binary.BigEndian.Uint64(data)
+	}
+
+	d.notifier.compacted = d.nextVersion()
+	d.notifier.last = d.notifier.compacted
+	return nil
+}
+
+// Revision returns the revision of the latest change, which can be used to watch the changes
+// after a read.
+func (d *DB) Revision() uint64 {
+	d.notifier.mu.Lock()
+	defer d.notifier.mu.Unlock()
+
+	return d.notifier.last
+}
+
+// Watch returns a watcher for the changes of the keys with the prefix. If after is not zero the
+// watcher first receives the events after that revision, or ErrCompacted is returned if they are
+// no longer in the history. The history is kept in memory, so it starts empty when the database is
+// opened.
+func (d *DB) Watch(prefix string, after uint64) (*Watcher, error) {
+	n := &d.notifier
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if after != 0 && after < n.compacted {
+		return nil, ErrCompacted
+	}
+
+	var replay []Event
+	if after != 0 {
+		for i := 0; i < len(n.history); i++ {
+			event := n.history[(n.head+i)%len(n.history)]
+			if event.Revision > after && strings.HasPrefix(event.Key, prefix) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	w := &Watcher{
+		events: make(chan Event, watchBuffer+len(replay)),
+		prefix: prefix,
+		n:      n,
+	}
+
+	for _, event := range replay {
+		w.events <- event
+	}
+
+	if n.watchers == nil {
+		n.watchers = make(map[*Watcher]struct{})
+	}
+	n.watchers[w] = struct{}{}
+
+	return w, nil
+}
+
+// publish adds the event into the history and sends it to the watchers. The watchers that are
+// full are closed with ErrWatchOverflow. The caller must hold the lock.
+func (n *notifier) publish(event Event) {
+	n.last = event.Revision
+	if WatchHistory > 0 {
+		if len(n.history) < WatchHistory {
+			n.history = append(n.history, event)
+		} else {
+			n.compacted = n.history[n.head].Revision
+			n.history[n.head] = event
+			n.head = (n.head + 1) % len(n.history)
+		}
+	} else {
+		n.compacted = event.Revision
+	}
+
+	for w := range n.watchers {
+		if !strings.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+
+		select {
+		case w.events <- event:
+		default:
+			w.err = ErrWatchOverflow
+			n.remove(w)
+		}
+	}
+}
+
+func (n *notifier) remove(w *Watcher) {
+	if _, ok := n.watchers[w]; ok {
+		delete(n.watchers, w)
+		close(w.events)
+	}
+}
+
+// Events returns the channel of the events. It is closed when the watcher is closed or when it
+// falls behind, see Err.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns ErrWatchOverflow if the watcher was closed because it fell behind.
+func (w *Watcher) Err() error {
+	w.n.mu.Lock()
+	defer w.n.mu.Unlock()
+
+	return w.err
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() {
+	w.n.mu.Lock()
+	defer w.n.mu.Unlock()
+
+	w.n.remove(w)
+}
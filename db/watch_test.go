@@ -0,0 +1,284 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+func nextEvent(t *testing.T, w *db.Watcher) db.Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("the watcher was closed: %v", w.Err())
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("no event was received")
+	}
+
+	return db.Event{}
+}
+
+func TestWatch(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	w, err := d.Watch("config/", 0)
+	if err != nil {
+		t.Fatalf("could not watch: %s", err)
+	}
+	defer w.Close()
+
+	d.Set("other", []byte("x"))
+	d.Set("config/a", []byte("1"))
+	d.Delete("config/a")
+
+	put := nextEvent(t, w)
+	if put.Type != db.EventPut || put.Key != "config/a" || string(put.Value) != "1" {
+		t.Errorf("wrong put event. got=%+v", put)
+	}
+
+	item, err := d.GetItem("other")
+	if err != nil || put.Revision <= item.Version {
+		t.Errorf("the revision is not after the earlier write. got=%d, earlier=%d", put.Revision, item.Version)
+	}
+
+	del := nextEvent(t, w)
+	if del.Type != db.EventDelete || del.Key != "config/a" || del.Revision <= put.Revision {
+		t.Errorf("wrong delete event. got=%+v", del)
+	}
+
+	if d.Revision() != del.Revision {
+		t.Errorf("wrong revision. got=%d want=%d", d.Revision(), del.Revision)
+	}
+
+	// resuming from the put replays the delete
+	resumed, err := d.Watch("config/", put.Revision)
+	if err != nil {
+		t.Fatalf("could not resume watch: %s", err)
+	}
+	defer resumed.Close()
+
+	if event := nextEvent(t, resumed); event.Revision != del.Revision {
+		t.Errorf("wrong replayed event. got=%+v", event)
+	}
+
+	if _, err := d.Watch("", 1); err != db.ErrCompacted {
+		t.Errorf("wrong error for a revision before the history. got=%v", err)
+	}
+
+	w.Close()
+	if _, ok := <-w.Events(); ok {
+		t.Errorf("the events were not closed")
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	w, err := d.Watch("", 0)
+	if err != nil {
+		t.Fatalf("could not watch: %s", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		d.Set("key", []byte("x"))
+	}
+
+	received := 0
+	for range w.Events() {
+		received++
+	}
+
+	if received == 0 || received >= 1000 || w.Err() != db.ErrWatchOverflow {
+		t.Errorf("the watcher was not closed after falling behind. received=%d, err=%v", received, w.Err())
+	}
+}
+
+func TestWatchHistory(t *testing.T) {
+	old := db.WatchHistory
+	db.WatchHistory = 2
+	defer func() { db.WatchHistory = old }()
+
+	d := createTestDatabase(t, false)
+	d.Set("a", []byte("1"))
+	first := d.Revision()
+	d.Set("b", []byte("2"))
+	d.Set("c", []byte("3"))
+
+	// the event of b is still in the history
+	if _, err := d.Watch("", first); err != nil {
+		t.Errorf("could not resume from a revision in the history: %s", err)
+	}
+
+	d.Set("d", []byte("4"))
+	if _, err := d.Watch("", first); err != db.ErrCompacted {
+		t.Errorf("wrong error for a compacted revision. got=%v", err)
+	}
+}
+
+// blockingEngine blocks the next write after block is called until release is called.
+type blockingEngine struct {
+	db.StorageEngine
+
+	mu      sync.Mutex
+	blocked chan struct{} // closed when the write starts waiting
+	release chan struct{}
+}
+
+func (e *blockingEngine) block() (blocked, release chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.blocked, e.release = make(chan struct{}), make(chan struct{})
+	return e.blocked, e.release
+}
+
+func (e *blockingEngine) Write(batch *db.Batch) error {
+	e.mu.Lock()
+	blocked, release := e.blocked, e.release
+	e.blocked, e.release = nil, nil
+	e.mu.Unlock()
+
+	if blocked != nil {
+		close(blocked)
+		<-release
+	}
+
+	return e.StorageEngine.Write(batch)
+}
+
+func TestWatchConcurrentWrites(t *testing.T) {
+	engine := &blockingEngine{StorageEngine: db.NewMemoryEngine()}
+	d, err := db.NewDatabaseWithEngine(engine, false)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+	defer d.Close()
+
+	w, err := d.Watch("", 0)
+	if err != nil {
+		t.Fatalf("could not watch: %s", err)
+	}
+	defer w.Close()
+
+	blocked, release := engine.block()
+	slow := make(chan error, 1)
+	go func() { slow <- d.Set("a", []byte("1")) }()
+	<-blocked
+
+	// the write of another key doesn't wait for the slow write
+	fast := make(chan error, 1)
+	go func() { fast <- d.Set("b", []byte("2")) }()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Fatalf("could not set b: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the write of b waited for the write of a")
+	}
+
+	// but its event is only published after the earlier write
+	select {
+	case event := <-w.Events():
+		t.Fatalf("an event was published before the earlier write finished: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("could not set a: %s", err)
+	}
+
+	if first, second := nextEvent(t, w), nextEvent(t, w); first.Key != "a" || second.Key != "b" ||
+		first.Revision >= second.Revision {
+		t.Errorf("the events were not published in the order of the revisions: %+v, %+v", first, second)
+	}
+
+	// the slow write stored its lower revision last, but the restarts still continue after both
+	item, err := d.GetItem("b")
+	if err != nil {
+		t.Fatalf("could not get b: %s", err)
+	}
+
+	if stored := db.StoredRevision(d); stored < item.Version {
+		t.Errorf("the stored revision went back. got=%d, want at least %d", stored, item.Version)
+	}
+}
+
+func TestWatchAfterReopen(t *testing.T) {
+	if !db.PersistentTestEngine() {
+		t.Skip("the engine doesn't keep its data")
+	}
+
+	dir, err := ioutil.TempDir("", "dkvdb")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := db.OpenTest(dir, false)
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+	d.Set("a", []byte("1"))
+	before := d.Revision()
+	d.Close()
+
+	d, err = db.OpenTest(dir, false)
+	if err != nil {
+		t.Fatalf("could not reopen database: %s", err)
+	}
+	defer d.Close()
+
+	// the events between before and the restart are not known
+	if _, err := d.Watch("", before); err != db.ErrCompacted {
+		t.Errorf("wrong error for a revision from before the restart. got=%v", err)
+	}
+
+	d.Set("b", []byte("2"))
+	item, err := d.GetItem("b")
+	if err != nil || item.Version <= before {
+		t.Errorf("the versions didn't continue after the restart. got=%d, before=%d", item.Version, before)
+	}
+}
+
+func TestWatchReplica(t *testing.T) {
+	d := createTestDatabase(t, false)
+
+	if _, err := d.SetWithOptions("key", []byte("1"), db.SetOptions{TTL: time.Hour, Flags: 7}); err != nil {
+		t.Fatalf("could not set key: %s", err)
+	}
+
+	w, err := d.Watch("", 0)
+	if err != nil {
+		t.Fatalf("could not watch: %s", err)
+	}
+	defer w.Close()
+
+	if err := d.SetOnReplica("key", []byte("2")); err != nil {
+		t.Fatalf("could not set key on replica: %s", err)
+	}
+
+	event := nextEvent(t, w)
+	if event.Type != db.EventPut || event.Key != "key" || string(event.Value) != "2" {
+		t.Errorf("wrong event for a replicated value: %+v", event)
+	}
+
+	// the metadata of the old value is not kept
+	item, err := d.GetItem("key")
+	if err != nil {
+		t.Fatalf("could not get item: %s", err)
+	}
+
+	if item.Version != event.Revision || item.Flags != 0 || !item.Deadline.IsZero() {
+		t.Errorf("wrong metadata for a replicated value: %+v", item)
+	}
+}
@@ -0,0 +1,351 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package dkvpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DKVClient is the client API for DKV service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DKVClient interface {
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Batch runs the operations in order and stops at the first error. The batch is not atomic.
+	Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// Scan streams the key-value pairs of every shard, or only the pairs of this node if local is
+	// set. The keys are sorted within a shard.
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (DKV_ScanClient, error)
+	// Replicate streams the replication queue of the node. Every event must be acknowledged after it
+	// has been applied, which removes it from the queue, before the next event is sent.
+	Replicate(ctx context.Context, opts ...grpc.CallOption) (DKV_ReplicateClient, error)
+}
+
+type dKVClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDKVClient(cc grpc.ClientConnInterface) DKVClient {
+	return &dKVClient{cc}
+}
+
+func (c *dKVClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/dkv.DKV/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dKVClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error) {
+	out := new(SetResponse)
+	err := c.cc.Invoke(ctx, "/dkv.DKV/Set", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dKVClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/dkv.DKV/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dKVClient) Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/dkv.DKV/Batch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dKVClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (DKV_ScanClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DKV_ServiceDesc.Streams[0], "/dkv.DKV/Scan", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dKVScanClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DKV_ScanClient interface {
+	Recv() (*KeyValue, error)
+	grpc.ClientStream
+}
+
+type dKVScanClient struct {
+	grpc.ClientStream
+}
+
+func (x *dKVScanClient) Recv() (*KeyValue, error) {
+	m := new(KeyValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *dKVClient) Replicate(ctx context.Context, opts ...grpc.CallOption) (DKV_ReplicateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DKV_ServiceDesc.Streams[1], "/dkv.DKV/Replicate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dKVReplicateClient{stream}
+	return x, nil
+}
+
+type DKV_ReplicateClient interface {
+	Send(*ReplicationAck) error
+	Recv() (*ReplicationEvent, error)
+	grpc.ClientStream
+}
+
+type dKVReplicateClient struct {
+	grpc.ClientStream
+}
+
+func (x *dKVReplicateClient) Send(m *ReplicationAck) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *dKVReplicateClient) Recv() (*ReplicationEvent, error) {
+	m := new(ReplicationEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DKVServer is the server API for DKV service.
+// All implementations must embed UnimplementedDKVServer
+// for forward compatibility
+type DKVServer interface {
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Set(context.Context, *SetRequest) (*SetResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Batch runs the operations in order and stops at the first error. The batch is not atomic.
+	Batch(context.Context, *BatchRequest) (*BatchResponse, error)
+	// Scan streams the key-value pairs of every shard, or only the pairs of this node if local is
+	// set. The keys are sorted within a shard.
+	Scan(*ScanRequest, DKV_ScanServer) error
+	// Replicate streams the replication queue of the node. Every event must be acknowledged after it
+	// has been applied, which removes it from the queue, before the next event is sent.
+	Replicate(DKV_ReplicateServer) error
+	mustEmbedUnimplementedDKVServer()
+}
+
+// UnimplementedDKVServer must be embedded to have forward compatible implementations.
+type UnimplementedDKVServer struct {
+}
+
+func (UnimplementedDKVServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedDKVServer) Set(context.Context, *SetRequest) (*SetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (UnimplementedDKVServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedDKVServer) Batch(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Batch not implemented")
+}
+func (UnimplementedDKVServer) Scan(*ScanRequest, DKV_ScanServer) error {
+	return status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedDKVServer) Replicate(DKV_ReplicateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedDKVServer) mustEmbedUnimplementedDKVServer() {}
+
+// UnsafeDKVServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DKVServer will
+// result in compilation errors.
+type UnsafeDKVServer interface {
+	mustEmbedUnimplementedDKVServer()
+}
+
+func RegisterDKVServer(s grpc.ServiceRegistrar, srv DKVServer) {
+	s.RegisterService(&DKV_ServiceDesc, srv)
+}
+
+func _DKV_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DKVServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dkv.DKV/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DKVServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DKV_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DKVServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dkv.DKV/Set",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DKVServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DKV_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DKVServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dkv.DKV/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DKVServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DKV_Batch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DKVServer).Batch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dkv.DKV/Batch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DKVServer).Batch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DKV_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DKVServer).Scan(m, &dKVScanServer{stream})
+}
+
+type DKV_ScanServer interface {
+	Send(*KeyValue) error
+	grpc.ServerStream
+}
+
+type dKVScanServer struct {
+	grpc.ServerStream
+}
+
+func (x *dKVScanServer) Send(m *KeyValue) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DKV_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DKVServer).Replicate(&dKVReplicateServer{stream})
+}
+
+type DKV_ReplicateServer interface {
+	Send(*ReplicationEvent) error
+	Recv() (*ReplicationAck, error)
+	grpc.ServerStream
+}
+
+type dKVReplicateServer struct {
+	grpc.ServerStream
+}
+
+func (x *dKVReplicateServer) Send(m *ReplicationEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *dKVReplicateServer) Recv() (*ReplicationAck, error) {
+	m := new(ReplicationAck)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DKV_ServiceDesc is the grpc.ServiceDesc for DKV service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DKV_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dkv.DKV",
+	HandlerType: (*DKVServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _DKV_Get_Handler,
+		},
+		{
+			MethodName: "Set",
+			Handler:    _DKV_Set_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _DKV_Delete_Handler,
+		},
+		{
+			MethodName: "Batch",
+			Handler:    _DKV_Batch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			Handler:       _DKV_Scan_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Replicate",
+			Handler:       _DKV_Replicate_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "dkv.proto",
+}
@@ -0,0 +1,4 @@
+// Package dkvpb contains the protocol buffer messages and the gRPC service of dkv.
+package dkvpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative dkv.proto
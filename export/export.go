@@ -0,0 +1,263 @@
+// Package export contains the JSON Lines and CSV formats used to export and import key-value pairs.
+package export
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// the supported formats. In JSON Lines every line is an object with the key as a string and the
+// value encoded in base64. A key that is not valid UTF-8 is encoded in base64 too and the object
+// has "key_encoding":"base64". CSV files have the key and the base64 encoded value as columns and
+// start with a key,value header.
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// EncodingBase64 is the key encoding of the JSON Lines records whose key is not valid UTF-8.
+const EncodingBase64 = "base64"
+
+// errorPrefix starts the value of the CSV row that ends a failed export, whose key is empty.
+const errorPrefix = "error: "
+
+var (
+	// ErrFormat happens when the format is not one of the supported formats.
+	ErrFormat = errors.New("unknown format, the format should be jsonl or csv")
+
+	// ErrExportFailed is returned by Read when the stream ends with the trailer of a failed
+	// export, so the records before it are not all of the records.
+	ErrExportFailed = errors.New("the export failed before all of the records were written")
+)
+
+// Record is a single key-value pair.
+type Record struct {
+	Key   string
+	Value []byte
+}
+
+// jsonRecord is the json form of a record. The error is only set in the trailer of a failed
+// export.
+type jsonRecord struct {
+	Key         string `json:"key"`
+	KeyEncoding string `json:"key_encoding,omitempty"`
+	Value       []byte `json:"value"`
+	Error       string `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the record as a json object and encodes the key in base64 if it is not
+// valid UTF-8, since json strings can't hold arbitrary bytes.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	rec := jsonRecord{Key: r.Key, Value: r.Value}
+	if !utf8.ValidString(r.Key) {
+		rec.Key = base64.StdEncoding.EncodeToString([]byte(r.Key))
+		rec.KeyEncoding = EncodingBase64
+	}
+
+	return json.Marshal(&rec)
+}
+
+// UnmarshalJSON decodes a record that was encoded with MarshalJSON.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var rec jsonRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+
+	return rec.decode(r)
+}
+
+func (j *jsonRecord) decode(r *Record) error {
+	switch j.KeyEncoding {
+	case "":
+		r.Key = j.Key
+	case EncodingBase64:
+		key, err := base64.StdEncoding.DecodeString(j.Key)
+		if err != nil {
+			return fmt.Errorf("invalid key: %s", err)
+		}
+		r.Key = string(key)
+	default:
+		return fmt.Errorf("unknown key encoding %q", j.KeyEncoding)
+	}
+
+	r.Value = j.Value
+	return nil
+}
+
+// Writer encodes records into a stream. Flush must be called after the last record. Fail ends a
+// failed export with a trailer that makes the readers return ErrExportFailed and flushes the
+// stream, such that a truncated export is not mistaken for a complete one.
+type Writer interface {
+	Write(rec *Record) error
+	Flush() error
+	Fail(err error) error
+}
+
+// Reader decodes records from a stream. Read returns io.EOF after the last record.
+type Reader interface {
+	Read() (*Record, error)
+}
+
+// ContentType returns the http content type of the format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv"
+	}
+
+	return "application/x-ndjson"
+}
+
+// NewWriter returns a writer that encodes the records into w in the given format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatJSONL, "":
+		bw := bufio.NewWriter(w)
+		return &jsonWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		return &csvWriter{w: cw, header: true}, nil
+	}
+
+	return nil, ErrFormat
+}
+
+// NewReader returns a reader that decodes the records in the given format from r.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatJSONL, "":
+		return &jsonReader{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = 2
+		return &csvReader{r: cr}, nil
+	}
+
+	return nil, ErrFormat
+}
+
+type jsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonWriter) Write(rec *Record) error {
+	return w.enc.Encode(rec)
+}
+
+func (w *jsonWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *jsonWriter) Fail(err error) error {
+	if err := w.enc.Encode(&jsonRecord{Error: err.Error()}); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+type jsonReader struct {
+	dec  *json.Decoder
+	line int
+}
+
+func (r *jsonReader) Read() (*Record, error) {
+	var line jsonRecord
+	if err := r.dec.Decode(&line); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid record %d: %s", r.line+1, err)
+	}
+	r.line++
+
+	if line.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrExportFailed, line.Error)
+	}
+
+	var rec Record
+	if err := line.decode(&rec); err != nil {
+		return nil, fmt.Errorf("invalid record %d: %s", r.line, err)
+	}
+
+	if rec.Key == "" {
+		return nil, fmt.Errorf("invalid record %d: the key is missing", r.line)
+	}
+
+	return &rec, nil
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (w *csvWriter) Write(rec *Record) error {
+	if w.header {
+		if err := w.w.Write([]string{"key", "value"}); err != nil {
+			return err
+		}
+		w.header = false
+	}
+
+	return w.w.Write([]string{rec.Key, base64.StdEncoding.EncodeToString(rec.Value)})
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// Fail writes a row with an empty key, which no record has, and the error as the value.
+func (w *csvWriter) Fail(err error) error {
+	if err := w.w.Write([]string{"", errorPrefix + err.Error()}); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+type csvReader struct {
+	r    *csv.Reader
+	read bool
+	row  int
+}
+
+func (r *csvReader) Read() (*Record, error) {
+	row, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.row++
+
+	// skip the header row
+	if !r.read {
+		r.read = true
+		if row[0] == "key" && row[1] == "value" {
+			return r.Read()
+		}
+	}
+
+	if row[0] == "" && strings.HasPrefix(row[1], errorPrefix) {
+		return nil, fmt.Errorf("%w: %s", ErrExportFailed, strings.TrimPrefix(row[1], errorPrefix))
+	}
+
+	value, err := base64.StdEncoding.DecodeString(row[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid record %d: %s", r.row, err)
+	}
+
+	if row[0] == "" {
+		return nil, fmt.Errorf("invalid record %d: the key is missing", r.row)
+	}
+
+	return &Record{Key: row[0], Value: value}, nil
+}
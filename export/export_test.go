@@ -0,0 +1,98 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	records := []*Record{
+		{Key: "key1", Value: []byte("value1")},
+		{Key: "key,with\"quotes", Value: []byte{0, 1, 2, 255}},
+		{Key: "empty", Value: []byte{}},
+		{Key: "\xff\x00binary", Value: []byte("value")},
+	}
+
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, format)
+		if err != nil {
+			t.Fatalf("could not create writer, err: %s", err)
+		}
+
+		for _, rec := range records {
+			if err := w.Write(rec); err != nil {
+				t.Fatalf("could not write record, err: %s", err)
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			t.Fatalf("could not flush records, err: %s", err)
+		}
+
+		r, _ := NewReader(&buf, format)
+		for _, want := range records {
+			got, err := r.Read()
+			if err != nil {
+				t.Fatalf("%s: could not read record, err: %s", format, err)
+			}
+
+			if got.Key != want.Key || !bytes.Equal(got.Value, want.Value) {
+				t.Fatalf("%s: wrong record. got=%+v want=%+v", format, got, want)
+			}
+		}
+
+		if _, err := r.Read(); err != io.EOF {
+			t.Fatalf("%s: expected io.EOF, got %v", format, err)
+		}
+	}
+}
+
+func TestInvalidRecords(t *testing.T) {
+	testCases := []struct {
+		format string
+		input  string
+	}{
+		{FormatJSONL, `{"key":"a","value":"not base64!"}`},
+		{FormatJSONL, `{"value":"dmFsdWU="}`},
+		{FormatJSONL, `{"key":"not base64!","key_encoding":"base64","value":"dmFsdWU="}`},
+		{FormatJSONL, `{"key":"a","key_encoding":"hex","value":"dmFsdWU="}`},
+		{FormatCSV, "key,value\na,not base64!\n"},
+		{FormatCSV, "a,dmFsdWU=,extra\n"},
+	}
+
+	for _, tc := range testCases {
+		r, _ := NewReader(strings.NewReader(tc.input), tc.format)
+		if _, err := r.Read(); err == nil || err == io.EOF {
+			t.Errorf("%s: expected an error for %q", tc.format, tc.input)
+		}
+	}
+
+	if _, err := NewWriter(io.Discard, "xml"); err != ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
+
+func TestFailedExport(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, format)
+		w.Write(&Record{Key: "key1", Value: []byte("value1")})
+		if err := w.Fail(errors.New("disk on fire")); err != nil {
+			t.Fatalf("%s: could not end the export, err: %s", format, err)
+		}
+
+		r, _ := NewReader(&buf, format)
+		if rec, err := r.Read(); err != nil || rec.Key != "key1" {
+			t.Fatalf("%s: could not read the record before the failure. got=%+v, err=%v", format, rec, err)
+		}
+
+		_, err := r.Read()
+		if !errors.Is(err, ErrExportFailed) || !strings.Contains(err.Error(), "disk on fire") {
+			t.Errorf("%s: expected ErrExportFailed, got %v", format, err)
+		}
+	}
+}
@@ -3,10 +3,14 @@ module github.com/nireo/dkv
 go 1.16
 
 require (
+	github.com/golang/protobuf v1.4.2
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/klauspost/compress v1.11.12 // indirect
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/valyala/fasthttp v1.22.0 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
+	go.etcd.io/bbolt v1.3.6
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.2.2
 )
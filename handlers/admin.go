@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/nireo/dkv/cdc"
+	"github.com/nireo/dkv/db"
+)
+
+// Namespaces returns the quotas and the current usage of the namespaces on this node as json.
+func (s *Server) Namespaces(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.db.Namespaces())
+}
+
+// CreateNamespace takes in a name and the limits max_keys, max_bytes and write_rate as url
+// parameters and creates a bucket with the given quota. The limits are enforced on each shard
+// separately and the namespace is created on every shard unless the local parameter is set.
+func (s *Server) CreateNamespace(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	quota, err := parseQuota(r.Form)
+	if err != nil {
+		http.Error(w, "invalid quota: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.CreateNamespace(r.Form.Get("name"), quota); err != nil {
+		http.Error(w, "could not create namespace: "+err.Error(), bucketErrorStatus(err))
+		return
+	}
+
+	if err := s.broadcast(r); err != nil {
+		http.Error(w, "could not create namespace on all shards: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// SetQuota takes in the same url parameters as CreateNamespace and changes the quota of an
+// existing bucket.
+func (s *Server) SetQuota(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	quota, err := parseQuota(r.Form)
+	if err != nil {
+		http.Error(w, "invalid quota: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.SetQuota(r.Form.Get("name"), quota); err != nil {
+		http.Error(w, "could not set quota: "+err.Error(), bucketErrorStatus(err))
+		return
+	}
+
+	if err := s.broadcast(r); err != nil {
+		http.Error(w, "could not set quota on all shards: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseQuota parses the quota limits from the url parameters. Missing limits are unlimited.
+func parseQuota(form url.Values) (db.Quota, error) {
+	var q db.Quota
+	var err error
+
+	if v := form.Get("max_keys"); v != "" {
+		if q.MaxKeys, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, err
+		}
+	}
+
+	if v := form.Get("max_bytes"); v != "" {
+		if q.MaxBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return q, err
+		}
+	}
+
+	if v := form.Get("write_rate"); v != "" {
+		if q.WriteRate, err = strconv.ParseFloat(v, 64); err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// Backup streams a backup archive of the database on this node. Without parameters the archive
+// is a full backup and with the since parameter it is an incremental backup that contains the
+// change log entries after the given sequence.
+func (s *Server) Backup(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	var since uint64
+	var err error
+	incremental := r.Form.Get("since") != ""
+	if incremental {
+		if since, err = strconv.ParseUint(r.Form.Get("since"), 10, 64); err != nil {
+			http.Error(w, "invalid sequence: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	bw := &backupWriter{w: w}
+	if incremental {
+		err = s.db.BackupSince(bw, since)
+	} else {
+		err = s.db.Backup(bw)
+	}
+
+	if err == nil {
+		return
+	}
+
+	// once the archive has been started the status cannot be changed anymore, but the archive
+	// is missing its checksum so it will not be restored.
+	if bw.started {
+		log.Printf("backup failed: %s", err)
+		return
+	}
+
+	switch err {
+	case db.ErrChangeLogDisabled, db.ErrChangeLogTrimmed, db.ErrBackupSequence:
+		http.Error(w, "could not create backup: "+err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "could not create backup: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// TrimChangeLog takes in a seq url parameter and deletes the change log entries up to and
+// including it. It should be called once the entries have been archived. It responds with
+// db.ChangeLogTrim as json, which has the sequence that was actually trimmed and the sink that
+// held the trim back.
+func (s *Server) TrimChangeLog(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	seq, err := strconv.ParseUint(r.Form.Get("seq"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid sequence: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trim, err := s.db.TrimChangeLog(seq)
+	if err != nil {
+		http.Error(w, "could not trim change log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&trim)
+}
+
+// Restore applies the backup archive in the request body to the database of this node, see
+// db.Restore. It responds with the info of the archive as json.
+func (s *Server) Restore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the node doesn't have all of its data until the backup has been applied
+	defer s.migrating()()
+
+	info, err := s.db.Restore(r.Body)
+	switch {
+	case err == db.ErrNotEmpty || err == db.ErrBackupSequence:
+		http.Error(w, "could not restore backup: "+err.Error(), http.StatusConflict)
+		return
+	case err == db.ErrCorruptFile:
+		http.Error(w, "could not restore backup: "+err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "could not restore backup: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// ReplicationStatus is the response of the replication status route.
+type ReplicationStatus struct {
+	// Backlog is the amount of changed values that the replicas haven't applied yet.
+	Backlog  int  `json:"backlog"`
+	ReadOnly bool `json:"read_only"`
+}
+
+// Replication returns the replication status of this node as json.
+func (s *Server) Replication(w http.ResponseWriter, r *http.Request) {
+	backlog, err := s.db.ReplicationBacklog()
+	if err != nil {
+		http.Error(w, "could not read replication queue: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&ReplicationStatus{Backlog: backlog, ReadOnly: s.db.ReadOnly()})
+}
+
+// AddFeed adds a change data capture feed to the statistics of the CDC route. The feed is run by
+// the caller.
+func (s *Server) AddFeed(f *cdc.Feed) {
+	s.feeds = append(s.feeds, f)
+}
+
+// RemoveSink takes in a name url parameter and removes the stored offset of a change data capture
+// sink that is no longer used, such that the change log can be trimmed past it. The sinks that are
+// running on this node cannot be removed.
+func (s *Server) RemoveSink(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	name := r.Form.Get("name")
+	if name == "" {
+		http.Error(w, "the sink name is required", http.StatusBadRequest)
+		return
+	}
+
+	for _, f := range s.feeds {
+		if f.Name() == name {
+			http.Error(w, "the sink "+name+" is running on this node", http.StatusConflict)
+			return
+		}
+	}
+
+	switch err := s.db.DeleteSinkOffset(name); {
+	case err == db.ErrNotFound:
+		http.Error(w, "the sink "+name+" doesn't have an offset", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "could not remove sink: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CDC returns the delivery statistics of the change data capture feeds on this node as json.
+func (s *Server) CDC(w http.ResponseWriter, r *http.Request) {
+	stats := make([]cdc.Stats, len(s.feeds))
+	for i, f := range s.feeds {
+		var err error
+		if stats[i], err = f.Stats(); err != nil {
+			http.Error(w, "could not read the stats of sink "+f.Name()+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+// backupWriter sets the headers of the archive response before the first write.
+type backupWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (bw *backupWriter) Write(p []byte) (int, error) {
+	if !bw.started {
+		bw.w.Header().Set("Content-Type", "application/octet-stream")
+		bw.started = true
+	}
+
+	return bw.w.Write(p)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nireo/dkv/cdc"
+	"github.com/nireo/dkv/db"
+)
+
+func TestBackupRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	_, srv := createTestServer(t, 0, map[int]string{0: strings.TrimPrefix(ts.URL, "http://")})
+	mux.HandleFunc("/admin/backup", srv.Backup)
+
+	resp, err := http.Get(ts.URL + "/admin/backup")
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status for backup. got=%d", resp.StatusCode)
+	}
+
+	info, err := db.ReadBackupInfo(resp.Body)
+	if err != nil || !info.Full {
+		t.Fatalf("invalid backup archive. info=%+v err=%v", info, err)
+	}
+
+	// incremental backups need the change log
+	resp, err = http.Get(ts.URL + "/admin/backup?since=0")
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("wrong status for incremental backup. got=%d", resp.StatusCode)
+	}
+}
+
+func TestCDCRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	d, srv := createTestServer(t, 0, map[int]string{0: strings.TrimPrefix(ts.URL, "http://")})
+	mux.HandleFunc("/admin/cdc", srv.CDC)
+
+	if err := d.EnableChangeLog(); err != nil {
+		t.Fatalf("could not enable change log: %s", err)
+	}
+
+	f, err := cdc.New(d, "test", cdc.NewWebhookSink(ts.URL+"/hook"), cdc.DefaultOptions)
+	if err != nil {
+		t.Fatalf("could not create feed: %s", err)
+	}
+	srv.AddFeed(f)
+	d.Set("key", []byte("value"))
+
+	resp, err := http.Get(ts.URL + "/admin/cdc")
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var stats []cdc.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("could not decode stats: %s", err)
+	}
+
+	// the feed isn't running, so the change is pending
+	if len(stats) != 1 || stats[0].Name != "test" || stats[0].Sequence != stats[0].Offset+1 {
+		t.Errorf("wrong stats. got=%+v", stats)
+	}
+}
+
+func TestRemoveSink(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	d, srv := createTestServer(t, 0, map[int]string{0: strings.TrimPrefix(ts.URL, "http://")})
+	mux.HandleFunc("/admin/changelog/trim", srv.TrimChangeLog)
+	mux.HandleFunc("/admin/cdc/remove", srv.RemoveSink)
+
+	if err := d.EnableChangeLog(); err != nil {
+		t.Fatalf("could not enable change log: %s", err)
+	}
+
+	running, err := cdc.New(d, "running", cdc.NewWebhookSink(ts.URL+"/hook"), cdc.DefaultOptions)
+	if err != nil {
+		t.Fatalf("could not create feed: %s", err)
+	}
+	srv.AddFeed(running)
+	d.SetSinkOffset("running", 2)
+	d.SetSinkOffset("old", 1)
+
+	for i := 0; i < 3; i++ {
+		d.Set("key", []byte("value"))
+	}
+
+	trim := func() db.ChangeLogTrim {
+		resp, err := http.Get(ts.URL + "/admin/changelog/trim?seq=3")
+		if err != nil {
+			t.Fatalf("request failed: %s", err)
+		}
+		defer resp.Body.Close()
+
+		var trim db.ChangeLogTrim
+		if err := json.NewDecoder(resp.Body).Decode(&trim); err != nil {
+			t.Fatalf("could not decode trim: %s", err)
+		}
+		return trim
+	}
+
+	if got := trim(); got.Requested != 3 || got.Seq != 1 || got.Sink != "old" {
+		t.Errorf("wrong trim with the old sink. got=%+v", got)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"running", http.StatusConflict},
+		{"old", http.StatusNoContent},
+		{"old", http.StatusNotFound},
+	} {
+		resp, err := http.Get(ts.URL + "/admin/cdc/remove?name=" + tt.name)
+		if err != nil {
+			t.Fatalf("request failed: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("removing %q: want status %d, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+
+	if got := trim(); got.Seq != 2 || got.Sink != "running" {
+		t.Errorf("wrong trim after removing the old sink. got=%+v", got)
+	}
+}
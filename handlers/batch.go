@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/shards"
+)
+
+// BatchGetRequest is the body of POST /v1/batch/get.
+type BatchGetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// BatchValues is the body of POST /v1/batch/set and the response of POST /v1/batch/get. The
+// values are base64 encoded in json.
+type BatchValues struct {
+	Values map[string][]byte `json:"values"`
+}
+
+// v1Shards returns the shard map of the server, which the clients use to route the keys.
+func (s *Server) v1Shards(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set(shards.EpochHeader, strconv.FormatUint(s.shards.Epoch, 10))
+	writeJSON(w, http.StatusOK, s.shards.Config())
+}
+
+// v1BatchGet returns the values of the keys, the keys that are not found are left out. All of the
+// keys must belong to this shard, since the batches are grouped per shard by the clients.
+func (s *Server) v1BatchGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req BatchGetRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.valueLimit())).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, "could not decode batch: "+err.Error())
+		return
+	}
+
+	if !s.batchLocal(w, r, req.Keys) {
+		return
+	}
+
+	res := BatchValues{Values: make(map[string][]byte, len(req.Keys))}
+	for _, key := range req.Keys {
+		value, err := s.db.Get(key)
+		if err == db.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+
+		res.Values[key] = value
+	}
+
+	writeJSON(w, http.StatusOK, &res)
+}
+
+// v1BatchSet sets all of the values in the batch. All of the keys must belong to this shard.
+func (s *Server) v1BatchSet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req BatchValues
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.valueLimit())).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, "could not decode batch: "+err.Error())
+		return
+	}
+
+	keys := make([]string, 0, len(req.Values))
+	for key := range req.Values {
+		keys = append(keys, key)
+	}
+
+	if !s.batchLocal(w, r, keys) {
+		return
+	}
+
+	for key, value := range req.Values {
+		if err := s.db.Set(key, value); err != nil {
+			writeDBError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// batchLocal checks that every key of the batch belongs to this shard. Otherwise it responds with
+// 421 and the epoch of the server, such that the client can refresh its shard map.
+func (s *Server) batchLocal(w http.ResponseWriter, r *http.Request, keys []string) bool {
+	for _, key := range keys {
+		if key == "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidKey, db.ErrKeyLength.Error())
+			return false
+		}
+
+		if s.shards.GetShardIndex(key) != s.shards.Index {
+			w.Header().Set(shards.EpochHeader, strconv.FormatUint(s.shards.Epoch, 10))
+			writeError(w, http.StatusMisdirectedRequest, CodeWrongShard,
+				"the key "+strconv.Quote(key)+" belongs to another shard")
+			return false
+		}
+	}
+
+	return true
+}
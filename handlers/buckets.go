@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nireo/dkv/db"
+)
+
+// ListBuckets returns the names of the user created buckets as a json list.
+func (s *Server) ListBuckets(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.db.ListBuckets())
+}
+
+// CreateBucket takes in a bucket name as an url parameter and creates the bucket. The bucket
+// is created on every shard unless the local parameter is set.
+func (s *Server) CreateBucket(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	name := r.Form.Get("name")
+
+	if err := s.db.CreateBucket(name); err != nil {
+		http.Error(w, "could not create bucket: "+err.Error(), bucketErrorStatus(err))
+		return
+	}
+
+	if err := s.broadcast(r); err != nil {
+		http.Error(w, "could not create bucket on all shards: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DropBucket takes in a bucket name as an url parameter and removes the bucket with all of its
+// data. The bucket is dropped on every shard unless the local parameter is set.
+func (s *Server) DropBucket(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	name := r.Form.Get("name")
+
+	if err := s.db.DropBucket(name); err != nil {
+		http.Error(w, "could not drop bucket: "+err.Error(), bucketErrorStatus(err))
+		return
+	}
+
+	if err := s.broadcast(r); err != nil {
+		http.Error(w, "could not drop bucket on all shards: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BucketOp handles the key-value operations on named buckets. The paths are in the form of
+// /b/{bucket}/get, /b/{bucket}/set and /b/{bucket}/del and they take the same url parameters
+// as the default bucket routes.
+func (s *Server) BucketOp(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/b/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, "the path should be in the form of /b/{bucket}/{get|set|del}", http.StatusNotFound)
+		return
+	}
+	bucket, op := parts[0], parts[1]
+
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	b := s.db.Bucket(bucket)
+	switch op {
+	case "get":
+		value, err := b.Get([]byte(key))
+		if err != nil {
+			http.Error(w, "error finding key from bucket: "+err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Write(value)
+	case "set":
+		if err := b.Set([]byte(key), []byte(r.Form.Get("value"))); err != nil {
+			http.Error(w, "error setting value: "+err.Error(), bucketErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "del":
+		if err := b.Delete([]byte(key)); err != nil {
+			http.Error(w, "could not delete key: "+err.Error(), bucketErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unknown bucket operation: "+op, http.StatusNotFound)
+	}
+}
+
+// broadcast sends the request to all of the other shards using the same method with the local
+// parameter set, such that they don't broadcast it again. Requests that already have the local parameter are not sent.
+func (s *Server) broadcast(r *http.Request) error {
+	if r.Form.Get("local") != "" {
+		return nil
+	}
+
+	query := url.Values{}
+	for k, v := range r.Form {
+		query[k] = v
+	}
+	query.Set("local", "1")
+
+	for index, addr := range s.shards.Addresses {
+		if index == s.shards.Index {
+			continue
+		}
+
+		req, err := http.NewRequest(r.Method, s.shardURL(addr)+r.URL.Path+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("shard %d responded with status %d", index, resp.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// bucketErrorStatus maps the bucket errors into http status codes.
+func bucketErrorStatus(err error) int {
+	switch err {
+	case db.ErrBucketNotFound:
+		return http.StatusNotFound
+	case db.ErrBucketExists, db.ErrBucketReserved:
+		return http.StatusConflict
+	case db.ErrBucketName, db.ErrInvalidBucketName, db.ErrTooManyBuckets, db.ErrKeyLength, db.ErrInvalidQuota:
+		return http.StatusBadRequest
+	case db.ErrReadOnly:
+		return http.StatusForbidden
+	case db.ErrQuotaExceeded:
+		return http.StatusTooManyRequests
+	}
+
+	return http.StatusInternalServerError
+}
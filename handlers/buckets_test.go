@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBucketRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	_, srv := createTestServer(t, 0, map[int]string{0: strings.TrimPrefix(ts.URL, "http://")})
+	mux.HandleFunc("/buckets", srv.ListBuckets)
+	mux.HandleFunc("/buckets/create", srv.CreateBucket)
+	mux.HandleFunc("/buckets/drop", srv.DropBucket)
+	mux.HandleFunc("/b/", srv.BucketOp)
+
+	testCases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/b/users/set?key=alice&value=admin", http.StatusNotFound, ""},
+		{"/buckets/create?name=users", http.StatusCreated, ""},
+		{"/buckets/create?name=users", http.StatusConflict, ""},
+		{"/b/users/set?key=alice&value=admin", http.StatusNoContent, ""},
+		{"/b/users/get?key=alice", http.StatusOK, "admin"},
+		{"/buckets", http.StatusOK, `["users"]`},
+		{"/buckets/drop?name=users", http.StatusNoContent, ""},
+		{"/b/users/get?key=alice", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		resp, err := http.Get(ts.URL + tc.path)
+		if err != nil {
+			t.Fatalf("request to %s failed: %s", tc.path, err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("wrong status for %s. got=%d want=%d", tc.path, resp.StatusCode, tc.status)
+		}
+
+		if tc.body != "" && strings.TrimSpace(string(body)) != tc.body {
+			t.Errorf("wrong body for %s. got=%q want=%q", tc.path, body, tc.body)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Incr takes in a key and an optional delta as url parameters and adds the delta into the counter.
+// The delta defaults to 1 and it can be negative. The new value of the counter is returned.
+func (s *Server) Incr(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	delta := int64(1)
+	if d := r.Form.Get("delta"); d != "" {
+		var err error
+		if delta, err = strconv.ParseInt(d, 10, 64); err != nil {
+			http.Error(w, "delta is not a valid integer: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	value, err := s.db.CounterIncr(key, delta)
+	if err != nil {
+		http.Error(w, "error incrementing counter: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte(strconv.FormatInt(value, 10)))
+}
+
+// Counter takes in a key as an url parameter and returns the value of the counter.
+func (s *Server) Counter(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	value, err := s.db.CounterValue(key)
+	if err != nil {
+		http.Error(w, "error reading counter: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte(strconv.FormatInt(value, 10)))
+}
+
+// SetAdd takes in a key and a member as url parameters and adds the member into the set.
+func (s *Server) SetAdd(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	if err := s.db.SetAdd(key, r.Form.Get("member")); err != nil {
+		http.Error(w, "error adding member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetRemove takes in a key and a member as url parameters and removes the member from the set.
+func (s *Server) SetRemove(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	if err := s.db.SetRemove(key, r.Form.Get("member")); err != nil {
+		http.Error(w, "error removing member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetMembers takes in a key as an url parameter and returns the members of the set as a json list.
+func (s *Server) SetMembers(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	members, err := s.db.SetMembers(key)
+	if err != nil {
+		http.Error(w, "error reading set members: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(members)
+}
+
+// RegisterSet takes in a key-value pair as url parameters and writes the value into the register.
+func (s *Server) RegisterSet(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	if err := s.db.RegisterSet(key, []byte(r.Form.Get("value"))); err != nil {
+		http.Error(w, "error setting register: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterGet takes in a key as an url parameter and returns the value of the register.
+func (s *Server) RegisterGet(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	value, err := s.db.RegisterGet(key)
+	if err != nil {
+		http.Error(w, "error finding register: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Write(value)
+}
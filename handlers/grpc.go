@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/dkvpb"
+	"github.com/nireo/dkv/export"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReplicationPollInterval is how often the replication stream checks the replication queue when
+// it is empty.
+const ReplicationPollInterval = 100 * time.Millisecond
+
+// SetReplicationPollInterval sets how often the replication stream of this server checks the empty
+// replication queue, overriding ReplicationPollInterval.
+func (s *Server) SetReplicationPollInterval(interval time.Duration) {
+	s.pollInterval = interval
+}
+
+// replicationPoll returns how often the replication stream of this server checks the empty queue.
+func (s *Server) replicationPoll() time.Duration {
+	if s.pollInterval > 0 {
+		return s.pollInterval
+	}
+	return ReplicationPollInterval
+}
+
+// grpcCodes maps the error codes of the /v1 api into gRPC codes.
+var grpcCodes = map[string]codes.Code{
+	CodeNotFound:        codes.NotFound,
+	CodeBucketNotFound:  codes.NotFound,
+	CodeBucketExists:    codes.AlreadyExists,
+	CodeBucketReserved:  codes.FailedPrecondition,
+	CodeInvalidBucket:   codes.InvalidArgument,
+	CodeTooManyBuckets:  codes.ResourceExhausted,
+	CodeInvalidKey:      codes.InvalidArgument,
+	CodeInvalidArgument: codes.InvalidArgument,
+	CodeReadOnly:        codes.FailedPrecondition,
+	CodeQuotaExceeded:   codes.ResourceExhausted,
+	CodeNotInteger:      codes.FailedPrecondition,
+	CodeOverflow:        codes.FailedPrecondition,
+	CodeValueTooLarge:   codes.InvalidArgument,
+	CodeWrongShard:      codes.FailedPrecondition,
+	CodePrecondition:    codes.FailedPrecondition,
+}
+
+// grpcServer implements the gRPC api. The keys of other shards are forwarded to the /v1 api of
+// their owners, since the shards file only contains the HTTP addresses.
+type grpcServer struct {
+	dkvpb.UnimplementedDKVServer
+	s *Server
+}
+
+// RegisterGRPC registers the gRPC api of the server into g.
+func (s *Server) RegisterGRPC(g *grpc.Server) {
+	dkvpb.RegisterDKVServer(g, &grpcServer{s: s})
+}
+
+func (g *grpcServer) Get(ctx context.Context, req *dkvpb.GetRequest) (*dkvpb.GetResponse, error) {
+	value, contentType, err := g.get(ctx, req.Bucket, string(req.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &dkvpb.GetResponse{Value: value, ContentType: contentType}, nil
+}
+
+func (g *grpcServer) Set(ctx context.Context, req *dkvpb.SetRequest) (*dkvpb.SetResponse, error) {
+	if err := g.set(ctx, req.Bucket, string(req.Key), req.Value, req.ContentType); err != nil {
+		return nil, err
+	}
+
+	return &dkvpb.SetResponse{}, nil
+}
+
+func (g *grpcServer) Delete(ctx context.Context, req *dkvpb.DeleteRequest) (*dkvpb.DeleteResponse, error) {
+	if err := g.delete(ctx, req.Bucket, string(req.Key)); err != nil {
+		return nil, err
+	}
+
+	return &dkvpb.DeleteResponse{}, nil
+}
+
+func (g *grpcServer) Batch(ctx context.Context, req *dkvpb.BatchRequest) (*dkvpb.BatchResponse, error) {
+	res := &dkvpb.BatchResponse{Results: make([]*dkvpb.Result, len(req.Operations))}
+	for i, op := range req.Operations {
+		result := &dkvpb.Result{}
+
+		var err error
+		switch op.Type {
+		case dkvpb.Operation_GET:
+			result.Value, _, err = g.get(ctx, op.Bucket, string(op.Key))
+			result.Found = err == nil
+			if grpcErrorCode(err) == CodeNotFound {
+				err = nil
+			}
+		case dkvpb.Operation_SET:
+			err = g.set(ctx, op.Bucket, string(op.Key), op.Value, "")
+		case dkvpb.Operation_DELETE:
+			err = g.delete(ctx, op.Bucket, string(op.Key))
+		default:
+			err = status.Errorf(codes.InvalidArgument, "unknown operation %d", op.Type)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		res.Results[i] = result
+	}
+
+	return res, nil
+}
+
+func (g *grpcServer) Scan(req *dkvpb.ScanRequest, stream dkvpb.DKV_ScanServer) error {
+	send := func(key, value []byte) error {
+		return stream.Send(&dkvpb.KeyValue{Key: key, Value: value})
+	}
+
+	var err error
+	if req.Bucket != "" {
+		err = g.s.db.Bucket(req.Bucket).Scan(req.Prefix, nil, send)
+	} else {
+		err = g.s.db.Scan(req.Prefix, nil, send)
+	}
+
+	if err != nil {
+		return grpcError(err)
+	}
+
+	if req.Local {
+		return nil
+	}
+
+	for index := range g.s.shards.Addresses {
+		if index == g.s.shards.Index {
+			continue
+		}
+
+		if err := g.scanShard(stream.Context(), index, req, send); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanShard streams the key-value pairs of another shard from its export route.
+func (g *grpcServer) scanShard(ctx context.Context, shard int, req *dkvpb.ScanRequest, send func(key, value []byte) error) error {
+	query := url.Values{"bucket": {req.Bucket}, "prefix": {string(req.Prefix)}, "format": {export.FormatJSONL}}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		g.s.shardURL(g.s.shards.Addresses[shard])+"/export?"+query.Encode(), nil)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	// the export can take longer than the timeout of the forwarded requests
+	resp, err := (&http.Client{Transport: g.s.client.Transport}).Do(hreq)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "could not scan shard %d: %s", shard, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return forwardedError(newRemoteError(resp.StatusCode, body))
+	}
+
+	dec, _ := export.NewReader(resp.Body, export.FormatJSONL)
+	for {
+		rec, err := dec.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return status.Errorf(codes.Internal, "could not scan shard %d: %s", shard, err)
+		}
+
+		if err := send([]byte(rec.Key), rec.Value); err != nil {
+			return err
+		}
+	}
+}
+
+func (g *grpcServer) Replicate(stream dkvpb.DKV_ReplicateServer) error {
+	for {
+		next, err := g.s.nextReplica()
+		if err != nil {
+			return grpcError(err)
+		}
+
+		if next.Key == "" {
+			select {
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			case <-time.After(g.s.replicationPoll()):
+				continue
+			}
+		}
+
+		key, err := next.RawKey()
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		value, err := next.RawValue()
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		err = stream.Send(&dkvpb.ReplicationEvent{
+			Key:         key,
+			Value:       value,
+			Bucket:      next.Bucket,
+			ContentType: next.ContentType,
+		})
+		if err != nil {
+			return err
+		}
+
+		ack, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// the value has changed after it was sent, so it stays in the queue and is sent again
+		err = g.s.deleteReplica(ack.Bucket, ack.Key, ack.Value)
+		if err != nil && err != db.ErrValDontMatch {
+			return grpcError(err)
+		}
+	}
+}
+
+func (g *grpcServer) get(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	if shard, ok := g.owner(key); !ok {
+		return g.forward(ctx, shard, http.MethodGet, bucket, key, nil, "")
+	}
+
+	var value []byte
+	var contentType string
+	var err error
+	if bucket != "" {
+		value, err = g.s.db.Bucket(bucket).Get([]byte(key))
+	} else {
+		value, contentType, err = g.s.db.GetWithType(key)
+	}
+
+	if err != nil {
+		return nil, "", grpcError(err)
+	}
+
+	return value, contentType, nil
+}
+
+func (g *grpcServer) set(ctx context.Context, bucket, key string, value []byte, contentType string) error {
+	if shard, ok := g.owner(key); !ok {
+		_, _, err := g.forward(ctx, shard, http.MethodPut, bucket, key, value, contentType)
+		return err
+	}
+
+	var err error
+	if bucket != "" {
+		err = g.s.db.Bucket(bucket).Set([]byte(key), value)
+	} else {
+		err = g.s.db.SetWithType(key, value, contentType)
+	}
+
+	return grpcError(err)
+}
+
+func (g *grpcServer) delete(ctx context.Context, bucket, key string) error {
+	if shard, ok := g.owner(key); !ok {
+		_, _, err := g.forward(ctx, shard, http.MethodDelete, bucket, key, nil, "")
+		return err
+	}
+
+	var err error
+	if bucket != "" {
+		err = g.s.db.Bucket(bucket).Delete([]byte(key))
+	} else {
+		err = g.s.db.Delete(key)
+	}
+
+	return grpcError(err)
+}
+
+// owner returns the shard of the key and whether it is this shard. The empty keys are handled
+// locally, such that the database returns the error.
+func (g *grpcServer) owner(key string) (int, bool) {
+	shard := g.s.shards.GetShardIndex(key)
+	return shard, key == "" || shard == g.s.shards.Index
+}
+
+// forward sends the operation to the shard and converts the errors into gRPC errors.
+func (g *grpcServer) forward(ctx context.Context, shard int, method, bucket, key string, body []byte, contentType string) ([]byte, string, error) {
+	data, contentType, err := g.s.forwardKey(ctx, shard, method, keyPath(bucket, key), body, contentType)
+	if err != nil {
+		return nil, "", forwardedError(err)
+	}
+
+	return data, contentType, nil
+}
+
+// codeError is a gRPC status error that keeps the error code of the /v1 api, such that the errors
+// that share a gRPC code, like a missing key and a missing bucket, can be told apart.
+type codeError struct {
+	code string
+	st   *status.Status
+}
+
+func (e *codeError) Error() string {
+	return e.st.Err().Error()
+}
+
+// GRPCStatus returns the status that is sent to the client.
+func (e *codeError) GRPCStatus() *status.Status {
+	return e.st
+}
+
+// grpcErrorCode returns the /v1 error code of an error from grpcError or forwardedError. It is
+// empty for the errors that don't have one.
+func grpcErrorCode(err error) string {
+	var e *codeError
+	if errors.As(err, &e) {
+		return e.code
+	}
+
+	return ""
+}
+
+// grpcError converts a database error into a gRPC status error.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	_, code := dbErrorCode(err)
+	if c, ok := grpcCodes[code]; ok {
+		return &codeError{code: code, st: status.New(c, err.Error())}
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// forwardedError converts an error from another shard into a gRPC status error.
+func forwardedError(err error) error {
+	remote, ok := err.(*remoteError)
+	if !ok {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	if c, ok := grpcCodes[remote.Code]; ok {
+		return &codeError{code: remote.Code, st: status.New(c, remote.Message)}
+	}
+
+	switch remote.status {
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, remote.Message)
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return status.Error(codes.Unavailable, remote.Message)
+	}
+
+	return status.Error(codes.Internal, remote.Message)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/dkvpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPC starts two shards with the HTTP api and serves the gRPC api of the first shard over
+// an in-process listener.
+func startGRPC(t *testing.T) (dkvpb.DKVClient, []*db.DB, []*Server) {
+	t.Helper()
+
+	mux1, mux2 := http.NewServeMux(), http.NewServeMux()
+	ts1, ts2 := httptest.NewServer(mux1), httptest.NewServer(mux2)
+	t.Cleanup(ts1.Close)
+	t.Cleanup(ts2.Close)
+
+	addrs := map[int]string{
+		0: strings.TrimPrefix(ts1.URL, "http://"),
+		1: strings.TrimPrefix(ts2.URL, "http://"),
+	}
+
+	db1, web1 := createTestServer(t, 0, addrs)
+	db2, web2 := createTestServer(t, 1, addrs)
+	for i, mux := range []*http.ServeMux{mux1, mux2} {
+		web := []*Server{web1, web2}[i]
+		mux.Handle("/v1/", web.V1())
+		mux.HandleFunc("/export", web.Export)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	g := grpc.NewServer()
+	web1.RegisterGRPC(g)
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(
+		func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+	if err != nil {
+		t.Fatalf("could not dial: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return dkvpb.NewDKVClient(conn), []*db.DB{db1, db2}, []*Server{web1, web2}
+}
+
+func TestGRPCGetSetDelete(t *testing.T) {
+	c, dbs, servers := startGRPC(t)
+	ctx := context.Background()
+
+	for _, shard := range []int{0, 1} {
+		key := keyOnShard(t, servers[0], shard)
+		value := []byte{0, 1, 0xff}
+
+		_, err := c.Set(ctx, &dkvpb.SetRequest{Key: []byte(key), Value: value, ContentType: "image/png"})
+		if err != nil {
+			t.Fatalf("could not set key on shard %d: %s", shard, err)
+		}
+
+		stored, contentType, err := dbs[shard].GetWithType(key)
+		if err != nil || string(stored) != string(value) || contentType != "image/png" {
+			t.Errorf("the key was not stored on shard %d. got=%v %q, err=%v", shard, stored, contentType, err)
+		}
+
+		res, err := c.Get(ctx, &dkvpb.GetRequest{Key: []byte(key)})
+		if err != nil {
+			t.Fatalf("could not get key from shard %d: %s", shard, err)
+		}
+
+		if string(res.Value) != string(value) || res.ContentType != "image/png" {
+			t.Errorf("wrong value from shard %d. got=%v %q", shard, res.Value, res.ContentType)
+		}
+
+		if _, err := c.Delete(ctx, &dkvpb.DeleteRequest{Key: []byte(key)}); err != nil {
+			t.Fatalf("could not delete key from shard %d: %s", shard, err)
+		}
+
+		_, err = c.Get(ctx, &dkvpb.GetRequest{Key: []byte(key)})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("wrong error for a deleted key on shard %d. got=%v", shard, err)
+		}
+	}
+
+	_, err := c.Set(ctx, &dkvpb.SetRequest{Key: []byte("k"), Bucket: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("wrong error for a missing bucket. got=%v", err)
+	}
+
+	_, err = c.Get(ctx, &dkvpb.GetRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("wrong error for an empty key. got=%v", err)
+	}
+}
+
+func TestGRPCBatch(t *testing.T) {
+	c, dbs, servers := startGRPC(t)
+	ctx := context.Background()
+
+	local, remote := keyOnShard(t, servers[0], 0), keyOnShard(t, servers[0], 1)
+	res, err := c.Batch(ctx, &dkvpb.BatchRequest{Operations: []*dkvpb.Operation{
+		{Type: dkvpb.Operation_SET, Key: []byte(local), Value: []byte("1")},
+		{Type: dkvpb.Operation_SET, Key: []byte(remote), Value: []byte("2")},
+		{Type: dkvpb.Operation_GET, Key: []byte(local)},
+		{Type: dkvpb.Operation_GET, Key: []byte(remote)},
+		{Type: dkvpb.Operation_DELETE, Key: []byte(local)},
+		{Type: dkvpb.Operation_GET, Key: []byte(local)},
+	}})
+	if err != nil {
+		t.Fatalf("batch failed: %s", err)
+	}
+
+	results := res.Results
+	if !results[2].Found || string(results[2].Value) != "1" || !results[3].Found || string(results[3].Value) != "2" {
+		t.Errorf("wrong values from batch. got=%v", results)
+	}
+
+	if results[5].Found {
+		t.Errorf("the deleted key was found")
+	}
+
+	if _, err := dbs[1].Get(remote); err != nil {
+		t.Errorf("the remote key was not stored on its shard: %s", err)
+	}
+
+	// a missing key in a bucket is not found, but a missing bucket fails the batch
+	dbs[0].CreateBucket("users")
+	dbs[1].CreateBucket("users")
+	res, err = c.Batch(ctx, &dkvpb.BatchRequest{Operations: []*dkvpb.Operation{
+		{Type: dkvpb.Operation_GET, Bucket: "users", Key: []byte(local)},
+		{Type: dkvpb.Operation_GET, Bucket: "users", Key: []byte(remote)},
+	}})
+	if err != nil || res.Results[0].Found || res.Results[1].Found {
+		t.Errorf("wrong results for missing keys in a bucket. got=%v, err=%v", res, err)
+	}
+
+	for _, key := range []string{local, remote} {
+		_, err := c.Batch(ctx, &dkvpb.BatchRequest{Operations: []*dkvpb.Operation{
+			{Type: dkvpb.Operation_GET, Bucket: "missing", Key: []byte(key)},
+		}})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("wrong error for %s in a missing bucket. got=%v", key, err)
+		}
+	}
+}
+
+func TestGRPCScan(t *testing.T) {
+	c, dbs, _ := startGRPC(t)
+
+	dbs[0].Set("scan/a", []byte("1"))
+	dbs[1].Set("scan/b", []byte("2"))
+	dbs[1].Set("other", []byte("3"))
+
+	for _, local := range []bool{false, true} {
+		stream, err := c.Scan(context.Background(), &dkvpb.ScanRequest{Prefix: []byte("scan/"), Local: local})
+		if err != nil {
+			t.Fatalf("could not scan: %s", err)
+		}
+
+		found := make(map[string]string)
+		for {
+			kv, err := stream.Recv()
+			if err != nil {
+				break
+			}
+			found[string(kv.Key)] = string(kv.Value)
+		}
+
+		want := 2
+		if local {
+			want = 1
+		}
+
+		if len(found) != want || found["scan/a"] != "1" {
+			t.Errorf("wrong scan results with local=%v. got=%v", local, found)
+		}
+	}
+}
+
+func TestGRPCReplicate(t *testing.T) {
+	c, dbs, servers := startGRPC(t)
+	servers[0].SetReplicationPollInterval(10 * time.Millisecond)
+
+	dbs[0].Set("a", []byte("1"))
+	dbs[0].Set("b", []byte{0xff})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.Replicate(ctx)
+	if err != nil {
+		t.Fatalf("could not start replication: %s", err)
+	}
+
+	got := make(map[string]string)
+	for len(got) < 2 {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("could not receive replication event: %s", err)
+		}
+		got[string(event.Key)] = string(event.Value)
+
+		err = stream.Send(&dkvpb.ReplicationAck{Key: event.Key, Value: event.Value, Bucket: event.Bucket})
+		if err != nil {
+			t.Fatalf("could not acknowledge event: %s", err)
+		}
+	}
+	stream.CloseSend()
+
+	if got["a"] != "1" || got["b"] != "\xff" {
+		t.Errorf("wrong replication events. got=%q", got)
+	}
+
+	// the last acknowledgement is handled before the stream ends
+	for i := 0; i < 100; i++ {
+		if n, _ := dbs[0].ReplicationBacklog(); n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Errorf("the acknowledged events were not removed from the queue")
+}
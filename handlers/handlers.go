@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"io"
 	"net/http"
+	"net/http/httputil"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/nireo/dkv/cdc"
 	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/metrics"
 	"github.com/nireo/dkv/replica"
 	"github.com/nireo/dkv/shards"
 )
@@ -16,14 +19,58 @@ import (
 type Server struct {
 	db     *db.DB
 	shards *shards.Shards
+
+	// the requests for keys on other shards are forwarded through the proxies, or redirected if
+	// redirect is set.
+	client   *http.Client
+	proxies  map[int]*httputil.ReverseProxy
+	redirect bool
+	scheme   string // http, or https if UseTLS was called
+
+	// maxValueSize overrides MaxValueSize and pollInterval overrides ReplicationPollInterval for
+	// this server when they are set.
+	maxValueSize int64
+	pollInterval time.Duration
+
+	// the change data capture feeds of this node, whose statistics are shown in the admin api.
+	feeds []*cdc.Feed
+
+	metrics *serverMetrics
+	replica *replica.Replica
+
+	// the state of the readiness check. The migrations are counted atomically.
+	started       time.Time
+	maxReplicaLag time.Duration
+	migrations    int32
+
+	// draining is closed when the server starts shutting down and conns are the connections of the
+	// redis and memcached listeners.
+	draining  chan struct{}
+	drainOnce sync.Once
+	conns     connTracker
 }
 
 // NewServer returns a new instance of server given a database
 func NewServer(db *db.DB, s *shards.Shards) *Server {
-	return &Server{
-		db:     db,
-		shards: s,
+	transport := newTransport()
+	srv := &Server{
+		db:       db,
+		shards:   s,
+		client:   &http.Client{Transport: transport, Timeout: forwardTimeout},
+		scheme:   "http",
+		proxies:  make(map[int]*httputil.ReverseProxy, len(s.Addresses)),
+		metrics:  newServerMetrics(),
+		started:  time.Now(),
+		draining: make(chan struct{}),
+	}
+	srv.metrics.registry.Register(srv.metrics.requests, srv.metrics.latency, srv.metrics.forwarded,
+		metrics.CollectorFunc(srv.collect))
+
+	for index, addr := range s.Addresses {
+		srv.proxies[index] = newProxy(srv.scheme, addr, transport)
 	}
+
+	return srv
 }
 
 // Get takes a key as a url parameter and return the value in the request body
@@ -33,7 +80,7 @@ func (s *Server) Get(w http.ResponseWriter, r *http.Request) {
 
 	shard := s.shards.GetShardIndex(key)
 	if shard != s.shards.Index {
-		s.redirectHTTP(shard, w, r)
+		s.forward(shard, w, r)
 		return
 	}
 
@@ -55,7 +102,7 @@ func (s *Server) Set(w http.ResponseWriter, r *http.Request) {
 
 	shard := s.shards.GetShardIndex(key)
 	if shard != s.shards.Index {
-		s.redirectHTTP(shard, w, r)
+		s.forward(shard, w, r)
 		return
 	}
 
@@ -66,17 +113,6 @@ func (s *Server) Set(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("shards sent to" + strconv.Itoa(shard)))
 }
 
-func (s *Server) redirectHTTP(shard int, w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "redirecting from shards %d to shards %d", s.shards.Index, shard)
-	resp, err := http.Get("http://" + s.shards.Addresses[shard] + r.RequestURI)
-	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-	io.Copy(w, resp.Body)
-}
-
 // Delete takes in a key as an url parameter and removes that key from the database
 func (s *Server) Delete(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
@@ -84,7 +120,7 @@ func (s *Server) Delete(w http.ResponseWriter, r *http.Request) {
 
 	shard := s.shards.GetShardIndex(key)
 	if shard != s.shards.Index {
-		s.redirectHTTP(shard, w, r)
+		s.forward(shard, w, r)
 		return
 	}
 
@@ -96,20 +132,48 @@ func (s *Server) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// GetNextReplicationKey returns the next key in the replication queue
+// GetNextReplicationKey returns the next key in the replication queue. The plain key-value pairs
+// are replicated first and after that the CRDT values.
 func (s *Server) GetNextReplicationKey(w http.ResponseWriter, r *http.Request) {
-	enc := json.NewEncoder(w)
-	k, v, err := s.db.GetNextReplica()
+	next, err := s.nextReplica()
 	if err != nil {
 		http.Error(w, "could not retrieve next replication key: "+err.Error(),
 			http.StatusInternalServerError)
 		return
 	}
 
-	enc.Encode(&replica.Next{
-		Key:   string(k),
-		Value: string(v),
-	})
+	json.NewEncoder(w).Encode(next)
+}
+
+// nextReplica returns the next value of the replication queue. The key of the value is empty if
+// the queue is empty.
+func (s *Server) nextReplica() (*replica.Next, error) {
+	k, v, err := s.db.GetNextReplica()
+	if err != nil {
+		return nil, err
+	}
+
+	if k == nil {
+		bucket, k, v, err := s.db.GetNextCRDTReplica()
+		if err != nil {
+			return nil, err
+		}
+
+		return replica.NewNext(k, v, bucket, ""), nil
+	}
+
+	item, err := s.db.ReplicationItem(string(k))
+	if err != nil {
+		return nil, err
+	}
+
+	next := replica.NewNext(k, v, "", item.ContentType)
+	next.Flags = item.Flags
+	if !item.Deadline.IsZero() {
+		next.Deadline = item.Deadline.UnixNano()
+	}
+
+	return next, nil
 }
 
 // DeleteReplicationKey removes given key-value pair from the replication queue
@@ -118,7 +182,7 @@ func (s *Server) DeleteReplicationKey(w http.ResponseWriter, r *http.Request) {
 	key := r.Form.Get("key")
 	value := r.Form.Get("value")
 
-	if err := s.db.DeleteReplicationKey([]byte(key), []byte(value)); err != nil {
+	if err := s.deleteReplica(r.Form.Get("bucket"), []byte(key), []byte(value)); err != nil {
 		http.Error(w, "could not delete replication key: "+err.Error(),
 			http.StatusInternalServerError)
 		return
@@ -127,9 +191,21 @@ func (s *Server) DeleteReplicationKey(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// deleteReplica removes the value from the replication queue of the bucket, which is empty for
+// the plain key-value pairs.
+func (s *Server) deleteReplica(bucket string, key, value []byte) error {
+	if bucket != "" {
+		return s.db.DeleteCRDTReplicationKey(bucket, key, value)
+	}
+
+	return s.db.DeleteReplicationKey(key, value)
+}
+
 // DeleteNotBelonging removes all of the values in the database that don't match with the
 // shard hash.
 func (s *Server) DeleteNotBelonging(w http.ResponseWriter, r *http.Request) {
+	defer s.migrating()()
+
 	doesntBelong := (func(key string) bool {
 		return s.shards.GetShardIndex(key) != s.shards.Index
 	})
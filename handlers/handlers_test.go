@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nireo/dkv/db"
 	"github.com/nireo/dkv/shards"
@@ -53,6 +55,43 @@ func createTestServer(t *testing.T, id int, addresses map[int]string) (*db.DB, *
 	return db, s
 }
 
+// startListener starts two shards with the /v1 api, serves the first shard with serve on a tcp
+// listener and returns a connection to it.
+func startListener(t *testing.T, serve func(*Server, net.Listener) error) (net.Conn, []*db.DB, []*Server) {
+	t.Helper()
+
+	mux1, mux2 := http.NewServeMux(), http.NewServeMux()
+	ts1, ts2 := httptest.NewServer(mux1), httptest.NewServer(mux2)
+	t.Cleanup(ts1.Close)
+	t.Cleanup(ts2.Close)
+
+	addrs := map[int]string{
+		0: strings.TrimPrefix(ts1.URL, "http://"),
+		1: strings.TrimPrefix(ts2.URL, "http://"),
+	}
+
+	db1, web1 := createTestServer(t, 0, addrs)
+	db2, web2 := createTestServer(t, 1, addrs)
+	mux1.Handle("/v1/", web1.V1())
+	mux2.Handle("/v1/", web2.V1())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serve(web1, l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return conn, []*db.DB{db1, db2}, []*Server{web1, web2}
+}
+
 func TestServer(t *testing.T) {
 	var ts1GetHandler, ts1SetHandler func(w http.ResponseWriter, r *http.Request)
 	var ts2GetHandler, ts2SetHandler func(w http.ResponseWriter, r *http.Request)
@@ -88,10 +127,10 @@ func TestServer(t *testing.T) {
 		"testvalue":  0,
 	}
 
-	ts1GetHandler = web1.GetHTTP
-	ts1SetHandler = web1.SetHTTP
-	ts2GetHandler = web2.GetHTTP
-	ts2SetHandler = web2.SetHTTP
+	ts1GetHandler = web1.Get
+	ts1SetHandler = web1.Set
+	ts2GetHandler = web2.Get
+	ts2SetHandler = web2.Set
 
 	for key := range keys {
 		_, err := http.Get(fmt.Sprintf(ts1.URL+"/set?key=%s&value=value-%s", key, key))
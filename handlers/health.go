@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nireo/dkv/replica"
+)
+
+// Version is the version of dkv that is shown in /info. It is set when building a release with
+// -ldflags "-X github.com/nireo/dkv/handlers.Version=<version>".
+var Version = "dev"
+
+// DefaultMaxReplicaLag is the replication lag after which a replica is not ready.
+const DefaultMaxReplicaLag = 10 * time.Second
+
+// Readiness is the response of the readiness check. The checks map the name of a check to ok or
+// to the reason why the node isn't ready.
+type Readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Info describes the node.
+type Info struct {
+	Version  string  `json:"version"`
+	Shard    string  `json:"shard"`
+	Index    int     `json:"index"`
+	Role     string  `json:"role"`
+	ReadOnly bool    `json:"read_only"`
+	Uptime   float64 `json:"uptime_seconds"`
+	Epoch    uint64  `json:"epoch"`
+}
+
+// the roles of the nodes in Info.
+const (
+	RoleMaster  = "master"
+	RoleReplica = "replica"
+)
+
+// SetReplica makes the readiness check fail while the replica is more than maxLag behind the
+// master and adds the replication lag to the metrics.
+func (s *Server) SetReplica(r *replica.Replica, maxLag time.Duration) {
+	s.replica = r
+	s.maxReplicaLag = maxLag
+}
+
+// migrating makes the readiness check fail until the returned function is called.
+func (s *Server) migrating() func() {
+	atomic.AddInt32(&s.migrations, 1)
+	return func() { atomic.AddInt32(&s.migrations, -1) }
+}
+
+// Healthz responds with 200 as long as the process is able to serve requests.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz responds with 200 when the node is ready to serve traffic and with 503 otherwise. The
+// node isn't ready if the database is closed, the shard config doesn't contain this node, a replica
+// is behind its master, a migration is running or the node is shutting down.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	res := s.readiness()
+
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) readiness() *Readiness {
+	res := &Readiness{Ready: true, Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			res.Ready = false
+			res.Checks[name] = err.Error()
+			return
+		}
+
+		res.Checks[name] = "ok"
+	}
+
+	check("database", s.db.Ping())
+
+	var shardErr error
+	if _, ok := s.shards.Addresses[s.shards.Index]; !ok {
+		shardErr = fmt.Errorf("the shard config doesn't contain shard %d", s.shards.Index)
+	}
+	check("shards", shardErr)
+
+	if s.replica != nil {
+		var lagErr error
+		if lag := s.replica.Lag(); lag > s.maxReplicaLag {
+			lagErr = fmt.Errorf("the replica is %s behind the master", lag.Round(time.Millisecond))
+		}
+		check("replica", lagErr)
+	}
+
+	var migrationErr error
+	if n := atomic.LoadInt32(&s.migrations); n > 0 {
+		migrationErr = fmt.Errorf("%d migrations in progress", n)
+	}
+	check("migrations", migrationErr)
+
+	var drainErr error
+	if s.Draining() {
+		drainErr = fmt.Errorf("the node is shutting down")
+	}
+	check("shutdown", drainErr)
+
+	return res
+}
+
+// NodeInfo returns the version, shard, role and uptime of the node as json.
+func (s *Server) NodeInfo(w http.ResponseWriter, r *http.Request) {
+	info := &Info{
+		Version:  Version,
+		Shard:    s.shards.Name,
+		Index:    s.shards.Index,
+		Role:     RoleMaster,
+		ReadOnly: s.db.ReadOnly(),
+		Uptime:   time.Since(s.started).Seconds(),
+		Epoch:    s.shards.Epoch,
+	}
+
+	if s.replica != nil {
+		info.Role = RoleReplica
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
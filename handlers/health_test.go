@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/replica"
+)
+
+func readyz(t *testing.T, s *Server) (int, *Readiness) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	s.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var res Readiness
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("could not decode readiness: %s", err)
+	}
+
+	return w.Code, &res
+}
+
+func TestReadyz(t *testing.T) {
+	d, s := createTestServer(t, 0, map[int]string{0: "localhost:0"})
+
+	if code, res := readyz(t, s); code != http.StatusOK || !res.Ready {
+		t.Fatalf("the node is not ready. code=%d checks=%v", code, res.Checks)
+	}
+
+	done := s.migrating()
+	if code, res := readyz(t, s); code != http.StatusServiceUnavailable || res.Checks["migrations"] == "ok" {
+		t.Errorf("the node is ready during a migration. code=%d checks=%v", code, res.Checks)
+	}
+
+	done()
+	if code, _ := readyz(t, s); code != http.StatusOK {
+		t.Errorf("the node is not ready after the migration. code=%d", code)
+	}
+
+	// the replica has never reached the master, so its lag is the time since it was created
+	s.SetReplica(replica.New(d, "localhost:0"), time.Hour)
+	if code, _ := readyz(t, s); code != http.StatusOK {
+		t.Errorf("the replica is not ready within the lag. code=%d", code)
+	}
+
+	s.SetReplica(replica.New(d, "localhost:0"), time.Nanosecond)
+	if code, res := readyz(t, s); code != http.StatusServiceUnavailable || res.Checks["replica"] == "ok" {
+		t.Errorf("the replica is ready behind the master. code=%d checks=%v", code, res.Checks)
+	}
+	s.SetReplica(nil, 0)
+
+	s.Drain()
+	if code, res := readyz(t, s); code != http.StatusServiceUnavailable || res.Checks["shutdown"] == "ok" {
+		t.Errorf("the node is ready while shutting down. code=%d checks=%v", code, res.Checks)
+	}
+}
+
+func TestReadyzClosedDatabase(t *testing.T) {
+	d, s := createTestServer(t, 0, map[int]string{0: "localhost:0"})
+	d.Close()
+
+	if code, res := readyz(t, s); code != http.StatusServiceUnavailable || res.Checks["database"] == "ok" {
+		t.Errorf("the node is ready with a closed database. code=%d checks=%v", code, res.Checks)
+	}
+
+	w := httptest.NewRecorder()
+	s.Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("the process is not alive. code=%d", w.Code)
+	}
+}
+
+func TestNodeInfo(t *testing.T) {
+	d, s := createTestServer(t, 1, map[int]string{0: "localhost:0", 1: "localhost:1"})
+	s.shards.Name = "sh2"
+	s.shards.Epoch = 4
+
+	w := httptest.NewRecorder()
+	s.NodeInfo(w, httptest.NewRequest(http.MethodGet, "/info", nil))
+
+	var info Info
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("could not decode info: %s", err)
+	}
+
+	want := Info{Version: Version, Shard: "sh2", Index: 1, Role: RoleMaster, Epoch: 4, Uptime: info.Uptime}
+	if info != want || info.Uptime <= 0 {
+		t.Errorf("wrong info. got=%+v want=%+v", info, want)
+	}
+
+	s.SetReplica(replica.New(d, "localhost:0"), time.Hour)
+	w = httptest.NewRecorder()
+	s.NodeInfo(w, httptest.NewRequest(http.MethodGet, "/info", nil))
+	json.NewDecoder(w.Body).Decode(&info)
+
+	if info.Role != RoleReplica {
+		t.Errorf("wrong role. got=%q want=%q", info.Role, RoleReplica)
+	}
+}
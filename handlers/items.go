@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/nireo/dkv/db"
+)
+
+// The item functions operate on the keys of the default bucket on the shard that owns the key,
+// either with the local database or through the /v1 api of the owner. They are shared by the
+// redis and memcached listeners and they return the database errors for both, such that
+// db.ErrNotFound is returned for a missing key on any shard.
+
+// getItem returns the value of the key with its metadata. The deadline is not known for the keys
+// of other shards.
+func (s *Server) getItem(ctx context.Context, key string) (*db.Item, error) {
+	shard := s.shards.GetShardIndex(key)
+	if shard == s.shards.Index {
+		return s.db.GetItem(key)
+	}
+
+	data, header, err := s.forwardRequest(ctx, shard, http.MethodGet, keyPath("", key), nil, nil)
+	if err != nil {
+		return nil, remoteDBError(err)
+	}
+
+	item := &db.Item{Value: data}
+	if item.Value == nil {
+		item.Value = []byte{}
+	}
+
+	if contentType := header.Get("Content-Type"); contentType != "application/octet-stream" {
+		item.ContentType = contentType
+	}
+
+	item.Version, _ = parseETag(header.Get("ETag"))
+	if flags, err := strconv.ParseUint(header.Get(FlagsHeader), 10, 32); err == nil {
+		item.Flags = uint32(flags)
+	}
+
+	return item, nil
+}
+
+// setItem sets the value of the key like db.SetWithOptions.
+func (s *Server) setItem(ctx context.Context, key string, value []byte, opts db.SetOptions) (bool, error) {
+	shard := s.shards.GetShardIndex(key)
+	if shard == s.shards.Index {
+		return s.db.SetWithOptions(key, value, opts)
+	}
+
+	query := url.Values{}
+	if opts.TTL > 0 {
+		query.Set("ttl", opts.TTL.String())
+	}
+	if opts.KeepTTL {
+		query.Set("keepttl", "1")
+	}
+	if opts.IfNotExists {
+		query.Set("nx", "1")
+	}
+	if opts.IfExists {
+		query.Set("xx", "1")
+	}
+	if opts.Flags != 0 {
+		query.Set("flags", strconv.FormatUint(uint64(opts.Flags), 10))
+	}
+
+	path := keyPath("", key)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	header := make(http.Header)
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+	if opts.CheckVersion {
+		header.Set("If-Match", formatETag(opts.Version))
+	}
+
+	_, _, err := s.forwardRequest(ctx, shard, http.MethodPut, path, value, header)
+	if remote, ok := err.(*remoteError); ok && remote.Code == CodePrecondition {
+		// a precondition only fails because of the version when it is checked
+		if opts.CheckVersion {
+			return false, db.ErrVersionMismatch
+		}
+		return false, nil
+	}
+
+	if err != nil {
+		return false, remoteDBError(err)
+	}
+
+	return true, nil
+}
+
+// keyExists reports whether the key exists.
+func (s *Server) keyExists(ctx context.Context, key string) (bool, error) {
+	var err error
+	if shard := s.shards.GetShardIndex(key); shard == s.shards.Index {
+		_, err = s.db.Get(key)
+	} else {
+		_, _, err = s.forwardRequest(ctx, shard, http.MethodHead, keyPath("", key), nil, nil)
+		err = remoteDBError(err)
+	}
+
+	if err == db.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// deleteItem deletes the key and reports whether it existed.
+func (s *Server) deleteItem(ctx context.Context, key string) (bool, error) {
+	exists, err := s.keyExists(ctx, key)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	if shard := s.shards.GetShardIndex(key); shard == s.shards.Index {
+		err = s.db.Delete(key)
+	} else {
+		_, _, err = s.forwardRequest(ctx, shard, http.MethodDelete, keyPath("", key), nil, nil)
+		err = remoteDBError(err)
+	}
+
+	return err == nil, err
+}
+
+// remoteDBError converts the not found errors of other shards into db.ErrNotFound. The HEAD
+// responses don't have a body, so their code is not known.
+func remoteDBError(err error) error {
+	if remote, ok := err.(*remoteError); ok && remote.status == http.StatusNotFound {
+		if remote.Code == CodeNotFound || remote.Code == "" {
+			return db.ErrNotFound
+		}
+	}
+
+	return err
+}
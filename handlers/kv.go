@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nireo/dkv/db"
+)
+
+// MaxValueSize is the largest value in bytes that can be written through the /kv/ routes.
+const MaxValueSize int64 = 64 << 20
+
+// SetMaxValueSize sets the largest value in bytes that can be written to this server, overriding
+// MaxValueSize.
+func (s *Server) SetMaxValueSize(size int64) {
+	s.maxValueSize = size
+}
+
+// valueLimit returns the largest value in bytes that can be written to this server.
+func (s *Server) valueLimit() int64 {
+	if s.maxValueSize > 0 {
+		return s.maxValueSize
+	}
+	return MaxValueSize
+}
+
+// KV handles the binary-safe routes of the default bucket. The key is the percent-decoded path
+// after /kv/, so it can contain any bytes. The path must not be cleaned before the handler, since
+// the keys can contain "//", "." and ".." segments. PUT /kv/{key} stores the request body as the value
+// together with its Content-Type header, GET /kv/{key} returns the value with the stored content
+// type and DELETE /kv/{key} removes the key. Requests for keys on other shards are forwarded.
+func (s *Server) KV(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/kv/"))
+	if err != nil {
+		http.Error(w, "invalid key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if key == "" {
+		http.Error(w, "the key cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		value, contentType, err := s.db.GetWithType(key)
+		if err == db.ErrNotFound {
+			http.Error(w, "the key was not found", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "error finding key from database: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		if r.Method == http.MethodGet {
+			w.Write(value)
+		}
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.valueLimit()))
+		if err != nil {
+			http.Error(w, "could not read value: "+err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err := s.db.SetWithType(key, value, r.Header.Get("Content-Type")); err != nil {
+			http.Error(w, "error setting value: "+err.Error(), bucketErrorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := s.db.Delete(key); err != nil {
+			http.Error(w, "could not delete key: "+err.Error(), bucketErrorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
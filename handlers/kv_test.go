@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestKVRoutes(t *testing.T) {
+	mux1, mux2 := http.NewServeMux(), http.NewServeMux()
+	ts1, ts2 := httptest.NewServer(mux1), httptest.NewServer(mux2)
+	defer ts1.Close()
+	defer ts2.Close()
+
+	addrs := map[int]string{
+		0: strings.TrimPrefix(ts1.URL, "http://"),
+		1: strings.TrimPrefix(ts2.URL, "http://"),
+	}
+
+	_, web1 := createTestServer(t, 0, addrs)
+	_, web2 := createTestServer(t, 1, addrs)
+	mux1.HandleFunc("/kv/", web1.KV)
+	mux2.HandleFunc("/kv/", web2.KV)
+
+	value := []byte{0, 1, 2, 0xff, 0xfe, '\n'}
+	keys := []string{"plain", "with/slash", "binary\xff\x00key", "space key?"}
+	for _, key := range keys {
+		u := ts1.URL + "/kv/" + url.PathEscape(key)
+
+		req, _ := http.NewRequest(http.MethodPut, u, bytes.NewReader(value))
+		req.Header.Set("Content-Type", "image/png")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("put request failed: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("wrong status for put %q. got=%d", key, resp.StatusCode)
+		}
+
+		resp, err = http.Get(u)
+		if err != nil {
+			t.Fatalf("get request failed: %s", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if !bytes.Equal(body, value) {
+			t.Errorf("wrong value for %q. got=%v", key, body)
+		}
+
+		if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+			t.Errorf("wrong content type for %q. got=%q", key, ct)
+		}
+
+		req, _ = http.NewRequest(http.MethodDelete, u, nil)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("delete request failed: %s", err)
+		}
+		resp.Body.Close()
+
+		resp, err = http.Get(u)
+		if err != nil {
+			t.Fatalf("get request failed: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("deleted key %q was found. status=%d", key, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(ts1.URL+"/kv/plain", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("post request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("wrong status for post. got=%d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+const (
+	// memcacheMaxLine is the maximum length of a command line, which limits the amount of keys in
+	// a single get.
+	memcacheMaxLine = 64 * 1024
+
+	// memcacheMaxKey is the maximum length of a key in the memcached protocol.
+	memcacheMaxKey = 250
+
+	// memcacheMaxRelative is the largest exptime that is relative to the current time. The larger
+	// values are unix timestamps.
+	memcacheMaxRelative = 30 * 24 * 60 * 60
+)
+
+// ServeMemcache serves the memcached text protocol on the listener until it is closed. The keys
+// of other shards are proxied to their owners and the cas tokens are the versions of the values.
+func (s *Server) ServeMemcache(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serveMemcacheConn(conn)
+	}
+}
+
+// memcacheConn is a connection of a memcached client.
+type memcacheConn struct {
+	s   *Server
+	r   *bufio.Reader
+	w   *bufio.Writer
+	ctx context.Context
+
+	// noreply is set when the current command ends with noreply and closed after quit or an error
+	// after which the rest of the input can't be parsed.
+	noreply bool
+	closed  bool
+}
+
+func (s *Server) serveMemcacheConn(conn net.Conn) {
+	defer s.trackConn(conn)()
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &memcacheConn{
+		s:   s,
+		r:   bufio.NewReaderSize(conn, memcacheMaxLine),
+		w:   bufio.NewWriter(conn),
+		ctx: ctx,
+	}
+
+	for !c.closed {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		}
+
+		if err != nil {
+			// the connections are closed by their read deadline when the server shuts down
+			if err != io.EOF && !s.Draining() {
+				log.Printf("memcached connection from %s failed: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		c.run(strings.Fields(string(line)))
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *memcacheConn) run(fields []string) {
+	c.noreply = false
+	if len(fields) == 0 {
+		c.reply("ERROR")
+		return
+	}
+
+	args := fields[1:]
+	switch fields[0] {
+	case "get":
+		c.retrieve(args, false)
+	case "gets":
+		c.retrieve(args, true)
+	case "set", "add", "replace", "cas":
+		c.store(fields[0], args)
+	case "delete":
+		c.delete(args)
+	case "incr", "decr":
+		c.incr(fields[0] == "decr", args)
+	case "version":
+		c.reply("VERSION dkv")
+	case "quit":
+		c.closed = true
+	default:
+		c.reply("ERROR")
+	}
+}
+
+// reply writes a line of response unless the command ended with noreply.
+func (c *memcacheConn) reply(line string) {
+	if c.noreply {
+		return
+	}
+
+	c.w.WriteString(line)
+	c.w.WriteString("\r\n")
+}
+
+// replyErr writes a database error or an error from another shard.
+func (c *memcacheConn) replyErr(err error) {
+	c.reply("SERVER_ERROR " + err.Error())
+}
+
+// parseNoreply removes the noreply argument from the end of the arguments.
+func (c *memcacheConn) parseNoreply(args []string) []string {
+	if len(args) > 0 && args[len(args)-1] == "noreply" {
+		c.noreply = true
+		return args[:len(args)-1]
+	}
+
+	return args
+}
+
+// retrieve writes the values of the keys that exist. The cas tokens are included for gets.
+func (c *memcacheConn) retrieve(keys []string, cas bool) {
+	if len(keys) == 0 {
+		c.reply("ERROR")
+		return
+	}
+
+	for _, key := range keys {
+		if !validMemcacheKey(key) {
+			c.reply("CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+
+	for _, key := range keys {
+		item, err := c.s.getItem(c.ctx, key)
+		if err == db.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			c.replyErr(err)
+			return
+		}
+
+		header := "VALUE " + key + " " + strconv.FormatUint(uint64(item.Flags), 10) + " " + strconv.Itoa(len(item.Value))
+		if cas {
+			header += " " + strconv.FormatUint(item.Version, 10)
+		}
+
+		c.reply(header)
+		c.w.Write(item.Value)
+		c.w.WriteString("\r\n")
+	}
+
+	c.reply("END")
+}
+
+// store handles the storage commands: <command> <key> <flags> <exptime> <bytes> [<cas>] [noreply]
+// followed by the data block.
+func (c *memcacheConn) store(command string, args []string) {
+	args = c.parseNoreply(args)
+
+	want := 4
+	if command == "cas" {
+		want = 5
+	}
+
+	if len(args) != want {
+		c.reply("ERROR")
+		return
+	}
+
+	size, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || size < 0 {
+		// the data block can't be skipped without its size
+		c.reply("CLIENT_ERROR bad command line format")
+		c.closed = true
+		return
+	}
+
+	if size > c.s.valueLimit() {
+		c.reply("SERVER_ERROR object too large for cache")
+		if _, err := io.CopyN(ioutil.Discard, c.r, size+2); err != nil {
+			c.closed = true
+		}
+		return
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.closed = true
+		return
+	}
+
+	if string(data[size:]) != "\r\n" {
+		c.reply("CLIENT_ERROR bad data chunk")
+		c.closed = true
+		return
+	}
+
+	flags, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil || !validMemcacheKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	opts := db.SetOptions{Flags: uint32(flags), TTL: memcacheTTL(exptime)}
+	switch command {
+	case "add":
+		opts.IfNotExists = true
+	case "replace":
+		opts.IfExists = true
+	case "cas":
+		opts.CheckVersion = true
+		if opts.Version, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			c.reply("CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+
+	stored, err := c.s.setItem(c.ctx, args[0], data[:size], opts)
+	switch {
+	case err == db.ErrVersionMismatch:
+		c.reply("EXISTS")
+	case err == db.ErrNotFound:
+		c.reply("NOT_FOUND")
+	case err != nil:
+		c.replyErr(err)
+	case !stored:
+		c.reply("NOT_STORED")
+	default:
+		c.reply("STORED")
+	}
+}
+
+func (c *memcacheConn) delete(args []string) {
+	args = c.parseNoreply(args)
+	if len(args) != 1 || !validMemcacheKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	deleted, err := c.s.deleteItem(c.ctx, args[0])
+	switch {
+	case err != nil:
+		c.replyErr(err)
+	case !deleted:
+		c.reply("NOT_FOUND")
+	default:
+		c.reply("DELETED")
+	}
+}
+
+// incr changes the value as a 64-bit unsigned integer. The increments wrap around and the
+// decrements stop at 0 like in memcached. The value is written with its version, such that the
+// concurrent changes are retried, which keeps the flags and the time to live also on other shards.
+func (c *memcacheConn) incr(decr bool, args []string) {
+	args = c.parseNoreply(args)
+	if len(args) != 2 || !validMemcacheKey(args[0]) {
+		c.reply("ERROR")
+		return
+	}
+
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+
+	for {
+		item, err := c.s.getItem(c.ctx, args[0])
+		if err == db.ErrNotFound {
+			c.reply("NOT_FOUND")
+			return
+		}
+
+		if err != nil {
+			c.replyErr(err)
+			return
+		}
+
+		value, err := strconv.ParseUint(strings.TrimSpace(string(item.Value)), 10, 64)
+		if err != nil {
+			c.reply("CLIENT_ERROR cannot increment or decrement non-numeric value")
+			return
+		}
+
+		switch {
+		case !decr:
+			value += delta
+		case delta > value:
+			value = 0
+		default:
+			value -= delta
+		}
+
+		_, err = c.s.setItem(c.ctx, args[0], []byte(strconv.FormatUint(value, 10)), db.SetOptions{
+			KeepTTL:      true,
+			CheckVersion: true,
+			Version:      item.Version,
+			ContentType:  item.ContentType,
+			Flags:        item.Flags,
+		})
+
+		switch {
+		case err == db.ErrVersionMismatch:
+			continue
+		case err == db.ErrNotFound:
+			c.reply("NOT_FOUND")
+		case err != nil:
+			c.replyErr(err)
+		default:
+			c.reply(strconv.FormatUint(value, 10))
+		}
+		return
+	}
+}
+
+// memcacheTTL converts an exptime into a time to live. The exptimes up to 30 days are relative
+// and the larger ones are unix timestamps. The keys with a negative exptime or a timestamp in the
+// past expire immediately, which gets them a ttl of a nanosecond.
+func memcacheTTL(exptime int64) time.Duration {
+	if exptime == 0 {
+		return 0
+	}
+
+	var ttl time.Duration
+	if exptime > 0 && exptime <= memcacheMaxRelative {
+		ttl = time.Duration(exptime) * time.Second
+	} else if exptime > memcacheMaxRelative {
+		ttl = time.Until(time.Unix(exptime, 0))
+	}
+
+	if ttl <= 0 {
+		return time.Nanosecond
+	}
+
+	return ttl
+}
+
+// validMemcacheKey reports whether the key is valid in the memcached protocol, which doesn't
+// allow control characters in the keys.
+func validMemcacheKey(key string) bool {
+	if len(key) == 0 || len(key) > memcacheMaxKey {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+// memcacheClient is a raw memcached client that sends the command lines and reads the reply lines.
+type memcacheClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startMemcache(t *testing.T) (*memcacheClient, []*db.DB, []*Server) {
+	t.Helper()
+
+	conn, dbs, servers := startListener(t, (*Server).ServeMemcache)
+	c := &memcacheClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	return c, dbs, servers
+}
+
+// do sends the lines and returns the reply lines up to the line that ends the reply.
+func (c *memcacheClient) do(lines ...string) []string {
+	c.t.Helper()
+
+	if _, err := io.WriteString(c.conn, strings.Join(lines, "\r\n")+"\r\n"); err != nil {
+		c.t.Fatalf("could not write command: %s", err)
+	}
+
+	var reply []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("could not read reply to %q: %s", lines[0], err)
+		}
+
+		line = strings.TrimSuffix(line, "\r\n")
+		reply = append(reply, line)
+		if !strings.HasPrefix(line, "VALUE ") && (!strings.HasPrefix(lines[0], "get") || line == "END") {
+			return reply
+		}
+
+		if strings.HasPrefix(line, "VALUE ") {
+			data, err := c.r.ReadString('\n')
+			if err != nil {
+				c.t.Fatalf("could not read value: %s", err)
+			}
+			reply = append(reply, strings.TrimSuffix(data, "\r\n"))
+		}
+	}
+}
+
+func TestMemcacheCommands(t *testing.T) {
+	c, dbs, servers := startMemcache(t)
+
+	for _, shard := range []int{0, 1} {
+		key := keyOnShard(t, servers[0], shard)
+		step := func(want string, lines ...string) {
+			t.Helper()
+			if got := strings.Join(c.do(lines...), "|"); got != want {
+				t.Errorf("wrong reply to %q on shard %d. got=%q want=%q", lines[0], shard, got, want)
+			}
+		}
+
+		step("END", "get "+key)
+		step("NOT_STORED", "replace "+key+" 0 0 1", "x")
+		step("STORED", "add "+key+" 5 0 5", "hello")
+		step("NOT_STORED", "add "+key+" 0 0 1", "x")
+		step("VALUE "+key+" 5 5|hello|END", "get "+key)
+
+		item, err := dbs[shard].GetItem(key)
+		if err != nil || string(item.Value) != "hello" || item.Flags != 5 {
+			t.Fatalf("the key was not stored on shard %d. got=%+v, err=%v", shard, item, err)
+		}
+
+		cas := fmt.Sprint(item.Version)
+		step("VALUE "+key+" 5 5 "+cas+"|hello|END", "gets "+key)
+		step("EXISTS", "cas "+key+" 1 0 5 "+fmt.Sprint(item.Version+1), "world")
+		step("STORED", "cas "+key+" 1 0 5 "+cas, "world")
+		step("EXISTS", "cas "+key+" 1 0 5 "+cas, "again")
+		step("NOT_FOUND", "cas missing-key 0 0 1 1", "x")
+
+		step("STORED", "set "+key+" 3 0 2", "10")
+		step("15", "incr "+key+" 5")
+		step("0", "decr "+key+" 20")
+		step("VALUE "+key+" 3 1|0|END", "get "+key)
+		step("CLIENT_ERROR invalid numeric delta argument", "incr "+key+" x")
+
+		step("STORED", "set "+key+" 0 0 1", "a")
+		step("CLIENT_ERROR cannot increment or decrement non-numeric value", "incr "+key+" 1")
+
+		step("DELETED", "delete "+key)
+		step("NOT_FOUND", "delete "+key)
+		step("NOT_FOUND", "incr "+key+" 1")
+	}
+
+	local, remote := keyOnShard(t, servers[0], 0), keyOnShard(t, servers[0], 1)
+	c.do("set "+local+" 0 0 1 noreply", "1", "set "+remote+" 0 0 1", "2")
+	got := strings.Join(c.do("get "+local+" missing-key "+remote), "|")
+	if want := "VALUE " + local + " 0 1|1|VALUE " + remote + " 0 1|2|END"; got != want {
+		t.Errorf("wrong reply to get with many keys. got=%q want=%q", got, want)
+	}
+
+	if got := c.do("unknown")[0]; got != "ERROR" {
+		t.Errorf("wrong reply to an unknown command. got=%q", got)
+	}
+
+	if got := c.do("version")[0]; !strings.HasPrefix(got, "VERSION") {
+		t.Errorf("wrong reply to version. got=%q", got)
+	}
+}
+
+func TestMemcacheExpiry(t *testing.T) {
+	c, dbs, servers := startMemcache(t)
+
+	for _, shard := range []int{0, 1} {
+		key := keyOnShard(t, servers[0], shard)
+		if got := c.do("set "+key+" 0 100 1", "x")[0]; got != "STORED" {
+			t.Fatalf("could not set key on shard %d. got=%q", shard, got)
+		}
+
+		if ttl, err := dbs[shard].TTL(key); err != nil || ttl <= 99*time.Second || ttl > 100*time.Second {
+			t.Errorf("wrong ttl on shard %d. got=%v, err=%v", shard, ttl, err)
+		}
+
+		// the increments keep the time to live
+		c.do("set "+key+" 0 100 1", "1")
+		c.do("incr " + key + " 1")
+		if ttl, err := dbs[shard].TTL(key); err != nil || ttl <= 0 {
+			t.Errorf("the increment removed the ttl on shard %d. got=%v, err=%v", shard, ttl, err)
+		}
+
+		// a negative exptime expires the key immediately
+		c.do("set "+key+" 0 -1 1", "x")
+		if got := c.do("get " + key)[0]; got != "END" {
+			t.Errorf("the key with a negative exptime was found on shard %d. got=%q", shard, got)
+		}
+	}
+}
+
+func TestMemcacheBadData(t *testing.T) {
+	c, _, _ := startMemcache(t)
+
+	if got := c.do("set key 0 0 1", "toolong")[0]; got != "CLIENT_ERROR bad data chunk" {
+		t.Errorf("wrong reply to a bad data chunk. got=%q", got)
+	}
+
+	// the connection is closed, since the rest of the input can't be parsed
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("the connection was not closed. err=%v", err)
+	}
+}
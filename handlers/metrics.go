@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nireo/dkv/cdc"
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/metrics"
+)
+
+// UsageInterval is how often the key and byte counts of the buckets are recounted for the metrics.
+// Counting goes through all of the keys that are not in a namespace, so it isn't done on every
+// scrape.
+var UsageInterval = time.Minute
+
+// serverMetrics contains the metrics that are updated by the handlers. The other metrics are read
+// from the database when they are scraped.
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	requests  *metrics.Counter
+	latency   *metrics.Histogram
+	forwarded *metrics.Counter
+
+	// the usage of the buckets from the latest count.
+	mu      sync.Mutex
+	usage   map[string]db.Usage
+	counted time.Time
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		registry: metrics.NewRegistry(),
+		requests: metrics.NewCounter("dkv_http_requests_total",
+			"The amount of http requests by handler and status code.", "handler", "code"),
+		latency: metrics.NewHistogram("dkv_http_request_duration_seconds",
+			"The latency of the http requests by handler and status code.", metrics.DefaultBuckets, "handler", "code"),
+		forwarded: metrics.NewCounter("dkv_forwarded_requests_total",
+			"The amount of requests for keys on other shards by target shard and whether they were proxied or redirected.", "shard", "mode"),
+	}
+}
+
+// Metrics writes the metrics of the node in the Prometheus text format.
+func (s *Server) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := s.metrics.registry.Write(w); err != nil {
+		log.Printf("could not write metrics: %s", err)
+	}
+}
+
+// Instrument counts the requests of the handler and measures their latency by status code. The
+// name is the label of the handler in the metrics.
+func (s *Server) Instrument(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		code := strconv.Itoa(rec.status)
+		s.metrics.requests.Inc(name, code)
+		s.metrics.latency.Observe(time.Since(start).Seconds(), name, code)
+	})
+}
+
+// countForward counts a request that was forwarded to another shard. The mode is proxy or
+// redirect.
+func (s *Server) countForward(shard int, mode string) {
+	s.metrics.forwarded.Inc(strconv.Itoa(shard), mode)
+}
+
+// collect writes the metrics that are read from the database and the background workers.
+func (s *Server) collect(w *metrics.Writer) {
+	if backlog, err := s.db.ReplicationBacklog(); err == nil {
+		w.Gauge("dkv_replication_queue_depth", "The amount of changed values that have not been copied to the replicas.", float64(backlog))
+	} else {
+		log.Printf("could not read replication queue: %s", err)
+	}
+
+	if s.replica != nil {
+		w.Gauge("dkv_replica_lag_seconds", "The time since the replica last had all of the changes of the master.", s.replica.Lag().Seconds())
+
+		w.Header("dkv_replica_applied_total", "The amount of values the replica has copied from the master.", "counter")
+		w.Sample("dkv_replica_applied_total", float64(s.replica.Applied()))
+	}
+
+	s.collectUsage(w)
+	s.collectEngine(w)
+	s.collectFeeds(w)
+}
+
+// collectUsage writes the key and byte counts of the buckets, where the default bucket has an
+// empty bucket label.
+func (s *Server) collectUsage(w *metrics.Writer) {
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.usage == nil || time.Since(m.counted) >= UsageInterval {
+		usage, err := s.db.Usage()
+		if err != nil {
+			log.Printf("could not count keys: %s", err)
+			return
+		}
+
+		m.usage, m.counted = usage, time.Now()
+	}
+
+	names := make([]string, 0, len(m.usage))
+	for name := range m.usage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header("dkv_keys", "The amount of keys in a bucket.", "gauge")
+	for _, name := range names {
+		w.Sample("dkv_keys", float64(m.usage[name].Keys), "bucket", name)
+	}
+
+	w.Header("dkv_bytes", "The size of the keys and values in a bucket in bytes.", "gauge")
+	for _, name := range names {
+		w.Sample("dkv_bytes", float64(m.usage[name].Bytes), "bucket", name)
+	}
+}
+
+// collectEngine writes the statistics of the leveldb engine.
+func (s *Server) collectEngine(w *metrics.Writer) {
+	stats, err := s.db.EngineStats()
+	if err != nil {
+		log.Printf("could not read engine stats: %s", err)
+		return
+	}
+
+	if stats == nil {
+		return
+	}
+
+	levels := []struct {
+		name, help, typ string
+		value           func(l int) float64
+	}{
+		{"dkv_leveldb_level_tables", "The amount of tables in a leveldb level.", "gauge",
+			func(l int) float64 { return float64(stats.Levels[l].Tables) }},
+		{"dkv_leveldb_level_size_bytes", "The size of a leveldb level in bytes.", "gauge",
+			func(l int) float64 { return float64(stats.Levels[l].Size) }},
+		{"dkv_leveldb_compaction_seconds_total", "The time spent compacting into a leveldb level.", "counter",
+			func(l int) float64 { return stats.Levels[l].CompactionTime.Seconds() }},
+		{"dkv_leveldb_compaction_read_bytes_total", "The bytes read by the compactions into a leveldb level.", "counter",
+			func(l int) float64 { return float64(stats.Levels[l].CompactionRead) }},
+		{"dkv_leveldb_compaction_write_bytes_total", "The bytes written by the compactions into a leveldb level.", "counter",
+			func(l int) float64 { return float64(stats.Levels[l].CompactionWrite) }},
+	}
+
+	for _, m := range levels {
+		w.Header(m.name, m.help, m.typ)
+		for i, level := range stats.Levels {
+			w.Sample(m.name, m.value(i), "level", strconv.Itoa(level.Level))
+		}
+	}
+
+	w.Header("dkv_leveldb_io_read_bytes_total", "The bytes read by leveldb.", "counter")
+	w.Sample("dkv_leveldb_io_read_bytes_total", float64(stats.IORead))
+	w.Header("dkv_leveldb_io_write_bytes_total", "The bytes written by leveldb.", "counter")
+	w.Sample("dkv_leveldb_io_write_bytes_total", float64(stats.IOWrite))
+	w.Header("dkv_leveldb_write_delays_total", "The amount of writes delayed by the compactions.", "counter")
+	w.Sample("dkv_leveldb_write_delays_total", float64(stats.WriteDelays))
+	w.Header("dkv_leveldb_write_delay_seconds_total", "The time the writes were delayed by the compactions.", "counter")
+	w.Sample("dkv_leveldb_write_delay_seconds_total", stats.WriteDelay.Seconds())
+}
+
+// collectFeeds writes the delivery statistics of the change data capture feeds.
+func (s *Server) collectFeeds(w *metrics.Writer) {
+	if len(s.feeds) == 0 {
+		return
+	}
+
+	stats := make([]cdc.Stats, len(s.feeds))
+	for i, f := range s.feeds {
+		var err error
+		if stats[i], err = f.Stats(); err != nil {
+			log.Printf("could not read the stats of sink %s: %s", f.Name(), err)
+			return
+		}
+	}
+
+	families := []struct {
+		name, help, typ string
+		value           func(st cdc.Stats) uint64
+	}{
+		{"dkv_cdc_offset", "The change log sequence that a sink has received.", "gauge",
+			func(st cdc.Stats) uint64 { return st.Offset }},
+		{"dkv_cdc_lag", "The amount of change log entries that a sink has not received.", "gauge",
+			func(st cdc.Stats) uint64 { return st.Lag() }},
+		{"dkv_cdc_delivered_events_total", "The amount of events delivered to a sink.", "counter",
+			func(st cdc.Stats) uint64 { return st.Delivered }},
+		{"dkv_cdc_failures_total", "The amount of failed deliveries to a sink.", "counter",
+			func(st cdc.Stats) uint64 { return st.Failures }},
+	}
+
+	for _, m := range families {
+		w.Header(m.name, m.help, m.typ)
+		for _, st := range stats {
+			w.Sample(m.name, float64(m.value(st)), "sink", st.Name)
+		}
+	}
+}
+
+// statusRecorder records the status code of a response. It implements http.Flusher, such that the
+// event streams can be instrumented.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
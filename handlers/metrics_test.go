@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// sampleLine matches a sample of the Prometheus text format.
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{([a-zA-Z_][a-zA-Z0-9_]*="([^"\\]|\\.)*",?)*\})? [-+0-9.eEInfNa]+$`)
+
+func TestMetrics(t *testing.T) {
+	servers, _ := startShards(t, 2)
+	srv := servers[0]
+
+	mux := http.NewServeMux()
+	mux.Handle("/kv/", srv.Instrument("/kv/", http.HandlerFunc(srv.KV)))
+	mux.HandleFunc("/metrics", srv.Metrics)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	local := keyOnShard(t, srv, 0)
+	remote := keyOnShard(t, srv, 1)
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/kv/"+local, strings.NewReader("value"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("put request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	for _, key := range []string{local, remote} {
+		resp, err := http.Get(ts.URL + "/kv/" + key)
+		if err != nil {
+			t.Fatalf("get request failed: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("metrics request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("wrong content type: %q", ct)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	types := make(map[string]string)
+	samples := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			types[fields[2]] = fields[3]
+			continue
+		}
+
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+
+		match := sampleLine.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("invalid sample line: %q", line)
+			continue
+		}
+
+		// the samples of a histogram have suffixes after the family name
+		name := match[1]
+		family := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if types[name] == "" && types[family] != "histogram" {
+			t.Errorf("the sample %s doesn't have a type", name)
+		}
+
+		i := strings.LastIndex(line, " ")
+		samples[line[:i]] = line[i+1:]
+	}
+
+	want := map[string]string{
+		`dkv_http_requests_total{handler="/kv/",code="204"}`:                            "1",
+		`dkv_http_requests_total{handler="/kv/",code="200"}`:                            "1",
+		`dkv_http_requests_total{handler="/kv/",code="404"}`:                            "1",
+		`dkv_http_request_duration_seconds_count{handler="/kv/",code="200"}`:            "1",
+		`dkv_http_request_duration_seconds_bucket{handler="/kv/",code="200",le="+Inf"}`: "1",
+		`dkv_forwarded_requests_total{shard="1",mode="proxy"}`:                          "1",
+		`dkv_replication_queue_depth`:                                                   "1",
+		`dkv_keys{bucket=""}`:                                                           "1",
+		`dkv_bytes{bucket=""}`:                                                          "9",
+	}
+
+	for sample, value := range want {
+		if samples[sample] != value {
+			t.Errorf("wrong value for %s. got=%q want=%q", sample, samples[sample], value)
+		}
+	}
+
+	if types["dkv_leveldb_io_write_bytes_total"] != "counter" {
+		t.Errorf("the leveldb stats are missing")
+	}
+}
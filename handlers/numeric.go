@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/nireo/dkv/db"
+)
+
+// IncrBy takes in a key and an optional delta as url parameters and atomically adds the delta
+// into the integer value of the key. The new value is returned in the response.
+func (s *Server) IncrBy(w http.ResponseWriter, r *http.Request) {
+	s.numeric(w, r, s.db.Incr)
+}
+
+// DecrBy takes in a key and an optional delta as url parameters and atomically subtracts the
+// delta from the integer value of the key. The new value is returned in the response.
+func (s *Server) DecrBy(w http.ResponseWriter, r *http.Request) {
+	s.numeric(w, r, s.db.Decr)
+}
+
+// numeric handles the shard routing and parameter parsing for the increment and decrement routes.
+func (s *Server) numeric(w http.ResponseWriter, r *http.Request, op func(string, int64) (int64, error)) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	delta := int64(1)
+	if d := r.Form.Get("delta"); d != "" {
+		var err error
+		if delta, err = strconv.ParseInt(d, 10, 64); err != nil {
+			http.Error(w, "delta is not a valid integer: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	value, err := op(key, delta)
+	if err == db.ErrNotInteger || err == db.ErrOverflow {
+		http.Error(w, "could not update value: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, "could not update value: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte(strconv.FormatInt(value, 10)))
+}
+
+// Append takes in a key-value pair as url parameters and atomically appends the value to the
+// end of the current value. The new value is returned in the response.
+func (s *Server) Append(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	key := r.Form.Get("key")
+
+	shard := s.shards.GetShardIndex(key)
+	if shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return
+	}
+
+	value, err := s.db.Append(key, []byte(r.Form.Get("value")))
+	if err != nil {
+		http.Error(w, "could not append value: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(value)
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/dkv/shards"
+)
+
+// HopHeader counts how many times a request has been forwarded between the shards. A shard that
+// receives a forwarded request for a key that it doesn't own responds with 508 Loop Detected
+// instead of forwarding it again, which happens when the shards disagree about the shard config.
+const HopHeader = "X-Dkv-Hops"
+
+// maxHops is the amount of times a request can be forwarded. A single hop is always enough when
+// the shards have the same config.
+const maxHops = 1
+
+// the timeouts of the requests between the shards.
+const (
+	dialTimeout    = 5 * time.Second
+	forwardTimeout = 30 * time.Second
+)
+
+// UseRedirects makes the server respond with 307 Temporary Redirect and the address of the owning
+// shard in the Location header instead of proxying the requests for keys on other shards.
+func (s *Server) UseRedirects(enabled bool) {
+	s.redirect = enabled
+}
+
+// UseTLS makes the server send the requests to the other shards over https with the given client
+// config. Either every shard of a cluster serves https or none of them does.
+func (s *Server) UseTLS(config *tls.Config) {
+	transport := newTransport()
+	transport.TLSClientConfig = config
+
+	s.scheme = "https"
+	s.client.Transport = transport
+	for index, addr := range s.shards.Addresses {
+		s.proxies[index] = newProxy(s.scheme, addr, transport)
+	}
+}
+
+// shardURL returns the base url of the node at addr.
+func (s *Server) shardURL(addr string) string {
+	return s.scheme + "://" + addr
+}
+
+// forward sends the request for a key on another shard to the shard with the original method,
+// body and headers and copies the response back with its status code.
+func (s *Server) forward(shard int, w http.ResponseWriter, r *http.Request) {
+	if s.staleEpoch(r) {
+		s.misdirected(w, "the shard map of the client is stale")
+		return
+	}
+
+	hops, _ := strconv.Atoi(r.Header.Get(HopHeader))
+	if hops >= maxHops {
+		http.Error(w, fmt.Sprintf("the key belongs to shard %d, but the request was already forwarded", shard),
+			http.StatusLoopDetected)
+		return
+	}
+
+	if s.redirect {
+		s.countForward(shard, "redirect")
+		w.Header().Set("Location", s.shardURL(s.shards.Addresses[shard])+r.URL.RequestURI())
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	proxy, ok := s.proxies[shard]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown shard %d", shard), http.StatusInternalServerError)
+		return
+	}
+
+	// the old routes parse the form before forwarding, which consumes form encoded bodies
+	if r.PostForm != nil && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		body := r.PostForm.Encode()
+		r.Body = http.NoBody
+		if body != "" {
+			r.Body = ioutil.NopCloser(strings.NewReader(body))
+		}
+		r.ContentLength = int64(len(body))
+	}
+
+	s.countForward(shard, "proxy")
+	proxy.ServeHTTP(w, r)
+}
+
+// staleEpoch reports whether the request comes from a client whose shard map is older than the
+// shard map of the server. A client with a newer shard map would only get the older one from the
+// server, so its requests are forwarded with the shard map of the server instead.
+func (s *Server) staleEpoch(r *http.Request) bool {
+	header := r.Header.Get(shards.EpochHeader)
+	if header == "" {
+		return false
+	}
+
+	epoch, err := strconv.ParseUint(header, 10, 64)
+	return err != nil || epoch < s.shards.Epoch
+}
+
+// misdirected tells the client that the request was sent to the wrong shard, such that it can
+// refresh its shard map from /v1/shards.
+func (s *Server) misdirected(w http.ResponseWriter, msg string) {
+	w.Header().Set(shards.EpochHeader, strconv.FormatUint(s.shards.Epoch, 10))
+	http.Error(w, msg, http.StatusMisdirectedRequest)
+}
+
+// newProxy returns a reverse proxy to the shard at addr that increments the hop header.
+func newProxy(scheme, addr string, transport http.RoundTripper) *httputil.ReverseProxy {
+	director := func(req *http.Request) {
+		req.URL.Scheme = scheme
+		req.URL.Host = addr
+		req.Host = addr
+
+		hops, _ := strconv.Atoi(req.Header.Get(HopHeader))
+		req.Header.Set(HopHeader, strconv.Itoa(hops+1))
+	}
+
+	return &httputil.ReverseProxy{
+		Director:  director,
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("could not forward request to %s: %s", addr, err)
+			http.Error(w, "could not reach shard: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+}
+
+// newTransport returns the pooled transport that is shared by the requests between the shards.
+func newTransport() *http.Transport {
+	return &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConns:          256,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: forwardTimeout,
+	}
+}
+
+// remoteError is an error response of another shard.
+type remoteError struct {
+	status int
+	APIError
+}
+
+func (e *remoteError) Error() string {
+	return fmt.Sprintf("shard responded with status %d: %s", e.status, e.Message)
+}
+
+// newRemoteError returns the error of an error response, which is json for the /v1 api.
+func newRemoteError(status int, body []byte) *remoteError {
+	var res errorResponse
+	if err := json.Unmarshal(body, &res); err == nil && res.Error.Code != "" {
+		return &remoteError{status: status, APIError: res.Error}
+	}
+
+	return &remoteError{status: status, APIError: APIError{Message: string(bytes.TrimSpace(body))}}
+}
+
+// forwardKey sends an operation on a single key to the /v1 api of the shard, which is used by
+// the other protocols than HTTP for the keys of other shards. It returns the body and the content
+// type of the response, or a *remoteError if the shard responded with an error.
+func (s *Server) forwardKey(ctx context.Context, shard int, method, path string, body []byte, contentType string) ([]byte, string, error) {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	data, header, err := s.forwardRequest(ctx, shard, method, path, body, header)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType = header.Get("Content-Type")
+	if contentType == "application/octet-stream" {
+		contentType = ""
+	}
+
+	return data, contentType, nil
+}
+
+// forwardRequest sends a request with the headers to the /v1 api of the shard like forwardKey
+// and returns the body and the headers of the response.
+func (s *Server) forwardRequest(ctx context.Context, shard int, method, path string, body []byte, header http.Header) ([]byte, http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.shardURL(s.shards.Addresses[shard])+path, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	// the owner must not forward the request again
+	req.Header.Set(HopHeader, strconv.Itoa(maxHops))
+	s.countForward(shard, "proxy")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not reach shard %d: %s", shard, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read response of shard %d: %s", shard, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, nil, newRemoteError(resp.StatusCode, data)
+	}
+
+	return data, resp.Header, nil
+}
+
+// keyPath returns the path of the key in the /v1 api.
+func keyPath(bucket, key string) string {
+	if bucket != "" {
+		return "/v1/buckets/" + url.PathEscape(bucket) + "/kv/" + url.PathEscape(key)
+	}
+
+	return "/v1/kv/" + url.PathEscape(key)
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nireo/dkv/shards"
+)
+
+// startShards starts a server for every shard and returns the servers with their test servers.
+func startShards(t *testing.T, amount int) ([]*Server, []*httptest.Server) {
+	t.Helper()
+
+	muxes := make([]*http.ServeMux, amount)
+	tss := make([]*httptest.Server, amount)
+	addrs := make(map[int]string, amount)
+	for i := 0; i < amount; i++ {
+		muxes[i] = http.NewServeMux()
+		tss[i] = httptest.NewServer(muxes[i])
+		t.Cleanup(tss[i].Close)
+		addrs[i] = strings.TrimPrefix(tss[i].URL, "http://")
+	}
+
+	servers := make([]*Server, amount)
+	for i := 0; i < amount; i++ {
+		_, servers[i] = createTestServer(t, i, addrs)
+		muxes[i].HandleFunc("/get", servers[i].Get)
+		muxes[i].HandleFunc("/set", servers[i].Set)
+		muxes[i].HandleFunc("/kv/", servers[i].KV)
+		muxes[i].Handle("/v1/", servers[i].V1())
+	}
+
+	return servers, tss
+}
+
+// keyOnShard returns a key that belongs to the given shard.
+func keyOnShard(t *testing.T, s *Server, shard int) string {
+	t.Helper()
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if s.shards.GetShardIndex(key) == shard {
+			return key
+		}
+	}
+
+	t.Fatalf("could not find a key for shard %d", shard)
+	return ""
+}
+
+func TestForwardBody(t *testing.T) {
+	servers, tss := startShards(t, 2)
+	key := keyOnShard(t, servers[0], 1)
+
+	value := []byte("forwarded\x00value")
+	req, _ := http.NewRequest(http.MethodPut, tss[0].URL+"/kv/"+key, bytes.NewReader(value))
+	req.Header.Set("Content-Type", "application/x-test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("put request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("wrong status for forwarded put. got=%d", resp.StatusCode)
+	}
+
+	stored, contentType, err := servers[1].db.GetWithType(key)
+	if err != nil {
+		t.Fatalf("the value was not stored on the owning shard: %s", err)
+	}
+
+	if !bytes.Equal(stored, value) || contentType != "application/x-test" {
+		t.Errorf("wrong value on the owning shard. got=%q %q", stored, contentType)
+	}
+
+	if _, err := servers[0].db.Get(key); err == nil {
+		t.Errorf("the value was stored on the forwarding shard")
+	}
+
+	// the old routes parse the form before forwarding, so the form body is sent again.
+	form := url.Values{"key": {key}, "value": {"from form"}}
+	resp, err = http.PostForm(tss[0].URL+"/set", form)
+	if err != nil {
+		t.Fatalf("set request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status for forwarded set. got=%d", resp.StatusCode)
+	}
+
+	stored, err = servers[1].db.Get(key)
+	if err != nil || string(stored) != "from form" {
+		t.Errorf("the form value was not forwarded. got=%q, err=%v", stored, err)
+	}
+}
+
+func TestForwardStatus(t *testing.T) {
+	servers, tss := startShards(t, 2)
+	key := keyOnShard(t, servers[0], 1)
+
+	resp, err := http.Get(tss[0].URL + "/kv/" + key)
+	if err != nil {
+		t.Fatalf("get request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("the status of the owning shard was not propagated. got=%d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, tss[0].URL+"/kv/"+key, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") == "" {
+		t.Errorf("the response of the owning shard was not propagated. got=%d, allow=%q",
+			resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
+
+func TestForwardLoop(t *testing.T) {
+	servers, tss := startShards(t, 2)
+	key := keyOnShard(t, servers[0], 1)
+
+	// the second shard has the wrong index, so it forwards the keys of shard 1 to itself.
+	servers[1].shards.Index = 0
+
+	resp, err := http.Get(tss[0].URL + "/kv/" + key)
+	if err != nil {
+		t.Fatalf("get request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusLoopDetected {
+		t.Errorf("the forwarding loop was not detected. got=%d", resp.StatusCode)
+	}
+}
+
+func TestForwardUnreachable(t *testing.T) {
+	servers, tss := startShards(t, 2)
+	key := keyOnShard(t, servers[0], 1)
+	tss[1].Close()
+
+	resp, err := http.Get(tss[0].URL + "/kv/" + key)
+	if err != nil {
+		t.Fatalf("get request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("wrong status for an unreachable shard. got=%d", resp.StatusCode)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	servers, tss := startShards(t, 2)
+	key := keyOnShard(t, servers[0], 1)
+	servers[0].UseRedirects(true)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(tss[0].URL + "/kv/" + key)
+	if err != nil {
+		t.Fatalf("get request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("wrong status for redirect. got=%d", resp.StatusCode)
+	}
+
+	if loc := resp.Header.Get("Location"); loc != tss[1].URL+"/kv/"+key {
+		t.Errorf("wrong location. got=%q", loc)
+	}
+
+	// the clients that follow the redirect send the body again to the owning shard.
+	req, _ := http.NewRequest(http.MethodPut, tss[0].URL+"/kv/"+key, bytes.NewReader([]byte("value")))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("put request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("wrong status for redirected put. got=%d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(tss[1].URL + "/kv/" + key)
+	if err != nil {
+		t.Fatalf("get request failed: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "value" {
+		t.Errorf("wrong value after redirect. got=%q", body)
+	}
+}
+
+func TestForwardEpoch(t *testing.T) {
+	servers, tss := startShards(t, 2)
+	for _, s := range servers {
+		s.shards.Epoch = 3
+	}
+	key := keyOnShard(t, servers[0], 1)
+	servers[1].db.Set(key, []byte("value"))
+
+	for _, tc := range []struct {
+		epoch  string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"2", http.StatusMisdirectedRequest},
+		{"3", http.StatusOK},
+		{"4", http.StatusOK},
+		{"invalid", http.StatusMisdirectedRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tss[0].URL+"/kv/"+key, nil)
+		if tc.epoch != "" {
+			req.Header.Set(shards.EpochHeader, tc.epoch)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get request failed: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("epoch %q: wrong status. got=%d want=%d", tc.epoch, resp.StatusCode, tc.status)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/resp"
+)
+
+// redisCommand is a command of the redis front-end. The arity is the smallest amount of arguments
+// including the command name and the arguments are checked before the command is called.
+type redisCommand struct {
+	arity int
+	run   func(c *redisConn, args [][]byte)
+}
+
+var redisCommands map[string]redisCommand
+
+func init() {
+	redisCommands = map[string]redisCommand{
+		"PING":    {1, redisPing},
+		"HELLO":   {1, redisHello},
+		"QUIT":    {1, redisQuit},
+		"SELECT":  {2, redisSelect},
+		"COMMAND": {1, redisCommandInfo},
+		"GET":     {2, redisGet},
+		"SET":     {3, redisSet},
+		"DEL":     {2, redisDel},
+		"EXISTS":  {2, redisExists},
+		"MGET":    {2, redisMGet},
+		"MSET":    {3, redisMSet},
+		"INCR":    {2, redisIncr},
+		"SCAN":    {2, redisScan},
+	}
+}
+
+// errRedisSyntax is returned for the commands with invalid options.
+var errRedisSyntax = errors.New("ERR syntax error")
+
+// ServeRedis serves the redis protocol on the listener until it is closed. The keys of other
+// shards are proxied to their owners, or with redirects enabled the client gets a MOVED error
+// with the redis address of the owner.
+func (s *Server) ServeRedis(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serveRedisConn(conn)
+	}
+}
+
+// redisConn is a connection of a redis client.
+type redisConn struct {
+	s      *Server
+	w      *resp.Writer
+	ctx    context.Context
+	closed bool
+}
+
+func (s *Server) serveRedisConn(conn net.Conn) {
+	defer s.trackConn(conn)()
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the values are checked against the limit of the server by the commands, such that the
+	// client gets an error reply, and the reader only needs to be able to read them.
+	r := resp.NewReader(conn)
+	if limit := s.valueLimit(); limit > r.MaxBulk {
+		r.MaxBulk = limit
+	}
+
+	c := &redisConn{s: s, w: resp.NewWriter(conn), ctx: ctx}
+	for !c.closed {
+		args, err := r.ReadCommand()
+		if err == resp.ErrProtocol {
+			c.w.WriteError("ERR Protocol error")
+			c.w.Flush()
+			return
+		}
+
+		if err != nil {
+			// the connections are closed by their read deadline when the server shuts down
+			if err != io.EOF && !s.Draining() {
+				log.Printf("redis connection from %s failed: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		c.run(args)
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *redisConn) run(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := redisCommands[name]
+	if !ok {
+		c.w.WriteError("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+
+	if len(args) < cmd.arity {
+		c.w.WriteError("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+
+	cmd.run(c, args)
+}
+
+// writeErr writes a database error or an error from another shard in the form of the redis
+// errors.
+func (c *redisConn) writeErr(err error) {
+	var code, msg string
+	if remote, ok := err.(*remoteError); ok {
+		code, msg = remote.Code, remote.Message
+	} else {
+		_, code = dbErrorCode(err)
+		msg = err.Error()
+	}
+
+	switch {
+	case err == errRedisSyntax:
+		c.w.WriteError(err.Error())
+	case code == CodeNotInteger:
+		c.w.WriteError("ERR value is not an integer or out of range")
+	case code == CodeOverflow:
+		c.w.WriteError("ERR increment or decrement would overflow")
+	case code == CodeReadOnly:
+		c.w.WriteError("READONLY You can't write against a read only replica.")
+	default:
+		c.w.WriteError("ERR " + msg)
+	}
+}
+
+// checkValues writes an error if one of the values is larger than the limit of the server.
+func (c *redisConn) checkValues(values ...[]byte) bool {
+	for _, value := range values {
+		if int64(len(value)) > c.s.valueLimit() {
+			c.w.WriteError("ERR the value is larger than the limit of " + strconv.FormatInt(c.s.valueLimit(), 10) + " bytes")
+			return false
+		}
+	}
+
+	return true
+}
+
+// route returns the shard of the key and whether it is handled by this node. If the key belongs
+// to another shard and redirects are enabled, the MOVED error has already been written.
+func (c *redisConn) route(key string) (int, bool) {
+	shard := c.s.shards.GetShardIndex(key)
+	if shard == c.s.shards.Index {
+		return shard, true
+	}
+
+	if addr, ok := c.s.shards.RedisAddresses[shard]; ok && c.s.redirect {
+		c.s.countForward(shard, "redirect")
+		c.w.WriteError("MOVED " + strconv.Itoa(shard) + " " + addr)
+		return shard, false
+	}
+
+	return shard, true
+}
+
+// routeAll checks that the keys of a multi-key command can be handled by this node. With redirects
+// enabled the keys must belong to the same shard.
+func (c *redisConn) routeAll(keys [][]byte) bool {
+	if !c.s.redirect || len(c.s.shards.RedisAddresses) == 0 {
+		return true
+	}
+
+	first := c.s.shards.GetShardIndex(string(keys[0]))
+	for _, key := range keys[1:] {
+		if c.s.shards.GetShardIndex(string(key)) != first {
+			c.w.WriteError("CROSSSLOT Keys in request don't hash to the same shard")
+			return false
+		}
+	}
+
+	_, ok := c.route(string(keys[0]))
+	return ok
+}
+
+func (c *redisConn) local(shard int) bool {
+	return shard == c.s.shards.Index
+}
+
+// get returns the value of the key, which is nil if the key doesn't exist.
+func (c *redisConn) get(key string) ([]byte, error) {
+	item, err := c.s.getItem(c.ctx, key)
+	if err == db.ErrNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return item.Value, nil
+}
+
+func redisPing(c *redisConn, args [][]byte) {
+	if len(args) > 1 {
+		c.w.WriteBulk(args[1])
+		return
+	}
+
+	c.w.WriteSimple("PONG")
+}
+
+// redisHello switches the protocol version and replies with the information of the server.
+func redisHello(c *redisConn, args [][]byte) {
+	if len(args) > 1 {
+		version, err := strconv.Atoi(string(args[1]))
+		if err != nil || (version != 2 && version != 3) {
+			c.w.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		c.w.Protocol = version
+	}
+
+	c.w.WriteMap(3)
+	c.w.WriteBulk([]byte("server"))
+	c.w.WriteBulk([]byte("dkv"))
+	c.w.WriteBulk([]byte("proto"))
+	c.w.WriteInt(int64(c.w.Protocol))
+	c.w.WriteBulk([]byte("mode"))
+	c.w.WriteBulk([]byte("cluster"))
+}
+
+func redisQuit(c *redisConn, args [][]byte) {
+	c.w.WriteSimple("OK")
+	c.closed = true
+}
+
+// redisSelect only accepts the database 0, since there is a single keyspace.
+func redisSelect(c *redisConn, args [][]byte) {
+	if string(args[1]) != "0" {
+		c.w.WriteError("ERR DB index is out of range")
+		return
+	}
+
+	c.w.WriteSimple("OK")
+}
+
+// redisCommandInfo replies with an empty list, which is enough for the clients that ask for the
+// commands when they connect.
+func redisCommandInfo(c *redisConn, args [][]byte) {
+	c.w.WriteArray(0)
+}
+
+func redisGet(c *redisConn, args [][]byte) {
+	if _, ok := c.route(string(args[1])); !ok {
+		return
+	}
+
+	value, err := c.get(string(args[1]))
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+
+	if value == nil {
+		c.w.WriteNull()
+		return
+	}
+
+	c.w.WriteBulk(value)
+}
+
+// redisSet supports the EX, PX, NX and XX options.
+func redisSet(c *redisConn, args [][]byte) {
+	opts, err := parseSetOptions(args[3:])
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+
+	if !c.checkValues(args[2]) {
+		return
+	}
+
+	if _, ok := c.route(string(args[1])); !ok {
+		return
+	}
+
+	set, err := c.s.setItem(c.ctx, string(args[1]), args[2], opts)
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+
+	if !set {
+		c.w.WriteNull()
+		return
+	}
+
+	c.w.WriteSimple("OK")
+}
+
+func parseSetOptions(args [][]byte) (db.SetOptions, error) {
+	var opts db.SetOptions
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			opts.IfNotExists = true
+		case "XX":
+			opts.IfExists = true
+		case "EX", "PX":
+			if i+1 >= len(args) || opts.TTL != 0 {
+				return opts, errRedisSyntax
+			}
+
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				return opts, errors.New("ERR invalid expire time in 'set' command")
+			}
+
+			unit := time.Second
+			if strings.ToUpper(string(args[i])) == "PX" {
+				unit = time.Millisecond
+			}
+			opts.TTL = time.Duration(n) * unit
+			i++
+		default:
+			return opts, errRedisSyntax
+		}
+	}
+
+	if opts.IfNotExists && opts.IfExists {
+		return opts, errRedisSyntax
+	}
+
+	return opts, nil
+}
+
+func redisDel(c *redisConn, args [][]byte) {
+	if !c.routeAll(args[1:]) {
+		return
+	}
+
+	var deleted int64
+	for _, key := range args[1:] {
+		ok, err := c.s.deleteItem(c.ctx, string(key))
+		if err != nil {
+			c.writeErr(err)
+			return
+		}
+
+		if ok {
+			deleted++
+		}
+	}
+
+	c.w.WriteInt(deleted)
+}
+
+func redisExists(c *redisConn, args [][]byte) {
+	if !c.routeAll(args[1:]) {
+		return
+	}
+
+	var count int64
+	for _, key := range args[1:] {
+		ok, err := c.s.keyExists(c.ctx, string(key))
+		if err != nil {
+			c.writeErr(err)
+			return
+		}
+
+		if ok {
+			count++
+		}
+	}
+
+	c.w.WriteInt(count)
+}
+
+func redisMGet(c *redisConn, args [][]byte) {
+	if !c.routeAll(args[1:]) {
+		return
+	}
+
+	values := make([][]byte, len(args)-1)
+	for i, key := range args[1:] {
+		var err error
+		if values[i], err = c.get(string(key)); err != nil {
+			c.writeErr(err)
+			return
+		}
+	}
+
+	c.w.WriteArray(len(values))
+	for _, value := range values {
+		if value == nil {
+			c.w.WriteNull()
+		} else {
+			c.w.WriteBulk(value)
+		}
+	}
+}
+
+func redisMSet(c *redisConn, args [][]byte) {
+	if len(args)%2 != 1 {
+		c.w.WriteError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	keys := make([][]byte, 0, len(args)/2)
+	values := make([][]byte, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+		values = append(values, args[i+1])
+	}
+
+	if !c.checkValues(values...) || !c.routeAll(keys) {
+		return
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		if _, err := c.s.setItem(c.ctx, string(args[i]), args[i+1], db.SetOptions{}); err != nil {
+			c.writeErr(err)
+			return
+		}
+	}
+
+	c.w.WriteSimple("OK")
+}
+
+func redisIncr(c *redisConn, args [][]byte) {
+	key := string(args[1])
+	shard, ok := c.route(key)
+	if !ok {
+		return
+	}
+
+	if c.local(shard) {
+		value, err := c.s.db.Incr(key, 1)
+		if err != nil {
+			c.writeErr(err)
+			return
+		}
+
+		c.w.WriteInt(value)
+		return
+	}
+
+	data, _, err := c.s.forwardKey(c.ctx, shard, http.MethodPost, "/v1/incr/"+url.PathEscape(key), nil, "")
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+
+	var res struct {
+		Value int64 `json:"value"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		c.writeErr(err)
+		return
+	}
+
+	c.w.WriteInt(res.Value)
+}
+
+// errScanDone stops the scan once COUNT keys have been scanned.
+var errScanDone = errors.New("scan done")
+
+// redisScan scans the keys of this node like SCAN in a redis cluster. The cursor is the amount of
+// keys that have been scanned, so keys that are added or removed during the scan can be skipped
+// or returned twice, which is allowed by the SCAN guarantees for changed keys.
+func redisScan(c *redisConn, args [][]byte) {
+	cursor, err := strconv.Atoi(string(args[1]))
+	if err != nil || cursor < 0 {
+		c.w.WriteError("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.writeErr(errRedisSyntax)
+			return
+		}
+
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count <= 0 {
+				c.writeErr(errRedisSyntax)
+				return
+			}
+		default:
+			c.writeErr(errRedisSyntax)
+			return
+		}
+	}
+
+	var keys [][]byte
+	seen, scanned := 0, 0
+	err = c.s.db.Scan(nil, nil, func(key, value []byte) error {
+		seen++
+		if seen <= cursor {
+			return nil
+		}
+
+		scanned++
+		if resp.Match(pattern, string(key)) {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+
+		if scanned >= count {
+			return errScanDone
+		}
+		return nil
+	})
+
+	next := 0
+	if err == errScanDone {
+		next, err = cursor+scanned, nil
+	}
+
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+
+	c.w.WriteArray(2)
+	c.w.WriteBulk([]byte(strconv.Itoa(next)))
+	c.w.WriteArray(len(keys))
+	for _, key := range keys {
+		c.w.WriteBulk(key)
+	}
+}
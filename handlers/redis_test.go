@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+// redisClient is a raw redis client that writes the commands as arrays of bulk strings and reads
+// the replies into strings, integers, nils and slices.
+type redisClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is a redis error reply.
+type redisError string
+
+func (c *redisClient) do(args ...string) interface{} {
+	c.t.Helper()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.t.Fatalf("could not write command: %s", err)
+	}
+
+	reply, err := c.read()
+	if err != nil {
+		c.t.Fatalf("could not read reply to %v: %s", args, err)
+	}
+
+	return reply
+}
+
+func (c *redisClient) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+
+		if line[0] == '%' {
+			n *= 2
+		}
+
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unknown reply %q", line)
+}
+
+// startRedis starts two shards with the /v1 api and serves the redis protocol of the first shard.
+func startRedis(t *testing.T) (*redisClient, []*db.DB, []*Server) {
+	t.Helper()
+
+	conn, dbs, servers := startListener(t, (*Server).ServeRedis)
+	c := &redisClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	return c, dbs, servers
+}
+
+func TestRedisCommands(t *testing.T) {
+	c, dbs, servers := startRedis(t)
+
+	if got := c.do("PING"); got != "PONG" {
+		t.Errorf("wrong reply to PING. got=%v", got)
+	}
+
+	for _, shard := range []int{0, 1} {
+		key := keyOnShard(t, servers[0], shard)
+
+		if got := c.do("GET", key); got != nil {
+			t.Errorf("missing key on shard %d was found. got=%v", shard, got)
+		}
+
+		if got := c.do("SET", key, "value"); got != "OK" {
+			t.Fatalf("could not set key on shard %d. got=%v", shard, got)
+		}
+
+		if value, err := dbs[shard].Get(key); err != nil || string(value) != "value" {
+			t.Errorf("the key was not stored on shard %d. got=%q, err=%v", shard, value, err)
+		}
+
+		if got := c.do("GET", key); got != "value" {
+			t.Errorf("wrong value from shard %d. got=%v", shard, got)
+		}
+
+		if got := c.do("SET", key, "other", "NX"); got != nil {
+			t.Errorf("NX overwrote the key on shard %d. got=%v", shard, got)
+		}
+
+		if got := c.do("EXISTS", key, "missing-key"); got != int64(1) {
+			t.Errorf("wrong amount of existing keys on shard %d. got=%v", shard, got)
+		}
+
+		if got := c.do("DEL", key); got != int64(1) {
+			t.Errorf("wrong amount of deleted keys on shard %d. got=%v", shard, got)
+		}
+
+		if got := c.do("SET", key, "value", "XX"); got != nil {
+			t.Errorf("XX created the key on shard %d. got=%v", shard, got)
+		}
+
+		if got := c.do("INCR", key); got != int64(1) {
+			t.Errorf("wrong value from INCR on shard %d. got=%v", shard, got)
+		}
+
+		if got := c.do("INCR", key); got != int64(2) {
+			t.Errorf("wrong value from INCR on shard %d. got=%v", shard, got)
+		}
+
+		if got := c.do("SET", key, "text"); got != "OK" {
+			t.Fatalf("could not set key on shard %d. got=%v", shard, got)
+		}
+
+		got, ok := c.do("INCR", key).(redisError)
+		if !ok || !strings.HasPrefix(string(got), "ERR value is not an integer") {
+			t.Errorf("wrong error for INCR of text on shard %d. got=%v", shard, got)
+		}
+	}
+
+	local, remote := keyOnShard(t, servers[0], 0), keyOnShard(t, servers[0], 1)
+	if got := c.do("MSET", local, "1", remote, "2"); got != "OK" {
+		t.Fatalf("MSET failed. got=%v", got)
+	}
+
+	got := c.do("MGET", local, "missing-key", remote)
+	if fmt.Sprint(got) != "[1 <nil> 2]" {
+		t.Errorf("wrong values from MGET. got=%v", got)
+	}
+
+	if _, ok := c.do("NOPE").(redisError); !ok {
+		t.Errorf("unknown command did not fail")
+	}
+
+	if _, ok := c.do("GET").(redisError); !ok {
+		t.Errorf("GET without a key did not fail")
+	}
+}
+
+func TestRedisValueLimit(t *testing.T) {
+	d, srv := createTestServer(t, 0, map[int]string{0: "localhost:0"})
+	srv.SetMaxValueSize(8)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	defer l.Close()
+	go srv.ServeRedis(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &redisClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	if got, ok := c.do("SET", "key", "too large value").(redisError); !ok || !strings.Contains(string(got), "limit") {
+		t.Errorf("the oversized SET did not fail. got=%v", got)
+	}
+
+	if got, ok := c.do("MSET", "a", "small", "b", "too large value").(redisError); !ok || !strings.Contains(string(got), "limit") {
+		t.Errorf("the oversized MSET did not fail. got=%v", got)
+	}
+
+	for _, key := range []string{"key", "a", "b"} {
+		if _, err := d.Get(key); err != db.ErrNotFound {
+			t.Errorf("%s was stored over the limit, err: %v", key, err)
+		}
+	}
+
+	// the connection is still usable after the errors
+	if got := c.do("SET", "key", "12345678"); got != "OK" {
+		t.Errorf("a value at the limit was not stored. got=%v", got)
+	}
+}
+
+func TestRedisExpiry(t *testing.T) {
+	c, dbs, servers := startRedis(t)
+
+	for _, shard := range []int{0, 1} {
+		key := keyOnShard(t, servers[0], shard)
+		if got := c.do("SET", key, "value", "PX", "50"); got != "OK" {
+			t.Fatalf("could not set key on shard %d. got=%v", shard, got)
+		}
+
+		ttl, err := dbs[shard].TTL(key)
+		if err != nil || ttl <= 0 || ttl > 50*time.Millisecond {
+			t.Errorf("wrong ttl on shard %d. got=%v, err=%v", shard, ttl, err)
+		}
+	}
+
+	if _, ok := c.do("SET", "k", "v", "EX", "0").(redisError); !ok {
+		t.Errorf("SET with a zero expiry did not fail")
+	}
+
+	if _, ok := c.do("SET", "k", "v", "NX", "XX").(redisError); !ok {
+		t.Errorf("SET with NX and XX did not fail")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	for _, shard := range []int{0, 1} {
+		if got := c.do("GET", keyOnShard(t, servers[0], shard)); got != nil {
+			t.Errorf("expired key was found on shard %d. got=%v", shard, got)
+		}
+	}
+}
+
+func TestRedisMoved(t *testing.T) {
+	c, _, servers := startRedis(t)
+	servers[0].UseRedirects(true)
+	servers[0].shards.RedisAddresses = map[int]string{0: "127.0.0.1:6379", 1: "127.0.0.1:6380"}
+
+	remote := keyOnShard(t, servers[0], 1)
+	if got := c.do("GET", remote); got != redisError("MOVED 1 127.0.0.1:6380") {
+		t.Errorf("wrong reply for a key of another shard. got=%v", got)
+	}
+
+	local := keyOnShard(t, servers[0], 0)
+	if got, ok := c.do("MGET", local, remote).(redisError); !ok || !strings.HasPrefix(string(got), "CROSSSLOT") {
+		t.Errorf("wrong reply for keys of different shards. got=%v", got)
+	}
+
+	if got := c.do("SET", local, "value"); got != "OK" {
+		t.Errorf("could not set local key. got=%v", got)
+	}
+}
+
+func TestRedisScan(t *testing.T) {
+	c, dbs, _ := startRedis(t)
+
+	for i := 0; i < 25; i++ {
+		dbs[0].Set(fmt.Sprintf("user:%02d", i), []byte("x"))
+	}
+	dbs[0].Set("other", []byte("x"))
+	dbs[1].Set("user:remote", []byte("x"))
+
+	found := make(map[string]bool)
+	cursor := "0"
+	for i := 0; ; i++ {
+		if i > 10 {
+			t.Fatalf("the scan did not finish")
+		}
+
+		reply := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "7").([]interface{})
+		for _, key := range reply[1].([]interface{}) {
+			found[key.(string)] = true
+		}
+
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if len(found) != 25 || found["other"] || found["user:remote"] {
+		t.Errorf("wrong keys from scan. got=%v", found)
+	}
+}
+
+func TestRedisHello(t *testing.T) {
+	c, _, _ := startRedis(t)
+
+	reply, ok := c.do("HELLO", "3").([]interface{})
+	if !ok || len(reply) != 6 || reply[3] != int64(3) {
+		t.Fatalf("wrong reply to HELLO. got=%v", reply)
+	}
+
+	// the nulls of RESP3 are read as nil like the null bulk strings of RESP2
+	if _, err := c.conn.Write([]byte("*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n")); err != nil {
+		t.Fatalf("could not write command: %s", err)
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil || line != "_\r\n" {
+		t.Errorf("wrong null in RESP3. got=%q, err=%v", line, err)
+	}
+
+	if _, ok := c.do("HELLO", "4").(redisError); !ok {
+		t.Errorf("unsupported protocol version did not fail")
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// connTracker keeps track of the open connections of the redis and memcached listeners, such that
+// they can be closed when the server shuts down.
+type connTracker struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// trackConn adds a redis or memcached connection to the connections that are closed by
+// CloseConns. The returned function must be called when the connection is closed.
+func (s *Server) trackConn(conn net.Conn) func() {
+	t := &s.conns
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[conn] = struct{}{}
+	t.wg.Add(1)
+
+	// the connection was accepted while the connections were being closed
+	if t.closed {
+		conn.SetReadDeadline(time.Now())
+	}
+
+	return func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		t.wg.Done()
+	}
+}
+
+// Drain makes the readiness check fail, such that the node is taken out of the rotation before it
+// is shut down. It also ends the watches, since the long-polls and the event streams would
+// otherwise keep the http server from shutting down.
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() { close(s.draining) })
+}
+
+// Draining reports whether Drain has been called.
+func (s *Server) Draining() bool {
+	select {
+	case <-s.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseConns closes the redis and memcached connections after the commands that they are running
+// and waits until they are closed or ctx is done. The listeners should be closed before, such that
+// no new connections are accepted.
+func (s *Server) CloseConns(ctx context.Context) error {
+	t := &s.conns
+	t.mu.Lock()
+	t.closed = true
+	for conn := range t.conns {
+		// the reads of the next commands fail, but the current commands can still respond
+		conn.SetReadDeadline(time.Now())
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
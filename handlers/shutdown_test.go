@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloseConns(t *testing.T) {
+	_, s := createTestServer(t, 0, map[int]string{0: "localhost:0"})
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.serveRedisConn(conn)
+
+	r := bufio.NewReader(client)
+	if _, err := client.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		t.Fatalf("could not write command: %s", err)
+	}
+
+	if line, err := r.ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Fatalf("wrong reply. got=%q err=%v", line, err)
+	}
+
+	s.Drain()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := s.CloseConns(ctx); err != nil {
+		t.Fatalf("the idle connection was not closed: %s", err)
+	}
+
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("the connection is still open. err=%v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/export"
+)
+
+// ImportBatchSize is the amount of records that are sent to another shard in a single request.
+const ImportBatchSize = 500
+
+// Export streams the key-value pairs of this shard in the format given by the format url
+// parameter, which is jsonl by default. The bucket parameter selects the bucket instead of the
+// default bucket, prefix limits the keys and after continues an export after the given key.
+func (s *Server) Export(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	format := r.Form.Get("format")
+
+	enc, err := export.NewWriter(w, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prefix, after := []byte(r.Form.Get("prefix")), []byte(r.Form.Get("after"))
+	write := func(key, value []byte) error {
+		return enc.Write(&export.Record{Key: string(key), Value: value})
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	if bucket := r.Form.Get("bucket"); bucket != "" {
+		err = s.db.Bucket(bucket).Scan(prefix, after, write)
+	} else {
+		err = s.db.Scan(prefix, after, write)
+	}
+
+	if err == db.ErrBucketNotFound {
+		http.Error(w, "could not export: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the status has already been sent, so the readers learn about the failure from the trailer
+	if err != nil {
+		log.Printf("export failed: %s", err)
+		enc.Fail(err)
+		return
+	}
+
+	enc.Flush()
+}
+
+// Import reads records in the format given by the format url parameter from the request body and
+// writes them into the default bucket or the bucket given by the bucket parameter. The records
+// that belong to other shards are forwarded to them in batches, unless the local parameter is set.
+// The response contains the amount of imported records as json. The parameters are only read
+// from the url, since the body contains the records.
+func (s *Server) Import(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	bucket, format := query.Get("bucket"), query.Get("format")
+	local := query.Get("local") != ""
+
+	dec, err := export.NewReader(r.Body, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imported := 0
+	pending := make(map[int][]*export.Record)
+	for {
+		rec, err := dec.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not import after %d records: %s", imported, err), http.StatusBadRequest)
+			return
+		}
+
+		shard := s.shards.GetShardIndex(rec.Key)
+		if local || shard == s.shards.Index {
+			if err := s.importRecord(bucket, rec); err != nil {
+				http.Error(w, fmt.Sprintf("could not import after %d records: %s", imported, err), bucketErrorStatus(err))
+				return
+			}
+			imported++
+			continue
+		}
+
+		pending[shard] = append(pending[shard], rec)
+		if len(pending[shard]) >= ImportBatchSize {
+			if err := s.forwardImport(shard, bucket, pending[shard]); err != nil {
+				http.Error(w, fmt.Sprintf("could not import after %d records: %s", imported, err), http.StatusBadGateway)
+				return
+			}
+			imported += len(pending[shard])
+			delete(pending, shard)
+		}
+	}
+
+	for shard, records := range pending {
+		if err := s.forwardImport(shard, bucket, records); err != nil {
+			http.Error(w, fmt.Sprintf("could not import after %d records: %s", imported, err), http.StatusBadGateway)
+			return
+		}
+		imported += len(records)
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"imported": imported})
+}
+
+func (s *Server) importRecord(bucket string, rec *export.Record) error {
+	if bucket == "" {
+		return s.db.Set(rec.Key, rec.Value)
+	}
+
+	return s.db.Bucket(bucket).Set([]byte(rec.Key), rec.Value)
+}
+
+// forwardImport sends the records to the shard that owns them.
+func (s *Server) forwardImport(shard int, bucket string, records []*export.Record) error {
+	return PostImport(s.client, s.shardURL(s.shards.Addresses[shard]), bucket, records)
+}
+
+// PostImport sends the records with the client to the import endpoint of the node at the base url,
+// e.g. https://localhost:8080. The node writes all of the records without checking which shard
+// they belong to.
+func PostImport(client *http.Client, base, bucket string, records []*export.Record) error {
+	var body bytes.Buffer
+	enc, _ := export.NewWriter(&body, export.FormatJSONL)
+	for _, rec := range records {
+		if err := enc.Write(rec); err != nil {
+			return err
+		}
+	}
+	enc.Flush()
+
+	query := url.Values{"bucket": {bucket}, "format": {export.FormatJSONL}, "local": {"1"}}
+	resp, err := client.Post(base+"/import?"+query.Encode(), export.ContentType(export.FormatJSONL), &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("node at %s responded with status %d: %s", base, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nireo/dkv/export"
+)
+
+func TestImportExport(t *testing.T) {
+	mux1, mux2 := http.NewServeMux(), http.NewServeMux()
+	ts1, ts2 := httptest.NewServer(mux1), httptest.NewServer(mux2)
+	defer ts1.Close()
+	defer ts2.Close()
+
+	addrs := map[int]string{
+		0: strings.TrimPrefix(ts1.URL, "http://"),
+		1: strings.TrimPrefix(ts2.URL, "http://"),
+	}
+
+	_, web1 := createTestServer(t, 0, addrs)
+	_, web2 := createTestServer(t, 1, addrs)
+	for _, m := range []struct {
+		mux *http.ServeMux
+		srv *Server
+	}{{mux1, web1}, {mux2, web2}} {
+		m.mux.HandleFunc("/import", m.srv.Import)
+		m.mux.HandleFunc("/export", m.srv.Export)
+	}
+
+	var body strings.Builder
+	enc, _ := export.NewWriter(&body, export.FormatCSV)
+	for i := 0; i < 20; i++ {
+		enc.Write(&export.Record{Key: fmt.Sprintf("key%d", i), Value: []byte{byte(i), 0xff}})
+	}
+	enc.Flush()
+
+	resp, err := http.Post(ts1.URL+"/import?format=csv", "text/csv", strings.NewReader(body.String()))
+	if err != nil {
+		t.Fatalf("import request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status for import. got=%d", resp.StatusCode)
+	}
+
+	// every key should be stored on the shard that owns it
+	exported := 0
+	for i, srv := range []*Server{web1, web2} {
+		for j := 0; j < 20; j++ {
+			key := fmt.Sprintf("key%d", j)
+			_, err := srv.db.Get(key)
+			owner := srv.shards.GetShardIndex(key) == i
+			if owner != (err == nil) {
+				t.Errorf("key %s on shard %d: owner=%v err=%v", key, i, owner, err)
+			}
+		}
+
+		resp, err := http.Get([]string{ts1.URL, ts2.URL}[i] + "/export?prefix=key")
+		if err != nil {
+			t.Fatalf("export request failed: %s", err)
+		}
+
+		dec, _ := export.NewReader(resp.Body, export.FormatJSONL)
+		for {
+			rec, err := dec.Read()
+			if err != nil {
+				break
+			}
+
+			var idx int
+			fmt.Sscanf(rec.Key, "key%d", &idx)
+			if len(rec.Value) != 2 || rec.Value[0] != byte(idx) {
+				t.Errorf("wrong value for %s: %v", rec.Key, rec.Value)
+			}
+			exported++
+		}
+		resp.Body.Close()
+	}
+
+	if exported != 20 {
+		t.Fatalf("wrong amount of exported records. got=%d", exported)
+	}
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/dkv/db"
+)
+
+// the machine-readable error codes of the /v1 api.
+const (
+	CodeNotFound         = "not_found"
+	CodeBucketNotFound   = "bucket_not_found"
+	CodeBucketExists     = "bucket_exists"
+	CodeBucketReserved   = "bucket_reserved"
+	CodeInvalidBucket    = "invalid_bucket_name"
+	CodeTooManyBuckets   = "too_many_buckets"
+	CodeInvalidKey       = "invalid_key"
+	CodeInvalidArgument  = "invalid_argument"
+	CodeReadOnly         = "read_only"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeNotInteger       = "not_integer"
+	CodeOverflow         = "overflow"
+	CodeValueTooLarge    = "value_too_large"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeWrongShard       = "wrong_shard"
+	CodePrecondition     = "precondition_failed"
+	CodeCompacted        = "compacted"
+	CodeInternal         = "internal"
+)
+
+// FlagsHeader carries the flags of a value in the default bucket, see db.Item. The version of the
+// value is in the ETag header.
+const FlagsHeader = "X-Dkv-Flags"
+
+// APIError is the json body of the error responses of the /v1 api.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorResponse wraps the error, such that the body is in the form of {"error": {...}}.
+type errorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// V1 returns the router of the /v1 api. The api follows the http method semantics: reads use GET,
+// writes use PUT, deletions use DELETE and the operations that are not idempotent use POST. The
+// errors are returned as json with a machine-readable code.
+func (s *Server) V1() *httprouter.Router {
+	router := httprouter.New()
+
+	router.GET("/v1/kv/*key", s.v1Get)
+	router.HEAD("/v1/kv/*key", s.v1Get)
+	router.PUT("/v1/kv/*key", s.v1Put)
+	router.DELETE("/v1/kv/*key", s.v1Delete)
+
+	router.POST("/v1/incr/*key", s.v1Incr)
+	router.POST("/v1/append/*key", s.v1Append)
+	router.GET("/v1/watch/*key", s.v1Watch)
+
+	router.GET("/v1/buckets", s.v1ListBuckets)
+	router.PUT("/v1/buckets/:bucket", s.v1CreateBucket)
+	router.DELETE("/v1/buckets/:bucket", s.v1DropBucket)
+	router.GET("/v1/buckets/:bucket/kv/*key", s.v1Get)
+	router.HEAD("/v1/buckets/:bucket/kv/*key", s.v1Get)
+	router.PUT("/v1/buckets/:bucket/kv/*key", s.v1Put)
+	router.DELETE("/v1/buckets/:bucket/kv/*key", s.v1Delete)
+
+	router.GET("/v1/shards", s.v1Shards)
+	router.POST("/v1/batch/get", s.v1BatchGet)
+	router.POST("/v1/batch/set", s.v1BatchSet)
+
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "the route was not found")
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "the method is not allowed")
+	})
+
+	return router
+}
+
+// v1Key returns the key from the catch-all parameter and forwards the request if the key belongs
+// to another shard. It returns false if the request has already been handled.
+func (s *Server) v1Key(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (string, bool) {
+	key := strings.TrimPrefix(ps.ByName("key"), "/")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidKey, db.ErrKeyLength.Error())
+		return "", false
+	}
+
+	if shard := s.shards.GetShardIndex(key); shard != s.shards.Index {
+		s.forward(shard, w, r)
+		return "", false
+	}
+
+	return key, true
+}
+
+func (s *Server) v1Get(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := s.v1Key(w, r, ps)
+	if !ok {
+		return
+	}
+
+	var value []byte
+	var contentType string
+	var err error
+	if bucket := ps.ByName("bucket"); bucket != "" {
+		value, err = s.db.Bucket(bucket).Get([]byte(key))
+	} else {
+		var item *db.Item
+		if item, err = s.db.GetItem(key); err == nil {
+			value, contentType = item.Value, item.ContentType
+			w.Header().Set("ETag", formatETag(item.Version))
+			if item.Flags != 0 {
+				w.Header().Set(FlagsHeader, strconv.FormatUint(uint64(item.Flags), 10))
+			}
+		}
+	}
+
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	if r.Method == http.MethodGet {
+		w.Write(value)
+	}
+}
+
+// v1Put sets the value of the key to the request body. The ttl url parameter sets the time to live
+// of a key in the default bucket as a duration like 1m30s, and nx or xx only set the value if the
+// key doesn't exist or exists. If the value was not set because of nx or xx the response is 412.
+// The If-Match header only sets the value if the key has the version of the ETag, keepttl keeps
+// the time to live of the key and flags sets the flags of the value.
+func (s *Server) v1Put(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := s.v1Key(w, r, ps)
+	if !ok {
+		return
+	}
+
+	value, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.valueLimit()))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeValueTooLarge, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	opts := db.SetOptions{
+		KeepTTL:     query.Get("keepttl") != "",
+		IfNotExists: query.Get("nx") != "",
+		IfExists:    query.Get("xx") != "",
+		ContentType: r.Header.Get("Content-Type"),
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		opts.CheckVersion = true
+		if opts.Version, err = parseETag(match); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "If-Match is not a valid ETag")
+			return
+		}
+	}
+
+	if flags := query.Get("flags"); flags != "" {
+		f, err := strconv.ParseUint(flags, 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "flags is not a valid 32-bit unsigned integer")
+			return
+		}
+		opts.Flags = uint32(f)
+	}
+
+	if ttl := query.Get("ttl"); ttl != "" {
+		if opts.TTL, err = time.ParseDuration(ttl); err != nil || opts.TTL <= 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "ttl is not a valid positive duration")
+			return
+		}
+	}
+
+	set := true
+	if bucket := ps.ByName("bucket"); bucket != "" {
+		if opts != (db.SetOptions{ContentType: opts.ContentType}) {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "the options are only supported in the default bucket")
+			return
+		}
+		err = s.db.Bucket(bucket).Set([]byte(key), value)
+	} else {
+		set, err = s.db.SetWithOptions(key, value, opts)
+	}
+
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	if !set {
+		writeError(w, http.StatusPreconditionFailed, CodePrecondition, "the value was not set because of nx or xx")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) v1Delete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := s.v1Key(w, r, ps)
+	if !ok {
+		return
+	}
+
+	var err error
+	if bucket := ps.ByName("bucket"); bucket != "" {
+		err = s.db.Bucket(bucket).Delete([]byte(key))
+	} else {
+		err = s.db.Delete(key)
+	}
+
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// v1Incr adds the delta url parameter, which is 1 by default, into the integer value of the key
+// and returns the new value as json.
+func (s *Server) v1Incr(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := s.v1Key(w, r, ps)
+	if !ok {
+		return
+	}
+
+	delta := int64(1)
+	if d := r.URL.Query().Get("delta"); d != "" {
+		var err error
+		if delta, err = strconv.ParseInt(d, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "delta is not a valid integer")
+			return
+		}
+	}
+
+	value, err := s.db.Incr(key, delta)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{"value": value})
+}
+
+// v1Append appends the request body to the value of the key and returns the new value.
+func (s *Server) v1Append(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key, ok := s.v1Key(w, r, ps)
+	if !ok {
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.valueLimit()))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeValueTooLarge, err.Error())
+		return
+	}
+
+	value, err := s.db.Append(key, data)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+func (s *Server) v1ListBuckets(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeJSON(w, http.StatusOK, s.db.ListBuckets())
+}
+
+// v1CreateBucket creates the bucket on every shard, see CreateBucket.
+func (s *Server) v1CreateBucket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := s.db.CreateBucket(ps.ByName("bucket")); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	r.ParseForm()
+	if err := s.broadcast(r); err != nil {
+		writeError(w, http.StatusBadGateway, CodeInternal, "could not create bucket on all shards: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// v1DropBucket drops the bucket on every shard, see DropBucket.
+func (s *Server) v1DropBucket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if err := s.db.DropBucket(ps.ByName("bucket")); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	r.ParseForm()
+	if err := s.broadcast(r); err != nil {
+		writeError(w, http.StatusBadGateway, CodeInternal, "could not drop bucket on all shards: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeDBError maps the database errors into statuses and error codes.
+func writeDBError(w http.ResponseWriter, err error) {
+	status, code := dbErrorCode(err)
+	writeError(w, status, code, err.Error())
+}
+
+// dbErrorCode returns the status and the error code of a database error.
+func dbErrorCode(err error) (int, string) {
+	switch err {
+	case db.ErrNotFound:
+		return http.StatusNotFound, CodeNotFound
+	case db.ErrBucketNotFound:
+		return http.StatusNotFound, CodeBucketNotFound
+	case db.ErrBucketExists:
+		return http.StatusConflict, CodeBucketExists
+	case db.ErrBucketReserved:
+		return http.StatusConflict, CodeBucketReserved
+	case db.ErrTooManyBuckets:
+		return http.StatusConflict, CodeTooManyBuckets
+	case db.ErrBucketName, db.ErrInvalidBucketName:
+		return http.StatusBadRequest, CodeInvalidBucket
+	case db.ErrKeyLength:
+		return http.StatusBadRequest, CodeInvalidKey
+	case db.ErrReadOnly:
+		return http.StatusServiceUnavailable, CodeReadOnly
+	case db.ErrQuotaExceeded:
+		return http.StatusTooManyRequests, CodeQuotaExceeded
+	case db.ErrNotInteger:
+		return http.StatusConflict, CodeNotInteger
+	case db.ErrOverflow:
+		return http.StatusConflict, CodeOverflow
+	case db.ErrVersionMismatch:
+		return http.StatusPreconditionFailed, CodePrecondition
+	}
+
+	return http.StatusInternalServerError, CodeInternal
+}
+
+// formatETag returns the ETag of a value version.
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag returns the value version of an ETag.
+func parseETag(etag string) (uint64, error) {
+	return strconv.ParseUint(strings.Trim(etag, `"`), 10, 64)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, &errorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
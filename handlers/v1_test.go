@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/shards"
+)
+
+type v1Case struct {
+	method string
+	path   string
+	body   string
+	status int
+	code   string
+}
+
+func runV1Cases(t *testing.T, url string, cases []v1Case) {
+	t.Helper()
+
+	for _, tc := range cases {
+		req, _ := http.NewRequest(tc.method, url+tc.path, strings.NewReader(tc.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %s", tc.method, tc.path, err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: wrong status. got=%d want=%d body=%s", tc.method, tc.path, resp.StatusCode, tc.status, body)
+			continue
+		}
+
+		if tc.code == "" {
+			continue
+		}
+
+		var res errorResponse
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Errorf("%s %s: the error body is not json: %q", tc.method, tc.path, body)
+			continue
+		}
+
+		if res.Error.Code != tc.code {
+			t.Errorf("%s %s: wrong error code. got=%q want=%q", tc.method, tc.path, res.Error.Code, tc.code)
+		}
+	}
+}
+
+func createV1Server(t *testing.T) (*db.DB, *httptest.Server) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	d, srv := createTestServer(t, 0, map[int]string{0: strings.TrimPrefix(ts.URL, "http://")})
+	mux.Handle("/v1/", srv.V1())
+
+	return d, ts
+}
+
+func TestV1Errors(t *testing.T) {
+	d, ts := createV1Server(t)
+	if err := d.CreateNamespace("limited", db.Quota{MaxKeys: 1}); err != nil {
+		t.Fatalf("could not create namespace, err: %s", err)
+	}
+
+	runV1Cases(t, ts.URL, []v1Case{
+		{"GET", "/v1/kv/missing", "", http.StatusNotFound, CodeNotFound},
+		{"GET", "/v1/kv/", "", http.StatusBadRequest, CodeInvalidKey},
+		{"PUT", "/v1/kv/text", "abc", http.StatusNoContent, ""},
+		{"GET", "/v1/kv/text", "", http.StatusOK, ""},
+		{"POST", "/v1/kv/text", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"POST", "/v1/incr/text", "", http.StatusConflict, CodeNotInteger},
+		{"POST", "/v1/incr/number?delta=abc", "", http.StatusBadRequest, CodeInvalidArgument},
+		{"PUT", "/v1/kv/big", "9223372036854775807", http.StatusNoContent, ""},
+		{"POST", "/v1/incr/big", "", http.StatusConflict, CodeOverflow},
+		{"POST", "/v1/incr/number?delta=2", "", http.StatusOK, ""},
+		{"DELETE", "/v1/kv/text", "", http.StatusNoContent, ""},
+		{"GET", "/v1/kv/text", "", http.StatusNotFound, CodeNotFound},
+		{"GET", "/v1/unknown", "", http.StatusNotFound, CodeNotFound},
+		{"PUT", "/v1/kv/cond?xx=1", "1", http.StatusPreconditionFailed, CodePrecondition},
+		{"PUT", "/v1/kv/cond?nx=1&ttl=1m", "1", http.StatusNoContent, ""},
+		{"PUT", "/v1/kv/cond?nx=1", "2", http.StatusPreconditionFailed, CodePrecondition},
+		{"PUT", "/v1/kv/cond?ttl=-1s", "2", http.StatusBadRequest, CodeInvalidArgument},
+
+		{"GET", "/v1/buckets/missing/kv/key", "", http.StatusNotFound, CodeBucketNotFound},
+		{"PUT", "/v1/buckets/users", "", http.StatusCreated, ""},
+		{"PUT", "/v1/buckets/users", "", http.StatusConflict, CodeBucketExists},
+		{"PUT", "/v1/buckets/bad!name", "", http.StatusBadRequest, CodeInvalidBucket},
+		{"DELETE", "/v1/buckets/de", "", http.StatusConflict, CodeBucketReserved},
+		{"DELETE", "/v1/buckets/missing", "", http.StatusNotFound, CodeBucketNotFound},
+		{"PUT", "/v1/buckets/users/kv/alice", "admin", http.StatusNoContent, ""},
+		{"GET", "/v1/buckets/users/kv/alice", "", http.StatusOK, ""},
+		{"DELETE", "/v1/buckets/users", "", http.StatusNoContent, ""},
+
+		{"PUT", "/v1/buckets/limited/kv/first", "1", http.StatusNoContent, ""},
+		{"PUT", "/v1/buckets/limited/kv/second", "2", http.StatusTooManyRequests, CodeQuotaExceeded},
+	})
+}
+
+func TestV1ValueTooLarge(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	_, srv := createTestServer(t, 0, map[int]string{0: strings.TrimPrefix(ts.URL, "http://")})
+	srv.SetMaxValueSize(4)
+	mux.Handle("/v1/", srv.V1())
+
+	runV1Cases(t, ts.URL, []v1Case{
+		{"PUT", "/v1/kv/key", "12345", http.StatusRequestEntityTooLarge, CodeValueTooLarge},
+		{"POST", "/v1/append/key", "12345", http.StatusRequestEntityTooLarge, CodeValueTooLarge},
+	})
+}
+
+func TestV1ReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "dkvro")
+	if err != nil {
+		t.Fatalf("could not create a temp directory")
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := db.NewDatabase(dir, true)
+	if err != nil {
+		t.Fatalf("could not create new database, err: %s", err)
+	}
+	defer d.Close()
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	srv := NewServer(d, &shards.Shards{
+		Addresses: map[int]string{0: strings.TrimPrefix(ts.URL, "http://")},
+		Amount:    1,
+	})
+	mux.Handle("/v1/", srv.V1())
+
+	runV1Cases(t, ts.URL, []v1Case{
+		{"PUT", "/v1/kv/key", "value", http.StatusServiceUnavailable, CodeReadOnly},
+		{"DELETE", "/v1/kv/key", "", http.StatusServiceUnavailable, CodeReadOnly},
+		{"POST", "/v1/incr/key", "", http.StatusServiceUnavailable, CodeReadOnly},
+		{"PUT", "/v1/buckets/users", "", http.StatusServiceUnavailable, CodeReadOnly},
+	})
+}
+
+func TestV1Versions(t *testing.T) {
+	_, ts := createV1Server(t)
+
+	runV1Cases(t, ts.URL, []v1Case{
+		{"PUT", "/v1/kv/key?flags=12", "1", http.StatusNoContent, ""},
+		{"PUT", "/v1/kv/key?flags=-1", "1", http.StatusBadRequest, CodeInvalidArgument},
+		{"PUT", "/v1/buckets/users/kv/key?flags=1", "1", http.StatusBadRequest, CodeInvalidArgument},
+	})
+
+	resp, err := http.Get(ts.URL + "/v1/kv/key")
+	if err != nil {
+		t.Fatalf("could not get key: %s", err)
+	}
+	resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get(FlagsHeader) != "12" {
+		t.Fatalf("wrong version headers. got=%v", resp.Header)
+	}
+
+	put := func(etag string) int {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/v1/kv/key", strings.NewReader("2"))
+		req.Header.Set("If-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not set key: %s", err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := put(`"1"`); status != http.StatusPreconditionFailed {
+		t.Errorf("wrong status for a different version. got=%d", status)
+	}
+
+	if status := put(etag); status != http.StatusNoContent {
+		t.Errorf("wrong status for the current version. got=%d", status)
+	}
+
+	if status := put(etag); status != http.StatusPreconditionFailed {
+		t.Errorf("the old version was accepted again. got=%d", status)
+	}
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nireo/dkv/db"
+)
+
+const (
+	// watchTimeout is the default time that a long-poll waits for events. It is at most
+	// maxWatchTimeout, which is below the timeout of the forwarded requests.
+	watchTimeout    = 20 * time.Second
+	maxWatchTimeout = 25 * time.Second
+
+	// watchBatch is the maximum amount of events in a long-poll response.
+	watchBatch = 1000
+)
+
+// WatchKeepAlive is how often a comment is sent to the idle event streams, such that the
+// connection isn't closed by proxies.
+var WatchKeepAlive = 15 * time.Second
+
+// watchEvent is the json form of a db.Event.
+type watchEvent struct {
+	Type     db.EventType `json:"type"`
+	Key      string       `json:"key"`
+	Value    []byte       `json:"value,omitempty"`
+	Revision uint64       `json:"revision"`
+}
+
+// watchResponse is the response of a long-poll. The revision is the revision of the last event,
+// or the revision that the watch started from if there were no events, such that the next poll
+// can continue from it.
+type watchResponse struct {
+	Events   []watchEvent `json:"events"`
+	Revision uint64       `json:"revision"`
+}
+
+// v1Watch watches the changes of a key, or of the keys with a prefix if the prefix parameter is
+// set. The watches of a single key are forwarded to the owner, but the prefix watches only see the
+// keys of this shard. The rev parameter, or the Last-Event-ID header of an event stream, resumes
+// the watch after that revision.
+//
+// The response is an event stream if the client accepts text/event-stream and otherwise it is a
+// long-poll that waits for the timeout parameter until there are events.
+func (s *Server) v1Watch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix") != ""
+
+	key := strings.TrimPrefix(ps.ByName("key"), "/")
+	if !prefix {
+		var ok bool
+		if key, ok = s.v1Key(w, r, ps); !ok {
+			return
+		}
+	}
+
+	rev := query.Get("rev")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		rev = id
+	}
+
+	var after uint64
+	if rev != "" {
+		var err error
+		if after, err = strconv.ParseUint(rev, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "rev is not a valid revision")
+			return
+		}
+	} else {
+		// starting from the current revision doesn't lose the events that happen before the
+		// watcher is registered
+		after = s.db.Revision()
+	}
+
+	timeout := watchTimeout
+	if t := query.Get("timeout"); t != "" {
+		var err error
+		if timeout, err = time.ParseDuration(t); err != nil || timeout <= 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidArgument, "timeout is not a valid positive duration")
+			return
+		}
+
+		if timeout > maxWatchTimeout {
+			timeout = maxWatchTimeout
+		}
+	}
+
+	watcher, err := s.db.Watch(key, after)
+	if err == db.ErrCompacted {
+		writeError(w, http.StatusGone, CodeCompacted, err.Error())
+		return
+	}
+
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	defer watcher.Close()
+
+	// the key is watched as a prefix, so the longer keys are filtered out
+	match := func(event db.Event) bool {
+		return prefix || event.Key == key
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamEvents(w, r, watcher, match)
+		return
+	}
+
+	res := watchResponse{Events: []watchEvent{}, Revision: after}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for len(res.Events) == 0 {
+		select {
+		case event, ok := <-watcher.Events():
+			// the watcher fell behind without matching events, so the next poll continues from the
+			// same revision
+			if !ok {
+				writeJSON(w, http.StatusOK, res)
+				return
+			}
+
+			if match(event) {
+				res.Events = append(res.Events, newWatchEvent(event))
+			}
+		case <-timer.C:
+			writeJSON(w, http.StatusOK, res)
+			return
+		case <-s.draining:
+			// the client polls again from another node
+			writeJSON(w, http.StatusOK, res)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	// the events that have already happened are returned in the same response
+drain:
+	for len(res.Events) < watchBatch {
+		select {
+		case event, ok := <-watcher.Events():
+			if !ok {
+				break drain
+			}
+
+			if match(event) {
+				res.Events = append(res.Events, newWatchEvent(event))
+			}
+		default:
+			break drain
+		}
+	}
+
+	res.Revision = res.Events[len(res.Events)-1].Revision
+	writeJSON(w, http.StatusOK, res)
+}
+
+// streamEvents sends the events as server-sent events, where the id of an event is its revision.
+// If the watcher falls behind, an error event is sent and the stream ends, such that the client
+// reconnects with the id of the last event.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, watcher *db.Watcher, match func(db.Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(WatchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events():
+			if !ok {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", watcher.Err())
+				flusher.Flush()
+				return
+			}
+
+			if !match(event) {
+				continue
+			}
+
+			data, _ := json.Marshal(newWatchEvent(event))
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Revision, event.Type, data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-s.draining:
+			// the client reconnects with the id of the last event
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func newWatchEvent(event db.Event) watchEvent {
+	return watchEvent{Type: event.Type, Key: event.Key, Value: event.Value, Revision: event.Revision}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+// poll sends a long-poll to the url and returns the response.
+func poll(t *testing.T, url string) watchResponse {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("could not watch: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status from watch. got=%d", resp.StatusCode)
+	}
+
+	var res watchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("could not decode watch response: %s", err)
+	}
+
+	return res
+}
+
+func TestWatchLongPoll(t *testing.T) {
+	servers, tss := startShards(t, 2)
+
+	for _, shard := range []int{0, 1} {
+		key := keyOnShard(t, servers[0], shard)
+		d := servers[shard].db
+		start := d.Revision()
+
+		done := make(chan watchResponse)
+		go func() {
+			done <- poll(t, tss[0].URL+"/v1/watch/"+key+"?timeout=5s")
+		}()
+
+		// the watch is registered before the write, or it is resumed from the revision
+		time.Sleep(50 * time.Millisecond)
+		d.Set(key+"suffix", []byte("other"))
+		d.Set(key, []byte("1"))
+
+		res := <-done
+		if len(res.Events) != 1 || res.Events[0].Key != key || string(res.Events[0].Value) != "1" || res.Events[0].Type != db.EventPut {
+			t.Fatalf("wrong events from shard %d. got=%+v", shard, res)
+		}
+
+		if res.Revision <= start || res.Revision != res.Events[0].Revision {
+			t.Errorf("wrong revision from shard %d. got=%d, start=%d", shard, res.Revision, start)
+		}
+
+		// the events after a revision are returned immediately
+		d.Delete(key)
+		res = poll(t, tss[0].URL+"/v1/watch/"+key+"?timeout=5s&rev="+strconv.FormatUint(start, 10))
+		if len(res.Events) != 2 || res.Events[1].Type != db.EventDelete {
+			t.Errorf("wrong events after a revision from shard %d. got=%+v", shard, res)
+		}
+	}
+
+	missing := keyOnShard(t, servers[0], 0)
+	res := poll(t, tss[0].URL+"/v1/watch/"+missing+"?timeout=10ms")
+	if len(res.Events) != 0 || res.Revision != servers[0].db.Revision() {
+		t.Errorf("wrong response after timeout. got=%+v", res)
+	}
+
+	runV1Cases(t, tss[0].URL, []v1Case{
+		{"GET", "/v1/watch/key?rev=1", "", http.StatusGone, CodeCompacted},
+		{"GET", "/v1/watch/key?rev=abc", "", http.StatusBadRequest, CodeInvalidArgument},
+		{"GET", "/v1/watch/key?timeout=-1s", "", http.StatusBadRequest, CodeInvalidArgument},
+		{"GET", "/v1/watch/", "", http.StatusBadRequest, CodeInvalidKey},
+	})
+}
+
+func TestWatchPrefix(t *testing.T) {
+	servers, tss := startShards(t, 1)
+	d := servers[0].db
+	start := d.Revision()
+
+	d.Set("config/a", []byte("1"))
+	d.Set("other", []byte("2"))
+	d.Set("config/b", []byte("3"))
+
+	res := poll(t, tss[0].URL+"/v1/watch/config/?prefix=1&rev="+strconv.FormatUint(start, 10))
+	if len(res.Events) != 2 || res.Events[0].Key != "config/a" || res.Events[1].Key != "config/b" {
+		t.Errorf("wrong events for prefix. got=%+v", res)
+	}
+}
+
+func TestWatchEventStream(t *testing.T) {
+	servers, tss := startShards(t, 2)
+	key := keyOnShard(t, servers[0], 1)
+	d := servers[1].db
+
+	d.Set(key, []byte("1"))
+	first := d.Revision()
+	d.Set(key, []byte("2"))
+
+	// the stream is forwarded to the owner and resumed after the first write
+	req, _ := http.NewRequest(http.MethodGet, tss[0].URL+"/v1/watch/"+key, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(first, 10))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not watch: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("wrong content type. got=%q", ct)
+	}
+
+	d.Delete(key)
+
+	r := bufio.NewReader(resp.Body)
+	var events []string
+	for len(events) < 2 {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("could not read event: %s", err)
+			}
+
+			if line == "\n" {
+				break
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+		events = append(events, strings.Join(lines, "|"))
+	}
+
+	if !strings.HasPrefix(events[0], "id: ") || !strings.Contains(events[0], "event: put") ||
+		!strings.Contains(events[0], `"value":"Mg=="`) {
+		t.Errorf("wrong put event. got=%q", events[0])
+	}
+
+	if !strings.Contains(events[1], "event: delete") {
+		t.Errorf("wrong delete event. got=%q", events[1])
+	}
+}
@@ -3,74 +3,83 @@ package main
 import (
 	"flag"
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/nireo/dkv/db"
-	"github.com/nireo/dkv/handlers"
-	"github.com/nireo/dkv/replica"
-	"github.com/nireo/dkv/shards"
+	"github.com/nireo/dkv/server"
 )
 
-// define command-line flags
-var (
-	dbPath      = flag.String("db", "", "path to the database")
-	address     = flag.String("addr", "localhost:8080", "address where the server will be hosted")
-	configFile  = flag.String("conf", "conf.json", "shards file for shards")
-	shardName   = flag.String("shards", "", "the shards used for the data")
-	ronly       = flag.Bool("ronly", false, "set the database into read-only mode")
-	replication = flag.Bool("replica", false, "run as read-only replica server")
-)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
 
-// parse command-line flags
-func parse() {
-	flag.Parse()
-	if *dbPath == "" {
-		log.Fatal("database field cannot be empty")
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		restore(os.Args[2:])
+		return
 	}
-	if *shardName == "" {
-		log.Fatal("shards field cannot be empty")
+
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		recoverDB(os.Args[2:])
+		return
 	}
-}
 
-func main() {
-	parse()
-	// read the shard config from the conf.json file
-	conf, err := shards.ParseConfigFile("./conf.json")
-	if err != nil {
-		log.Fatalf("could not parse shards file, err: %s", err)
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		exportData(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importData(os.Args[2:])
+		return
 	}
 
-	// parse the shards
-	shardsList, err := conf.ParseConfigShards(*shardName)
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		configCommand(os.Args[2:])
+		return
+	}
+
+	conf, err := loadConfig(flag.CommandLine, os.Args[1:])
 	if err != nil {
-		log.Fatalf("error parsing shards, err: %s", err)
+		log.Fatal(err)
 	}
 
-	// create a new db instance based on the command-line flags
-	db, err := db.NewDatabase(*dbPath, *ronly)
+	nodeConf, err := conf.Node()
 	if err != nil {
-		log.Fatalf("error opening db: %s, err: %s", *dbPath, err)
+		log.Fatal(err)
 	}
-	defer db.Close()
 
-	log.Printf("starting shards: %d at %s", shardsList.Amount, shardsList.Addresses[shardsList.Index])
+	log.Printf("starting shards: %d at %s", nodeConf.Shards.Amount, nodeConf.Shards.Addresses[nodeConf.Shards.Index])
+
+	node, err := server.New(nodeConf)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if *replication {
-		master, ok := shardsList.Addresses[shardsList.Index]
-		if !ok {
-			log.Fatalf("could not find master address: %s", err)
-		}
-		go replica.Loop(db, master)
+	if err := node.Start(); err != nil {
+		log.Fatal(err)
 	}
 
-	srv := handlers.NewServer(db, shardsList)
+	// the node is stopped gracefully on SIGINT and SIGTERM, and the exit code is 0 only if
+	// everything was stopped cleanly
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	code := 0
+	select {
+	case sig := <-signals:
+		log.Printf("received %s, shutting down", sig)
+	case err := <-node.Err():
+		log.Printf("shutting down: %s", err)
+		code = 1
+	}
 
-	http.HandleFunc("/get", srv.Get)
-	http.HandleFunc("/set", srv.Set)
-	http.HandleFunc("/del", srv.Delete)
-	http.HandleFunc("/purge", srv.DeleteNotBelonging)
-	http.HandleFunc("/del-rep", srv.DeleteReplicationKey)
-	http.HandleFunc("/next", srv.GetNextReplicationKey)
+	if err := node.Stop(); err != nil {
+		log.Print(err)
+		code = 1
+	}
 
-	log.Fatal(http.ListenAndServe(*address, nil))
+	os.Exit(code)
 }
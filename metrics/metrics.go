@@ -0,0 +1,302 @@
+// Package metrics is a small implementation of counters and histograms that are exposed in the
+// Prometheus text format, such that the server doesn't need the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the histogram buckets for request latencies in seconds.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector writes metric families into a Writer.
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// CollectorFunc is a function that is used as a Collector, which suits the metrics that are read
+// when they are scraped.
+type CollectorFunc func(w *Writer)
+
+// Collect calls f.
+func (f CollectorFunc) Collect(w *Writer) {
+	f(w)
+}
+
+// Registry contains the collectors that are written on a scrape in the order they were registered.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the collectors to the registry.
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Write writes the metrics of all of the collectors into out in the text format.
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	w := NewWriter(out)
+	for _, c := range collectors {
+		c.Collect(w)
+	}
+
+	return w.Flush()
+}
+
+// Writer writes metric families in the Prometheus text format. The samples of a family must be
+// written right after its header.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a writer that writes into w. Flush must be called after the metrics have been
+// written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Header writes the help text and the type of a metric family. The type is counter, gauge or
+// histogram.
+func (w *Writer) Header(name, help, typ string) {
+	w.w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes a single sample. The label names and values are given in pairs.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// Gauge writes a gauge family with a single sample without labels.
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.Header(name, help, "gauge")
+	w.Sample(name, value)
+}
+
+// Flush writes the buffered metrics into the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Counter is a family of counters that are separated by the values of their labels.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// NewCounter returns a counter family with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+}
+
+// Inc increments the counter with the label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with the label values. The amount of values must match the labels.
+func (c *Counter) Add(v float64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic("metrics: wrong amount of label values for " + c.name)
+	}
+
+	key := seriesKey(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: append([]string(nil), values...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Value returns the value of the counter with the label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.series[seriesKey(values)]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+// Collect writes the counters sorted by their label values.
+func (c *Counter) Collect(w *Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.Header(c.name, c.help, "counter")
+	for _, key := range keys {
+		s := c.series[key]
+		w.Sample(c.name, s.value, pairs(c.labels, s.values)...)
+	}
+}
+
+// Histogram is a family of histograms that are separated by the values of their labels.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram returns a histogram family with the given upper bounds of the buckets, which must
+// be sorted, and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// Observe adds the value into the histogram with the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic("metrics: wrong amount of label values for " + h.name)
+	}
+
+	key := seriesKey(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	// the counts are per bucket and they are summed into cumulative counts when collected
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Collect writes the cumulative buckets, the sum and the count of the histograms sorted by their
+// label values.
+func (h *Histogram) Collect(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.Header(h.name, h.help, "histogram")
+	for _, key := range keys {
+		s := h.series[key]
+		labels := pairs(h.labels, s.values)
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			w.Sample(h.name+"_bucket", float64(cumulative), append(labels, "le", formatFloat(bound))...)
+		}
+		w.Sample(h.name+"_bucket", float64(s.count), append(labels, "le", "+Inf")...)
+		w.Sample(h.name+"_sum", s.sum, labels...)
+		w.Sample(h.name+"_count", float64(s.count), labels...)
+	}
+}
+
+// seriesKey joins the label values into a map key.
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// pairs returns the label names and values in pairs. The result has no extra capacity, so
+// appending to it doesn't modify other results.
+func pairs(names, values []string) []string {
+	labels := make([]string, 0, 2*len(names))
+	for i, name := range names {
+		labels = append(labels, name, values[i])
+	}
+
+	return labels[:len(labels):len(labels)]
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
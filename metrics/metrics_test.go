@@ -0,0 +1,72 @@
+package metrics_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/nireo/dkv/metrics"
+)
+
+func TestTextFormat(t *testing.T) {
+	requests := metrics.NewCounter("requests_total", "The amount of requests.", "handler", "code")
+	requests.Inc("/get", "200")
+	requests.Inc("/get", "200")
+	requests.Add(3, "/set", "500")
+	requests.Inc(`/a"b\c`, "200")
+
+	latency := metrics.NewHistogram("latency_seconds", "The latency\nof requests.", []float64{0.1, 1}, "handler")
+	latency.Observe(0.05, "/get")
+	latency.Observe(0.1, "/get")
+	latency.Observe(0.5, "/get")
+	latency.Observe(2, "/get")
+
+	registry := metrics.NewRegistry()
+	registry.Register(requests, latency, metrics.CollectorFunc(func(w *metrics.Writer) {
+		w.Gauge("up", "Whether the node is up.", 1)
+		w.Gauge("lag_seconds", "The lag.", math.Inf(1))
+	}))
+
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("could not write metrics: %s", err)
+	}
+
+	want := `# HELP requests_total The amount of requests.
+# TYPE requests_total counter
+requests_total{handler="/a\"b\\c",code="200"} 1
+requests_total{handler="/get",code="200"} 2
+requests_total{handler="/set",code="500"} 3
+# HELP latency_seconds The latency\nof requests.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{handler="/get",le="0.1"} 2
+latency_seconds_bucket{handler="/get",le="1"} 3
+latency_seconds_bucket{handler="/get",le="+Inf"} 4
+latency_seconds_sum{handler="/get"} 2.65
+latency_seconds_count{handler="/get"} 4
+# HELP up Whether the node is up.
+# TYPE up gauge
+up 1
+# HELP lag_seconds The lag.
+# TYPE lag_seconds gauge
+lag_seconds +Inf
+`
+
+	if buf.String() != want {
+		t.Errorf("wrong output.\ngot:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	if v := requests.Value("/get", "200"); v != 2 {
+		t.Errorf("wrong counter value. got=%v want=2", v)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("a wrong amount of label values didn't panic")
+		}
+	}()
+
+	metrics.NewCounter("requests_total", "", "handler").Inc()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/nireo/dkv/db"
+)
+
+// migrate rewrites a database that uses the old key encoding into the current one. The migrated
+// database is written next to the old one and the directories are swapped once it is done, such
+// that the old data is kept in <db>.old until it's removed by hand.
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	path := fs.String("db", "", "path to the database that is migrated")
+	fs.Parse(args)
+
+	if *path == "" {
+		log.Fatal("database field cannot be empty")
+	}
+
+	tmp := *path + ".migrating"
+	count, err := db.Migrate(*path, tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		log.Fatalf("could not migrate database: %s", err)
+	}
+
+	if err := os.Rename(*path, *path+".old"); err != nil {
+		log.Fatalf("could not move old database: %s", err)
+	}
+
+	if err := os.Rename(tmp, *path); err != nil {
+		log.Fatalf("could not move migrated database: %s", err)
+	}
+
+	log.Printf("migrated %d keys, the old database is in %s.old", count, *path)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nireo/dkv/db"
+)
+
+// recoverDB recovers a database to a point in time from a set of full and incremental backups.
+// The backups can be given in any order, the latest full backup before the recovery point is
+// chosen and the incremental backups that continue from it are replayed up to the point.
+func recoverDB(args []string) {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	path := fs.String("db", "", "path of the database that is created")
+	engine := fs.String("engine", db.EngineLevelDB, "the storage engine: leveldb or bolt")
+	until := fs.String("until", "", "the recovery point as a change log sequence or an RFC 3339 timestamp")
+	dryRun := fs.Bool("dry-run", false, "only report what would be restored")
+	fs.Usage = func() {
+		log.Printf("usage: dkv recover -db=<path> -until=<timestamp|sequence> <backups...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if (*path == "" && !*dryRun) || *until == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *engine == db.EngineMemory {
+		log.Fatal("backups can only be restored into a leveldb or bolt database")
+	}
+
+	target, err := parseRecoveryTarget(*until)
+	if err != nil {
+		log.Fatalf("invalid recovery point: %s", err)
+	}
+
+	archives := fs.Args()
+	infos := make([]*db.BackupInfo, len(archives))
+	for i, archive := range archives {
+		if infos[i], err = readBackupInfo(archive); err != nil {
+			log.Fatalf("could not read backup %s: %s", archive, err)
+		}
+	}
+
+	chain, err := db.RecoveryChain(infos, target)
+	if err != nil {
+		log.Fatalf("could not recover: %s", err)
+	}
+
+	chosen := make([]string, len(chain))
+	for i, index := range chain {
+		chosen[i] = archives[index]
+	}
+
+	if *dryRun {
+		dryRunRecovery(chosen, target)
+		return
+	}
+
+	restoreInto(*engine, *path, chosen, target)
+}
+
+// dryRunRecovery verifies the chosen backups and prints what would be restored from them.
+func dryRunRecovery(archives []string, target db.RecoveryTarget) {
+	var seq uint64
+	var at time.Time
+	reached := false
+	for _, archive := range archives {
+		f, err := os.Open(archive)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		res, err := db.ScanBackup(f, target)
+		f.Close()
+		if err != nil {
+			log.Fatalf("invalid backup %s: %s", archive, err)
+		}
+
+		if res.Info.Full {
+			fmt.Printf("%s: full backup at sequence %d, %d records\n", archive, res.Info.To, res.Writes)
+		} else {
+			fmt.Printf("%s: sequences %d-%d, applying %d entries with %d writes\n",
+				archive, res.Info.From+1, res.Info.To, res.Entries, res.Writes)
+		}
+
+		if res.Info.Full || res.Entries > 0 {
+			seq, at = res.Seq, res.Time
+		}
+		reached = res.Reached
+	}
+
+	fmt.Printf("the database would be recovered to sequence %d at %s\n", seq, at.Format(time.RFC3339Nano))
+	if !reached && !target.Time.IsZero() {
+		fmt.Println("the backups end before the recovery point, the later writes are not included")
+	}
+}
+
+// parseRecoveryTarget parses the recovery point as a sequence or as a timestamp.
+func parseRecoveryTarget(until string) (db.RecoveryTarget, error) {
+	if seq, err := strconv.ParseUint(until, 10, 64); err == nil {
+		return db.RecoveryTarget{Seq: seq}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, until)
+	if err != nil {
+		return db.RecoveryTarget{}, err
+	}
+
+	return db.RecoveryTarget{Time: t}, nil
+}
+
+func readBackupInfo(archive string) (*db.BackupInfo, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return db.ReadBackupInfo(f)
+}
@@ -1,12 +1,17 @@
 package replica
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nireo/dkv/db"
 )
@@ -15,34 +20,163 @@ import (
 type Next struct {
 	Key   string
 	Value string
+
+	// KeyEncoding is EncodingBase64 when the key is not valid UTF-8 and it has been encoded in
+	// base64 like the value.
+	KeyEncoding string `json:",omitempty"`
+
+	// Bucket is set for CRDT values and it tells which merge function to use.
+	Bucket string `json:",omitempty"`
+
+	// ContentType is the stored content type of the value.
+	ContentType string `json:",omitempty"`
+
+	// Flags are the flags of the value and Deadline is the unix nano time when the key expires,
+	// which is zero if the key doesn't expire.
+	Flags    uint32 `json:",omitempty"`
+	Deadline int64  `json:",omitempty"`
+
+	// Encoding is EncodingBase64 when the value is not valid UTF-8 and it has been encoded in
+	// base64, since json strings cannot hold arbitrary bytes.
+	Encoding string `json:",omitempty"`
 }
 
-type replicationQueue struct {
+// EncodingBase64 is the encoding of binary keys and values in Next.
+const EncodingBase64 = "base64"
+
+// NewNext returns the replication response of the key-value pair and encodes binary keys and
+// values.
+func NewNext(key, value []byte, bucket, contentType string) *Next {
+	next := &Next{Key: string(key), Value: string(value), Bucket: bucket, ContentType: contentType}
+	if !utf8.Valid(key) {
+		next.Key = base64.StdEncoding.EncodeToString(key)
+		next.KeyEncoding = EncodingBase64
+	}
+
+	if !utf8.Valid(value) {
+		next.Value = base64.StdEncoding.EncodeToString(value)
+		next.Encoding = EncodingBase64
+	}
+
+	return next
+}
+
+// RawKey returns the decoded key.
+func (n *Next) RawKey() ([]byte, error) {
+	if n.KeyEncoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(n.Key)
+	}
+
+	return []byte(n.Key), nil
+}
+
+// RawValue returns the decoded value.
+func (n *Next) RawValue() ([]byte, error) {
+	if n.Encoding == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(n.Value)
+	}
+
+	return []byte(n.Value), nil
+}
+
+// item returns the item of the value with the metadata of the response.
+func (n *Next) item(value []byte) *db.Item {
+	item := &db.Item{Value: value, ContentType: n.ContentType, Flags: n.Flags}
+	if n.Deadline != 0 {
+		item.Deadline = time.Unix(0, n.Deadline)
+	}
+
+	return item
+}
+
+// Replica copies the changes from the replication queue of the master into the local database.
+type Replica struct {
 	db         *db.DB
 	masterAddr string
+	scheme     string
+	client     *http.Client
+
+	// caughtUp is the unix nano time when the queue of the master was last seen empty, and
+	// applied is the amount of values that have been copied.
+	caughtUp int64
+	applied  uint64
+}
+
+// New returns a replica of the master at masterAddr. It doesn't copy anything before Run is called.
+func New(db *db.DB, masterAddr string) *Replica {
+	return &Replica{db: db, masterAddr: masterAddr, scheme: "http", client: http.DefaultClient,
+		caughtUp: time.Now().UnixNano()}
+}
+
+// UseTLS makes the replica connect to the master over https with the given client config.
+func (r *Replica) UseTLS(config *tls.Config) {
+	r.scheme = "https"
+	r.client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
 }
 
 // Loop retrieves new keys from the master and adds them
 func Loop(db *db.DB, masterAddr string) {
-	c := &replicationQueue{db: db, masterAddr: masterAddr}
+	New(db, masterAddr).Run(nil)
+}
+
+// Run copies the changes from the master until stop is closed. The requests to the master are
+// cancelled when stop is closed, but a value that has been applied locally is removed from the
+// queue of the master again on the next run.
+func (r *Replica) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	for {
-		curr, err := c.loop()
+		wait := time.Duration(0)
+
+		curr, err := r.loop(ctx)
 		if err != nil {
-			log.Printf("Loop error: %v", err)
-			time.Sleep(time.Second)
-			continue
+			if ctx.Err() == nil {
+				log.Printf("Loop error: %v", err)
+			}
+			wait = time.Second
+		} else if !curr {
+			atomic.StoreInt64(&r.caughtUp, time.Now().UnixNano())
+			wait = time.Millisecond * 100
+		} else {
+			atomic.AddUint64(&r.applied, 1)
 		}
 
-		if !curr {
-			time.Sleep(time.Millisecond * 100)
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
 		}
 	}
 }
 
+// Lag returns the time since the replica last had all of the changes of the master. It grows while
+// the replica is copying a backlog or can't reach the master.
+func (r *Replica) Lag() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&r.caughtUp)))
+}
+
+// Applied returns the amount of values that the replica has copied from the master.
+func (r *Replica) Applied() uint64 {
+	return atomic.LoadUint64(&r.applied)
+}
+
 // loops over the replication keys and adds to the replication bucket while
 // deleting the keys from the replication queue.
-func (r *replicationQueue) loop() (curr bool, err error) {
-	resp, err := http.Get("http://" + r.masterAddr + "/next")
+func (r *Replica) loop(ctx context.Context) (curr bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.scheme+"://"+r.masterAddr+"/next", nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -57,11 +191,26 @@ func (r *replicationQueue) loop() (curr bool, err error) {
 		return false, nil
 	}
 
-	if err := r.db.SetOnReplica(res.Key, []byte(res.Value)); err != nil {
+	key, err := res.RawKey()
+	if err != nil {
+		return false, err
+	}
+
+	value, err := res.RawValue()
+	if err != nil {
+		return false, err
+	}
+
+	if res.Bucket != "" {
+		err = r.db.MergeOnReplica(res.Bucket, string(key), value)
+	} else {
+		err = r.db.SetOnReplicaItem(string(key), res.item(value))
+	}
+	if err != nil {
 		return false, err
 	}
 
-	if err := r.deleteFromQueue(res.Key, res.Value); err != nil {
+	if err := r.deleteFromQueue(ctx, res.Bucket, key, value); err != nil {
 		log.Printf("could not delete from queue")
 	}
 
@@ -70,13 +219,21 @@ func (r *replicationQueue) loop() (curr bool, err error) {
 
 // deleteFromReplicationQueue takes in a key-value pair and removes it from the queue
 // we need the value to be correct such that the replication value is not stale.
-func (r *replicationQueue) deleteFromQueue(key, value string) error {
+func (r *Replica) deleteFromQueue(ctx context.Context, bucket string, key, value []byte) error {
 	u := url.Values{}
-	u.Set("key", key)
-	u.Set("value", value)
+	u.Set("key", string(key))
+	u.Set("value", string(value))
+	if bucket != "" {
+		u.Set("bucket", bucket)
+	}
 	log.Printf("deleting %q", key)
 
-	resp, err := http.Get("http://" + r.masterAddr + "/del-rep?" + u.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.scheme+"://"+r.masterAddr+"/del-rep?"+u.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,195 @@
+// Package resp implements the parts of the redis serialization protocol that the redis front-end
+// of dkv needs. The commands are read as arrays of bulk strings or as inline commands and the
+// replies are written in RESP2 or RESP3.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxBulkLength is the default limit of the bulk strings that are accepted in a command, since the
+// arguments are read into memory before the command is run.
+const MaxBulkLength = 64 << 20
+
+// MaxArgs is the largest amount of arguments that is accepted in a command.
+const MaxArgs = 1 << 20
+
+// ErrProtocol happens when the client sends something that is not valid RESP.
+var ErrProtocol = errors.New("protocol error")
+
+// Reader reads commands from a client.
+type Reader struct {
+	r *bufio.Reader
+
+	// MaxBulk is the largest bulk string that is accepted, which is MaxBulkLength by default.
+	MaxBulk int64
+}
+
+// NewReader returns a reader that reads commands from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), MaxBulk: MaxBulkLength}
+}
+
+// ReadCommand reads the next command. A command is an array of bulk strings or an inline command,
+// which is a line of arguments separated by spaces. Empty inline commands are skipped.
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] != '*' {
+			fields := strings.Fields(string(line))
+			if len(fields) == 0 {
+				continue
+			}
+
+			args := make([][]byte, len(fields))
+			for i, f := range fields {
+				args[i] = []byte(f)
+			}
+			return args, nil
+		}
+
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > MaxArgs {
+			return nil, ErrProtocol
+		}
+
+		if n <= 0 {
+			continue
+		}
+
+		args := make([][]byte, n)
+		for i := range args {
+			if args[i], err = r.readBulk(); err != nil {
+				return nil, err
+			}
+		}
+
+		return args, nil
+	}
+}
+
+func (r *Reader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '$' {
+		return nil, ErrProtocol
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || int64(n) > r.MaxBulk {
+		return nil, ErrProtocol
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, ErrProtocol
+	}
+
+	return buf[:n], nil
+}
+
+// readLine reads a line without the line ending.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, ErrProtocol
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	return line, nil
+}
+
+// Writer writes replies to a client. The replies are buffered until Flush is called.
+type Writer struct {
+	w *bufio.Writer
+
+	// Protocol is 2 or 3. It only changes how nulls and maps are written.
+	Protocol int
+}
+
+// NewWriter returns a writer that writes RESP2 replies into w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w), Protocol: 2}
+}
+
+// WriteSimple writes a simple string, which cannot contain line breaks.
+func (w *Writer) WriteSimple(s string) {
+	fmt.Fprintf(w.w, "+%s\r\n", s)
+}
+
+// WriteError writes an error. The message should start with an error code like ERR.
+func (w *Writer) WriteError(msg string) {
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	fmt.Fprintf(w.w, "-%s\r\n", msg)
+}
+
+// WriteInt writes an integer.
+func (w *Writer) WriteInt(n int64) {
+	fmt.Fprintf(w.w, ":%d\r\n", n)
+}
+
+// WriteBulk writes a bulk string.
+func (w *Writer) WriteBulk(b []byte) {
+	fmt.Fprintf(w.w, "$%d\r\n", len(b))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+// WriteNull writes a null, which is a null bulk string in RESP2.
+func (w *Writer) WriteNull() {
+	if w.Protocol >= 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+
+	w.w.WriteString("$-1\r\n")
+}
+
+// WriteArray writes the header of an array with n elements, which must be written after it.
+func (w *Writer) WriteArray(n int) {
+	fmt.Fprintf(w.w, "*%d\r\n", n)
+}
+
+// WriteMap writes the header of a map with n key-value pairs, which must be written after it. In
+// RESP2 the map is written as an array of the keys and the values.
+func (w *Writer) WriteMap(n int) {
+	if w.Protocol >= 3 {
+		fmt.Fprintf(w.w, "%%%d\r\n", n)
+		return
+	}
+
+	w.WriteArray(2 * n)
+}
+
+// Flush writes the buffered replies.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,102 @@
+package resp
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n" +
+		"PING hello\r\n" +
+		"\r\n" +
+		"*1\r\n$4\r\nPING\r\n"
+
+	r := NewReader(strings.NewReader(input))
+	want := [][]string{
+		{"SET", "key", "va\r\nl"},
+		{"PING", "hello"},
+		{"PING"},
+	}
+
+	for _, w := range want {
+		args, err := r.ReadCommand()
+		if err != nil {
+			t.Fatalf("could not read command: %s", err)
+		}
+
+		got := make([]string, len(args))
+		for i, arg := range args {
+			got[i] = string(arg)
+		}
+
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("wrong command. got=%q want=%q", got, w)
+		}
+	}
+
+	for _, bad := range []string{"*1\r\n+PING\r\n", "*x\r\n", "*1\r\n$4\r\nPINGxx"} {
+		if _, err := NewReader(strings.NewReader(bad)).ReadCommand(); err == nil {
+			t.Errorf("invalid command %q was accepted", bad)
+		}
+	}
+
+	r = NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$5\r\nvalue\r\n"))
+	r.MaxBulk = 4
+	if _, err := r.ReadCommand(); err != ErrProtocol {
+		t.Errorf("a bulk string over the limit was accepted, err: %v", err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	w.WriteSimple("OK")
+	w.WriteError("ERR bad\r\nthing")
+	w.WriteInt(-3)
+	w.WriteBulk([]byte("a\r\nb"))
+	w.WriteNull()
+	w.WriteMap(1)
+	w.Protocol = 3
+	w.WriteNull()
+	w.WriteMap(1)
+	w.Flush()
+
+	want := "+OK\r\n-ERR bad  thing\r\n:-3\r\n$4\r\na\r\nb\r\n$-1\r\n*2\r\n_\r\n%1\r\n"
+	if buf.String() != want {
+		t.Errorf("wrong output. got=%q want=%q", buf.String(), want)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"*llo", "hello", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+	}
+
+	for _, tc := range testCases {
+		if got := Match(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
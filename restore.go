@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/nireo/dkv/db"
+)
+
+// restore rebuilds a database from a full backup archive followed by any amount of incremental
+// backup archives in order. The database is written next to the target path and moved into place
+// once all of the archives have been restored, such that a failed restore doesn't leave a partial
+// database behind.
+func restore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := fs.String("db", "", "path of the database that is created")
+	engine := fs.String("engine", db.EngineLevelDB, "the storage engine: leveldb or bolt")
+	fs.Usage = func() {
+		log.Printf("usage: dkv restore -db=<path> <full backup> [incremental backups...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *path == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *engine == db.EngineMemory {
+		log.Fatal("backups can only be restored into a leveldb or bolt database")
+	}
+
+	restoreInto(*engine, *path, fs.Args(), db.RecoveryTarget{})
+}
+
+// restoreInto restores the archives up to the target into a new database at path.
+func restoreInto(engine, path string, archives []string, target db.RecoveryTarget) {
+	if _, err := os.Stat(path); err == nil {
+		log.Fatalf("the database %s already exists", path)
+	}
+
+	tmp := path + ".restoring"
+	if err := restoreArchives(engine, tmp, archives, target); err != nil {
+		os.RemoveAll(tmp)
+		log.Fatalf("could not restore database: %s", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		log.Fatalf("could not move restored database: %s", err)
+	}
+}
+
+func restoreArchives(engine, path string, archives []string, target db.RecoveryTarget) error {
+	d, err := db.Open(engine, path, false)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	for _, archive := range archives {
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+
+		res, err := d.RestoreUntil(f, target)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		log.Printf("restored %s up to sequence %d", archive, res.Seq)
+	}
+
+	return nil
+}
@@ -12,7 +12,7 @@ sleep 0.1
 go install -v
 
 
-dkv -db=sh1.db -addr=localhost:8080 -shard=sh1 &
-dkv -db=sh2.db -addr=localhost:8081 -shard=sh2 &
+dkv -db=sh1.db -addr=localhost:8080 -shards=sh1 &
+dkv -db=sh2.db -addr=localhost:8081 -shards=sh2 &
 
 wait
@@ -0,0 +1,507 @@
+// Package server runs a dkv node: it opens the database, serves the http api and the optional
+// gRPC, redis and memcached listeners and runs the background workers of the node, such that nodes
+// can be started from other binaries and from tests.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nireo/dkv/cdc"
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/handlers"
+	"github.com/nireo/dkv/replica"
+	"github.com/nireo/dkv/shards"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Config contains the settings of a node. The zero values of the optional fields are replaced
+// with their defaults.
+type Config struct {
+	// Shards is the shard map of the cluster, where Index is the shard of this node.
+	Shards *shards.Shards
+
+	// DBPath is the path of the database and Engine is its storage engine, leveldb by default.
+	DBPath    string
+	Engine    string
+	ReadOnly  bool
+	Replica   bool
+	ChangeLog bool
+	Redirect  bool
+
+	// Addr is the address of the http api. It is not used if Listener is set, which allows
+	// knowing the addresses of the nodes before they are started. The other listeners are
+	// disabled when their address is empty.
+	Addr         string
+	Listener     net.Listener
+	GRPCAddr     string
+	RedisAddr    string
+	MemcacheAddr string
+
+	// the change data capture sinks, which are disabled when CDCFile or CDCWebhook is empty.
+	CDCFile      string
+	CDCFileSize  int64
+	CDCFileCount int
+	CDCWebhook   string
+
+	// MaxReplicaLag is the lag after which a replica is not ready, handlers.DefaultMaxReplicaLag
+	// by default.
+	MaxReplicaLag time.Duration
+
+	// ShutdownTimeout is how long Stop waits for the requests in flight, 30 seconds by default.
+	ShutdownTimeout time.Duration
+
+	// the limits of the http server, which are unlimited when they are zero. MaxValueSize is
+	// handlers.MaxValueSize by default.
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxValueSize      int64
+
+	// TLS makes the http and gRPC apis serve TLS with its certificates. The node then talks to
+	// the other shards and to the master over https with ClientTLS, which uses the system roots
+	// when it is nil, so either every node of the cluster uses TLS or none of them does. The
+	// redis and memcached listeners don't use TLS.
+	TLS       *tls.Config
+	ClientTLS *tls.Config
+}
+
+// DefaultShutdownTimeout is the default of Config.ShutdownTimeout.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Server is a dkv node. It owns the database, the listeners and the background workers, such that
+// they can be stopped in order before the database is closed.
+type Server struct {
+	cfg Config
+
+	db   *db.DB
+	srv  *handlers.Server
+	http *http.Server
+	grpc *grpc.Server
+
+	// the addresses of the listeners after Start.
+	addr, grpcAddr, redisAddr, memcacheAddr net.Addr
+
+	// the listeners of the redis and memcached protocols.
+	listeners []net.Listener
+
+	// stop is closed to stop the replica, the expiry loop and the cdc feeds.
+	stop    chan struct{}
+	workers sync.WaitGroup
+
+	// errc receives the errors of the listeners that stop serving.
+	errc chan error
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// New opens the database of the node and sets up its handlers. The node doesn't serve anything
+// before Start is called.
+func New(cfg Config) (*Server, error) {
+	if cfg.Shards == nil {
+		return nil, fmt.Errorf("the shard map is missing")
+	}
+
+	if _, ok := cfg.Shards.Addresses[cfg.Shards.Index]; !ok {
+		return nil, fmt.Errorf("the shard map doesn't contain shard %d", cfg.Shards.Index)
+	}
+
+	if cfg.Engine == "" {
+		cfg.Engine = db.EngineLevelDB
+	}
+	if cfg.MaxReplicaLag <= 0 {
+		cfg.MaxReplicaLag = handlers.DefaultMaxReplicaLag
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+
+	d, err := db.Open(cfg.Engine, cfg.DBPath, cfg.ReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error opening db: %s, err: %s", cfg.DBPath, err)
+	}
+
+	// the change data capture is built on the change log
+	if cfg.ChangeLog || cfg.CDCFile != "" || cfg.CDCWebhook != "" {
+		if err := d.EnableChangeLog(); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("could not enable change log: %s", err)
+		}
+	}
+
+	// the node id separates the CRDT updates of different nodes
+	d.SetNodeID(cfg.Shards.Addresses[cfg.Shards.Index])
+
+	s := &Server{cfg: cfg, db: d, stop: make(chan struct{}), errc: make(chan error, 4)}
+	s.srv = handlers.NewServer(d, cfg.Shards)
+	s.srv.UseRedirects(cfg.Redirect)
+	if cfg.MaxValueSize > 0 {
+		s.srv.SetMaxValueSize(cfg.MaxValueSize)
+	}
+	if cfg.TLS != nil {
+		s.srv.UseTLS(cfg.ClientTLS)
+	}
+
+	s.http = &http.Server{
+		Handler:           s.routes(),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		TLSConfig:         cfg.TLS,
+	}
+
+	return s, nil
+}
+
+// DB returns the database of the node.
+func (s *Server) DB() *db.DB {
+	return s.db
+}
+
+// Handler returns the http api of the node.
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+// Addr returns the address of the http api after the node has been started.
+func (s *Server) Addr() string {
+	return addrString(s.addr)
+}
+
+// GRPCAddr returns the address of the gRPC api, or an empty string if it is disabled.
+func (s *Server) GRPCAddr() string {
+	return addrString(s.grpcAddr)
+}
+
+// RedisAddr returns the address of the redis listener, or an empty string if it is disabled.
+func (s *Server) RedisAddr() string {
+	return addrString(s.redisAddr)
+}
+
+// MemcacheAddr returns the address of the memcached listener, or an empty string if it is
+// disabled.
+func (s *Server) MemcacheAddr() string {
+	return addrString(s.memcacheAddr)
+}
+
+// Err returns a channel that receives an error if a listener stops serving before Stop is called.
+func (s *Server) Err() <-chan error {
+	return s.errc
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
+
+// Start starts the listeners and the background workers of the node. If it fails, the node is
+// stopped and the database is closed.
+func (s *Server) Start() error {
+	if err := s.start(); err != nil {
+		s.Stop()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) start() error {
+	cfg, d := s.cfg, s.db
+
+	if cfg.Replica {
+		rep := replica.New(d, cfg.Shards.Addresses[cfg.Shards.Index])
+		if cfg.TLS != nil {
+			rep.UseTLS(cfg.ClientTLS)
+		}
+		s.srv.SetReplica(rep, cfg.MaxReplicaLag)
+		s.run(func() { rep.Run(s.stop) })
+	}
+
+	// the expired keys are removed in the background, reads skip them before that. The replicas
+	// expire the keys on their own with the deadlines copied from the master.
+	if !cfg.ReadOnly {
+		s.run(func() { d.ExpireLoop(time.Second, s.stop) })
+	}
+
+	if cfg.CDCFile != "" {
+		sink, err := cdc.NewFileSink(cfg.CDCFile, cfg.CDCFileSize, cfg.CDCFileCount)
+		if err != nil {
+			return fmt.Errorf("could not open cdc file: %s", err)
+		}
+
+		if err := s.startFeed("file", sink); err != nil {
+			return err
+		}
+	}
+
+	if cfg.CDCWebhook != "" {
+		if err := s.startFeed("webhook", cdc.NewWebhookSink(cfg.CDCWebhook)); err != nil {
+			return err
+		}
+	}
+
+	lis := cfg.Listener
+	if lis == nil {
+		var err error
+		if lis, err = net.Listen("tcp", cfg.Addr); err != nil {
+			return fmt.Errorf("could not listen for http: %s", err)
+		}
+	}
+
+	s.addr = lis.Addr()
+	go func() {
+		var err error
+		if cfg.TLS != nil {
+			err = s.http.ServeTLS(lis, "", "")
+		} else {
+			err = s.http.Serve(lis)
+		}
+
+		if err != http.ErrServerClosed {
+			s.errc <- fmt.Errorf("http server failed: %s", err)
+		}
+	}()
+
+	if cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen for gRPC: %s", err)
+		}
+
+		s.grpcAddr = lis.Addr()
+		var opts []grpc.ServerOption
+		if cfg.TLS != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(cfg.TLS)))
+		}
+
+		s.grpc = grpc.NewServer(opts...)
+		s.srv.RegisterGRPC(s.grpc)
+		go func() {
+			if err := s.grpc.Serve(lis); err != nil {
+				s.errc <- fmt.Errorf("gRPC server failed: %s", err)
+			}
+		}()
+	}
+
+	if cfg.RedisAddr != "" {
+		if err := s.serve("redis", cfg.RedisAddr, &s.redisAddr, s.srv.ServeRedis); err != nil {
+			return err
+		}
+	}
+
+	if cfg.MemcacheAddr != "" {
+		if err := s.serve("memcached", cfg.MemcacheAddr, &s.memcacheAddr, s.srv.ServeMemcache); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// routes returns the http routes of the node.
+func (s *Server) routes() http.Handler {
+	srv := s.srv
+	mux := http.NewServeMux()
+
+	// the requests are counted in the metrics by the route
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, srv.Instrument(pattern, h))
+	}
+
+	handle("/get", srv.Get)
+	handle("/set", srv.Set)
+	handle("/del", srv.Delete)
+	handle("/purge", srv.DeleteNotBelonging)
+	handle("/del-rep", srv.DeleteReplicationKey)
+	handle("/next", srv.GetNextReplicationKey)
+
+	handle("/buckets", srv.ListBuckets)
+	handle("/buckets/create", srv.CreateBucket)
+	handle("/buckets/drop", srv.DropBucket)
+	handle("/b/", srv.BucketOp)
+
+	handle("/export", srv.Export)
+	handle("/import", srv.Import)
+
+	handle("/admin/namespaces", srv.Namespaces)
+	handle("/admin/namespaces/create", srv.CreateNamespace)
+	handle("/admin/quota", srv.SetQuota)
+	handle("/admin/backup", srv.Backup)
+	handle("/admin/changelog/trim", srv.TrimChangeLog)
+	handle("/admin/restore", srv.Restore)
+	handle("/admin/replication", srv.Replication)
+	handle("/admin/cdc", srv.CDC)
+	handle("/admin/cdc/remove", srv.RemoveSink)
+
+	handle("/incrby", srv.IncrBy)
+	handle("/decrby", srv.DecrBy)
+	handle("/append", srv.Append)
+
+	handle("/incr", srv.Incr)
+	handle("/counter", srv.Counter)
+	handle("/sadd", srv.SetAdd)
+	handle("/srem", srv.SetRemove)
+	handle("/smembers", srv.SetMembers)
+	handle("/rset", srv.RegisterSet)
+	handle("/rget", srv.RegisterGet)
+
+	mux.HandleFunc("/metrics", srv.Metrics)
+	mux.HandleFunc("/healthz", srv.Healthz)
+	mux.HandleFunc("/readyz", srv.Readyz)
+	mux.HandleFunc("/info", srv.NodeInfo)
+
+	// the mux redirects the paths with "//", "." or ".." segments to the cleaned path, but they
+	// are valid in keys, so the key routes are dispatched before it
+	kv := srv.Instrument("/kv/", http.HandlerFunc(srv.KV))
+	v1 := srv.Instrument("/v1/", srv.V1())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/kv/"):
+			kv.ServeHTTP(w, r)
+		case strings.HasPrefix(r.URL.Path, "/v1/"):
+			v1.ServeHTTP(w, r)
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
+
+// run runs a background worker that returns when s.stop is closed.
+func (s *Server) run(fn func()) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn()
+	}()
+}
+
+// serve serves a protocol listener at addr and stores the address of the listener in bound.
+func (s *Server) serve(name, addr string, bound *net.Addr, serve func(net.Listener) error) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("could not listen for %s: %s", name, err)
+	}
+	s.listeners = append(s.listeners, lis)
+	*bound = lis.Addr()
+
+	go func() {
+		if err := serve(lis); err != nil && !s.srv.Draining() {
+			s.errc <- fmt.Errorf("%s listener failed: %s", name, err)
+		}
+	}()
+
+	return nil
+}
+
+// startFeed delivers the changes of the database to the sink in the background.
+func (s *Server) startFeed(name string, sink cdc.Sink) error {
+	feed, err := cdc.New(s.db, name, sink, cdc.DefaultOptions)
+	if err != nil {
+		return fmt.Errorf("could not create cdc feed %s: %s", name, err)
+	}
+
+	s.srv.AddFeed(feed)
+	s.run(func() { feed.Run(s.stop) })
+	return nil
+}
+
+// Stop stops the node gracefully. The node is first marked as not ready, then the listeners
+// stop accepting connections and the requests in flight are finished, after which the background
+// workers are stopped and the database is closed. The requests that are still running after the
+// shutdown timeout are cancelled. It returns an error if something couldn't be stopped cleanly.
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() { s.stopErr = s.shutdown() })
+	return s.stopErr
+}
+
+func (s *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		mu.Lock()
+		errs = append(errs, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+
+	s.srv.Drain()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.http.Shutdown(ctx); err != nil {
+			fail("could not finish http requests: %s", err)
+			s.http.Close()
+		}
+	}()
+
+	if s.grpc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan struct{})
+			go func() {
+				s.grpc.GracefulStop()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				fail("could not finish gRPC requests: %s", ctx.Err())
+				s.grpc.Stop()
+			}
+		}()
+	}
+
+	for _, lis := range s.listeners {
+		lis.Close()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.srv.CloseConns(ctx); err != nil {
+			fail("could not close protocol connections: %s", err)
+		}
+	}()
+	wg.Wait()
+
+	// the background workers finish the work that they are doing
+	close(s.stop)
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		fail("could not stop background workers: %s", ctx.Err())
+	}
+
+	if err := s.db.Close(); err != nil {
+		fail("could not close database: %s", err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unclean shutdown: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,420 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nireo/dkv/db"
+	"github.com/nireo/dkv/server"
+	"github.com/nireo/dkv/shards"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "dkvserver")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// startCluster starts the shards of a cluster in this process on ephemeral ports. The configs of
+// the nodes are changed with configure if it is not nil.
+func startCluster(t *testing.T, amount int, configure func(*server.Config)) []*server.Server {
+	t.Helper()
+
+	listeners := make([]net.Listener, amount)
+	addrs := make(map[int]string, amount)
+	for i := range listeners {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not listen: %s", err)
+		}
+		listeners[i] = lis
+		addrs[i] = lis.Addr().String()
+	}
+
+	nodes := make([]*server.Server, amount)
+	for i := range nodes {
+		cfg := server.Config{
+			Shards:    &shards.Shards{Amount: amount, Index: i, Addresses: addrs},
+			DBPath:    tempDir(t),
+			Listener:  listeners[i],
+			RedisAddr: "127.0.0.1:0",
+		}
+		if configure != nil {
+			configure(&cfg)
+		}
+
+		s, err := server.New(cfg)
+		if err != nil {
+			t.Fatalf("could not create node %d: %s", i, err)
+		}
+
+		if err := s.Start(); err != nil {
+			t.Fatalf("could not start node %d: %s", i, err)
+		}
+		t.Cleanup(func() { s.Stop() })
+		nodes[i] = s
+	}
+
+	return nodes
+}
+
+func TestCluster(t *testing.T) {
+	nodes := startCluster(t, 2, nil)
+
+	// the writes are forwarded to the owner
+	for i := 0; i < 20; i++ {
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/v1/kv/key%d", nodes[0].Addr(), i), strings.NewReader("value"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("put request failed: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("wrong status for put. got=%d", resp.StatusCode)
+		}
+	}
+
+	counts := make([]int, len(nodes))
+	for i, n := range nodes {
+		for j := 0; j < 20; j++ {
+			if _, err := n.DB().Get(fmt.Sprintf("key%d", j)); err == nil {
+				counts[i]++
+			}
+		}
+
+		if n.RedisAddr() == "" || n.RedisAddr() == "127.0.0.1:0" {
+			t.Errorf("the redis address of node %d is not known: %q", i, n.RedisAddr())
+		}
+	}
+
+	if counts[0] == 0 || counts[1] == 0 || counts[0]+counts[1] != 20 {
+		t.Errorf("the keys were not split between the shards. counts=%v", counts)
+	}
+}
+
+// selfSigned returns a certificate for 127.0.0.1 and a pool that trusts it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dkv"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("could not parse certificate: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestTLSCluster(t *testing.T) {
+	cert, pool := selfSigned(t)
+	clientTLS := &tls.Config{RootCAs: pool}
+
+	nodes := startCluster(t, 2, func(cfg *server.Config) {
+		cfg.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		cfg.ClientTLS = clientTLS
+	})
+
+	// the requests for the keys of the other shard are proxied over https
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/v1/kv/key%d", nodes[0].Addr(), i), strings.NewReader("value"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("put request failed: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("wrong status for put. got=%d", resp.StatusCode)
+		}
+	}
+
+	forwarded := 0
+	for i := 0; i < 10; i++ {
+		if _, err := nodes[1].DB().Get(fmt.Sprintf("key%d", i)); err == nil {
+			forwarded++
+		}
+	}
+
+	if forwarded == 0 {
+		t.Errorf("no keys were forwarded to the other shard")
+	}
+
+	if resp, err := http.Get("http://" + nodes[0].Addr() + "/healthz"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("the node served plain http")
+		}
+	}
+}
+
+func TestStopKeepsWrites(t *testing.T) {
+	dir := tempDir(t)
+
+	s, err := server.New(server.Config{
+		Shards:          &shards.Shards{Amount: 1, Index: 0, Addresses: map[int]string{0: "127.0.0.1:0"}},
+		DBPath:          dir,
+		Addr:            "127.0.0.1:0",
+		ShutdownTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("could not create node: %s", err)
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("could not start node: %s", err)
+	}
+	url := "http://" + s.Addr()
+
+	// a long-poll doesn't keep the node from shutting down
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		resp, err := http.Get(url + "/v1/watch/missing?timeout=25s")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	// the writers keep writing until the node stops accepting requests and remember the writes
+	// that were acknowledged
+	var mu sync.Mutex
+	acked := make(map[string]string)
+	started := make(chan struct{}, 8)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			client := &http.Client{Transport: &http.Transport{}}
+
+			// a writer that fails early must not leave the test waiting for it
+			signaled := false
+			signal := func() {
+				if !signaled {
+					signaled = true
+					started <- struct{}{}
+				}
+			}
+			defer signal()
+
+			for i := 0; ; i++ {
+				key, value := fmt.Sprintf("w%d-%d", w, i), fmt.Sprintf("value-%d-%d", w, i)
+				req, _ := http.NewRequest(http.MethodPut, url+"/v1/kv/"+key, strings.NewReader(value))
+				resp, err := client.Do(req)
+				if err != nil {
+					return
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusNoContent {
+					return
+				}
+
+				mu.Lock()
+				acked[key] = value
+				mu.Unlock()
+
+				if i == 10 {
+					signal()
+				}
+			}
+		}(w)
+	}
+
+	for i := 0; i < 8; i++ {
+		<-started
+	}
+
+	start := time.Now()
+	if err := s.Stop(); err != nil {
+		t.Fatalf("could not stop node: %s", err)
+	}
+	wg.Wait()
+	<-watchDone
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the shutdown waited for the long-poll. took=%s", elapsed)
+	}
+
+	d, err := db.Open(db.EngineLevelDB, dir, false)
+	if err != nil {
+		t.Fatalf("could not reopen database: %s", err)
+	}
+	defer d.Close()
+
+	for key, value := range acked {
+		got, err := d.Get(key)
+		if err != nil || string(got) != value {
+			t.Errorf("an acknowledged write was lost. key=%s got=%q err=%v", key, got, err)
+		}
+	}
+}
+
+// startReplica starts a master and its replica. The replica copies the master at the address of
+// its shard in the shard map.
+func startReplica(t *testing.T) (master, rep *server.Server) {
+	t.Helper()
+
+	nodes := startCluster(t, 1, nil)
+
+	rep, err := server.New(server.Config{
+		Shards:  &shards.Shards{Amount: 1, Index: 0, Addresses: map[int]string{0: nodes[0].Addr()}},
+		DBPath:  tempDir(t),
+		Addr:    "127.0.0.1:0",
+		Replica: true,
+	})
+	if err != nil {
+		t.Fatalf("could not create replica: %s", err)
+	}
+
+	if err := rep.Start(); err != nil {
+		t.Fatalf("could not start replica: %s", err)
+	}
+	t.Cleanup(func() { rep.Stop() })
+
+	return nodes[0], rep
+}
+
+// waitFor polls cond until it is true or the time runs out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReplicaBinaryKey(t *testing.T) {
+	master, rep := startReplica(t)
+
+	keys := []string{"\xff\x00binary", "text", "\xfe"}
+	for _, key := range keys {
+		if err := master.DB().Set(key, []byte("value-"+key)); err != nil {
+			t.Fatalf("could not set %q: %s", key, err)
+		}
+	}
+
+	waitFor(t, "the replication queue to drain", func() bool {
+		backlog, err := master.DB().ReplicationBacklog()
+		return err == nil && backlog == 0
+	})
+
+	for _, key := range keys {
+		value, err := rep.DB().Get(key)
+		if err != nil || string(value) != "value-"+key {
+			t.Errorf("the replica has a wrong value for %q. got=%q err=%v", key, value, err)
+		}
+	}
+}
+
+func TestReplicaTTL(t *testing.T) {
+	master, rep := startReplica(t)
+
+	_, err := master.DB().SetWithOptions("session", []byte("value"), db.SetOptions{TTL: time.Minute, Flags: 7})
+	if err != nil {
+		t.Fatalf("could not set with ttl: %s", err)
+	}
+
+	waitFor(t, "the replication queue to drain", func() bool {
+		backlog, err := master.DB().ReplicationBacklog()
+		return err == nil && backlog == 0
+	})
+
+	if left, err := rep.DB().TTL("session"); err != nil || left <= 0 || left > time.Minute {
+		t.Errorf("the replica has a wrong ttl. got=%s, err=%v", left, err)
+	}
+
+	if item, err := rep.DB().GetItem("session"); err != nil || item.Flags != 7 {
+		t.Errorf("the replica has wrong flags. got=%+v, err=%v", item, err)
+	}
+}
+
+func TestKeysWithDotSegments(t *testing.T) {
+	nodes := startCluster(t, 1, nil)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for _, route := range []string{"/kv/", "/v1/kv/"} {
+		for _, key := range []string{"a//b", ".", "..", "./dot", "../up", "a/./b/../c", "dir/"} {
+			url := "http://" + nodes[0].Addr() + route + key
+			req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("value-"+key))
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("could not put %q: %s", key, err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("%s%s: wrong status for put. got=%d", route, key, resp.StatusCode)
+				continue
+			}
+
+			if value, err := nodes[0].DB().Get(key); err != nil || string(value) != "value-"+key {
+				t.Errorf("%s%s: wrong value in the database. got=%q, err=%v", route, key, value, err)
+			}
+
+			resp, err = client.Get(url)
+			if err != nil {
+				t.Fatalf("could not get %q: %s", key, err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK || string(body) != "value-"+key {
+				t.Errorf("%s%s: wrong response. got=%d %q", route, key, resp.StatusCode, body)
+			}
+		}
+	}
+}
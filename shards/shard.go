@@ -9,14 +9,25 @@ import (
 
 // Shard represents the config entry for a single shard
 type Shard struct {
-	Index   int    `json:"index"`
-	Name    string `json:"name"`
-	Address string `json:"address"`
+	Index   int    `json:"index" yaml:"index"`
+	Name    string `json:"name" yaml:"name"`
+	Address string `json:"address" yaml:"address"`
+
+	// RedisAddress is the address of the redis protocol listener of the shard, which is used
+	// in the MOVED redirects. It is optional.
+	RedisAddress string `json:"redis_address,omitempty" yaml:"redis_address,omitempty"`
 }
 
-// Config represents the data inside of the shard config file with multiple shard entries
+// EpochHeader is the http header in which the clients send the epoch of their shard map. The
+// servers respond with 421 Misdirected Request and their own epoch when the epochs differ.
+const EpochHeader = "X-Dkv-Epoch"
+
+// Config represents the data inside of the shard config file with multiple shard entries. The
+// epoch should be increased every time the shards change, such that the clients notice that
+// their shard map is stale.
 type Config struct {
-	Shards []Shard `json:"shards"`
+	Epoch  uint64  `json:"epoch,omitempty" yaml:"epoch,omitempty"`
+	Shards []Shard `json:"shards" yaml:"shards"`
 }
 
 // Shards represents the configuration of a server, but it also includes the amount of shards
@@ -24,7 +35,13 @@ type Config struct {
 type Shards struct {
 	Amount    int
 	Index     int
+	Name      string // the name of this shard in the config, empty outside of the cluster
+	Epoch     uint64
 	Addresses map[int]string
+
+	// RedisAddresses contains the redis addresses of the shards that have one, it is nil if none
+	// of the shards have a redis address.
+	RedisAddresses map[int]string
 }
 
 // ParseConfigFile opens the shards file and parses the json information into the Config struct.
@@ -44,17 +61,42 @@ func ParseConfigFile(path string) (*Config, error) {
 
 // ParseConfigShards returns a Shards struct from the config given a shard name
 func (c *Config) ParseConfigShards(shardName string) (*Shards, error) {
-	index := -1
-	addresses := make(map[int]string)
+	s, err := c.Cluster()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, shard := range c.Shards {
+		if shard.Name == shardName {
+			s.Index = shard.Index
+			s.Name = shard.Name
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("shards %s was not found", shardName)
+}
+
+// Cluster returns the shards of the config as seen from outside of the cluster, so the index is
+// -1. It is used by the clients and tools that talk to every shard.
+func (c *Config) Cluster() (*Shards, error) {
+	if len(c.Shards) == 0 {
+		return nil, fmt.Errorf("no shards in the config")
+	}
 
+	addresses := make(map[int]string)
+	var redisAddresses map[int]string
 	for _, s := range c.Shards {
 		if _, ok := addresses[s.Index]; ok {
 			return nil, fmt.Errorf("duplicated shards index: %d", s.Index)
 		}
 
 		addresses[s.Index] = s.Address
-		if s.Name == shardName {
-			index = s.Index
+		if s.RedisAddress != "" {
+			if redisAddresses == nil {
+				redisAddresses = make(map[int]string)
+			}
+			redisAddresses[s.Index] = s.RedisAddress
 		}
 	}
 
@@ -64,17 +106,25 @@ func (c *Config) ParseConfigShards(shardName string) (*Shards, error) {
 		}
 	}
 
-	if index < 0 {
-		return nil, fmt.Errorf("shards %s was not found", shardName)
-	}
-
 	return &Shards{
-		Addresses: addresses,
-		Amount:    len(c.Shards),
-		Index:     index,
+		Addresses:      addresses,
+		RedisAddresses: redisAddresses,
+		Amount:         len(c.Shards),
+		Index:          -1,
+		Epoch:          c.Epoch,
 	}, nil
 }
 
+// Config returns the config of the shards without the names of the shards.
+func (s *Shards) Config() *Config {
+	conf := &Config{Epoch: s.Epoch}
+	for i := 0; i < s.Amount; i++ {
+		conf.Shards = append(conf.Shards, Shard{Index: i, Address: s.Addresses[i], RedisAddress: s.RedisAddresses[i]})
+	}
+
+	return conf
+}
+
 // GetShardIndex is the sharding function which desides in which the shard the key-value
 // should go into
 func (s *Shards) GetShardIndex(key string) int {
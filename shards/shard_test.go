@@ -49,6 +49,7 @@ func TestShardParsing(t *testing.T) {
 	want := &Shards{
 		Amount: 2,
 		Index:  0,
+		Name:   "sh1",
 		Addresses: map[int]string{
 			0: "localhost:8080",
 			1: "localhost:8081",
@@ -59,3 +60,29 @@ func TestShardParsing(t *testing.T) {
 		t.Errorf("shards doesn't match. got=%v want=%v", got, want)
 	}
 }
+
+func TestCluster(t *testing.T) {
+	conf, err := ParseConfigFile("./test_config.json")
+	if err != nil {
+		t.Fatalf("error parsing shards, err: %s", err)
+	}
+	conf.Epoch = 3
+
+	got, err := conf.Cluster()
+	if err != nil {
+		t.Fatalf("could not parse cluster: %s", err)
+	}
+
+	if got.Index != -1 || got.Epoch != 3 || got.Amount != 2 {
+		t.Errorf("wrong cluster. got=%v", got)
+	}
+
+	back := got.Config()
+	if back.Epoch != 3 || len(back.Shards) != 2 || back.Shards[1].Address != "localhost:8081" {
+		t.Errorf("wrong config from shards. got=%v", back)
+	}
+
+	if _, err := (&Config{}).Cluster(); err == nil {
+		t.Errorf("a config without shards was accepted")
+	}
+}
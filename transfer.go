@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nireo/dkv/client"
+	"github.com/nireo/dkv/export"
+	"github.com/nireo/dkv/handlers"
+	"github.com/nireo/dkv/shards"
+)
+
+// exportData writes the key-value pairs of every shard in the cluster into a single file.
+func exportData(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	conf := fs.String("conf", "conf.json", "shards file of the cluster")
+	bucket := fs.String("bucket", "", "the bucket that is exported instead of the default bucket")
+	prefix := fs.String("prefix", "", "only export the keys that start with the prefix")
+	format := fs.String("format", export.FormatJSONL, "the output format: jsonl or csv")
+	output := fs.String("o", "", "the output file, the standard output is used by default")
+	nodes := tlsFlags(fs)
+	fs.Parse(args)
+
+	cluster, err := clusterShards(*conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c, scheme, err := nodes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc, err := export.NewWriter(out, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	count := 0
+	for i := 0; i < cluster.Amount; i++ {
+		n, err := exportShard(c, scheme+"://"+cluster.Addresses[i], *bucket, *prefix, enc)
+		if err != nil {
+			enc.Fail(fmt.Errorf("shard %d: %s", i, err))
+			log.Fatalf("could not export shard %d: %s", i, err)
+		}
+		count += n
+	}
+
+	if err := enc.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("exported %d records", count)
+}
+
+func exportShard(c *http.Client, base, bucket, prefix string, enc export.Writer) (int, error) {
+	query := url.Values{"bucket": {bucket}, "prefix": {prefix}, "format": {export.FormatJSONL}}
+	resp, err := c.Get(base + "/export?" + query.Encode())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	dec, _ := export.NewReader(resp.Body, export.FormatJSONL)
+	count := 0
+	for {
+		rec, err := dec.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if err := enc.Write(rec); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// importData sends the records of a file to the shards that own them in batches. The amount of
+// imported records is stored in a checkpoint file after every batch, such that an interrupted
+// import continues from where it stopped when the command is run again.
+func importData(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	conf := fs.String("conf", "conf.json", "shards file of the cluster")
+	bucket := fs.String("bucket", "", "the bucket that the records are imported into instead of the default bucket")
+	format := fs.String("format", export.FormatJSONL, "the input format: jsonl or csv")
+	batchSize := fs.Int("batch", handlers.ImportBatchSize, "the amount of records imported in a batch")
+	checkpoint := fs.String("checkpoint", "", "the checkpoint file, <input>.progress by default")
+	nodes := tlsFlags(fs)
+	fs.Usage = func() {
+		log.Printf("usage: dkv import [flags] <input>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	input := fs.Arg(0)
+	if *checkpoint == "" {
+		*checkpoint = input + ".progress"
+	}
+
+	cluster, err := clusterShards(*conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c, scheme, err := nodes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	dec, err := export.NewReader(f, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	done, err := readCheckpoint(*checkpoint)
+	if err != nil {
+		log.Fatalf("could not read checkpoint: %s", err)
+	}
+
+	if done > 0 {
+		log.Printf("continuing import after %d records", done)
+	}
+
+	read := 0
+	batch := make(map[int][]*export.Record)
+	pending := 0
+	for {
+		rec, err := dec.Read()
+		if err != nil && err != io.EOF {
+			log.Fatalf("could not read record %d: %s", read+1, err)
+		}
+
+		if rec != nil {
+			read++
+			if read <= done {
+				continue
+			}
+
+			shard := cluster.GetShardIndex(rec.Key)
+			batch[shard] = append(batch[shard], rec)
+			pending++
+		}
+
+		if pending > 0 && (pending >= *batchSize || err == io.EOF) {
+			for shard, records := range batch {
+				if err := handlers.PostImport(c, scheme+"://"+cluster.Addresses[shard], *bucket, records); err != nil {
+					log.Fatalf("import stopped after %d records: %s", done, err)
+				}
+			}
+
+			done += pending
+			if err := ioutil.WriteFile(*checkpoint, []byte(strconv.Itoa(done)), 0644); err != nil {
+				log.Fatalf("could not write checkpoint: %s", err)
+			}
+
+			batch = make(map[int][]*export.Record)
+			pending = 0
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	os.Remove(*checkpoint)
+	log.Printf("imported %d records", done)
+}
+
+func readCheckpoint(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// tlsFlags defines the flags that make the command connect to the nodes over https. The returned
+// function gives the http client and the url scheme of the nodes after the flags have been parsed.
+func tlsFlags(fs *flag.FlagSet) func() (*http.Client, string, error) {
+	useTLS := fs.Bool("tls", false, "connect to the nodes over https, verifying them with the system roots")
+	caFile := fs.String("tls-ca", "", "the CA that is used to verify the nodes, enables -tls")
+
+	return func() (*http.Client, string, error) {
+		if !*useTLS && *caFile == "" {
+			return http.DefaultClient, "http", nil
+		}
+
+		config, err := client.TLSConfig(*caFile)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}, "https", nil
+	}
+}
+
+// clusterShards returns the shards of the cluster from the shards file.
+func clusterShards(path string) (*shards.Shards, error) {
+	conf, err := shards.ParseConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf.Cluster()
+}